@@ -0,0 +1,142 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+
+	"joshuamURD/go-auth-api/pkgs/auth"
+	"joshuamURD/go-auth-api/pkgs/controllers"
+	"joshuamURD/go-auth-api/pkgs/db"
+	"joshuamURD/go-auth-api/pkgs/db/memory"
+	"joshuamURD/go-auth-api/pkgs/hash"
+	"joshuamURD/go-auth-api/pkgs/mail"
+	"joshuamURD/go-auth-api/pkgs/webauthn"
+)
+
+// Config holds everything needed to build an App
+type Config struct {
+	// Addr is the address the server listens on
+	Addr string
+	// DB selects and configures the database
+	DB db.Config
+	// Keys configures the signing key ring
+	Keys auth.KeyManagerConfig
+	// Hash selects the algorithm passwords are hashed with
+	Hash hash.Config
+	// WebAuthn describes the relying party passkeys are registered with
+	WebAuthn webauthn.Config
+	// Controller holds the policy settings of the handlers
+	Controller controllers.Config
+	// MailOutput is where emails are written until a real mail provider is configured
+	MailOutput io.Writer
+}
+
+// DefaultConfig returns the settings used when nothing else is configured
+func DefaultConfig() Config {
+	return Config{
+		Addr:       "127.0.0.1:8080",
+		DB:         db.Config{Driver: db.DriverSQLite, Path: "test.db", Migrate: true},
+		Keys:       auth.KeyManagerConfig{Dir: "keys", Algorithm: auth.RS256},
+		Hash:       hash.DefaultConfig(),
+		WebAuthn:   webauthn.DefaultConfig(),
+		Controller: controllers.DefaultConfig(),
+		MailOutput: os.Stdout,
+	}
+}
+
+// App wires the database, the key ring, the auth service and the controllers together
+// nothing is shared between apps, so several can run side by side in one process
+type App struct {
+	Config     Config
+	Store      *db.Store
+	Hasher     hash.Hasher
+	Keys       *auth.KeyManager
+	Auth       *auth.JWTAuthService
+	Controller *controllers.Controller
+}
+
+// New opens the database and loads the keys described by config and builds the services on top of them
+func New(config Config) (*App, error) {
+	hasher, err := hash.New(config.Hash)
+	if err != nil {
+		return nil, err
+	}
+
+	relyingParty, err := webauthn.NewRelyingParty(config.WebAuthn)
+	if err != nil {
+		return nil, err
+	}
+
+	store, err := openStore(config.DB)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	keys := auth.NewKeyManager(config.Keys)
+	if err := keys.EnsureKeys(); err != nil {
+		store.Close()
+		return nil, fmt.Errorf("failed to ensure keys: %w", err)
+	}
+
+	//bcrypt ignores everything past 72 bytes, so longer passwords are refused rather than truncated
+	//a pepper hashes the password to a fixed length first, which makes the limit unnecessary
+	if config.Hash.Algorithm == hash.Bcrypt && len(config.Hash.Peppers) == 0 && (config.Controller.PasswordPolicy.MaxBytes == 0 || config.Controller.PasswordPolicy.MaxBytes > 72) {
+		config.Controller.PasswordPolicy.MaxBytes = 72
+	}
+
+	authService := auth.NewJWTAuthService(keys, store.RefreshTokens)
+	mailer := mail.NewWriterMailer(config.MailOutput)
+	controller := controllers.NewController(hasher, store, authService, relyingParty, mailer, config.Controller)
+
+	return &App{
+		Config:     config,
+		Store:      store,
+		Hasher:     hasher,
+		Keys:       keys,
+		Auth:       authService,
+		Controller: controller,
+	}, nil
+}
+
+// openStore opens the database described by config, including the in-memory store
+// which lives outside the db package
+func openStore(config db.Config) (*db.Store, error) {
+	if config.Driver == memory.Driver {
+		return memory.NewStore(), nil
+	}
+	return db.Open(config)
+}
+
+// Run serves the API on the configured address and rotates the signing key in the background
+// until ctx is cancelled
+func (a *App) Run(ctx context.Context) error {
+	go a.Keys.StartRotation(ctx)
+
+	server := &http.Server{
+		Addr:     a.Config.Addr,
+		Handler:  a.Handler(),
+		ErrorLog: log.New(os.Stderr, "ErrorLog: ", log.Lshortfile),
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+		return server.Shutdown(context.Background())
+	}
+}
+
+// Close waits for the emails still being sent and releases the database connection
+func (a *App) Close() error {
+	a.Controller.Wait()
+	return a.Store.Close()
+}
@@ -0,0 +1,690 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"joshuamURD/go-auth-api/pkgs/controllers"
+	"joshuamURD/go-auth-api/pkgs/db/memory"
+	"joshuamURD/go-auth-api/pkgs/mail"
+	"joshuamURD/go-auth-api/pkgs/models"
+	"joshuamURD/go-auth-api/pkgs/webauthn"
+	"joshuamURD/go-auth-api/pkgs/webauthn/webauthntest"
+
+	"github.com/google/uuid"
+)
+
+// mailbox collects the emails written by the app
+type mailbox struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (m *mailbox) Write(p []byte) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.buf.Write(p)
+}
+
+// String returns every email written so far
+func (m *mailbox) String() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.buf.String()
+}
+
+var linkPattern = regexp.MustCompile(`https?://\S+`)
+
+// token returns the token of the last link to path that was mailed
+func (m *mailbox) token(t *testing.T, path string) string {
+	t.Helper()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	links := linkPattern.FindAllString(m.buf.String(), -1)
+	for i := len(links) - 1; i >= 0; i-- {
+		link, err := url.Parse(links[i])
+		if err == nil && link.Path == path {
+			return link.Query().Get("token")
+		}
+	}
+	t.Fatalf("no link to %s was mailed", path)
+	return ""
+}
+
+// testServer serves an app backed by the in-memory store over TLS, so the secure refresh cookie is kept
+type testServer struct {
+	*httptest.Server
+	app    *App
+	mail   *mailbox
+	client *http.Client
+}
+
+// newTestServer starts a server with the default config changed by configure, which may be nil
+func newTestServer(t *testing.T, configure func(config *Config)) *testServer {
+	t.Helper()
+
+	mail := &mailbox{}
+	config := DefaultConfig()
+	config.DB.Driver = memory.Driver
+	config.Keys.Dir = t.TempDir()
+	config.Hash.Argon2id.Memory = 1024
+	config.MailOutput = mail
+	if configure != nil {
+		configure(&config)
+	}
+
+	a, err := New(config)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { a.Close() })
+
+	server := httptest.NewTLSServer(a.Handler())
+	t.Cleanup(server.Close)
+	return &testServer{Server: server, app: a, mail: mail, client: newClient(server)}
+}
+
+// newClient returns a client of server with a cookie jar of its own
+// server.Client always returns the same client, so it is copied before the jar is set
+func newClient(server *httptest.Server) *http.Client {
+	client := *server.Client()
+	client.Jar, _ = cookiejar.New(nil)
+	return &client
+}
+
+// do sends a request with body encoded as JSON when it is not nil and returns the status and the decoded response
+func (s *testServer) do(t *testing.T, client *http.Client, method string, path string, accessToken string, body any) (int, map[string]any) {
+	t.Helper()
+
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			t.Fatalf("Marshal: %v", err)
+		}
+		reader = bytes.NewReader(encoded)
+	}
+	req, err := http.NewRequest(method, s.URL+path, reader)
+	if err != nil {
+		t.Fatalf("NewRequest: %v", err)
+	}
+	if accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	//Emails are sent after the response, so they are waited for before the test reads the mailbox
+	s.app.Controller.Wait()
+
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	decoded := map[string]any{}
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") || json.Valid(raw) {
+		json.Unmarshal(raw, &decoded)
+	}
+	return resp.StatusCode, decoded
+}
+
+// expect fails the test when a request does not answer with want
+func expect(t *testing.T, what string, got int, want int) {
+	t.Helper()
+	if got != want {
+		t.Fatalf("%s returned %d, want %d", what, got, want)
+	}
+}
+
+func TestRegisterVerifyAndResetPassword(t *testing.T) {
+	s := newTestServer(t, nil)
+	credentials := map[string]string{"email": "alice@example.com", "password": "correct horse"}
+
+	status, body := s.do(t, s.client, http.MethodPost, "/register", "", credentials)
+	expect(t, "Register", status, http.StatusCreated)
+	if body["access_token"] == "" {
+		t.Fatalf("Register returned no access token")
+	}
+
+	status, _ = s.do(t, newClient(s.Server), http.MethodGet, "/verify?token="+url.QueryEscape(s.mail.token(t, "/verify")), "", nil)
+	expect(t, "Verify", status, http.StatusOK)
+	user, err := s.app.Store.Users.GetByEmail(context.Background(), "alice@example.com")
+	if err != nil {
+		t.Fatalf("GetByEmail: %v", err)
+	}
+	if !user.Verified {
+		t.Errorf("the user is not verified after following the link")
+	}
+
+	status, _ = s.do(t, newClient(s.Server), http.MethodPost, "/password/forgot", "", map[string]string{"email": "alice@example.com"})
+	expect(t, "ForgotPassword", status, http.StatusOK)
+	token := s.mail.token(t, "/password/reset")
+
+	//A password the policy refuses rolls the reset back, so the same link still works
+	status, _ = s.do(t, newClient(s.Server), http.MethodPost, "/password/reset", "", map[string]string{"token": token, "password": "alice is great"})
+	expect(t, "ResetPassword with the email in the password", status, http.StatusUnprocessableEntity)
+	status, _ = s.do(t, newClient(s.Server), http.MethodPost, "/password/reset", "", map[string]string{"token": token, "password": "battery staple"})
+	expect(t, "ResetPassword", status, http.StatusOK)
+	status, _ = s.do(t, newClient(s.Server), http.MethodPost, "/password/reset", "", map[string]string{"token": token, "password": "battery staple"})
+	expect(t, "ResetPassword with a used token", status, http.StatusBadRequest)
+
+	//The reset ended the session opened by Register
+	status, _ = s.do(t, s.client, http.MethodGet, "/login", "", nil)
+	expect(t, "Refresh after the reset", status, http.StatusUnauthorized)
+
+	status, _ = s.do(t, newClient(s.Server), http.MethodPost, "/login", "", map[string]string{"email": "alice@example.com", "password": "battery staple"})
+	expect(t, "Login with the new password", status, http.StatusOK)
+}
+
+func TestEndedSessionDoesNotBlockLogin(t *testing.T) {
+	s := newTestServer(t, nil)
+	credentials := map[string]string{"email": "alice@example.com", "password": "correct horse"}
+
+	status, _ := s.do(t, s.client, http.MethodPost, "/register", "", credentials)
+	expect(t, "Register", status, http.StatusCreated)
+	status, _ = s.do(t, s.client, http.MethodPost, "/login", "", credentials)
+	expect(t, "Login with an active session", status, http.StatusBadRequest)
+
+	status, _ = s.do(t, newClient(s.Server), http.MethodPost, "/password/forgot", "", map[string]string{"email": "alice@example.com"})
+	expect(t, "ForgotPassword", status, http.StatusOK)
+	credentials["password"] = "battery staple"
+	status, _ = s.do(t, newClient(s.Server), http.MethodPost, "/password/reset", "", map[string]string{"token": s.mail.token(t, "/password/reset"), "password": credentials["password"]})
+	expect(t, "ResetPassword", status, http.StatusOK)
+
+	//The client still holds the cookie of the session the reset ended
+	status, _ = s.do(t, s.client, http.MethodGet, "/login", "", nil)
+	expect(t, "Refresh after the reset", status, http.StatusUnauthorized)
+	status, _ = s.do(t, s.client, http.MethodPost, "/register", "", map[string]string{"email": "bob@example.com", "password": "correct horse"})
+	expect(t, "Register after the session ended", status, http.StatusCreated)
+	status, _ = s.do(t, s.client, http.MethodPost, "/logout", "", nil)
+	expect(t, "Logout", status, http.StatusNoContent)
+	status, _ = s.do(t, s.client, http.MethodPost, "/login", "", credentials)
+	expect(t, "Login after the session ended", status, http.StatusOK)
+}
+
+func TestRefreshClearsRefusedCookie(t *testing.T) {
+	s := newTestServer(t, nil)
+	status, _ := s.do(t, s.client, http.MethodPost, "/register", "", map[string]string{"email": "alice@example.com", "password": "correct horse"})
+	expect(t, "Register", status, http.StatusCreated)
+
+	//Replays the first refresh token from a second client after it was rotated
+	server, _ := url.Parse(s.URL)
+	replay := newClient(s.Server)
+	replay.Jar.SetCookies(server, s.client.Jar.Cookies(server))
+	status, _ = s.do(t, s.client, http.MethodGet, "/login", "", nil)
+	expect(t, "Refresh", status, http.StatusOK)
+	status, _ = s.do(t, replay, http.MethodGet, "/login", "", nil)
+	expect(t, "Refresh with a rotated token", status, http.StatusUnauthorized)
+
+	if cookies := replay.Jar.Cookies(server); len(cookies) != 0 {
+		t.Errorf("the refused refresh token was kept: %v", cookies)
+	}
+	status, _ = s.do(t, replay, http.MethodGet, "/login", "", nil)
+	expect(t, "Refresh without a cookie", status, http.StatusBadRequest)
+}
+
+func TestLockoutDoesNotRevealAccounts(t *testing.T) {
+	s := newTestServer(t, func(config *Config) {
+		config.Controller.MaxFailedAttempts = 2
+	})
+	status, _ := s.do(t, s.client, http.MethodPost, "/register", "", map[string]string{"email": "alice@example.com", "password": "correct horse"})
+	expect(t, "Register", status, http.StatusCreated)
+
+	for _, email := range []string{"alice@example.com", "nobody@example.com"} {
+		for i := 0; i < 4; i++ {
+			status, _ := s.do(t, newClient(s.Server), http.MethodPost, "/login", "", map[string]string{"email": email, "password": "wrong password"})
+			expect(t, "Login of "+email+" with a wrong password", status, http.StatusUnauthorized)
+		}
+	}
+
+	//Only the correct password learns that the account is locked
+	status, _ = s.do(t, newClient(s.Server), http.MethodPost, "/login", "", map[string]string{"email": "alice@example.com", "password": "correct horse"})
+	expect(t, "Login of a locked account", status, http.StatusTooManyRequests)
+}
+
+func TestResetPasswordFormLiftsLockout(t *testing.T) {
+	s := newTestServer(t, func(config *Config) {
+		config.Controller.MaxFailedAttempts = 2
+	})
+	status, _ := s.do(t, s.client, http.MethodPost, "/register", "", map[string]string{"email": "alice@example.com", "password": "correct horse"})
+	expect(t, "Register", status, http.StatusCreated)
+	for i := 0; i < 2; i++ {
+		s.do(t, newClient(s.Server), http.MethodPost, "/login", "", map[string]string{"email": "alice@example.com", "password": "wrong password"})
+	}
+
+	status, _ = s.do(t, newClient(s.Server), http.MethodPost, "/password/forgot", "", map[string]string{"email": "alice@example.com"})
+	expect(t, "ForgotPassword", status, http.StatusOK)
+	token := s.mail.token(t, "/password/reset")
+
+	//The emailed link opens a form carrying the token
+	client := newClient(s.Server)
+	resp, err := client.Get(s.URL + "/password/reset?token=" + url.QueryEscape(token))
+	if err != nil {
+		t.Fatalf("GET /password/reset: %v", err)
+	}
+	page, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	expect(t, "GET /password/reset", resp.StatusCode, http.StatusOK)
+	if !strings.Contains(string(page), `value="`+token+`"`) {
+		t.Fatalf("the reset form does not carry the token:\n%s", page)
+	}
+
+	resp, err = client.PostForm(s.URL+"/password/reset", url.Values{"token": {token}, "password": {"battery staple"}})
+	if err != nil {
+		t.Fatalf("POST /password/reset: %v", err)
+	}
+	resp.Body.Close()
+	expect(t, "ResetPassword with the form", resp.StatusCode, http.StatusOK)
+
+	status, _ = s.do(t, newClient(s.Server), http.MethodPost, "/login", "", map[string]string{"email": "alice@example.com", "password": "battery staple"})
+	expect(t, "Login after the reset", status, http.StatusOK)
+}
+
+func TestPasswordResetURL(t *testing.T) {
+	s := newTestServer(t, func(config *Config) {
+		config.Controller.PasswordResetURL = "https://app.example.com/reset?lang=en"
+	})
+	status, _ := s.do(t, s.client, http.MethodPost, "/register", "", map[string]string{"email": "alice@example.com", "password": "correct horse"})
+	expect(t, "Register", status, http.StatusCreated)
+	status, _ = s.do(t, newClient(s.Server), http.MethodPost, "/password/forgot", "", map[string]string{"email": "alice@example.com"})
+	expect(t, "ForgotPassword", status, http.StatusOK)
+
+	if !strings.Contains(s.mail.String(), "https://app.example.com/reset?lang=en&token=") {
+		t.Errorf("the reset email does not link to the configured page:\n%s", s.mail.String())
+	}
+}
+
+// register creates a user with a session and returns their ID and access token
+func (s *testServer) register(t *testing.T, email string) (uuid.UUID, string) {
+	t.Helper()
+	status, body := s.do(t, newClient(s.Server), http.MethodPost, "/register", "", map[string]string{"email": email, "password": "correct horse"})
+	expect(t, "Register", status, http.StatusCreated)
+	user, err := s.app.Store.Users.GetByEmail(context.Background(), email)
+	if err != nil {
+		t.Fatalf("GetByEmail: %v", err)
+	}
+	accessToken, _ := body["access_token"].(string)
+	return user.ID, accessToken
+}
+
+// login opens a new session and returns its access token
+func (s *testServer) login(t *testing.T, email string) string {
+	t.Helper()
+	status, body := s.do(t, newClient(s.Server), http.MethodPost, "/login", "", map[string]string{"email": email, "password": "correct horse"})
+	expect(t, "Login", status, http.StatusOK)
+	accessToken, _ := body["access_token"].(string)
+	return accessToken
+}
+
+// setRole changes the role of a user directly in the store
+func (s *testServer) setRole(t *testing.T, id uuid.UUID, role models.Role) {
+	t.Helper()
+	if err := s.app.Store.Users.SetRole(context.Background(), id, role); err != nil {
+		t.Fatalf("SetRole: %v", err)
+	}
+}
+
+func TestLockEndsIssuedAccessTokens(t *testing.T) {
+	s := newTestServer(t, nil)
+	adminID, _ := s.register(t, "admin@example.com")
+	s.setRole(t, adminID, models.RoleAdmin)
+	adminToken := s.login(t, "admin@example.com")
+	userID, userToken := s.register(t, "alice@example.com")
+
+	status, _ := s.do(t, s.client, http.MethodGet, "/todos", userToken, nil)
+	expect(t, "Todos before the lock", status, http.StatusOK)
+	status, _ = s.do(t, s.client, http.MethodPost, "/admin/users/"+userID.String()+"/lock", adminToken, nil)
+	expect(t, "LockUser", status, http.StatusOK)
+	status, _ = s.do(t, s.client, http.MethodGet, "/todos", userToken, nil)
+	expect(t, "Todos with an access token issued before the lock", status, http.StatusUnauthorized)
+
+	status, _ = s.do(t, s.client, http.MethodPost, "/admin/users/"+userID.String()+"/unlock", adminToken, nil)
+	expect(t, "UnlockUser", status, http.StatusOK)
+	status, _ = s.do(t, s.client, http.MethodGet, "/todos", userToken, nil)
+	expect(t, "Todos after the unlock", status, http.StatusOK)
+
+	status, _ = s.do(t, s.client, http.MethodDelete, "/admin/users/"+userID.String(), adminToken, nil)
+	expect(t, "Delete", status, http.StatusNoContent)
+	status, _ = s.do(t, s.client, http.MethodGet, "/todos", userToken, nil)
+	expect(t, "Todos of a deleted user", status, http.StatusUnauthorized)
+}
+
+func TestLockoutKeepsSessions(t *testing.T) {
+	s := newTestServer(t, func(config *Config) {
+		config.Controller.MaxFailedAttempts = 2
+	})
+	_, accessToken := s.register(t, "alice@example.com")
+	for i := 0; i < 2; i++ {
+		s.do(t, newClient(s.Server), http.MethodPost, "/login", "", map[string]string{"email": "alice@example.com", "password": "wrong password"})
+	}
+
+	//Failed logins by someone else do not end the session of the owner
+	status, _ := s.do(t, s.client, http.MethodGet, "/todos", accessToken, nil)
+	expect(t, "Todos during a lockout", status, http.StatusOK)
+}
+
+func TestRoleChangeAppliesToIssuedAccessTokens(t *testing.T) {
+	s := newTestServer(t, nil)
+	adminID, adminToken := s.register(t, "admin@example.com")
+	status, _ := s.do(t, s.client, http.MethodGet, "/admin/users", adminToken, nil)
+	expect(t, "ListUsers as a user", status, http.StatusForbidden)
+
+	//The token issued at registration carries the user role but the stored role is checked
+	s.setRole(t, adminID, models.RoleAdmin)
+	status, _ = s.do(t, s.client, http.MethodGet, "/admin/users", adminToken, nil)
+	expect(t, "ListUsers after the promotion", status, http.StatusOK)
+
+	otherID, otherToken := s.register(t, "bob@example.com")
+	s.setRole(t, otherID, models.RoleAdmin)
+	status, _ = s.do(t, s.client, http.MethodPut, "/admin/users/"+otherID.String()+"/role", adminToken, map[string]string{"role": "user"})
+	expect(t, "SetUserRole", status, http.StatusOK)
+	status, _ = s.do(t, s.client, http.MethodGet, "/admin/users", otherToken, nil)
+	expect(t, "ListUsers after the demotion", status, http.StatusForbidden)
+
+	//An admin cannot demote themselves, which would otherwise be the way to leave no admin
+	status, _ = s.do(t, s.client, http.MethodPut, "/admin/users/"+adminID.String()+"/role", adminToken, map[string]string{"role": "user"})
+	expect(t, "SetUserRole of the caller", status, http.StatusBadRequest)
+	status, _ = s.do(t, s.client, http.MethodGet, "/admin/users", adminToken, nil)
+	expect(t, "ListUsers after refusing the demotion", status, http.StatusOK)
+}
+
+func TestPlusTaggedAccountsAfterStripping(t *testing.T) {
+	s := newTestServer(t, nil)
+	_, accessToken := s.register(t, "alice+news@example.com")
+	if accessToken == "" {
+		t.Fatalf("Register returned no access token")
+	}
+
+	//Enables stripping on a store that already holds the tagged address
+	config := s.app.Config
+	config.Controller.EmailPolicy.StripPlusTag = true
+	s.app.Controller = controllers.NewController(s.app.Hasher, s.app.Store, s.app.Auth, nil, mail.NewWriterMailer(s.mail), config.Controller)
+	stripped := httptest.NewTLSServer(s.app.Handler())
+	t.Cleanup(stripped.Close)
+	s.Server = stripped
+
+	status, _ := s.do(t, newClient(s.Server), http.MethodPost, "/login", "", map[string]string{"email": "alice+news@example.com", "password": "correct horse"})
+	expect(t, "Login with the tagged address", status, http.StatusOK)
+	status, _ = s.do(t, newClient(s.Server), http.MethodPost, "/register", "", map[string]string{"email": "Alice+news@EXAMPLE.com", "password": "correct horse"})
+	expect(t, "Register of the tagged address", status, http.StatusConflict)
+	status, _ = s.do(t, newClient(s.Server), http.MethodPost, "/password/forgot", "", map[string]string{"email": "alice+news@example.com"})
+	expect(t, "ForgotPassword", status, http.StatusOK)
+	s.mail.token(t, "/password/reset")
+}
+
+// passkeyOptions decodes the publicKey options of a ceremony begin response into options
+func passkeyOptions(t *testing.T, body map[string]any, options any) {
+	t.Helper()
+	encoded, err := json.Marshal(body["publicKey"])
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	if err := json.Unmarshal(encoded, options); err != nil {
+		t.Fatalf("Unmarshal options: %v", err)
+	}
+}
+
+func TestPasskeyLoginHidesLockUntilVerified(t *testing.T) {
+	const origin = "https://localhost"
+	s := newTestServer(t, func(config *Config) {
+		config.WebAuthn.Origins = []string{origin}
+	})
+	authenticator := webauthntest.New(origin)
+	id, accessToken := s.register(t, "alice@example.com")
+
+	status, body := s.do(t, s.client, http.MethodPost, "/webauthn/register/begin", accessToken, nil)
+	expect(t, "BeginPasskeyRegistration", status, http.StatusOK)
+	var creation webauthn.CreationOptions
+	passkeyOptions(t, body, &creation)
+	registration, err := authenticator.Register(creation)
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	status, _ = s.do(t, s.client, http.MethodPost, "/webauthn/register/finish", accessToken, registration)
+	expect(t, "FinishPasskeyRegistration", status, http.StatusCreated)
+
+	if err := s.app.Store.Users.Lock(context.Background(), id); err != nil {
+		t.Fatalf("Lock: %v", err)
+	}
+	assert := func() webauthn.AssertionResponse {
+		t.Helper()
+		status, body := s.do(t, newClient(s.Server), http.MethodPost, "/webauthn/login/begin", "", map[string]string{"email": "alice@example.com"})
+		expect(t, "BeginPasskeyLogin", status, http.StatusOK)
+		var request webauthn.RequestOptions
+		passkeyOptions(t, body, &request)
+		assertion, err := authenticator.Login(request)
+		if err != nil {
+			t.Fatalf("Login: %v", err)
+		}
+		return assertion
+	}
+
+	//An assertion that does not verify cannot tell the account is locked
+	forged := assert()
+	forged.Response.Signature[len(forged.Response.Signature)-1] ^= 0xff
+	status, _ = s.do(t, newClient(s.Server), http.MethodPost, "/webauthn/login/finish", "", forged)
+	expect(t, "FinishPasskeyLogin with a bad signature", status, http.StatusUnauthorized)
+
+	status, _ = s.do(t, newClient(s.Server), http.MethodPost, "/webauthn/login/finish", "", assert())
+	expect(t, "FinishPasskeyLogin of a locked account", status, http.StatusTooManyRequests)
+}
+
+// totpCode computes the authenticator code of secret at now the way an authenticator app would
+func totpCode(t *testing.T, secret string, now time.Time) string {
+	t.Helper()
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		t.Fatalf("DecodeString: %v", err)
+	}
+	mac := hmac.New(sha1.New, key)
+	binary.Write(mac, binary.BigEndian, now.Unix()/30)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	return fmt.Sprintf("%06d", (binary.BigEndian.Uint32(sum[offset:offset+4])&0x7fffffff)%1000000)
+}
+
+func TestTOTPEnrollmentRequiresPassword(t *testing.T) {
+	s := newTestServer(t, nil)
+	id, accessToken := s.register(t, "alice@example.com")
+
+	status, _ := s.do(t, s.client, http.MethodPost, "/mfa/totp/setup", accessToken, nil)
+	expect(t, "SetupTOTP without a password", status, http.StatusBadRequest)
+	status, _ = s.do(t, s.client, http.MethodPost, "/mfa/totp/setup", accessToken, map[string]string{"password": "wrong password"})
+	expect(t, "SetupTOTP with a wrong password", status, http.StatusUnauthorized)
+	status, body := s.do(t, s.client, http.MethodPost, "/mfa/totp/setup", accessToken, map[string]string{"password": "correct horse"})
+	expect(t, "SetupTOTP", status, http.StatusOK)
+	secret, _ := body["secret"].(string)
+
+	code := totpCode(t, secret, time.Now())
+	status, _ = s.do(t, s.client, http.MethodPost, "/mfa/totp/confirm", accessToken, map[string]string{"password": "wrong password", "code": code})
+	expect(t, "ConfirmTOTP with a wrong password", status, http.StatusUnauthorized)
+	status, body = s.do(t, s.client, http.MethodPost, "/mfa/totp/confirm", accessToken, map[string]string{"password": "correct horse", "code": code})
+	expect(t, "ConfirmTOTP", status, http.StatusOK)
+	if codes, _ := body["recovery_codes"].([]any); len(codes) == 0 {
+		t.Errorf("ConfirmTOTP returned no recovery codes")
+	}
+
+	enrollment, err := s.app.Store.MFA.GetTOTP(context.Background(), id)
+	if err != nil {
+		t.Fatalf("GetTOTP: %v", err)
+	}
+	if !enrollment.Enabled() {
+		t.Errorf("the authenticator is not enabled after the confirmation")
+	}
+
+	//The wrong passwords counted as failed logins
+	user, err := s.app.Store.Users.GetByID(context.Background(), id)
+	if err != nil {
+		t.Fatalf("GetByID: %v", err)
+	}
+	if user.FailedAttempts != 2 {
+		t.Errorf("FailedAttempts = %d, want 2", user.FailedAttempts)
+	}
+}
+
+func TestUpdateTodoDueDate(t *testing.T) {
+	s := newTestServer(t, nil)
+	_, accessToken := s.register(t, "alice@example.com")
+
+	status, body := s.do(t, s.client, http.MethodPost, "/todos", accessToken, map[string]string{"title": "Write tests", "due_date": "2026-11-01T09:00:00Z"})
+	expect(t, "Create todo", status, http.StatusCreated)
+	id, _ := body["id"].(string)
+	path := "/todos/" + id
+
+	//An omitted due date is left alone
+	status, body = s.do(t, s.client, http.MethodPatch, path, accessToken, map[string]string{"title": "Write more tests"})
+	expect(t, "Update the title", status, http.StatusOK)
+	if body["due_date"] != "2026-11-01T09:00:00Z" {
+		t.Errorf("due_date = %v after updating the title, want it kept", body["due_date"])
+	}
+
+	status, _ = s.do(t, s.client, http.MethodPatch, path, accessToken, map[string]string{"due_date": "next week"})
+	expect(t, "Update with an invalid due date", status, http.StatusBadRequest)
+
+	//An explicit null clears it
+	status, body = s.do(t, s.client, http.MethodPatch, path, accessToken, map[string]any{"due_date": nil})
+	expect(t, "Clear the due date", status, http.StatusOK)
+	if due, ok := body["due_date"]; ok {
+		t.Errorf("due_date = %v after clearing it", due)
+	}
+	status, body = s.do(t, s.client, http.MethodGet, path, accessToken, nil)
+	expect(t, "Get todo", status, http.StatusOK)
+	if due, ok := body["due_date"]; ok {
+		t.Errorf("the cleared due date was stored as %v", due)
+	}
+	if body["title"] != "Write more tests" {
+		t.Errorf("title = %v, want the updated title", body["title"])
+	}
+}
+
+func TestTodoBelongsToOwner(t *testing.T) {
+	s := newTestServer(t, nil)
+	_, aliceToken := s.register(t, "alice@example.com")
+	_, bobToken := s.register(t, "bob@example.com")
+
+	status, body := s.do(t, s.client, http.MethodPost, "/todos", aliceToken, map[string]string{"title": "Alice's todo"})
+	expect(t, "Create todo", status, http.StatusCreated)
+	path := "/todos/" + body["id"].(string)
+
+	//Another user's todo answers like one that does not exist
+	status, _ = s.do(t, s.client, http.MethodGet, path, bobToken, nil)
+	expect(t, "Get of another user's todo", status, http.StatusNotFound)
+	status, _ = s.do(t, s.client, http.MethodPatch, path, bobToken, map[string]string{"title": "Bob's todo"})
+	expect(t, "Update of another user's todo", status, http.StatusNotFound)
+	status, _ = s.do(t, s.client, http.MethodDelete, path, bobToken, nil)
+	expect(t, "Delete of another user's todo", status, http.StatusNotFound)
+	status, _ = s.do(t, s.client, http.MethodGet, "/todos/"+uuid.NewString(), bobToken, nil)
+	expect(t, "Get of an unknown todo", status, http.StatusNotFound)
+	status, _ = s.do(t, s.client, http.MethodGet, "/todos/not-an-id", bobToken, nil)
+	expect(t, "Get with an invalid id", status, http.StatusNotFound)
+
+	status, body = s.do(t, s.client, http.MethodGet, path, aliceToken, nil)
+	expect(t, "Get todo", status, http.StatusOK)
+	if body["title"] != "Alice's todo" {
+		t.Errorf("title = %v, want the todo unchanged", body["title"])
+	}
+	status, _ = s.do(t, s.client, http.MethodDelete, path, aliceToken, nil)
+	expect(t, "Delete todo", status, http.StatusNoContent)
+}
+
+// enrollTOTP sets up and confirms an authenticator for the user of accessToken
+// it returns the secret and the recovery codes
+func (s *testServer) enrollTOTP(t *testing.T, accessToken string) (string, []string) {
+	t.Helper()
+	status, body := s.do(t, s.client, http.MethodPost, "/mfa/totp/setup", accessToken, map[string]string{"password": "correct horse"})
+	expect(t, "SetupTOTP", status, http.StatusOK)
+	secret, _ := body["secret"].(string)
+
+	status, body = s.do(t, s.client, http.MethodPost, "/mfa/totp/confirm", accessToken, map[string]string{"password": "correct horse", "code": totpCode(t, secret, time.Now())})
+	expect(t, "ConfirmTOTP", status, http.StatusOK)
+	var codes []string
+	raw, _ := body["recovery_codes"].([]any)
+	for _, code := range raw {
+		codes = append(codes, code.(string))
+	}
+	if len(codes) == 0 {
+		t.Fatalf("ConfirmTOTP returned no recovery codes")
+	}
+	return secret, codes
+}
+
+// mfaChallenge logs in with the password of an account with an authenticator and returns the challenge token
+func (s *testServer) mfaChallenge(t *testing.T, email string) string {
+	t.Helper()
+	status, body := s.do(t, newClient(s.Server), http.MethodPost, "/login", "", map[string]string{"email": email, "password": "correct horse"})
+	expect(t, "Login", status, http.StatusOK)
+	if body["message"] != "mfa_required" || body["access_token"] != nil {
+		t.Fatalf("Login answered %v, want a challenge without a session", body)
+	}
+	token, _ := body["mfa_token"].(string)
+	return token
+}
+
+func TestLoginMFA(t *testing.T) {
+	s := newTestServer(t, nil)
+	_, accessToken := s.register(t, "alice@example.com")
+	secret, recoveryCodes := s.enrollTOTP(t, accessToken)
+
+	//The confirmation used the current step, so the next one is the first code a login can use
+	code := totpCode(t, secret, time.Now().Add(30*time.Second))
+	status, body := s.do(t, newClient(s.Server), http.MethodPost, "/login/mfa", "", map[string]string{"mfa_token": s.mfaChallenge(t, "alice@example.com"), "code": code})
+	expect(t, "LoginMFA with a code", status, http.StatusOK)
+	session, _ := body["access_token"].(string)
+	status, _ = s.do(t, s.client, http.MethodGet, "/todos", session, nil)
+	expect(t, "List todos with the MFA session", status, http.StatusOK)
+
+	//A code is only good once, even with a new challenge
+	status, _ = s.do(t, newClient(s.Server), http.MethodPost, "/login/mfa", "", map[string]string{"mfa_token": s.mfaChallenge(t, "alice@example.com"), "code": code})
+	expect(t, "LoginMFA with a used code", status, http.StatusUnauthorized)
+
+	//So is a recovery code, which can be typed in capitals
+	challenge := s.mfaChallenge(t, "alice@example.com")
+	status, _ = s.do(t, newClient(s.Server), http.MethodPost, "/login/mfa", "", map[string]string{"mfa_token": challenge, "recovery_code": strings.ToUpper(recoveryCodes[0])})
+	expect(t, "LoginMFA with a recovery code", status, http.StatusOK)
+	status, _ = s.do(t, newClient(s.Server), http.MethodPost, "/login/mfa", "", map[string]string{"mfa_token": s.mfaChallenge(t, "alice@example.com"), "recovery_code": recoveryCodes[0]})
+	expect(t, "LoginMFA with a used recovery code", status, http.StatusUnauthorized)
+
+	status, _ = s.do(t, newClient(s.Server), http.MethodPost, "/login/mfa", "", map[string]string{"mfa_token": challenge + "x", "recovery_code": recoveryCodes[1]})
+	expect(t, "LoginMFA with an invalid challenge", status, http.StatusUnauthorized)
+	status, _ = s.do(t, newClient(s.Server), http.MethodPost, "/login/mfa", "", map[string]string{"mfa_token": accessToken, "recovery_code": recoveryCodes[1]})
+	expect(t, "LoginMFA with an access token as the challenge", status, http.StatusUnauthorized)
+	status, _ = s.do(t, newClient(s.Server), http.MethodPost, "/login/mfa", "", map[string]string{"mfa_token": challenge, "code": code, "recovery_code": recoveryCodes[1]})
+	expect(t, "LoginMFA with both a code and a recovery code", status, http.StatusBadRequest)
+}
+
+func TestLoginMFALockout(t *testing.T) {
+	s := newTestServer(t, func(config *Config) {
+		config.Controller.MaxFailedAttempts = 2
+	})
+	_, accessToken := s.register(t, "alice@example.com")
+	s.enrollTOTP(t, accessToken)
+
+	//Wrong codes count as failed logins, and the lock is reported to the holder of the challenge
+	challenge := s.mfaChallenge(t, "alice@example.com")
+	status, _ := s.do(t, newClient(s.Server), http.MethodPost, "/login/mfa", "", map[string]string{"mfa_token": challenge, "code": "000000"})
+	expect(t, "LoginMFA with a wrong code", status, http.StatusUnauthorized)
+	status, _ = s.do(t, newClient(s.Server), http.MethodPost, "/login/mfa", "", map[string]string{"mfa_token": challenge, "code": "000001"})
+	expect(t, "LoginMFA locking the account", status, http.StatusTooManyRequests)
+	status, _ = s.do(t, newClient(s.Server), http.MethodPost, "/login/mfa", "", map[string]string{"mfa_token": challenge, "recovery_code": "anything"})
+	expect(t, "LoginMFA of a locked account", status, http.StatusTooManyRequests)
+}
@@ -0,0 +1,131 @@
+package app
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"joshuamURD/go-auth-api/pkgs/auth"
+	"joshuamURD/go-auth-api/pkgs/hash"
+	"joshuamURD/go-auth-api/pkgs/password"
+)
+
+// ConfigFromEnv returns the default config adjusted by the environment
+//
+//	DB_DRIVER, DATABASE_URL          use PostgreSQL instead of the test.db SQLite file
+//	DB_DRIVER=memory                 keep everything in memory, lost when the server stops
+//	JWT_ALGORITHM                    RS256 (default), ES256 or EdDSA
+//	KEY_ENCRYPTION_KEY(_FILE)        base64 encoded 32 byte key the private keys are encrypted with
+//	KEY_PASSPHRASE                   passphrase the private keys are encrypted with if there is no key
+//	REQUIRE_KEY_ENCRYPTION=true      refuse to start with plaintext private keys
+//	REQUIRE_VERIFIED_EMAIL=true      refuse logins to unverified accounts
+//	BASE_URL                         public address of the server, used in the links of emails
+//	PASSWORD_RESET_URL               front end page the password reset email links to instead of the built-in form
+//	STRIP_PLUS_ADDRESSING=true       treat user+tag@example.com as user@example.com
+//	PASSWORD_HASH                    argon2id (default) or bcrypt, the other is still accepted on login
+//	BCRYPT_COST                      cost of new bcrypt hashes
+//	ARGON2_MEMORY_KIB, ARGON2_TIME, ARGON2_PARALLELISM  parameters of new Argon2id hashes
+//	PASSWORD_PEPPERS(_FILE)          version:base64-secret pairs, comma separated with the current one first
+//	PASSWORD_MIN_LENGTH              minimum number of characters in a new password
+//	BREACHED_PASSWORDS_DIR           directory of SHA-1 range files of breached passwords to refuse
+//	WEBAUTHN_RP_ID                   domain passkeys are registered for, localhost by default
+//	WEBAUTHN_ORIGINS                 comma separated origins allowed to use passkeys, e.g. https://example.com
+//	WEBAUTHN_REQUIRE_UV=true         refuse passkeys used without a PIN or biometric
+func ConfigFromEnv() (Config, error) {
+	config := DefaultConfig()
+
+	if driver := os.Getenv("DB_DRIVER"); driver != "" {
+		config.DB.Driver = driver
+	}
+	config.DB.DSN = os.Getenv("DATABASE_URL")
+
+	algorithm, err := auth.ParseAlgorithm(os.Getenv("JWT_ALGORITHM"))
+	if err != nil {
+		return config, fmt.Errorf("invalid JWT_ALGORITHM: %w", err)
+	}
+
+	//KEY_ENCRYPTION_KEY takes precedence over KEY_PASSPHRASE
+	kek, err := auth.LoadKEK(os.Getenv("KEY_ENCRYPTION_KEY"), os.Getenv("KEY_ENCRYPTION_KEY_FILE"))
+	if err != nil {
+		return config, fmt.Errorf("failed to load key-encryption key: %w", err)
+	}
+
+	//Retired keys are accepted for longer than the longest token lifetime
+	config.Keys = auth.KeyManagerConfig{
+		Dir:                  "keys",
+		Algorithm:            algorithm,
+		LegacyPrivateKeyPath: "private.pem",
+		RotationInterval:     30 * 24 * time.Hour,
+		VerificationPeriod:   8 * 24 * time.Hour,
+		Encryption: auth.KeyEncryption{
+			KEK:        kek,
+			Passphrase: os.Getenv("KEY_PASSPHRASE"),
+			Required:   os.Getenv("REQUIRE_KEY_ENCRYPTION") == "true",
+		},
+	}
+
+	config.Controller.RequireVerifiedEmail = os.Getenv("REQUIRE_VERIFIED_EMAIL") == "true"
+	if baseURL := os.Getenv("BASE_URL"); baseURL != "" {
+		config.Controller.BaseURL = strings.TrimSuffix(baseURL, "/")
+	}
+	config.Controller.PasswordResetURL = os.Getenv("PASSWORD_RESET_URL")
+	config.Controller.EmailPolicy.StripPlusTag = os.Getenv("STRIP_PLUS_ADDRESSING") == "true"
+
+	//Stored hashes made with other settings are upgraded on the next login
+	if config.Hash.Algorithm, err = hash.ParseAlgorithm(os.Getenv("PASSWORD_HASH")); err != nil {
+		return config, fmt.Errorf("invalid PASSWORD_HASH: %w", err)
+	}
+	if config.Hash.Peppers, err = hash.LoadPeppers(os.Getenv("PASSWORD_PEPPERS"), os.Getenv("PASSWORD_PEPPERS_FILE")); err != nil {
+		return config, fmt.Errorf("failed to load password peppers: %w", err)
+	}
+	if err := envInt("BCRYPT_COST", &config.Hash.BcryptCost, math.MaxInt32); err != nil {
+		return config, err
+	}
+	if err := envInt("ARGON2_MEMORY_KIB", &config.Hash.Argon2id.Memory, math.MaxUint32); err != nil {
+		return config, err
+	}
+	if err := envInt("ARGON2_TIME", &config.Hash.Argon2id.Time, math.MaxUint32); err != nil {
+		return config, err
+	}
+	if err := envInt("ARGON2_PARALLELISM", &config.Hash.Argon2id.Parallelism, math.MaxUint8); err != nil {
+		return config, err
+	}
+
+	if err := envInt("PASSWORD_MIN_LENGTH", &config.Controller.PasswordPolicy.MinLength, math.MaxInt32); err != nil {
+		return config, err
+	}
+	if dir := os.Getenv("BREACHED_PASSWORDS_DIR"); dir != "" {
+		config.Controller.PasswordPolicy.Breached = password.NewPrefixDir(dir)
+	}
+
+	if rpID := os.Getenv("WEBAUTHN_RP_ID"); rpID != "" {
+		config.WebAuthn.RPID = rpID
+	}
+	if origins := os.Getenv("WEBAUTHN_ORIGINS"); origins != "" {
+		config.WebAuthn.Origins = nil
+		for _, origin := range strings.Split(origins, ",") {
+			config.WebAuthn.Origins = append(config.WebAuthn.Origins, strings.TrimSpace(origin))
+		}
+	}
+	config.WebAuthn.RequireUserVerification = os.Getenv("WEBAUTHN_REQUIRE_UV") == "true"
+
+	return config, nil
+}
+
+// envInt sets *dst to the positive integer in the environment variable name, if it is set
+// values above limit are rejected
+func envInt[T int | uint8 | uint32](name string, dst *T, limit uint64) error {
+	value := os.Getenv(name)
+	if value == "" {
+		return nil
+	}
+	n, err := strconv.ParseUint(value, 10, 64)
+	if err != nil || n == 0 || n > limit {
+		return fmt.Errorf("invalid %s: %q must be a whole number between 1 and %d", name, value, limit)
+	}
+	*dst = T(n)
+	return nil
+}
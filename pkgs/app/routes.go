@@ -0,0 +1,47 @@
+package app
+
+import (
+	"net/http"
+
+	"joshuamURD/go-auth-api/pkgs/auth"
+	"joshuamURD/go-auth-api/pkgs/models"
+)
+
+// Handler returns the routes of the API
+func (a *App) Handler() http.Handler {
+	c := a.Controller
+
+	//Protected routes require a valid access token in the Authorization header of a user that is not locked
+	//and admin routes additionally require the admin role
+	requireAuth := auth.RequireAuth(a.Auth, a.Store.Users)
+	requireAdmin := func(h http.HandlerFunc) http.Handler {
+		return requireAuth(auth.RequireRole(models.RoleAdmin)(h))
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/jwks.json", a.Auth.JWKS)
+	mux.HandleFunc("/register", c.Register)
+	mux.HandleFunc("/login", c.Login)
+	mux.HandleFunc("/login/mfa", c.LoginMFA)
+	mux.HandleFunc("/logout", c.Logout)
+	mux.HandleFunc("/logout/all", c.LogoutAll)
+	mux.HandleFunc("/verify", c.Verify)
+	mux.HandleFunc("/password/forgot", c.ForgotPassword)
+	mux.HandleFunc("/password/reset", c.ResetPassword)
+	mux.HandleFunc("/webauthn/login/begin", c.BeginPasskeyLogin)
+	mux.HandleFunc("/webauthn/login/finish", c.FinishPasskeyLogin)
+	mux.Handle("/mfa/totp/setup", requireAuth(http.HandlerFunc(c.SetupTOTP)))
+	mux.Handle("/mfa/totp/confirm", requireAuth(http.HandlerFunc(c.ConfirmTOTP)))
+	mux.Handle("/webauthn/register/begin", requireAuth(http.HandlerFunc(c.BeginPasskeyRegistration)))
+	mux.Handle("/webauthn/register/finish", requireAuth(http.HandlerFunc(c.FinishPasskeyRegistration)))
+	mux.Handle("/todos", requireAuth(http.HandlerFunc(c.Todos)))
+	mux.Handle("/todos/{id}", requireAuth(http.HandlerFunc(c.Todo)))
+	mux.Handle("/admin/users", requireAdmin(c.ListUsers))
+	mux.Handle("/admin/users/{id}", requireAdmin(c.User))
+	mux.Handle("/admin/users/{id}/role", requireAdmin(c.SetUserRole))
+	mux.Handle("/admin/users/{id}/lock", requireAdmin(c.LockUser))
+	mux.Handle("/admin/users/{id}/unlock", requireAdmin(c.UnlockUser))
+	mux.Handle("/admin/users/{id}/verify", requireAdmin(c.VerifyUser))
+	mux.Handle("/admin/users/{id}/reset-attempts", requireAdmin(c.ResetUserAttempts))
+	return mux
+}
@@ -2,44 +2,88 @@ package auth
 
 import (
 	"context"
-	"crypto/rsa"
 	"errors"
 	"fmt"
 	"net/http"
 	"time"
 
+	"joshuamURD/go-auth-api/pkgs/db"
+	"joshuamURD/go-auth-api/pkgs/models"
+
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 )
 
 // AuthService is an interface that defines the methods for the authentication service
 type AuthService interface {
 	// Authenticate creates authentication state for a user and handles the response
-	Authenticate(ctx context.Context, userID string, w http.ResponseWriter) (*AuthResponse, error)
-	// Refresh updates the authentication state
-	RefreshAuth(ctx context.Context, refreshToken string) (*AuthResponse, error)
+	Authenticate(ctx context.Context, claims AuthClaims, w http.ResponseWriter) (*AuthResponse, error)
+	// Refresh rotates the authentication state and handles the response
+	RefreshAuth(ctx context.Context, refreshToken string, w http.ResponseWriter) (*AuthResponse, error)
+	// Validate parses a token and returns the claims it carries
+	Validate(tokenString string) (*JWTClaims, error)
+	// HasSession reports whether a refresh token belongs to a session that has not ended
+	HasSession(ctx context.Context, refreshToken string) (bool, error)
+	// Revoke ends the session the refresh token belongs to, or every session of its user, and clears the cookie
+	Revoke(ctx context.Context, refreshToken string, scope RevokeScope, w http.ResponseWriter) error
+	// RevokeUser ends every session of a user, e.g. after their password changed
+	RevokeUser(ctx context.Context, userID string) error
+	// IssueMFAChallenge returns a short-lived token stating that a user's password was checked,
+	// which is exchanged together with a second factor for a session
+	IssueMFAChallenge(userID string) (string, time.Time, error)
+	// ValidateMFAChallenge returns the user ID carried by a challenge token
+	ValidateMFAChallenge(token string) (string, error)
 }
 
+// RevokeScope selects which sessions Revoke ends
+type RevokeScope int
+
+const (
+	// RevokeCurrent ends only the session the refresh token belongs to
+	RevokeCurrent RevokeScope = iota
+	// RevokeAll ends every session of the user the refresh token belongs to
+	RevokeAll
+)
+
+const (
+	// accessTokenTTL is how long an access token is valid for
+	accessTokenTTL = 15 * time.Minute
+	// refreshTokenTTL is how long a refresh token is valid for
+	refreshTokenTTL = 7 * 24 * time.Hour
+	// mfaChallengeTTL is how long a user has to enter their second factor after their password
+	mfaChallengeTTL = 5 * time.Minute
+)
+
+var (
+	// ErrInvalidRefreshToken is returned when a refresh token is unknown, expired or revoked
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	// ErrRefreshTokenReused is returned when a refresh token that was already rotated is presented again
+	// the whole token family is revoked when this happens
+	ErrRefreshTokenReused = errors.New("refresh token reuse detected")
+	// ErrInvalidMFAChallenge is returned when an MFA challenge token is malformed, expired or of another type
+	ErrInvalidMFAChallenge = errors.New("invalid mfa challenge")
+)
+
 // AuthClaims represents generic authentication claims
 type AuthClaims struct {
 	UserID string
+	Role   models.Role
 	// Add other generic claims as needed
 }
 
-// RSAKeys holds the public and private keys for JWT signing
-type RSAKeys struct {
-	privateKey *rsa.PrivateKey
-	publicKey  *rsa.PublicKey
-}
-
 // JWTAuthService implements AuthService using JWT
+// refresh tokens are persisted in a RefreshTokenRepository so they can be rotated and revoked
 type JWTAuthService struct {
-	keys RSAKeys
+	keys   KeySource
+	tokens db.RefreshTokenRepository
 }
 
 // JWTClaims struct is used to store the JWT claims
+// the refresh token jti is carried in RegisteredClaims.ID
 type JWTClaims struct {
-	UserID string `json:"user_id"`
-	Type   string `json:"type"` // "access" or "refresh"
+	UserID string      `json:"user_id"`
+	Role   models.Role `json:"role,omitempty"`
+	Type   string      `json:"type"` // "access", "refresh" or "mfa_challenge"
 	jwt.RegisteredClaims
 }
 
@@ -49,25 +93,40 @@ type AuthResponse struct {
 	ExpiresAt   time.Time `json:"expires_at"`
 }
 
-// NewJWTAuthService creates a new JWT authentication service with RSA keys
-func NewJWTAuthService(privateKey *rsa.PrivateKey) *JWTAuthService {
+// NewJWTAuthService creates a new JWT authentication service with a source of RSA keys
+// and a repository used to track issued refresh tokens
+func NewJWTAuthService(keys KeySource, tokens db.RefreshTokenRepository) *JWTAuthService {
 	return &JWTAuthService{
-		keys: RSAKeys{
-			privateKey: privateKey,
-			publicKey:  &privateKey.PublicKey,
-		},
+		keys:   keys,
+		tokens: tokens,
 	}
 }
 
 // Authenticate generates JWTs, both access and refresh tokens
-// It takes a context and a user ID and returns the access and refresh tokens
-func (j *JWTAuthService) Authenticate(ctx context.Context, userID string, w http.ResponseWriter) (*AuthResponse, error) {
-	// Generate access token (short-lived)
+// It takes a context and the claims of a user and returns the access token, setting the refresh token as a cookie
+// Each call starts a new refresh token family
+func (j *JWTAuthService) Authenticate(ctx context.Context, claims AuthClaims, w http.ResponseWriter) (*AuthResponse, error) {
+	uid, err := uuid.Parse(claims.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid user id: %w", err)
+	}
+
+	if err := j.issueRefreshToken(ctx, w, uid, claims.Role, uuid.NewString(), uuid.NewString()); err != nil {
+		return nil, err
+	}
+
+	return j.issueAccessToken(claims.UserID, claims.Role)
+}
+
+// issueAccessToken generates a short-lived access token for a user
+func (j *JWTAuthService) issueAccessToken(userID string, role models.Role) (*AuthResponse, error) {
+	expiresAt := time.Now().Add(accessTokenTTL)
 	accessClaims := JWTClaims{
 		UserID: userID,
+		Role:   role,
 		Type:   "access",
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(15 * time.Minute)),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 		},
 	}
@@ -77,59 +136,130 @@ func (j *JWTAuthService) Authenticate(ctx context.Context, userID string, w http
 		return nil, fmt.Errorf("failed to generate access token: %w", err)
 	}
 
-	// Generate refresh token (longer-lived)
+	// Return access token in response body
+	return &AuthResponse{
+		AccessToken: accessToken,
+		ExpiresAt:   expiresAt,
+	}, nil
+}
+
+// issueRefreshToken generates a refresh token with the given jti in the given family,
+// persists it and sets it in an HTTP-only cookie
+// the role is carried along so that rotated access tokens keep it, sessions are revoked when it changes
+func (j *JWTAuthService) issueRefreshToken(ctx context.Context, w http.ResponseWriter, userID uuid.UUID, role models.Role, familyID, jti string) error {
+	now := time.Now()
+	expiresAt := now.Add(refreshTokenTTL)
+
 	refreshClaims := JWTClaims{
-		UserID: userID,
+		UserID: userID.String(),
+		Role:   role,
 		Type:   "refresh",
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(7 * 24 * time.Hour)),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ID:        jti,
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			IssuedAt:  jwt.NewNumericDate(now),
 		},
 	}
 
 	refreshToken, err := j.generateToken(refreshClaims)
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate refresh token: %w", err)
+		return fmt.Errorf("failed to generate refresh token: %w", err)
+	}
+
+	if err := j.tokens.Create(ctx, models.RefreshToken{
+		ID:        jti,
+		FamilyID:  familyID,
+		UserID:    userID,
+		ExpiresAt: expiresAt,
+		CreatedAt: now,
+	}); err != nil {
+		return fmt.Errorf("failed to store refresh token: %w", err)
 	}
 
 	// Set only refresh token in HTTP-only cookie
+	setRefreshCookie(w, refreshToken, expiresAt)
+
+	return nil
+}
+
+// setRefreshCookie sets the refresh token in an HTTP-only cookie
+// the cookie is sent to every route since login, register and logout all read it
+func setRefreshCookie(w http.ResponseWriter, refreshToken string, expiresAt time.Time) {
 	http.SetCookie(w, &http.Cookie{
 		Name:     "refresh_token",
 		Value:    refreshToken,
-		Expires:  time.Now().Add(7 * 24 * time.Hour),
+		Expires:  expiresAt,
 		HttpOnly: true,
 		Secure:   true,
 		SameSite: http.SameSiteStrictMode,
-		Path:     "/auth/refresh", // Restrict to refresh endpoint
+		Path:     "/",
 	})
+}
 
-	// Return access token in response body
-	return &AuthResponse{
-		AccessToken: accessToken,
-		ExpiresAt:   time.Now().Add(15 * time.Minute),
-	}, nil
+// clearRefreshCookie instructs the client to delete the refresh token cookie
+func clearRefreshCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     "refresh_token",
+		Value:    "",
+		MaxAge:   -1,
+		Expires:  time.Unix(0, 0),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+		Path:     "/",
+	})
 }
 
 // generateToken helper function to create signed tokens
+// the token is signed with the active key using that key's algorithm
 func (j *JWTAuthService) generateToken(claims JWTClaims) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
-	if j.keys.privateKey == nil {
-		return "", errors.New("private key is not initialized")
+	key, err := j.keys.SigningKey()
+	if err != nil {
+		return "", err
 	}
 
+	method, err := key.Algorithm.SigningMethod()
+	if err != nil {
+		return "", err
+	}
+
+	// Identify the key so that verifiers can pick it from the JWKS
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = key.ID
+
 	// Sign the token with the private key
-	return token.SignedString(j.keys.privateKey)
+	return token.SignedString(key.PrivateKey)
 }
 
 // Validate validates the JWT token and returns the claims
 func (j *JWTAuthService) Validate(tokenString string) (*JWTClaims, error) {
 
 	token, err := jwt.ParseWithClaims(tokenString, &JWTClaims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
+		var key VerificationKey
+
+		// Tokens issued before key IDs were added carry no kid and were signed with the active key
+		kid, ok := token.Header["kid"].(string)
+		if ok {
+			// Select the key by kid so tokens signed before a rotation still verify
+			verificationKey, err := j.keys.VerificationKey(kid)
+			if err != nil {
+				return nil, err
+			}
+			key = verificationKey
+		} else {
+			signingKey, err := j.keys.SigningKey()
+			if err != nil {
+				return nil, err
+			}
+			key = VerificationKey{ID: signingKey.ID, Algorithm: signingKey.Algorithm, PublicKey: signingKey.PrivateKey.Public()}
+		}
+
+		// The algorithm comes from the key, never from the token, so a token cannot pick a weaker one
+		if token.Method.Alg() != string(key.Algorithm) {
 			return nil, errors.New("unexpected signing method")
 		}
-		return j.keys.publicKey, nil
-	})
+		return key.PublicKey, nil
+	}, jwt.WithValidMethods([]string{string(RS256), string(ES256), string(EdDSA)}))
 
 	if err != nil {
 		return nil, err
@@ -142,37 +272,146 @@ func (j *JWTAuthService) Validate(tokenString string) (*JWTClaims, error) {
 	return nil, errors.New("invalid token")
 }
 
-// Refresh generates a new access token using a valid refresh token
-func (j *JWTAuthService) RefreshAuth(ctx context.Context, refreshToken string) (*AuthResponse, error) {
-	claims, err := j.Validate(refreshToken)
+// RefreshAuth rotates a valid refresh token
+// The presented token is revoked and replaced by a new one in the same family, which is set as a cookie,
+// and a new access token is returned. Presenting a token that was already rotated revokes the whole family.
+// The cookie is cleared whenever the token is refused, so the client does not keep sending a dead session.
+func (j *JWTAuthService) RefreshAuth(ctx context.Context, refreshToken string, w http.ResponseWriter) (*AuthResponse, error) {
+	claims, stored, err := j.loadRefreshToken(ctx, refreshToken)
+	if errors.Is(err, ErrInvalidRefreshToken) {
+		clearRefreshCookie(w)
+		return nil, err
+	}
 	if err != nil {
 		return nil, err
 	}
 
+	// A token that has already been used is being replayed, so the family is compromised
+	if stored.Revoked() {
+		clearRefreshCookie(w)
+		return nil, j.revokeFamily(ctx, stored.FamilyID)
+	}
+
+	// Revoke the presented token first so that a concurrent refresh with the same token loses
+	newID := uuid.NewString()
+	if err := j.tokens.Revoke(ctx, stored.ID, newID); err != nil {
+		if errors.Is(err, db.ErrRefreshTokenRevoked) {
+			clearRefreshCookie(w)
+			return nil, j.revokeFamily(ctx, stored.FamilyID)
+		}
+		return nil, fmt.Errorf("failed to revoke refresh token: %w", err)
+	}
+
+	if err := j.issueRefreshToken(ctx, w, stored.UserID, claims.Role, stored.FamilyID, newID); err != nil {
+		return nil, err
+	}
+
+	return j.issueAccessToken(claims.UserID, claims.Role)
+}
+
+// HasSession reports whether a refresh token is valid and has not been revoked
+func (j *JWTAuthService) HasSession(ctx context.Context, refreshToken string) (bool, error) {
+	_, stored, err := j.loadRefreshToken(ctx, refreshToken)
+	if errors.Is(err, ErrInvalidRefreshToken) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return !stored.Revoked(), nil
+}
+
+// loadRefreshToken checks the signature and type of a refresh token and loads its stored record
+// it returns ErrInvalidRefreshToken when the token is malformed, expired or unknown
+func (j *JWTAuthService) loadRefreshToken(ctx context.Context, refreshToken string) (*JWTClaims, models.RefreshToken, error) {
+	claims, err := j.Validate(refreshToken)
+	if err != nil {
+		return nil, models.RefreshToken{}, ErrInvalidRefreshToken
+	}
+
 	// Ensure the token is a refresh token
-	if claims.Type != "refresh" {
-		return nil, errors.New("invalid token type")
+	if claims.Type != "refresh" || claims.ID == "" {
+		return nil, models.RefreshToken{}, ErrInvalidRefreshToken
 	}
 
-	// Generate new access token
-	accessClaims := JWTClaims{
-		UserID: claims.UserID,
-		Type:   "access",
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(15 * time.Minute)), // 15 minutes
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-		},
+	stored, err := j.tokens.GetByID(ctx, claims.ID)
+	if errors.Is(err, db.ErrRefreshTokenNotFound) {
+		return nil, models.RefreshToken{}, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, models.RefreshToken{}, fmt.Errorf("failed to load refresh token: %w", err)
 	}
+	return claims, stored, nil
+}
 
-	accessToken, err := j.generateToken(accessClaims)
+// revokeFamily revokes a compromised token family and reports the reuse
+func (j *JWTAuthService) revokeFamily(ctx context.Context, familyID string) error {
+	if err := j.tokens.RevokeFamily(ctx, familyID); err != nil {
+		return fmt.Errorf("failed to revoke refresh token family: %w", err)
+	}
+	return ErrRefreshTokenReused
+}
+
+// Revoke ends sessions using a refresh token to identify them
+// RevokeCurrent revokes the token family the refresh token belongs to and RevokeAll revokes
+// every refresh token of its user. The cookie is cleared even when the token is invalid.
+func (j *JWTAuthService) Revoke(ctx context.Context, refreshToken string, scope RevokeScope, w http.ResponseWriter) error {
+	clearRefreshCookie(w)
+
+	_, stored, err := j.loadRefreshToken(ctx, refreshToken)
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate access token: %w", err)
+		return err
 	}
 
-	authResponse := AuthResponse{
-		AccessToken: accessToken,
-		ExpiresAt:   time.Now().Add(15 * time.Minute),
+	switch scope {
+	case RevokeAll:
+		if err := j.tokens.RevokeAllForUser(ctx, stored.UserID); err != nil {
+			return fmt.Errorf("failed to revoke refresh tokens: %w", err)
+		}
+	default:
+		if err := j.tokens.RevokeFamily(ctx, stored.FamilyID); err != nil {
+			return fmt.Errorf("failed to revoke refresh token family: %w", err)
+		}
+	}
+	return nil
+}
+
+// RevokeUser revokes every refresh token of a user
+func (j *JWTAuthService) RevokeUser(ctx context.Context, userID string) error {
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return fmt.Errorf("invalid user id: %w", err)
 	}
 
-	return &authResponse, nil
+	if err := j.tokens.RevokeAllForUser(ctx, uid); err != nil {
+		return fmt.Errorf("failed to revoke refresh tokens: %w", err)
+	}
+	return nil
+}
+
+// IssueMFAChallenge generates a challenge token for a user whose password was correct
+// the token carries no role and is refused by RequireAuth, so it grants nothing on its own
+func (j *JWTAuthService) IssueMFAChallenge(userID string) (string, time.Time, error) {
+	expiresAt := time.Now().Add(mfaChallengeTTL)
+	token, err := j.generateToken(JWTClaims{
+		UserID: userID,
+		Type:   "mfa_challenge",
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+	})
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to generate mfa challenge: %w", err)
+	}
+	return token, expiresAt, nil
+}
+
+// ValidateMFAChallenge validates a challenge token and returns the user it was issued to
+func (j *JWTAuthService) ValidateMFAChallenge(token string) (string, error) {
+	claims, err := j.Validate(token)
+	if err != nil || claims.Type != "mfa_challenge" || claims.UserID == "" {
+		return "", ErrInvalidMFAChallenge
+	}
+	return claims.UserID, nil
 }
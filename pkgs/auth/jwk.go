@@ -0,0 +1,128 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+)
+
+// JWK is a JSON Web Key as defined in RFC 7517
+// only the members needed to publish public signing keys are included
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Kid string `json:"kid,omitempty"`
+	// RSA members
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// EC and OKP members
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKSet is a set of JSON Web Keys as served from a JWKS endpoint
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// NewJWK returns the public JWK for a key used with the given algorithm, identified by its thumbprint
+func NewJWK(publicKey crypto.PublicKey, alg Algorithm) (JWK, error) {
+	jwk := JWK{
+		Use: "sig",
+		Alg: string(alg),
+	}
+
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(key.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes())
+	case *ecdsa.PublicKey:
+		ecdhKey, err := key.ECDH()
+		if err != nil {
+			return JWK{}, err
+		}
+		// The uncompressed point is 0x04 followed by the fixed size X and Y coordinates
+		point := ecdhKey.Bytes()
+		size := (len(point) - 1) / 2
+		jwk.Kty = "EC"
+		jwk.Crv = key.Curve.Params().Name
+		jwk.X = base64.RawURLEncoding.EncodeToString(point[1 : 1+size])
+		jwk.Y = base64.RawURLEncoding.EncodeToString(point[1+size:])
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(key)
+	default:
+		return JWK{}, fmt.Errorf("unsupported public key type %T", publicKey)
+	}
+
+	jwk.Kid = jwk.Thumbprint()
+	return jwk, nil
+}
+
+// Thumbprint returns the RFC 7638 SHA-256 thumbprint of the key, base64url encoded
+// it only depends on the required public members, so it is stable for a given key
+func (k JWK) Thumbprint() string {
+	// The required members of each key type in lexicographic order with no whitespace
+	var canonical []byte
+	switch k.Kty {
+	case "EC":
+		canonical, _ = json.Marshal(struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{k.Crv, k.Kty, k.X, k.Y})
+	case "OKP":
+		canonical, _ = json.Marshal(struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{k.Crv, k.Kty, k.X})
+	default:
+		canonical, _ = json.Marshal(struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{k.E, k.Kty, k.N})
+	}
+
+	sum := sha256.Sum256(canonical)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// JWKS serves the public keys used to verify tokens issued by this service
+// it is meant to be mounted at /.well-known/jwks.json
+func (j *JWTAuthService) JWKS(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Retired keys are published too, so tokens signed before a rotation can still be verified
+	publicKeys := j.keys.PublicKeys()
+	set := JWKSet{Keys: make([]JWK, 0, len(publicKeys))}
+	for _, key := range publicKeys {
+		jwk, err := NewJWK(key.PublicKey, key.Algorithm)
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		jwk.Kid = key.ID
+		set.Keys = append(set.Keys, jwk)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	json.NewEncoder(w).Encode(set)
+}
@@ -0,0 +1,206 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"joshuamURD/go-auth-api/pkgs/models"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// fetchJWKS gets the key set served by service the way a verifier would
+func fetchJWKS(t *testing.T, service *JWTAuthService) JWKSet {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	service.JWKS(rec, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("JWKS returned %d", rec.Code)
+	}
+	if rec.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("Content-Type = %q", rec.Header().Get("Content-Type"))
+	}
+	var set JWKSet
+	if err := json.NewDecoder(rec.Body).Decode(&set); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	return set
+}
+
+// decodeCoordinate decodes a base64url JWK member, failing when it is not size bytes long
+func decodeCoordinate(t *testing.T, value string, size int) []byte {
+	t.Helper()
+	b, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		t.Fatalf("DecodeString(%q): %v", value, err)
+	}
+	if size > 0 && len(b) != size {
+		t.Errorf("member %q is %d bytes, want %d", value, len(b), size)
+	}
+	return b
+}
+
+// publicKeyFromJWK rebuilds the public key a verifier would read from jwk
+func publicKeyFromJWK(t *testing.T, jwk JWK) crypto.PublicKey {
+	t.Helper()
+	switch jwk.Kty {
+	case "RSA":
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(decodeCoordinate(t, jwk.N, 0)),
+			E: int(new(big.Int).SetBytes(decodeCoordinate(t, jwk.E, 0)).Int64()),
+		}
+	case "EC":
+		if jwk.Crv != "P-256" {
+			t.Fatalf("crv = %q, want P-256", jwk.Crv)
+		}
+		return &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(decodeCoordinate(t, jwk.X, 32)),
+			Y:     new(big.Int).SetBytes(decodeCoordinate(t, jwk.Y, 32)),
+		}
+	case "OKP":
+		if jwk.Crv != "Ed25519" {
+			t.Fatalf("crv = %q, want Ed25519", jwk.Crv)
+		}
+		return ed25519.PublicKey(decodeCoordinate(t, jwk.X, ed25519.PublicKeySize))
+	default:
+		t.Fatalf("unexpected kty %q", jwk.Kty)
+		return nil
+	}
+}
+
+// verifyWithJWKS checks a token against the key of set its header names, as a verifier without the ring would
+func verifyWithJWKS(t *testing.T, set JWKSet, token string) error {
+	t.Helper()
+	_, err := jwt.ParseWithClaims(token, &JWTClaims{}, func(token *jwt.Token) (interface{}, error) {
+		for _, jwk := range set.Keys {
+			if jwk.Kid == token.Header["kid"] && jwk.Alg == token.Method.Alg() {
+				return publicKeyFromJWK(t, jwk), nil
+			}
+		}
+		return nil, jwt.ErrTokenUnverifiable
+	})
+	return err
+}
+
+func TestJWKSPublishesSigningKeys(t *testing.T) {
+	tests := []struct {
+		alg Algorithm
+		kty string
+	}{
+		{RS256, "RSA"},
+		{ES256, "EC"},
+		{EdDSA, "OKP"},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.alg), func(t *testing.T) {
+			km := newTestKeyManager(t, tt.alg)
+			service := NewJWTAuthService(km, nil)
+			resp, err := service.issueAccessToken("alice", models.RoleUser)
+			if err != nil {
+				t.Fatalf("issueAccessToken: %v", err)
+			}
+
+			set := fetchJWKS(t, service)
+			if len(set.Keys) != 1 {
+				t.Fatalf("JWKS has %d keys, want 1", len(set.Keys))
+			}
+			jwk := set.Keys[0]
+			if jwk.Kid != activeKid(t, km) || jwk.Kty != tt.kty || jwk.Alg != string(tt.alg) || jwk.Use != "sig" {
+				t.Errorf("JWKS key %+v, want kid %s, kty %s and alg %s for signing", jwk, activeKid(t, km), tt.kty, tt.alg)
+			}
+
+			//The header kid picks the published key, which verifies the token without access to the ring
+			token, _, err := jwt.NewParser().ParseUnverified(resp.AccessToken, &JWTClaims{})
+			if err != nil {
+				t.Fatalf("ParseUnverified: %v", err)
+			}
+			if token.Header["kid"] != jwk.Kid {
+				t.Errorf("header kid = %v, want the published kid %s", token.Header["kid"], jwk.Kid)
+			}
+			if err := verifyWithJWKS(t, set, resp.AccessToken); err != nil {
+				t.Errorf("the published key does not verify the token: %v", err)
+			}
+		})
+	}
+}
+
+func TestJWKSPublishesRetiredKeys(t *testing.T) {
+	km := newTestKeyManager(t, ES256)
+	service := NewJWTAuthService(km, nil)
+	before, err := service.issueAccessToken("alice", models.RoleUser)
+	if err != nil {
+		t.Fatalf("issueAccessToken: %v", err)
+	}
+	retired := activeKid(t, km)
+
+	if err := km.Rotate(); err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+	after, err := service.issueAccessToken("alice", models.RoleUser)
+	if err != nil {
+		t.Fatalf("issueAccessToken: %v", err)
+	}
+
+	set := fetchJWKS(t, service)
+	kids := map[string]bool{}
+	for _, jwk := range set.Keys {
+		kids[jwk.Kid] = true
+	}
+	if len(set.Keys) != 2 || !kids[retired] || !kids[activeKid(t, km)] {
+		t.Fatalf("JWKS has keys %v, want the retired %s and the active %s", kids, retired, activeKid(t, km))
+	}
+	for name, token := range map[string]string{"before the rotation": before.AccessToken, "after the rotation": after.AccessToken} {
+		if err := verifyWithJWKS(t, set, token); err != nil {
+			t.Errorf("the token issued %s does not verify with the JWKS: %v", name, err)
+		}
+	}
+}
+
+func TestJWKECCoordinatesKeepLeadingZeros(t *testing.T) {
+	//A coordinate below 2^248 starts with a zero byte, which big.Int.Bytes would drop
+	for i := 0; i < 10000; i++ {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatalf("GenerateKey: %v", err)
+		}
+		if len(key.X.Bytes()) == 32 && len(key.Y.Bytes()) == 32 {
+			continue
+		}
+
+		jwk, err := NewJWK(&key.PublicKey, ES256)
+		if err != nil {
+			t.Fatalf("NewJWK: %v", err)
+		}
+		rebuilt := publicKeyFromJWK(t, jwk).(*ecdsa.PublicKey)
+		if !rebuilt.Equal(&key.PublicKey) {
+			t.Errorf("the JWK does not give back the key")
+		}
+		return
+	}
+	t.Fatalf("no key with a short coordinate was generated")
+}
+
+func TestJWKThumbprint(t *testing.T) {
+	//The example of RFC 7638 section 3.1
+	jwk := JWK{
+		Kty: "RSA",
+		N:   "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+		E:   "AQAB",
+		Alg: "RS256",
+		Kid: "2011-04-29",
+	}
+	if got, want := jwk.Thumbprint(), "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs"; got != want {
+		t.Errorf("Thumbprint = %s, want %s", got, want)
+	}
+}
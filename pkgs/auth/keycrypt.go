@@ -0,0 +1,195 @@
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// sealedKeyType is the PEM block type of a private key encrypted by KeyEncryption
+const sealedKeyType = "SEALED PRIVATE KEY"
+
+// scrypt parameters used to derive a key from a passphrase, they are stored with each
+// sealed key so they can be raised later without breaking existing files
+const (
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+// ErrPlaintextKey is returned when an unencrypted private key is found while encryption is required
+var ErrPlaintextKey = errors.New("plaintext private key found while key encryption is required")
+
+// KeyEncryption holds the secret private key files are encrypted with at rest
+// keys are sealed with AES-256-GCM under either the KEK or a key derived from the passphrase with scrypt
+type KeyEncryption struct {
+	// KEK is a 32 byte key-encryption key, it takes precedence over the passphrase
+	KEK []byte
+	// Passphrase is used to derive a key-encryption key when no KEK is set
+	Passphrase string
+	// Required refuses to load any private key that is stored in plaintext
+	Required bool
+}
+
+// Enabled reports whether new keys are written encrypted
+func (e KeyEncryption) Enabled() bool {
+	return len(e.KEK) > 0 || e.Passphrase != ""
+}
+
+// Validate checks the configuration is usable
+func (e KeyEncryption) Validate() error {
+	if len(e.KEK) > 0 && len(e.KEK) != 32 {
+		return fmt.Errorf("key-encryption key must be 32 bytes, got %d", len(e.KEK))
+	}
+	if e.Required && !e.Enabled() {
+		return errors.New("key encryption is required but no passphrase or key-encryption key is configured")
+	}
+	return nil
+}
+
+// LoadKEK reads a base64 encoded 32 byte key-encryption key from an environment variable,
+// or from a file when the variable is empty. It returns nil when neither is set.
+func LoadKEK(envValue, path string) ([]byte, error) {
+	encoded := envValue
+	if encoded == "" && path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read key-encryption key file: %w", err)
+		}
+		encoded = strings.TrimSpace(string(data))
+	}
+	if encoded == "" {
+		return nil, nil
+	}
+
+	kek, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("key-encryption key is not valid base64: %w", err)
+	}
+	if len(kek) != 32 {
+		return nil, fmt.Errorf("key-encryption key must be 32 bytes, got %d", len(kek))
+	}
+	return kek, nil
+}
+
+// seal encrypts a DER encoded private key into a PEM block
+func (e KeyEncryption) seal(der []byte) (*pem.Block, error) {
+	headers := map[string]string{"Cipher": "AES-256-GCM"}
+
+	var key []byte
+	if len(e.KEK) > 0 {
+		headers["Kdf"] = "kek"
+		key = e.KEK
+	} else {
+		salt := make([]byte, 16)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, err
+		}
+		derived, err := scrypt.Key([]byte(e.Passphrase), salt, scryptN, scryptR, scryptP, 32)
+		if err != nil {
+			return nil, err
+		}
+		headers["Kdf"] = "scrypt"
+		headers["Salt"] = base64.StdEncoding.EncodeToString(salt)
+		headers["Scrypt-Params"] = fmt.Sprintf("%d,%d,%d", scryptN, scryptR, scryptP)
+		key = derived
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	headers["Nonce"] = base64.StdEncoding.EncodeToString(nonce)
+
+	// The KDF is authenticated so a file cannot be switched to a different key derivation
+	return &pem.Block{
+		Type:    sealedKeyType,
+		Headers: headers,
+		Bytes:   gcm.Seal(nil, nonce, der, []byte(headers["Kdf"])),
+	}, nil
+}
+
+// open decrypts a PEM block written by seal and returns the DER encoded private key
+func (e KeyEncryption) open(block *pem.Block) ([]byte, error) {
+	var key []byte
+	switch kdf := block.Headers["Kdf"]; kdf {
+	case "kek":
+		if len(e.KEK) == 0 {
+			return nil, errors.New("private key is encrypted with a key-encryption key but none is configured")
+		}
+		key = e.KEK
+	case "scrypt":
+		if e.Passphrase == "" {
+			return nil, errors.New("private key is encrypted with a passphrase but none is configured")
+		}
+		salt, err := base64.StdEncoding.DecodeString(block.Headers["Salt"])
+		if err != nil {
+			return nil, fmt.Errorf("invalid salt: %w", err)
+		}
+		n, r, p, err := parseScryptParams(block.Headers["Scrypt-Params"])
+		if err != nil {
+			return nil, err
+		}
+		if key, err = scrypt.Key([]byte(e.Passphrase), salt, n, r, p, 32); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported key derivation %q", kdf)
+	}
+
+	nonce, err := base64.StdEncoding.DecodeString(block.Headers["Nonce"])
+	if err != nil {
+		return nil, fmt.Errorf("invalid nonce: %w", err)
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != gcm.NonceSize() {
+		return nil, errors.New("invalid nonce")
+	}
+
+	der, err := gcm.Open(nil, nonce, block.Bytes, []byte(block.Headers["Kdf"]))
+	if err != nil {
+		return nil, errors.New("failed to decrypt private key, the passphrase or key-encryption key is wrong")
+	}
+	return der, nil
+}
+
+// newGCM returns an AES-256-GCM AEAD for the key
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// parseScryptParams parses the "N,r,p" scrypt parameters header
+func parseScryptParams(value string) (n, r, p int, err error) {
+	parts := strings.Split(value, ",")
+	if len(parts) != 3 {
+		return 0, 0, 0, fmt.Errorf("invalid scrypt parameters %q", value)
+	}
+	params := make([]int, 3)
+	for i, part := range parts {
+		if params[i], err = strconv.Atoi(part); err != nil {
+			return 0, 0, 0, fmt.Errorf("invalid scrypt parameters %q", value)
+		}
+	}
+	return params[0], params[1], params[2], nil
+}
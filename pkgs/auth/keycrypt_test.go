@@ -0,0 +1,196 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestKeyEncryptionSealOpen(t *testing.T) {
+	der := []byte("a private key in DER")
+	kek := bytes.Repeat([]byte{1}, 32)
+
+	tests := []struct {
+		name  string
+		seal  KeyEncryption
+		open  KeyEncryption
+		valid bool
+	}{
+		{"key-encryption key", KeyEncryption{KEK: kek}, KeyEncryption{KEK: kek}, true},
+		{"passphrase", KeyEncryption{Passphrase: "passphrase"}, KeyEncryption{Passphrase: "passphrase"}, true},
+		{"key-encryption key preferred over the passphrase", KeyEncryption{KEK: kek, Passphrase: "passphrase"}, KeyEncryption{KEK: kek}, true},
+		{"wrong passphrase", KeyEncryption{Passphrase: "passphrase"}, KeyEncryption{Passphrase: "another passphrase"}, false},
+		{"wrong key-encryption key", KeyEncryption{KEK: kek}, KeyEncryption{KEK: bytes.Repeat([]byte{2}, 32)}, false},
+		{"passphrase file opened with a key-encryption key", KeyEncryption{Passphrase: "passphrase"}, KeyEncryption{KEK: kek}, false},
+		{"no secret configured", KeyEncryption{KEK: kek}, KeyEncryption{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			block, err := tt.seal.seal(der)
+			if err != nil {
+				t.Fatalf("seal: %v", err)
+			}
+			if block.Type != sealedKeyType || bytes.Contains(block.Bytes, der) {
+				t.Fatalf("seal did not encrypt the key: %+v", block)
+			}
+
+			//The block goes through PEM as it would on disk
+			decoded, _ := pem.Decode(pem.EncodeToMemory(block))
+			opened, err := tt.open.open(decoded)
+			if tt.valid && (err != nil || !bytes.Equal(opened, der)) {
+				t.Errorf("open = %q, %v, want the sealed key", opened, err)
+			}
+			if !tt.valid && err == nil {
+				t.Errorf("open succeeded with the wrong secret")
+			}
+		})
+	}
+}
+
+func TestKeyEncryptionRejectsTampering(t *testing.T) {
+	encryption := KeyEncryption{KEK: bytes.Repeat([]byte{1}, 32)}
+	tests := []struct {
+		name   string
+		tamper func(block *pem.Block)
+	}{
+		{"ciphertext", func(block *pem.Block) { block.Bytes[0] ^= 1 }},
+		{"nonce", func(block *pem.Block) { block.Headers["Nonce"] = "AAAAAAAAAAAAAAAA" }},
+		{"key derivation", func(block *pem.Block) { block.Headers["Kdf"] = "scrypt" }},
+		{"unknown key derivation", func(block *pem.Block) { block.Headers["Kdf"] = "none" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			block, err := encryption.seal([]byte("a private key in DER"))
+			if err != nil {
+				t.Fatalf("seal: %v", err)
+			}
+			tt.tamper(block)
+			if _, err := (KeyEncryption{KEK: encryption.KEK, Passphrase: "passphrase"}).open(block); err == nil {
+				t.Errorf("open accepted a block with a changed %s", tt.name)
+			}
+		})
+	}
+}
+
+func TestKeyEncryptionValidate(t *testing.T) {
+	tests := []struct {
+		name       string
+		encryption KeyEncryption
+		valid      bool
+	}{
+		{"disabled", KeyEncryption{}, true},
+		{"passphrase", KeyEncryption{Passphrase: "passphrase", Required: true}, true},
+		{"key-encryption key", KeyEncryption{KEK: make([]byte, 32), Required: true}, true},
+		{"short key-encryption key", KeyEncryption{KEK: make([]byte, 16)}, false},
+		{"required without a secret", KeyEncryption{Required: true}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.encryption.Validate(); (err == nil) != tt.valid {
+				t.Errorf("Validate = %v, want valid %v", err, tt.valid)
+			}
+		})
+	}
+}
+
+// writePlaintextKey writes a new ES256 key to path the way keys were stored before encryption
+func writePlaintextKey(t *testing.T, path string) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("MarshalECPrivateKey: %v", err)
+	}
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	return key
+}
+
+func TestLoadPrivateKeyRefusesPlaintextWhenRequired(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "private.pem")
+	writePlaintextKey(t, path)
+
+	if _, plaintext, err := loadPrivateKey(path, KeyEncryption{Passphrase: "passphrase"}); err != nil || !plaintext {
+		t.Errorf("loadPrivateKey = %v, plaintext %v, want the key reported as plaintext", err, plaintext)
+	}
+	if _, _, err := loadPrivateKey(path, KeyEncryption{Passphrase: "passphrase", Required: true}); !errors.Is(err, ErrPlaintextKey) {
+		t.Errorf("loadPrivateKey returned %v, want ErrPlaintextKey", err)
+	}
+}
+
+func TestEnsureKeysImportsLegacyKey(t *testing.T) {
+	tests := []struct {
+		name       string
+		encryption KeyEncryption
+		removed    bool
+	}{
+		{"into an encrypted ring", KeyEncryption{Passphrase: "passphrase"}, true},
+		{"into a plaintext ring", KeyEncryption{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			legacyPath := filepath.Join(t.TempDir(), "private.pem")
+			legacy := writePlaintextKey(t, legacyPath)
+			km := NewKeyManager(KeyManagerConfig{
+				Dir:                  t.TempDir(),
+				Algorithm:            ES256,
+				LegacyPrivateKeyPath: legacyPath,
+				RotationInterval:     time.Hour,
+				VerificationPeriod:   time.Hour,
+				Encryption:           tt.encryption,
+			})
+			if err := km.EnsureKeys(); err != nil {
+				t.Fatalf("EnsureKeys: %v", err)
+			}
+
+			jwk, err := NewJWK(&legacy.PublicKey, ES256)
+			if err != nil {
+				t.Fatalf("NewJWK: %v", err)
+			}
+			if activeKid(t, km) != jwk.Kid {
+				t.Errorf("the active key is %s, want the legacy key %s", activeKid(t, km), jwk.Kid)
+			}
+			ringData, err := os.ReadFile(km.keyPath(jwk.Kid))
+			if err != nil {
+				t.Fatalf("ReadFile: %v", err)
+			}
+			if block, _ := pem.Decode(ringData); (block.Type == sealedKeyType) != tt.encryption.Enabled() {
+				t.Errorf("the ring stores the key as %s", block.Type)
+			}
+
+			_, err = os.Stat(legacyPath)
+			if removed := errors.Is(err, os.ErrNotExist); removed != tt.removed {
+				t.Errorf("the legacy key file was removed: %v, want %v", removed, tt.removed)
+			}
+		})
+	}
+}
+
+func TestEnsureKeysRefusesPlaintextLegacyKeyWhenRequired(t *testing.T) {
+	legacyPath := filepath.Join(t.TempDir(), "private.pem")
+	writePlaintextKey(t, legacyPath)
+	km := NewKeyManager(KeyManagerConfig{
+		Dir:                  t.TempDir(),
+		Algorithm:            ES256,
+		LegacyPrivateKeyPath: legacyPath,
+		Encryption:           KeyEncryption{Passphrase: "passphrase", Required: true},
+	})
+	if err := km.EnsureKeys(); !errors.Is(err, ErrPlaintextKey) {
+		t.Errorf("EnsureKeys returned %v, want ErrPlaintextKey", err)
+	}
+	if _, err := os.Stat(legacyPath); err != nil {
+		t.Errorf("the refused legacy key file was touched: %v", err)
+	}
+}
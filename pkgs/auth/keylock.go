@@ -0,0 +1,53 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// lockFile is the name of the file that marks the ring as being changed by one instance
+const lockFile = "ring.lock"
+
+// ringLockStale is how old a lock file must be before it is taken to be left over by an instance that died
+// changing the ring takes at most a few seconds, even with a new RSA key and scrypt encryption
+const ringLockStale = time.Minute
+
+// ringLockPoll is how often a waiting instance checks whether the lock was released
+const ringLockPoll = 50 * time.Millisecond
+
+// errRingLocked is returned by lockRing when another instance holds the lock for longer than the wait
+var errRingLocked = errors.New("key ring is locked by another instance")
+
+// lockRing takes the lock on the ring in dir by creating the lock file exclusively, waiting up to wait for it
+// it returns the function that releases the lock
+// the lock works across processes and hosts sharing the directory, unlike an advisory file lock on some network file systems
+func lockRing(dir string, wait time.Duration) (func(), error) {
+	path := filepath.Join(dir, lockFile)
+	deadline := time.Now().Add(wait)
+
+	for {
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err == nil {
+			fmt.Fprintf(file, "%d\n", os.Getpid())
+			file.Close()
+			return func() { os.Remove(path) }, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("failed to lock key ring: %w", err)
+		}
+
+		// A lock that is too old was left behind by an instance that died while holding it
+		if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > ringLockStale {
+			os.Remove(path)
+			continue
+		}
+
+		if !time.Now().Before(deadline) {
+			return nil, errRingLocked
+		}
+		time.Sleep(ringLockPoll)
+	}
+}
@@ -1,141 +1,734 @@
 package auth
 
 import (
-	"crypto/rand"
-	"crypto/rsa"
+	"bytes"
+	"context"
+	"crypto"
 	"crypto/x509"
+	"encoding/json"
 	"encoding/pem"
 	"errors"
 	"fmt"
+	"log"
 	"os"
+	"path/filepath"
+	"slices"
 	"sync"
+	"time"
 )
 
-// KeyManager handles RSA key operations and caching
+// ringFile is the name of the manifest describing the keys in the ring directory
+const ringFile = "ring.json"
+
+// rotationCheckInterval is how often StartRotation checks whether the active key is due for rotation
+const rotationCheckInterval = time.Minute
+
+// missReloadInterval is the least time between two reloads of the ring caused by an unknown key ID
+// it keeps tokens with made up key IDs from making every request read the ring
+const missReloadInterval = 10 * time.Second
+
+// ringLockWait is how long EnsureKeys and Rotate wait for another instance to finish changing the ring
+const ringLockWait = 30 * time.Second
+
+// KeySource provides the keys used to sign and verify tokens
+type KeySource interface {
+	// SigningKey returns the key new tokens are signed with
+	SigningKey() (SigningKey, error)
+	// VerificationKey returns the public key with the given key ID if it is still accepted
+	VerificationKey(kid string) (VerificationKey, error)
+	// PublicKeys returns every public key that is still accepted
+	PublicKeys() []VerificationKey
+}
+
+// KeyManagerConfig holds the settings of a KeyManager
+type KeyManagerConfig struct {
+	// Dir is the directory the key ring is persisted in
+	Dir string
+	// Algorithm is the algorithm new signing keys are generated for, RS256 when empty
+	// changing it rotates to a key of the new algorithm on the next start
+	Algorithm Algorithm
+	// LegacyPrivateKeyPath is a single private key file from before key rings existed
+	// it is imported as the first key of an empty ring so that outstanding tokens stay valid
+	LegacyPrivateKeyPath string
+	// RotationInterval is how long a key is used for signing before a new one replaces it
+	// zero disables scheduled rotation
+	RotationInterval time.Duration
+	// VerificationPeriod is how long a retired key is still accepted for verification
+	// it should be at least as long as the longest token lifetime
+	VerificationPeriod time.Duration
+	// Encryption encrypts the key files at rest when a passphrase or key-encryption key is set
+	Encryption KeyEncryption
+}
+
+// ringKey is a single key in the ring
+type ringKey struct {
+	kid        string
+	alg        Algorithm
+	privateKey crypto.Signer
+	createdAt  time.Time
+	retiredAt  *time.Time
+	// plaintext is set when the key was loaded from an unencrypted file
+	plaintext bool
+}
+
+// ringManifest is the on-disk description of the ring, the keys themselves live in one PEM file each
+type ringManifest struct {
+	Active string             `json:"active"`
+	Keys   []ringManifestItem `json:"keys"`
+}
+
+// ringManifestItem describes one key in the manifest
+type ringManifestItem struct {
+	Kid       string     `json:"kid"`
+	Alg       Algorithm  `json:"alg"`
+	File      string     `json:"file"`
+	CreatedAt time.Time  `json:"created_at"`
+	RetiredAt *time.Time `json:"retired_at,omitempty"`
+}
+
+// KeyManager handles signing key operations and caching
+// it manages a ring with one active signing key and the retired keys that still verify tokens
 type KeyManager struct {
-	privateKey     *rsa.PrivateKey
-	privateKeyPath string
-	publicKeyPath  string
-	mu             sync.RWMutex
+	config KeyManagerConfig
+	keys   []ringKey
+	active string
+	// manifest is the content of ring.json as last read or written, used to tell whether it changed
+	manifest []byte
+	mu       sync.RWMutex
+	// reloadMu serializes reloads, which decrypt keys without holding mu
+	reloadMu sync.Mutex
+	// lastMissReload is when an unknown key ID last caused a reload, guarded by missMu
+	lastMissReload time.Time
+	missMu         sync.Mutex
 }
 
 // NewKeyManager creates a new KeyManager instance
-func NewKeyManager(privateKeyPath, publicKeyPath string) *KeyManager {
+func NewKeyManager(config KeyManagerConfig) *KeyManager {
+	if config.Algorithm == "" {
+		config.Algorithm = RS256
+	}
 	return &KeyManager{
-		privateKeyPath: privateKeyPath,
-		publicKeyPath:  publicKeyPath,
+		config: config,
 	}
 }
 
-// EnsureKeys checks if keys exist and generates them if they don't
+// EnsureKeys loads the ring from disk and creates the first key if it is empty
+// the first key is imported from the legacy private key file when there is one
 func (km *KeyManager) EnsureKeys() error {
-	// First check without lock
-	if km.privateKey != nil {
+	if err := km.config.Encryption.Validate(); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(km.config.Dir, 0700); err != nil {
+		return fmt.Errorf("failed to create key directory: %w", err)
+	}
+
+	// Instances starting together would otherwise each create a first key and overwrite the others' ring
+	unlock, err := lockRing(km.config.Dir, ringLockWait)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	km.mu.Lock()
+	defer km.mu.Unlock()
+
+	if err := km.load(); err != nil {
+		return fmt.Errorf("failed to load key ring: %w", err)
+	}
+
+	if len(km.keys) == 0 {
+		key, importedPlaintext, err := km.initialKey()
+		if err != nil {
+			return err
+		}
+		km.keys = []ringKey{key}
+		km.active = key.kid
+		if err := km.save(); err != nil {
+			return err
+		}
+		// The legacy file holds the same key unencrypted, which would defeat encrypting the ring
+		if importedPlaintext && km.config.Encryption.Enabled() {
+			km.removeLegacyKey()
+		}
 		return nil
 	}
 
-	// Check if private key exists before acquiring lock
-	keyExists := false
-	if _, err := os.Stat(km.privateKeyPath); err == nil {
-		keyExists = true
+	// Encrypt keys that were written before encryption was configured
+	if err := km.sealPlaintextLocked(); err != nil {
+		return err
+	}
+
+	// Switch to a key of the configured algorithm, the old keys keep verifying until they are pruned
+	if active, ok := km.activeKeyLocked(); !ok || active.alg != km.config.Algorithm {
+		log.Printf("Rotating signing key to %s", km.config.Algorithm)
+		return km.rotateLocked()
 	}
 
-	// Generate keys if needed - do this outside the lock
-	if !keyExists {
-		if err := km.generateRSAKeys(); err != nil {
-			return fmt.Errorf("failed to generate RSA keys: %w", err)
+	if km.pruneLocked(time.Now()) {
+		return km.save()
+	}
+	return nil
+}
+
+// initialKey returns the first key of a new ring and whether it was imported from a plaintext legacy key file
+func (km *KeyManager) initialKey() (ringKey, bool, error) {
+	if km.config.LegacyPrivateKeyPath != "" {
+		privateKey, plaintext, err := loadPrivateKey(km.config.LegacyPrivateKeyPath, km.config.Encryption)
+		if err == nil {
+			key, err := newRingKey(privateKey)
+			// A legacy key of another algorithm is not imported, its tokens cannot be verified anyway
+			if err == nil && key.alg == km.config.Algorithm {
+				log.Printf("Imported legacy private key %s into the key ring", km.config.LegacyPrivateKeyPath)
+				return key, plaintext, nil
+			}
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return ringKey{}, false, fmt.Errorf("failed to load legacy private key: %w", err)
 		}
 	}
 
-	// Now acquire lock only for updating the in-memory key
+	key, err := km.generateKey()
+	return key, false, err
+}
+
+// removeLegacyKey deletes the plaintext legacy key file once its key is saved encrypted in the ring
+// a file that cannot be removed is only logged, the ring itself is already usable
+func (km *KeyManager) removeLegacyKey() {
+	path := km.config.LegacyPrivateKeyPath
+	if err := os.Remove(path); err != nil {
+		log.Printf("WARNING: the plaintext legacy private key %s was imported into the encrypted key ring but could not be removed, delete it by hand: %v", path, err)
+		return
+	}
+	log.Printf("Removed the plaintext legacy private key %s now that the key ring holds it encrypted", path)
+}
+
+// generateKey generates a new key of the configured algorithm
+func (km *KeyManager) generateKey() (ringKey, error) {
+	privateKey, err := km.config.Algorithm.GenerateKey()
+	if err != nil {
+		return ringKey{}, fmt.Errorf("failed to generate %s key: %w", km.config.Algorithm, err)
+	}
+	return newRingKey(privateKey)
+}
+
+// Rotate generates a new signing key and retires the current one
+// the retired key keeps verifying tokens for the configured verification period
+func (km *KeyManager) Rotate() error {
+	unlock, err := lockRing(km.config.Dir, ringLockWait)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// Starts from the ring on disk so that a rotation by another instance is not overwritten
+	if err := km.reload(); err != nil {
+		return fmt.Errorf("failed to reload key ring: %w", err)
+	}
+	return km.rotateUnlocked()
+}
+
+// rotateLocked is Rotate for callers that already hold the lock
+func (km *KeyManager) rotateLocked() error {
+	key, err := km.generateKey()
+	if err != nil {
+		return err
+	}
+	return km.addKeyLocked(key)
+}
+
+// rotateUnlocked is Rotate for callers that hold the lock on disk but not km.mu
+// the key is generated and written before km.mu is taken, so signing and verifying are not held up meanwhile
+func (km *KeyManager) rotateUnlocked() error {
+	key, err := km.generateKey()
+	if err != nil {
+		return err
+	}
+	if err := savePrivateKey(km.keyPath(key.kid), key.privateKey, km.config.Encryption); err != nil {
+		return fmt.Errorf("failed to save key %s: %w", key.kid, err)
+	}
+
 	km.mu.Lock()
 	defer km.mu.Unlock()
+	return km.addKeyLocked(key)
+}
 
-	// Double-check if key was loaded by another goroutine
-	if km.privateKey != nil {
+// addKeyLocked makes key the active key, retiring the current one, and saves the ring
+// the caller must hold the lock
+func (km *KeyManager) addKeyLocked(key ringKey) error {
+	now := time.Now()
+	for i := range km.keys {
+		if km.keys[i].kid == km.active {
+			km.keys[i].retiredAt = &now
+		}
+	}
+
+	km.keys = append(km.keys, key)
+	km.active = key.kid
+	km.pruneLocked(now)
+
+	return km.save()
+}
+
+// StartRotation rotates the signing key whenever it is older than the rotation interval
+// it blocks until ctx is cancelled, so it is meant to be run in its own goroutine
+// the ring is reloaded from disk when it changed so that instances sharing the directory stay in step
+func (km *KeyManager) StartRotation(ctx context.Context) {
+	if km.config.RotationInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(rotationCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := km.rotateIfDue(time.Now()); err != nil {
+				log.Printf("Key rotation failed: %v", err)
+			}
+		}
+	}
+}
+
+// rotateIfDue reloads the ring if it changed on disk and rotates the active key if it has reached the rotation interval
+// the ring is only locked on disk when it has to be changed, and only one instance sharing the directory changes it
+func (km *KeyManager) rotateIfDue(now time.Time) error {
+	if err := km.reload(); err != nil {
+		return fmt.Errorf("failed to reload key ring: %w", err)
+	}
+	if rotate, prune := km.maintenanceDue(now); !rotate && !prune {
 		return nil
 	}
 
-	// Load the private key
-	privateKey, err := km.LoadPrivateKey()
+	unlock, err := lockRing(km.config.Dir, 0)
+	if errors.Is(err, errRingLocked) {
+		// Another instance is changing the ring, the next reload picks up its result
+		return nil
+	}
 	if err != nil {
-		return fmt.Errorf("failed to load private key: %w", err)
+		return err
 	}
+	defer unlock()
 
-	km.privateKey = privateKey
+	// Another instance may have rotated between the reload and taking the lock
+	if err := km.reload(); err != nil {
+		return fmt.Errorf("failed to reload key ring: %w", err)
+	}
+	rotate, prune := km.maintenanceDue(now)
+	if rotate {
+		if err := km.rotateUnlocked(); err != nil {
+			return err
+		}
+		log.Printf("Rotated signing key")
+		return nil
+	}
+	if prune {
+		km.mu.Lock()
+		defer km.mu.Unlock()
+		if km.pruneLocked(now) {
+			return km.save()
+		}
+	}
 	return nil
 }
 
-// GetPublicKeyPEM returns the PEM encoded public key
-func (km *KeyManager) GetPublicKeyPEM() ([]byte, error) {
+// maintenanceDue reports whether the active key has reached the rotation interval
+// and whether any retired key has passed its verification period
+func (km *KeyManager) maintenanceDue(now time.Time) (rotate bool, prune bool) {
+	km.mu.RLock()
+	defer km.mu.RUnlock()
+
+	active, ok := km.activeKeyLocked()
+	rotate = !ok || now.Sub(active.createdAt) >= km.config.RotationInterval
+	for _, key := range km.keys {
+		if key.kid != km.active && !km.acceptedLocked(key, now) {
+			prune = true
+		}
+	}
+	return rotate, prune
+}
+
+// SigningKey returns the active key
+func (km *KeyManager) SigningKey() (SigningKey, error) {
+	km.mu.RLock()
+	defer km.mu.RUnlock()
+
+	key, ok := km.activeKeyLocked()
+	if !ok {
+		return SigningKey{}, errors.New("private key not loaded")
+	}
+	return SigningKey{ID: key.kid, Algorithm: key.alg, PrivateKey: key.privateKey}, nil
+}
+
+// VerificationKey returns the public key with the given key ID
+// retired keys are accepted until their verification period has passed
+// an unknown key ID reloads the ring first, at most once per missReloadInterval, since another
+// instance sharing the directory may have rotated to a key this one has not read yet
+func (km *KeyManager) VerificationKey(kid string) (VerificationKey, error) {
+	if key, ok := km.acceptedKey(kid); ok {
+		return key, nil
+	}
+	if km.reloadAfterMiss(time.Now()) {
+		if key, ok := km.acceptedKey(kid); ok {
+			return key, nil
+		}
+	}
+	return VerificationKey{}, errors.New("unknown key id")
+}
+
+// acceptedKey returns the public key with the given key ID if it is still accepted
+func (km *KeyManager) acceptedKey(kid string) (VerificationKey, bool) {
+	km.mu.RLock()
+	defer km.mu.RUnlock()
+
+	now := time.Now()
+	for _, key := range km.keys {
+		if key.kid == kid && km.acceptedLocked(key, now) {
+			return key.verificationKey(), true
+		}
+	}
+	return VerificationKey{}, false
+}
+
+// reloadAfterMiss reloads the ring unless an unknown key ID already did within missReloadInterval
+// it reports whether the ring was reloaded
+func (km *KeyManager) reloadAfterMiss(now time.Time) bool {
+	km.missMu.Lock()
+	if now.Sub(km.lastMissReload) < missReloadInterval {
+		km.missMu.Unlock()
+		return false
+	}
+	km.lastMissReload = now
+	km.missMu.Unlock()
+
+	if err := km.reload(); err != nil {
+		log.Printf("Failed to reload key ring: %v", err)
+		return false
+	}
+	return true
+}
+
+// PublicKeys returns every public key that is still accepted, in the order they were created
+func (km *KeyManager) PublicKeys() []VerificationKey {
 	km.mu.RLock()
 	defer km.mu.RUnlock()
 
-	if km.privateKey == nil {
-		return nil, errors.New("private key not loaded")
+	now := time.Now()
+	keys := make([]VerificationKey, 0, len(km.keys))
+	for _, key := range km.keys {
+		if km.acceptedLocked(key, now) {
+			keys = append(keys, key.verificationKey())
+		}
 	}
+	return keys
+}
 
-	publicKeyBytes, err := x509.MarshalPKIXPublicKey(&km.privateKey.PublicKey)
+// GetPublicKeyPEM returns the PEM encoded public key of the active key
+func (km *KeyManager) GetPublicKeyPEM() ([]byte, error) {
+	key, err := km.SigningKey()
+	if err != nil {
+		return nil, err
+	}
+
+	publicKeyBytes, err := x509.MarshalPKIXPublicKey(key.PrivateKey.Public())
 	if err != nil {
 		return nil, err
 	}
 
 	publicKeyPEM := pem.EncodeToMemory(&pem.Block{
-		Type:  "RSA PUBLIC KEY",
+		Type:  "PUBLIC KEY",
 		Bytes: publicKeyBytes,
 	})
 
 	return publicKeyPEM, nil
 }
 
-// GetPrivateKey returns the RSA private key
-func (km *KeyManager) GetPrivateKey() *rsa.PrivateKey {
-	km.mu.RLock()
-	defer km.mu.RUnlock()
-	return km.privateKey
+// activeKeyLocked returns the active key, the caller must hold the lock
+func (km *KeyManager) activeKeyLocked() (ringKey, bool) {
+	for _, key := range km.keys {
+		if key.kid == km.active {
+			return key, true
+		}
+	}
+	return ringKey{}, false
+}
+
+// acceptedLocked reports whether a key still verifies tokens, the caller must hold the lock
+func (km *KeyManager) acceptedLocked(key ringKey, now time.Time) bool {
+	return key.retiredAt == nil || now.Before(key.retiredAt.Add(km.config.VerificationPeriod))
+}
+
+// pruneLocked drops retired keys past their verification period and deletes their files
+// it reports whether anything was removed, the caller must hold the lock
+func (km *KeyManager) pruneLocked(now time.Time) bool {
+	kept := km.keys[:0]
+	pruned := false
+	for _, key := range km.keys {
+		if key.kid != km.active && !km.acceptedLocked(key, now) {
+			if err := os.Remove(km.keyPath(key.kid)); err != nil && !errors.Is(err, os.ErrNotExist) {
+				log.Printf("Failed to delete retired key %s: %v", key.kid, err)
+			}
+			pruned = true
+			continue
+		}
+		kept = append(kept, key)
+	}
+	km.keys = kept
+	return pruned
 }
 
-func (km *KeyManager) generateRSAKeys() error {
-	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+// load reads the ring from disk, an absent manifest leaves the ring empty
+// the caller must hold the lock
+func (km *KeyManager) load() error {
+	data, err := os.ReadFile(filepath.Join(km.config.Dir, ringFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
 	if err != nil {
 		return err
 	}
 
-	// Save private key
-	privateKeyBytes := x509.MarshalPKCS1PrivateKey(privateKey)
-	privateKeyPEM := pem.EncodeToMemory(&pem.Block{
-		Type:  "RSA PRIVATE KEY",
-		Bytes: privateKeyBytes,
-	})
+	keys, active, err := km.decodeRing(data, nil)
+	if err != nil {
+		return err
+	}
+	km.keys, km.active, km.manifest = keys, active, data
+	return nil
+}
 
-	if err := os.WriteFile(km.privateKeyPath, privateKeyPEM, 0600); err != nil {
+// reload reads the ring from disk again if ring.json changed since it was last read or written
+// keys already in memory are reused, and new ones are decrypted before the lock is taken
+// so that signing and verifying are not held up by the key derivation
+func (km *KeyManager) reload() error {
+	km.reloadMu.Lock()
+	defer km.reloadMu.Unlock()
+
+	data, err := os.ReadFile(filepath.Join(km.config.Dir, ringFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
 		return err
 	}
 
-	// Save public key
-	publicKeyBytes, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	km.mu.RLock()
+	previous := km.manifest
+	known := slices.Clone(km.keys)
+	km.mu.RUnlock()
+	if bytes.Equal(data, previous) {
+		return nil
+	}
+
+	keys, active, err := km.decodeRing(data, known)
 	if err != nil {
 		return err
 	}
-	publicKeyPEM := pem.EncodeToMemory(&pem.Block{
-		Type:  "RSA PUBLIC KEY",
-		Bytes: publicKeyBytes,
-	})
 
-	return os.WriteFile(km.publicKeyPath, publicKeyPEM, 0644)
+	km.mu.Lock()
+	defer km.mu.Unlock()
+	// A save while the keys were decrypted left a newer ring in memory than the one read here
+	if !bytes.Equal(km.manifest, previous) {
+		return nil
+	}
+	km.keys, km.active, km.manifest = keys, active, data
+	return nil
+}
+
+// decodeRing parses a manifest and loads the keys it lists, reusing the ones in known
+// instead of reading and decrypting their files again
+func (km *KeyManager) decodeRing(data []byte, known []ringKey) ([]ringKey, string, error) {
+	var manifest ringManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, "", fmt.Errorf("failed to parse %s: %w", ringFile, err)
+	}
+
+	keys := make([]ringKey, 0, len(manifest.Keys))
+	for _, item := range manifest.Keys {
+		key := ringKey{kid: item.Kid, createdAt: item.CreatedAt, retiredAt: item.RetiredAt}
+
+		i := slices.IndexFunc(known, func(k ringKey) bool { return k.kid == item.Kid })
+		if i >= 0 {
+			key.alg, key.privateKey, key.plaintext = known[i].alg, known[i].privateKey, known[i].plaintext
+		} else {
+			privateKey, plaintext, err := loadPrivateKey(filepath.Join(km.config.Dir, item.File), km.config.Encryption)
+			if err != nil {
+				return nil, "", fmt.Errorf("failed to load key %s: %w", item.Kid, err)
+			}
+			alg, err := algorithmForKey(privateKey)
+			if err != nil {
+				return nil, "", fmt.Errorf("failed to load key %s: %w", item.Kid, err)
+			}
+			key.alg, key.privateKey, key.plaintext = alg, privateKey, plaintext
+		}
+		keys = append(keys, key)
+	}
+	return keys, manifest.Active, nil
 }
 
-// LoadPrivateKey loads the private key from file
-func (km *KeyManager) LoadPrivateKey() (*rsa.PrivateKey, error) {
-	keyData, err := os.ReadFile(km.privateKeyPath)
+// save writes any new key files and then the manifest, the caller must hold the lock
+// the manifest is replaced atomically so a crash never leaves it referring to missing keys
+func (km *KeyManager) save() error {
+	manifest := ringManifest{Active: km.active}
+	for _, key := range km.keys {
+		path := km.keyPath(key.kid)
+		if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+			if err := savePrivateKey(path, key.privateKey, km.config.Encryption); err != nil {
+				return fmt.Errorf("failed to save key %s: %w", key.kid, err)
+			}
+		}
+		manifest.Keys = append(manifest.Keys, ringManifestItem{
+			Kid:       key.kid,
+			Alg:       key.alg,
+			File:      filepath.Base(path),
+			CreatedAt: key.createdAt,
+			RetiredAt: key.retiredAt,
+		})
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
 	if err != nil {
-		return nil, err
+		return err
+	}
+
+	tmp := filepath.Join(km.config.Dir, ringFile+".tmp")
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(km.config.Dir, ringFile)); err != nil {
+		return err
+	}
+	km.manifest = data
+	return nil
+}
+
+// sealPlaintextLocked rewrites plaintext key files encrypted when encryption is configured
+// the caller must hold the lock
+func (km *KeyManager) sealPlaintextLocked() error {
+	if !km.config.Encryption.Enabled() {
+		return nil
+	}
+
+	for i, key := range km.keys {
+		if !key.plaintext {
+			continue
+		}
+		if err := savePrivateKey(km.keyPath(key.kid), key.privateKey, km.config.Encryption); err != nil {
+			return fmt.Errorf("failed to encrypt key %s: %w", key.kid, err)
+		}
+		km.keys[i].plaintext = false
+		log.Printf("Encrypted private key %s at rest", key.kid)
+	}
+	return nil
+}
+
+// keyPath returns the file a key is stored in
+func (km *KeyManager) keyPath(kid string) string {
+	return filepath.Join(km.config.Dir, kid+".pem")
+}
+
+// newRingKey wraps a private key for the ring, identified by the thumbprint of its public key
+func newRingKey(privateKey crypto.Signer) (ringKey, error) {
+	alg, err := algorithmForKey(privateKey)
+	if err != nil {
+		return ringKey{}, err
+	}
+
+	jwk, err := NewJWK(privateKey.Public(), alg)
+	if err != nil {
+		return ringKey{}, err
+	}
+
+	return ringKey{
+		kid:        jwk.Kid,
+		alg:        alg,
+		privateKey: privateKey,
+		createdAt:  time.Now(),
+	}, nil
+}
+
+// verificationKey returns the public half of the key
+func (k ringKey) verificationKey() VerificationKey {
+	return VerificationKey{ID: k.kid, Algorithm: k.alg, PublicKey: k.privateKey.Public()}
+}
+
+// savePrivateKey writes a private key in PKCS8 to a PEM file readable only by the owner
+// the key is encrypted when encryption is enabled
+func savePrivateKey(path string, privateKey crypto.Signer, encryption KeyEncryption) error {
+	privateKeyBytes, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return err
+	}
+
+	block := &pem.Block{
+		Type:  "PRIVATE KEY",
+		Bytes: privateKeyBytes,
+	}
+	if encryption.Enabled() {
+		if block, err = encryption.seal(privateKeyBytes); err != nil {
+			return err
+		}
+	}
+
+	// Write to a temporary file first so an existing key is never left half written
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, pem.EncodeToMemory(block), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// loadPrivateKey loads a private key from a PEM file and reports whether it was stored in plaintext
+// PKCS8 is used for every algorithm, PKCS1 and SEC1 are read for keys written by older versions or other tools
+// plaintext keys are refused when encryption is required
+func loadPrivateKey(path string, encryption KeyEncryption) (crypto.Signer, bool, error) {
+	keyData, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false, err
 	}
 
 	block, _ := pem.Decode(keyData)
 	if block == nil {
-		return nil, errors.New("failed to decode PEM block")
+		return nil, false, errors.New("failed to decode PEM block")
+	}
+
+	if block.Type == sealedKeyType {
+		der, err := encryption.open(block)
+		if err != nil {
+			return nil, false, err
+		}
+		signer, err := parsePKCS8Signer(der)
+		return signer, false, err
+	}
+
+	if encryption.Required {
+		return nil, true, fmt.Errorf("%w: %s", ErrPlaintextKey, path)
 	}
 
-	return x509.ParsePKCS1PrivateKey(block.Bytes)
+	var signer crypto.Signer
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		signer, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		signer, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		signer, err = parsePKCS8Signer(block.Bytes)
+	default:
+		err = fmt.Errorf("unsupported PEM block type %q", block.Type)
+	}
+	return signer, true, err
+}
+
+// parsePKCS8Signer parses a PKCS8 private key that can sign
+func parsePKCS8Signer(der []byte) (crypto.Signer, error) {
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+	return signer, nil
 }
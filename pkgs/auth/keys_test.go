@@ -0,0 +1,177 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newSharedKeyManagers returns two managers of the same ring directory, as two instances of the server would have
+// the first key of the ring is already due for rotation
+func newSharedKeyManagers(t *testing.T, encryption KeyEncryption) (*KeyManager, *KeyManager) {
+	t.Helper()
+	config := KeyManagerConfig{
+		Dir:                t.TempDir(),
+		Algorithm:          ES256,
+		RotationInterval:   time.Hour,
+		VerificationPeriod: time.Hour,
+		Encryption:         encryption,
+	}
+
+	a, b := NewKeyManager(config), NewKeyManager(config)
+	if err := a.EnsureKeys(); err != nil {
+		t.Fatalf("EnsureKeys: %v", err)
+	}
+	a.mu.Lock()
+	a.keys[0].createdAt = a.keys[0].createdAt.Add(-2 * config.RotationInterval)
+	err := a.save()
+	a.mu.Unlock()
+	if err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	if err := b.EnsureKeys(); err != nil {
+		t.Fatalf("EnsureKeys: %v", err)
+	}
+	return a, b
+}
+
+// activeKid returns the ID of the key km signs with
+func activeKid(t *testing.T, km *KeyManager) string {
+	t.Helper()
+	key, err := km.SigningKey()
+	if err != nil {
+		t.Fatalf("SigningKey: %v", err)
+	}
+	return key.ID
+}
+
+func TestRotateIfDueSharedRing(t *testing.T) {
+	a, b := newSharedKeyManagers(t, KeyEncryption{Passphrase: "passphrase"})
+	first := activeKid(t, a)
+	if activeKid(t, b) != first {
+		t.Fatalf("the instances started with different keys")
+	}
+	b.mu.RLock()
+	firstKey := b.keys[0].privateKey
+	b.mu.RUnlock()
+
+	if err := a.rotateIfDue(time.Now()); err != nil {
+		t.Fatalf("rotateIfDue: %v", err)
+	}
+	second := activeKid(t, a)
+	if second == first {
+		t.Fatalf("the key was not rotated")
+	}
+
+	//The second instance picks up the new key instead of rotating again
+	if err := b.rotateIfDue(time.Now()); err != nil {
+		t.Fatalf("rotateIfDue: %v", err)
+	}
+	if got := activeKid(t, b); got != second {
+		t.Errorf("the second instance signs with %s, want %s", got, second)
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if len(b.keys) != 2 {
+		t.Fatalf("got %d keys in the ring, want 2", len(b.keys))
+	}
+	if b.keys[0].privateKey != firstKey {
+		t.Errorf("the key already in memory was decrypted again")
+	}
+}
+
+func TestReloadSkipsUnchangedRing(t *testing.T) {
+	a, _ := newSharedKeyManagers(t, KeyEncryption{})
+	a.mu.RLock()
+	keys := a.keys
+	a.mu.RUnlock()
+
+	if err := a.reload(); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if &a.keys[0] != &keys[0] {
+		t.Errorf("the ring was reloaded although ring.json did not change")
+	}
+}
+
+func TestRotateIfDueWhileLocked(t *testing.T) {
+	a, _ := newSharedKeyManagers(t, KeyEncryption{})
+	first := activeKid(t, a)
+
+	unlock, err := lockRing(a.config.Dir, 0)
+	if err != nil {
+		t.Fatalf("lockRing: %v", err)
+	}
+	if err := a.rotateIfDue(time.Now()); err != nil {
+		t.Fatalf("rotateIfDue: %v", err)
+	}
+	if activeKid(t, a) != first {
+		t.Errorf("the key was rotated while another instance held the lock")
+	}
+
+	unlock()
+	if err := a.rotateIfDue(time.Now()); err != nil {
+		t.Fatalf("rotateIfDue: %v", err)
+	}
+	if activeKid(t, a) == first {
+		t.Errorf("the key was not rotated once the lock was released")
+	}
+}
+
+func TestLockRing(t *testing.T) {
+	dir := t.TempDir()
+	unlock, err := lockRing(dir, 0)
+	if err != nil {
+		t.Fatalf("lockRing: %v", err)
+	}
+	if _, err := lockRing(dir, 2*ringLockPoll); err != errRingLocked {
+		t.Fatalf("lockRing of a locked ring returned %v, want errRingLocked", err)
+	}
+	unlock()
+
+	//A lock left behind by an instance that died is taken over
+	if err := os.WriteFile(filepath.Join(dir, lockFile), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * ringLockStale)
+	if err := os.Chtimes(filepath.Join(dir, lockFile), old, old); err != nil {
+		t.Fatal(err)
+	}
+	unlock, err = lockRing(dir, 0)
+	if err != nil {
+		t.Fatalf("lockRing with a stale lock: %v", err)
+	}
+	unlock()
+}
+
+func TestVerificationKeyReloadsOnUnknownKid(t *testing.T) {
+	a, b := newSharedKeyManagers(t, KeyEncryption{})
+
+	if err := a.Rotate(); err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+	if _, err := b.VerificationKey(activeKid(t, a)); err != nil {
+		t.Fatalf("VerificationKey of a key rotated to by another instance: %v", err)
+	}
+
+	//Unknown key IDs reload the ring at most once per interval
+	if _, err := b.VerificationKey("unknown"); err == nil {
+		t.Fatalf("VerificationKey of an unknown key ID succeeded")
+	}
+	if err := a.Rotate(); err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+	if _, err := b.VerificationKey(activeKid(t, a)); err == nil {
+		t.Errorf("the ring was reloaded again within the interval")
+	}
+
+	b.missMu.Lock()
+	b.lastMissReload = b.lastMissReload.Add(-missReloadInterval)
+	b.missMu.Unlock()
+	if _, err := b.VerificationKey(activeKid(t, a)); err != nil {
+		t.Errorf("VerificationKey after the interval: %v", err)
+	}
+}
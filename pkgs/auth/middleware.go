@@ -0,0 +1,125 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+
+	"joshuamURD/go-auth-api/pkgs/db"
+	"joshuamURD/go-auth-api/pkgs/models"
+
+	"github.com/google/uuid"
+)
+
+// TokenValidator is implemented by anything that can turn a token string into claims
+type TokenValidator interface {
+	Validate(tokenString string) (*JWTClaims, error)
+}
+
+// UserSource is implemented by anything that can load a user by ID, such as db.Database
+type UserSource interface {
+	GetByID(ctx context.Context, id uuid.UUID) (models.User, error)
+}
+
+// claimsContextKey is the key used to store the claims in the request context
+// it is unexported so that only this package can set the value
+type claimsContextKey struct{}
+
+// RequireAuth returns a middleware that only lets requests with a valid access token through
+// it reads the token from the Authorization: Bearer header, rejects refresh tokens
+// and stores the parsed claims in the request context
+// the user is loaded on every request, so deleting or locking an account and changing its role
+// apply to access tokens that were already issued
+func RequireAuth(validator TokenValidator, users UserSource) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tokenString, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !found || tokenString == "" {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			claims, err := validator.Validate(tokenString)
+			if err != nil {
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			// Refresh tokens must only ever be used against the refresh endpoint
+			if claims.Type != "access" {
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			userID, err := uuid.Parse(claims.UserID)
+			if err != nil {
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			user, err := users.GetByID(r.Context(), userID)
+			if errors.Is(err, db.ErrNotFound) {
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			if err != nil {
+				log.Printf("Failed to load user %s: %v", userID, err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+
+			// Only a lock set by an admin ends the sessions in use, a lockout after failed
+			// logins does not, so guessing a password cannot lock the owner out of their sessions
+			if user.Locked && user.LockedUntil == nil {
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			// The stored role replaces the one in the token, which may have changed since it was issued
+			claims.Role = user.Role
+
+			next.ServeHTTP(w, r.WithContext(WithClaims(r.Context(), claims)))
+		})
+	}
+}
+
+// RequireRole returns a middleware that only lets through callers holding one of the given roles
+// it must be used behind RequireAuth, which stores the claims it checks with the current role of the user
+func RequireRole(roles ...models.Role) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := ClaimsFromContext(r.Context())
+			if !ok {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			if !slices.Contains(roles, claims.Role) {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// WithClaims returns a copy of ctx carrying the given claims
+func WithClaims(ctx context.Context, claims *JWTClaims) context.Context {
+	return context.WithValue(ctx, claimsContextKey{}, claims)
+}
+
+// ClaimsFromContext returns the claims stored by RequireAuth
+// the boolean is false if the request did not pass through the middleware
+func ClaimsFromContext(ctx context.Context) (*JWTClaims, bool) {
+	claims, ok := ctx.Value(claimsContextKey{}).(*JWTClaims)
+	return claims, ok && claims != nil
+}
@@ -0,0 +1,27 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+)
+
+// NewOneTimeToken generates a random token to be sent to a user out of band
+// it returns the token to send and the hash to store, so a leaked database cannot be used to redeem tokens
+func NewOneTimeToken() (token string, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	token = base64.RawURLEncoding.EncodeToString(b)
+	return token, HashOneTimeToken(token), nil
+}
+
+// HashOneTimeToken returns the hash under which a one-time token is stored
+// the tokens are high entropy so a fast hash is sufficient
+func HashOneTimeToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Algorithm is a JWS algorithm tokens can be signed with
+type Algorithm string
+
+const (
+	// RS256 is RSASSA-PKCS1-v1_5 with SHA-256 over a 2048-bit RSA key
+	RS256 Algorithm = "RS256"
+	// ES256 is ECDSA with SHA-256 over a P-256 key, its signatures are much shorter than RS256
+	ES256 Algorithm = "ES256"
+	// EdDSA is Ed25519, with the shortest signatures and fastest signing
+	EdDSA Algorithm = "EdDSA"
+)
+
+// ParseAlgorithm returns the algorithm with the given name, an empty name selects RS256
+func ParseAlgorithm(name string) (Algorithm, error) {
+	switch alg := Algorithm(name); alg {
+	case "":
+		return RS256, nil
+	case RS256, ES256, EdDSA:
+		return alg, nil
+	default:
+		return "", fmt.Errorf("unsupported signing algorithm %q", name)
+	}
+}
+
+// SigningMethod returns the jwt signing method implementing the algorithm
+func (a Algorithm) SigningMethod() (jwt.SigningMethod, error) {
+	switch a {
+	case RS256:
+		return jwt.SigningMethodRS256, nil
+	case ES256:
+		return jwt.SigningMethodES256, nil
+	case EdDSA:
+		return jwt.SigningMethodEdDSA, nil
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q", a)
+	}
+}
+
+// GenerateKey generates a new private key for the algorithm
+func (a Algorithm) GenerateKey() (crypto.Signer, error) {
+	switch a {
+	case RS256:
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case ES256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case EdDSA:
+		_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+		return privateKey, err
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q", a)
+	}
+}
+
+// algorithmForKey returns the algorithm a private key signs with
+func algorithmForKey(key crypto.Signer) (Algorithm, error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return RS256, nil
+	case *ecdsa.PrivateKey:
+		if k.Curve != elliptic.P256() {
+			return "", fmt.Errorf("unsupported ECDSA curve %s", k.Curve.Params().Name)
+		}
+		return ES256, nil
+	case ed25519.PrivateKey:
+		return EdDSA, nil
+	default:
+		return "", fmt.Errorf("unsupported private key type %T", key)
+	}
+}
+
+// SigningKey is a private key together with the algorithm and key ID it signs with
+type SigningKey struct {
+	ID         string
+	Algorithm  Algorithm
+	PrivateKey crypto.Signer
+}
+
+// VerificationKey is a public key together with the algorithm and key ID it verifies
+type VerificationKey struct {
+	ID        string
+	Algorithm Algorithm
+	PublicKey crypto.PublicKey
+}
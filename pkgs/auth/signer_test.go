@@ -0,0 +1,150 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"joshuamURD/go-auth-api/pkgs/models"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// newTestKeyManager returns a manager with a single key of the algorithm in a ring of its own
+func newTestKeyManager(t *testing.T, alg Algorithm) *KeyManager {
+	t.Helper()
+	km := NewKeyManager(KeyManagerConfig{
+		Dir:                t.TempDir(),
+		Algorithm:          alg,
+		RotationInterval:   time.Hour,
+		VerificationPeriod: time.Hour,
+	})
+	if err := km.EnsureKeys(); err != nil {
+		t.Fatalf("EnsureKeys: %v", err)
+	}
+	return km
+}
+
+// signWith signs claims with the active key of km, with the header kid set to kid
+func signWith(t *testing.T, km *KeyManager, kid string, claims JWTClaims) string {
+	t.Helper()
+	key, err := km.SigningKey()
+	if err != nil {
+		t.Fatalf("SigningKey: %v", err)
+	}
+	method, err := key.Algorithm.SigningMethod()
+	if err != nil {
+		t.Fatalf("SigningMethod: %v", err)
+	}
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key.PrivateKey)
+	if err != nil {
+		t.Fatalf("SignedString: %v", err)
+	}
+	return signed
+}
+
+// accessClaims returns the claims of an access token for user that expires in an hour
+func accessClaims(user string) JWTClaims {
+	return JWTClaims{
+		UserID: user,
+		Role:   models.RoleUser,
+		Type:   "access",
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+	}
+}
+
+func TestSignAndValidateEachAlgorithm(t *testing.T) {
+	for _, alg := range []Algorithm{RS256, ES256, EdDSA} {
+		t.Run(string(alg), func(t *testing.T) {
+			km := newTestKeyManager(t, alg)
+			service := NewJWTAuthService(km, nil)
+
+			resp, err := service.issueAccessToken("alice", models.RoleUser)
+			if err != nil {
+				t.Fatalf("issueAccessToken: %v", err)
+			}
+			token, _, err := jwt.NewParser().ParseUnverified(resp.AccessToken, &JWTClaims{})
+			if err != nil {
+				t.Fatalf("ParseUnverified: %v", err)
+			}
+			if token.Header["alg"] != string(alg) {
+				t.Errorf("alg = %v, want %s", token.Header["alg"], alg)
+			}
+			if token.Header["kid"] != activeKid(t, km) {
+				t.Errorf("kid = %v, want the active key %s", token.Header["kid"], activeKid(t, km))
+			}
+
+			claims, err := service.Validate(resp.AccessToken)
+			if err != nil {
+				t.Fatalf("Validate: %v", err)
+			}
+			if claims.UserID != "alice" || claims.Role != models.RoleUser || claims.Type != "access" {
+				t.Errorf("Validate returned %+v", claims)
+			}
+
+			//Changing a single character of the signature breaks it
+			tampered := resp.AccessToken[:len(resp.AccessToken)-2] + flipBase64(resp.AccessToken[len(resp.AccessToken)-2:])
+			if _, err := service.Validate(tampered); err == nil {
+				t.Errorf("Validate accepted a token with a changed signature")
+			}
+		})
+	}
+}
+
+// flipBase64 replaces the first character of s with another base64url character
+func flipBase64(s string) string {
+	if s[0] == 'A' {
+		return "B" + s[1:]
+	}
+	return "A" + s[1:]
+}
+
+func TestValidateRejectsTokensOfAnotherKey(t *testing.T) {
+	es256 := newTestKeyManager(t, ES256)
+	eddsa := newTestKeyManager(t, EdDSA)
+	otherES256 := newTestKeyManager(t, ES256)
+	service := NewJWTAuthService(es256, nil)
+	kid := activeKid(t, es256)
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"EdDSA signature under the kid of an ES256 key", signWith(t, eddsa, kid, accessClaims("alice"))},
+		{"ES256 signature of another key under the kid", signWith(t, otherES256, kid, accessClaims("alice"))},
+		{"kid of a key the service does not hold", signWith(t, eddsa, activeKid(t, eddsa), accessClaims("alice"))},
+		{"unsigned", unsignedToken(t, kid)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if claims, err := service.Validate(tt.token); err == nil {
+				t.Errorf("Validate accepted the token: %+v", claims)
+			}
+		})
+	}
+
+	//The same claims signed by the right key are accepted, so the refusals above come from the key
+	if _, err := service.Validate(signWith(t, es256, kid, accessClaims("alice"))); err != nil {
+		t.Errorf("Validate refused a token of its own key: %v", err)
+	}
+}
+
+// unsignedToken returns a token with the none algorithm under kid
+func unsignedToken(t *testing.T, kid string) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodNone, accessClaims("alice"))
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatalf("SignedString: %v", err)
+	}
+	if !strings.HasSuffix(signed, ".") {
+		t.Fatalf("the unsigned token has a signature: %s", signed)
+	}
+	return signed
+}
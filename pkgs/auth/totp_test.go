@@ -0,0 +1,139 @@
+package auth
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA-1 key of the RFC 6238 test vectors, "12345678901234567890", base32 encoded
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPRFC6238Vectors(t *testing.T) {
+	//The SHA-1 vectors of RFC 6238 appendix B, the last six of their eight digits
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	key, err := totpEncoding.DecodeString(rfc6238Secret)
+	if err != nil {
+		t.Fatalf("DecodeString: %v", err)
+	}
+	for _, tt := range tests {
+		now := time.Unix(tt.unix, 0)
+		if got := totpCode(key, tt.unix/totpPeriod); got != tt.code {
+			t.Errorf("code at %d = %s, want %s", tt.unix, got, tt.code)
+		}
+		step, ok := ValidateTOTP(rfc6238Secret, tt.code, now)
+		if !ok || step != tt.unix/totpPeriod {
+			t.Errorf("ValidateTOTP(%s) at %d = %d, %v, want step %d", tt.code, tt.unix, step, ok, tt.unix/totpPeriod)
+		}
+	}
+}
+
+func TestValidateTOTPWindow(t *testing.T) {
+	key, err := totpEncoding.DecodeString(rfc6238Secret)
+	if err != nil {
+		t.Fatalf("DecodeString: %v", err)
+	}
+	const step = 1111111111 / totpPeriod
+	code := totpCode(key, step)
+	start := time.Unix(step*totpPeriod, 0)
+
+	tests := []struct {
+		name string
+		now  time.Time
+		ok   bool
+	}{
+		{"same step", start, true},
+		{"end of the same step", start.Add(totpPeriod*time.Second - time.Second), true},
+		{"one step later", start.Add(totpPeriod * time.Second), true},
+		{"one step earlier", start.Add(-totpPeriod * time.Second), true},
+		{"two steps later", start.Add(2 * totpPeriod * time.Second), false},
+		{"two steps earlier", start.Add(-time.Second - totpPeriod*time.Second), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := ValidateTOTP(rfc6238Secret, code, tt.now)
+			if ok != tt.ok {
+				t.Fatalf("ValidateTOTP = %v, want %v", ok, tt.ok)
+			}
+			//The step of the code is returned, not the current one, so it is the step that is marked used
+			if ok && got != step {
+				t.Errorf("ValidateTOTP returned step %d, want %d", got, step)
+			}
+		})
+	}
+}
+
+func TestValidateTOTPRejectsMalformedInput(t *testing.T) {
+	now := time.Unix(59, 0)
+	tests := []struct {
+		name   string
+		secret string
+		code   string
+		ok     bool
+	}{
+		{"lowercase secret", strings.ToLower(rfc6238Secret), "287082", true},
+		{"wrong code", rfc6238Secret, "287083", false},
+		{"eight digits", rfc6238Secret, "94287082", false},
+		{"five digits", rfc6238Secret, "87082", false},
+		{"empty code", rfc6238Secret, "", false},
+		{"secret that is not base32", "not base32!", "287082", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := ValidateTOTP(tt.secret, tt.code, now); ok != tt.ok {
+				t.Errorf("ValidateTOTP = %v, want %v", ok, tt.ok)
+			}
+		})
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	uri, err := url.Parse(TOTPURI("go-auth-api", "alice@example.com", rfc6238Secret))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if uri.Scheme != "otpauth" || uri.Host != "totp" || uri.Path != "/go-auth-api:alice@example.com" {
+		t.Errorf("TOTPURI = %s", uri)
+	}
+	query := uri.Query()
+	if query.Get("secret") != rfc6238Secret || query.Get("issuer") != "go-auth-api" || query.Get("digits") != "6" || query.Get("period") != "30" {
+		t.Errorf("TOTPURI query = %v", query)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, hashes, err := NewRecoveryCodes()
+	if err != nil {
+		t.Fatalf("NewRecoveryCodes: %v", err)
+	}
+	if len(codes) != recoveryCodeCount || len(hashes) != recoveryCodeCount {
+		t.Fatalf("NewRecoveryCodes returned %d codes and %d hashes, want %d", len(codes), len(hashes), recoveryCodeCount)
+	}
+
+	seen := map[string]bool{}
+	for i, code := range codes {
+		if seen[hashes[i]] {
+			t.Errorf("recovery code %s was issued twice", code)
+		}
+		seen[hashes[i]] = true
+		if HashRecoveryCode(code) != hashes[i] {
+			t.Errorf("the hash of %s is not the one returned", code)
+		}
+		//The code can be typed back in capitals and without its dash
+		typed := " " + strings.ToUpper(strings.ReplaceAll(code, "-", " ")) + " "
+		if HashRecoveryCode(typed) != hashes[i] {
+			t.Errorf("%q does not hash like %s", typed, code)
+		}
+	}
+}
@@ -0,0 +1,321 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"joshuamURD/go-auth-api/pkgs/auth"
+	"joshuamURD/go-auth-api/pkgs/db"
+	"joshuamURD/go-auth-api/pkgs/models"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// defaultPageSize is the number of users returned per page when none is requested
+	defaultPageSize = 20
+	// maxPageSize is the largest page size a caller may request
+	maxPageSize = 100
+)
+
+// userResponse is the representation of a user returned by the admin API
+// it deliberately has no field for the password hash
+type userResponse struct {
+	ID             uuid.UUID   `json:"id"`
+	Email          string      `json:"email"`
+	Role           models.Role `json:"role"`
+	Verified       bool        `json:"verified"`
+	FailedAttempts int         `json:"failed_attempts"`
+	Locked         bool        `json:"locked"`
+	LockedUntil    *time.Time  `json:"locked_until,omitempty"`
+	CreatedAt      time.Time   `json:"created_at"`
+	UpdatedAt      time.Time   `json:"updated_at"`
+}
+
+// listUsersResponse is a page of users returned by the admin API
+type listUsersResponse struct {
+	Users   []userResponse `json:"users"`
+	Total   int            `json:"total"`
+	Page    int            `json:"page"`
+	PerPage int            `json:"per_page"`
+}
+
+// newUserResponse copies the fields of a user that are safe to return
+func newUserResponse(user models.User) userResponse {
+	return userResponse{
+		ID:             user.ID,
+		Email:          user.Email,
+		Role:           user.Role,
+		Verified:       user.Verified,
+		FailedAttempts: user.FailedAttempts,
+		Locked:         user.Locked,
+		LockedUntil:    user.LockedUntil,
+		CreatedAt:      user.CreatedAt,
+		UpdatedAt:      user.UpdatedAt,
+	}
+}
+
+// ListUsers handles listing users at /admin/users
+// it supports the page and per_page query parameters for pagination
+// and the email, verified and locked query parameters for filtering
+func (ac *Controller) ListUsers(w http.ResponseWriter, r *http.Request) {
+	//Ensures that the request method is GET
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+
+	page, err := intParam(query.Get("page"), 1)
+	if err != nil || page < 1 {
+		http.Error(w, "Invalid page", http.StatusBadRequest)
+		return
+	}
+	perPage, err := intParam(query.Get("per_page"), defaultPageSize)
+	if err != nil || perPage < 1 || perPage > maxPageSize {
+		http.Error(w, "Invalid per_page", http.StatusBadRequest)
+		return
+	}
+
+	filter := db.UserFilter{
+		Email:  query.Get("email"),
+		Limit:  perPage,
+		Offset: (page - 1) * perPage,
+	}
+	if filter.Verified, err = boolParam(query.Get("verified")); err != nil {
+		http.Error(w, "Invalid verified", http.StatusBadRequest)
+		return
+	}
+	if filter.Locked, err = boolParam(query.Get("locked")); err != nil {
+		http.Error(w, "Invalid locked", http.StatusBadRequest)
+		return
+	}
+
+	users, total, err := ac.db.List(r.Context(), filter)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	resp := listUsersResponse{
+		Users:   make([]userResponse, 0, len(users)),
+		Total:   total,
+		Page:    page,
+		PerPage: perPage,
+	}
+	for _, user := range users {
+		resp.Users = append(resp.Users, newUserResponse(user))
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// User handles a single user at /admin/users/{id}
+// GET returns the user and DELETE removes them along with their todos and sessions
+func (ac *Controller) User(w http.ResponseWriter, r *http.Request) {
+	user, ok := ac.pathUser(w, r)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, newUserResponse(user))
+
+	case http.MethodDelete:
+		if isCaller(r, user) {
+			http.Error(w, "Cannot delete your own account", http.StatusBadRequest)
+			return
+		}
+		if err := ac.db.Delete(r.Context(), user.ID); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// refusal is returned by an admin action that does not apply to the user in the path
+// updateUser sends its text with a 400
+type refusal string
+
+func (r refusal) Error() string { return string(r) }
+
+// LockUser handles locking a user indefinitely at /admin/users/{id}/lock
+// the user's sessions are revoked and RequireAuth refuses their access tokens, so the lock takes effect immediately
+func (ac *Controller) LockUser(w http.ResponseWriter, r *http.Request) {
+	ac.updateUser(w, r, func(ctx context.Context, user models.User) error {
+		if isCaller(r, user) {
+			return refusal("Cannot lock your own account")
+		}
+		return ac.db.Lock(ctx, user.ID)
+	}, true)
+}
+
+// UnlockUser handles unlocking a user at /admin/users/{id}/unlock
+// it also clears their failed login attempts
+func (ac *Controller) UnlockUser(w http.ResponseWriter, r *http.Request) {
+	ac.updateUser(w, r, func(ctx context.Context, user models.User) error {
+		return ac.db.ResetFailedLogins(ctx, user.ID)
+	}, false)
+}
+
+// VerifyUser handles marking a user's email as verified at /admin/users/{id}/verify
+func (ac *Controller) VerifyUser(w http.ResponseWriter, r *http.Request) {
+	ac.updateUser(w, r, func(ctx context.Context, user models.User) error {
+		return ac.db.MarkVerified(ctx, user.ID)
+	}, false)
+}
+
+// ResetUserAttempts handles clearing a user's failed login attempts at /admin/users/{id}/reset-attempts
+func (ac *Controller) ResetUserAttempts(w http.ResponseWriter, r *http.Request) {
+	ac.updateUser(w, r, func(ctx context.Context, user models.User) error {
+		return ac.db.ClearFailedAttempts(ctx, user.ID)
+	}, false)
+}
+
+// updateUser applies an admin action to the user in the path and responds with the user as stored afterwards
+// update writes only the columns the action changes, so concurrent logins, resets and role changes are not undone
+// revoke ends the user's sessions after the update
+func (ac *Controller) updateUser(w http.ResponseWriter, r *http.Request, update func(ctx context.Context, user models.User) error, revoke bool) {
+	//Ensures that the request method is POST
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, ok := ac.pathUser(w, r)
+	if !ok {
+		return
+	}
+
+	var refused refusal
+	if err := update(r.Context(), user); errors.As(err, &refused) {
+		http.Error(w, string(refused), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if revoke {
+		if err := ac.auth.RevokeUser(r.Context(), user.ID.String()); err != nil {
+			log.Printf("Failed to revoke sessions for user %s: %v", user.ID, err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	user, err := ac.db.GetByID(r.Context(), user.ID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newUserResponse(user))
+}
+
+// pathUser loads the user whose ID is in the path, writing the error response if there is none
+func (ac *Controller) pathUser(w http.ResponseWriter, r *http.Request) (models.User, bool) {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return models.User{}, false
+	}
+
+	user, err := ac.db.GetByID(r.Context(), id)
+	if err != nil {
+		writeError(w, err)
+		return models.User{}, false
+	}
+	return user, true
+}
+
+// isCaller reports whether the user is the one making the request
+func isCaller(r *http.Request, user models.User) bool {
+	claims, ok := auth.ClaimsFromContext(r.Context())
+	return ok && claims.UserID == user.ID.String()
+}
+
+// intParam parses an integer query parameter, returning def when it is empty
+func intParam(value string, def int) (int, error) {
+	if value == "" {
+		return def, nil
+	}
+	return strconv.Atoi(value)
+}
+
+// boolParam parses an optional boolean query parameter, returning nil when it is empty
+func boolParam(value string) (*bool, error) {
+	if value == "" {
+		return nil, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// setRoleRequest is a representation of a valid request to change the role of a user
+type setRoleRequest struct {
+	Role models.Role `json:"role"`
+}
+
+// SetUserRole handles assigning a role to the user at /admin/users/{id}/role
+// the user's sessions are revoked so that their next login carries the new role
+// admins cannot change their own role, and the last admin cannot be demoted, so the service always keeps one
+func (ac *Controller) SetUserRole(w http.ResponseWriter, r *http.Request) {
+	//Ensures that the request method is PUT
+	if r.Method != http.MethodPut {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req setRoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if !req.Role.Valid() {
+		http.Error(w, "Invalid role", http.StatusBadRequest)
+		return
+	}
+
+	user, ok := ac.pathUser(w, r)
+	if !ok {
+		return
+	}
+
+	if isCaller(r, user) {
+		http.Error(w, "Cannot change your own role", http.StatusBadRequest)
+		return
+	}
+
+	//Writes only the role, so a lock or password change made since the user was loaded is kept
+	if err := ac.db.SetRole(r.Context(), user.ID, req.Role); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	//Ends the existing sessions, their refresh tokens still carry the old role
+	//access tokens already issued are checked against the stored role by RequireAuth
+	if err := ac.auth.RevokeUser(r.Context(), user.ID.String()); err != nil {
+		log.Printf("Failed to revoke sessions for user %s: %v", user.ID, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{
+		"message": "Role updated successfully",
+		"role":    string(req.Role),
+	})
+}
@@ -1,27 +1,145 @@
 package controllers
 
 import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
 	"joshuamURD/go-auth-api/pkgs/auth"
 	"joshuamURD/go-auth-api/pkgs/db"
 	"joshuamURD/go-auth-api/pkgs/hash"
+	"joshuamURD/go-auth-api/pkgs/mail"
+	"joshuamURD/go-auth-api/pkgs/models"
+	"joshuamURD/go-auth-api/pkgs/password"
+	"joshuamURD/go-auth-api/pkgs/webauthn"
 )
 
 // Controller is a struct that contains the hasher, database, and middleware
 // a hasher is used to hash the password
+// a store is used to run the steps of a flow that touch several repositories in one transaction
 // a database is used to store the user data
 // an auth service is used to authenticate the user
+// a todo repository is used to store the todo items of each user
+// a token repository is used to store the one-time tokens sent by email
+// an mfa repository is used to store the second factors of each user
+// a webauthn repository is used to store the passkeys of each user and their pending challenges
+// a relying party is used to run the passkey ceremonies
+// a mailer is used to send emails to the user
+// a config holds the policy settings the handlers apply
+// a wait group tracks the emails still being sent after their request was answered
+// a dummy hash is compared against for unknown emails, made on first use with the current hasher settings
 type Controller struct {
 	hasher hash.Hasher
-	db     *db.Database
+	store  *db.Store
+	db     db.Database
 	auth   auth.AuthService
+	todos  db.TodoRepository
+	tokens db.OneTimeTokenRepository
+	mfa    db.MFARepository
+	mailer mail.Mailer
+	config Config
+
+	passkeys     db.WebAuthnRepository
+	relyingParty *webauthn.RelyingParty
+
+	background sync.WaitGroup
+	dummyHash  func() string
+}
+
+// Config holds the policy settings used by the controller
+type Config struct {
+	// MaxFailedAttempts is the number of consecutive failed logins before an account is locked
+	// zero disables the lockout
+	MaxFailedAttempts int
+	// LockoutDuration is how long an account stays locked before it unlocks automatically
+	LockoutDuration time.Duration
+	// RequireVerifiedEmail makes Login refuse accounts whose email has not been verified
+	RequireVerifiedEmail bool
+	// VerificationTokenTTL is how long an email verification link stays valid
+	VerificationTokenTTL time.Duration
+	// PasswordResetTokenTTL is how long a password reset token stays valid
+	PasswordResetTokenTTL time.Duration
+	// BaseURL is the public address of the server, used to build links in emails
+	BaseURL string
+	// PasswordResetURL is the page of a front end that asks for the new password, the reset email links to it
+	// with the token as the token query parameter. When empty it links to the form served at /password/reset.
+	PasswordResetURL string
+	// EmailPolicy canonicalizes the addresses given to Register, Login and ForgotPassword
+	EmailPolicy models.EmailPolicy
+	// PasswordPolicy is checked by Register and ResetPassword before a new password is hashed
+	PasswordPolicy password.Policy
+	// MFAIssuer is the name authenticator apps show next to the account
+	MFAIssuer string
+}
+
+// backgroundTimeout bounds the work a handler leaves running once it has responded
+const backgroundTimeout = 30 * time.Second
+
+// userByEmail loads the user stored under any of the forms EmailPolicy.Lookups returns for an address
+// it returns models.ErrInvalidEmail when the address cannot be normalized
+func (c *Controller) userByEmail(ctx context.Context, email string) (models.User, error) {
+	forms, err := c.config.EmailPolicy.Lookups(email)
+	if err != nil {
+		return models.User{}, err
+	}
+	return db.GetByAnyEmail(ctx, c.db, forms)
 }
 
-// NewRegisterController creates a new RegisterController
-// It takes a hasher and a database instance and returns a pointer to a RegisterController
-func NewController(hasher hash.Hasher, db *db.Database, auth auth.AuthService) *Controller {
+// sendInBackground runs send after the request has been answered, bounded by backgroundTimeout
+// the context keeps the values of the request but not its cancellation, which comes as soon as the response is written
+func (c *Controller) sendInBackground(ctx context.Context, send func(ctx context.Context)) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), backgroundTimeout)
+	c.background.Add(1)
+	go func() {
+		defer c.background.Done()
+		defer cancel()
+		send(ctx)
+	}()
+}
+
+// Wait blocks until the work started by sendInBackground has finished, e.g. before the store is closed
+func (c *Controller) Wait() {
+	c.background.Wait()
+}
+
+// DefaultConfig returns the settings used when nothing else is configured
+func DefaultConfig() Config {
+	return Config{
+		MaxFailedAttempts:     5,
+		LockoutDuration:       15 * time.Minute,
+		RequireVerifiedEmail:  false,
+		VerificationTokenTTL:  24 * time.Hour,
+		PasswordResetTokenTTL: 30 * time.Minute,
+		BaseURL:               "http://127.0.0.1:8080",
+		PasswordPolicy:        password.DefaultPolicy(),
+		MFAIssuer:             "go-auth-api",
+	}
+}
+
+// NewController creates a new Controller
+// It takes a hasher, a store, an auth service, a relying party, a mailer and a config and returns a pointer to a Controller
+func NewController(hasher hash.Hasher, store *db.Store, auth auth.AuthService, relyingParty *webauthn.RelyingParty, mailer mail.Mailer, config Config) *Controller {
 	return &Controller{
 		hasher: hasher,
-		db:     db,
+		store:  store,
+		db:     store.Users,
 		auth:   auth,
+		todos:  store.Todos,
+		tokens: store.OneTimeTokens,
+		mfa:    store.MFA,
+		mailer: mailer,
+		config: config,
+
+		passkeys:     store.WebAuthn,
+		relyingParty: relyingParty,
+
+		dummyHash: sync.OnceValue(func() string {
+			hashed, err := hasher.Hash("dummy password for unknown emails")
+			if err != nil {
+				log.Printf("Failed to make the dummy password hash: %v", err)
+			}
+			return hashed
+		}),
 	}
 }
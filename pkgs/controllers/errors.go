@@ -0,0 +1,62 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"joshuamURD/go-auth-api/pkgs/db"
+)
+
+// errorResponses maps the errors of the db package to the status and message sent to the client
+// specific errors come first since they also match the generic sentinel they belong to
+var errorResponses = []struct {
+	err     error
+	status  int
+	message string
+}{
+	{db.ErrEmailTaken, http.StatusConflict, "Email already registered"},
+	{db.ErrOneTimeTokenInvalid, http.StatusBadRequest, "Invalid or expired token"},
+	{db.ErrTOTPAlreadyEnabled, http.StatusConflict, "Two-factor authentication is already enabled"},
+	{db.ErrTOTPNotFound, http.StatusNotFound, "Two-factor authentication is not set up"},
+	{db.ErrWebAuthnSessionInvalid, http.StatusBadRequest, "Invalid or expired challenge"},
+	{db.ErrWebAuthnCredentialExists, http.StatusConflict, "Passkey already registered"},
+	{db.ErrLastAdmin, http.StatusConflict, "Cannot demote the last admin"},
+	{db.ErrUserNotFound, http.StatusNotFound, "User not found"},
+	{db.ErrTodoNotFound, http.StatusNotFound, "Todo not found"},
+	{db.ErrNotFound, http.StatusNotFound, "Not found"},
+	{db.ErrConflict, http.StatusConflict, "Conflict with existing data"},
+	{db.ErrConstraint, http.StatusUnprocessableEntity, "Request violates a data constraint"},
+}
+
+// writeError translates an error from a repository into an HTTP response
+// errors that match none of the db sentinels are logged and reported as a bare 500
+func writeError(w http.ResponseWriter, err error) {
+	for _, resp := range errorResponses {
+		if errors.Is(err, resp.err) {
+			http.Error(w, resp.message, resp.status)
+			return
+		}
+	}
+	log.Printf("Internal error: %v", err)
+	http.Error(w, "Internal server error", http.StatusInternalServerError)
+}
+
+// checkPassword applies the password policy, writing the response itself when the password is refused
+// the violations are sent as a 422 JSON body so that a client can show every problem at once
+func (c *Controller) checkPassword(w http.ResponseWriter, plainPassword string, email string) bool {
+	violations, err := c.config.PasswordPolicy.Check(plainPassword, email)
+	if err != nil {
+		log.Printf("Password policy error: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return false
+	}
+	if len(violations) > 0 {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]any{
+			"error":      "Password does not meet the policy",
+			"violations": violations,
+		})
+		return false
+	}
+	return true
+}
@@ -1,10 +1,17 @@
 package controllers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
-	"strings"
+	"strconv"
+	"time"
+
+	"joshuamURD/go-auth-api/pkgs/auth"
+	"joshuamURD/go-auth-api/pkgs/db"
+	"joshuamURD/go-auth-api/pkgs/models"
 )
 
 // loginRequest is a representation of a valid request to the login route
@@ -35,9 +42,14 @@ func (lc *Controller) Login(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		//Refreshes the refresh token
-		authResp, err := lc.auth.RefreshAuth(r.Context(), cookie.Value)
+		//Rotates the refresh token, setting the new one as a cookie
+		authResp, err := lc.auth.RefreshAuth(r.Context(), cookie.Value, w)
 		if err != nil {
+			if errors.Is(err, auth.ErrInvalidRefreshToken) || errors.Is(err, auth.ErrRefreshTokenReused) {
+				http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+				return
+			}
+			log.Printf("Refresh error: %v", err)
 			http.Error(w, "Failed to refresh token", http.StatusInternalServerError)
 			return
 		}
@@ -64,33 +76,89 @@ func (lc *Controller) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if _, err := r.Cookie("refresh_token"); err == nil {
+	if lc.hasSession(r) {
 		http.Error(w, "Already logged in", http.StatusBadRequest)
 		return
 	}
 
-	//Gets the user from the database
-	user, err := (*lc.db).GetByEmail(req.Email)
+	//Gets the user from the database, canonicalizing the email the same way Register stored it
+	//An unknown email, or one that cannot be normalized, gets the same response as a wrong password
+	//and is checked against a dummy hash, so it takes as long to answer as a registered one
+	user, err := lc.userByEmail(r.Context(), req.Email)
+	if errors.Is(err, db.ErrNotFound) || errors.Is(err, models.ErrInvalidEmail) {
+		lc.hasher.Compare(lc.dummyHash(), req.Password)
+		http.Error(w, "Invalid email or password", http.StatusUnauthorized)
+		return
+	}
 	if err != nil {
-		// Log the actual error for debugging
-		log.Printf("Login error for email %s: %v", req.Email, err)
-
-		if strings.Contains(err.Error(), "user not found") {
-			http.Error(w, "Invalid email or password", http.StatusUnauthorized)
-			return
-		}
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		writeError(w, err)
 		return
 	}
 
 	//Checks if the password hash matches the password provided
+	locked := user.IsLocked(time.Now())
 	if !lc.hasher.Compare(user.HashedPassword, req.Password) {
-		http.Error(w, "Invalid password", http.StatusUnauthorized)
+		//Records the failure, which locks the account once the threshold is reached
+		//a locked account is not charged again, so guessing does not keep extending the lock
+		if !locked {
+			if _, err := lc.db.RecordFailedLogin(r.Context(), user.ID, lc.config.MaxFailedAttempts, lc.config.LockoutDuration); err != nil {
+				log.Printf("Failed to record failed login for user %s: %v", user.ID, err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+		}
+		http.Error(w, "Invalid email or password", http.StatusUnauthorized)
+		return
+	}
+
+	//Tells the user the account is locked only once they have shown the password,
+	//so the lockout does not reveal to anyone else that the account exists
+	if locked {
+		writeLocked(w, user)
+		return
+	}
+
+	//Refuses unverified accounts when verification is required
+	if lc.config.RequireVerifiedEmail && !user.Verified {
+		http.Error(w, "Email address not verified", http.StatusForbidden)
 		return
 	}
 
+	//Upgrades a hash made with an old algorithm or cost while the password is known
+	if lc.hasher.NeedsRehash(user.HashedPassword) {
+		lc.rehashPassword(r.Context(), user, req.Password)
+	}
+
+	//Accounts with an authenticator get a challenge instead of a session, exchanged at /login/mfa
+	//failures are only cleared once the second factor is checked too, so they keep counting towards the lockout
+	enrollment, err := lc.mfa.GetTOTP(r.Context(), user.ID)
+	if err != nil && !errors.Is(err, db.ErrTOTPNotFound) {
+		writeError(w, err)
+		return
+	}
+	if err == nil && enrollment.Enabled() {
+		lc.writeMFAChallenge(w, user)
+		return
+	}
+
+	lc.completeLogin(w, r, user)
+}
+
+// completeLogin starts a session for a user whose credentials have all been checked
+// it clears any previous failed attempts and responds with the access token
+func (lc *Controller) completeLogin(w http.ResponseWriter, r *http.Request, user models.User) {
+	//Clears any previous failures now that the credentials are correct
+	//an admin lock set since the user was loaded is kept, and RequireAuth refuses the session
+	if user.FailedAttempts > 0 || user.Locked {
+		if err := lc.db.ClearLockout(r.Context(), user.ID); err != nil {
+			log.Printf("Failed to reset failed logins for user %s: %v", user.ID, err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+
 	//Gets the auth response with access token and refresh token
-	authResp, err := lc.auth.Authenticate(r.Context(), user.ID.String(), w)
+	authResp, err := lc.auth.Authenticate(r.Context(), auth.AuthClaims{UserID: user.ID.String(), Role: user.Role}, w)
 	if err != nil {
 		http.Error(w, "Authentication failed", http.StatusInternalServerError)
 		return
@@ -106,3 +174,48 @@ func (lc *Controller) Login(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(loginResp)
 }
+
+// rehashPassword stores a new hash of the password made with the current hasher settings
+// only the hash is written, and only if it is still the one that was checked, so a lock, role change or
+// password reset made since the user was loaded is never reverted
+// a failure only means the old hash stays in use, so it is logged rather than failing the login
+func (lc *Controller) rehashPassword(ctx context.Context, user models.User, password string) {
+	hashedPassword, err := lc.hasher.Hash(password)
+	if err != nil {
+		log.Printf("Failed to rehash password for user %s: %v", user.ID, err)
+		return
+	}
+
+	err = lc.db.UpdatePasswordHash(ctx, user.ID, user.HashedPassword, hashedPassword)
+	if err != nil && !errors.Is(err, db.ErrPasswordChanged) {
+		log.Printf("Failed to store rehashed password for user %s: %v", user.ID, err)
+	}
+}
+
+// writeLocked responds to a login attempt on a locked account
+// it reveals that the account exists, so it is only used once the client has shown the password, a challenge token or a passkey
+// the message does not say whether the password was correct
+func writeLocked(w http.ResponseWriter, user models.User) {
+	if user.LockedUntil != nil {
+		retryAfter := int(time.Until(*user.LockedUntil).Seconds()) + 1
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	}
+	http.Error(w, "Too many failed login attempts, try again later", http.StatusTooManyRequests)
+}
+
+// hasSession reports whether the request carries the refresh token of a session that has not ended
+// a cookie left behind by a revoked or expired session does not count, so it cannot block a new login
+func (lc *Controller) hasSession(r *http.Request) bool {
+	cookie, err := r.Cookie("refresh_token")
+	if err != nil {
+		return false
+	}
+
+	active, err := lc.auth.HasSession(r.Context(), cookie.Value)
+	if err != nil {
+		//The check only stops a second login, so a failure lets the request through
+		log.Printf("Failed to check the session of a refresh token: %v", err)
+		return false
+	}
+	return active
+}
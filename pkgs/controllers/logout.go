@@ -0,0 +1,52 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"joshuamURD/go-auth-api/pkgs/auth"
+)
+
+// Logout handles ending the current session
+// it revokes the refresh token in the cookie and expires the cookie
+func (lc *Controller) Logout(w http.ResponseWriter, r *http.Request) {
+	lc.logout(w, r, auth.RevokeCurrent)
+}
+
+// LogoutAll handles ending every session of the user
+// it revokes all of the user's refresh tokens and expires the cookie
+func (lc *Controller) LogoutAll(w http.ResponseWriter, r *http.Request) {
+	lc.logout(w, r, auth.RevokeAll)
+}
+
+// logout revokes sessions in the given scope using the refresh token cookie
+func (lc *Controller) logout(w http.ResponseWriter, r *http.Request, scope auth.RevokeScope) {
+	//Ensures that the request method is POST
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	//Gets the refresh token from the request
+	cookie, err := r.Cookie("refresh_token")
+	if err != nil {
+		http.Error(w, "No refresh token provided", http.StatusBadRequest)
+		return
+	}
+
+	err = lc.auth.Revoke(r.Context(), cookie.Value, scope, w)
+	if errors.Is(err, auth.ErrInvalidRefreshToken) {
+		//An unusable token has no session to end, but logging out everywhere needs to know the user
+		if scope == auth.RevokeAll {
+			http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+			return
+		}
+	} else if err != nil {
+		log.Printf("Logout error: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,308 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"joshuamURD/go-auth-api/pkgs/auth"
+	"joshuamURD/go-auth-api/pkgs/db"
+	"joshuamURD/go-auth-api/pkgs/models"
+
+	"github.com/google/uuid"
+)
+
+// mfaChallengeResponse is the response to a correct password on an account with two-factor authentication
+type mfaChallengeResponse struct {
+	Message   string    `json:"message"`
+	MFAToken  string    `json:"mfa_token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// loginMFARequest is a representation of a valid request to the MFA login route
+// it carries either an authenticator code or a recovery code
+type loginMFARequest struct {
+	MFAToken     string `json:"mfa_token"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// setupTOTPRequest is a representation of a valid request to the TOTP setup route
+// the current password is asked for again so that a stolen access token cannot enroll an authenticator
+type setupTOTPRequest struct {
+	Password string `json:"password"`
+}
+
+// confirmTOTPRequest is a representation of a valid request to the TOTP confirm route
+type confirmTOTPRequest struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+// SetupTOTP starts enrolling an authenticator app for the current user
+// it responds with the secret and the otpauth:// URI to show as a QR code, which only take effect once confirmed
+func (mc *Controller) SetupTOTP(w http.ResponseWriter, r *http.Request) {
+	//Ensures that the request method is POST
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := mc.currentUserID(w, r)
+	if !ok {
+		return
+	}
+
+	var req setupTOTPRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Password == "" {
+		http.Error(w, "Password is required", http.StatusBadRequest)
+		return
+	}
+
+	user, err := mc.db.GetByID(r.Context(), userID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if !mc.reauthenticate(w, r, user, req.Password) {
+		return
+	}
+
+	secret, err := auth.NewTOTPSecret()
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	//Replaces an unconfirmed secret, an enabled authenticator is left alone
+	if err := mc.mfa.SaveTOTP(r.Context(), models.TOTPEnrollment{
+		UserID:    user.ID,
+		Secret:    secret,
+		CreatedAt: time.Now(),
+	}); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{
+		"secret":      secret,
+		"otpauth_uri": auth.TOTPURI(mc.config.MFAIssuer, user.Email, secret),
+	})
+}
+
+// ConfirmTOTP enables the pending authenticator of the current user with a code from the app
+// it responds with the recovery codes, which are only ever shown this once
+func (mc *Controller) ConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	//Ensures that the request method is POST
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := mc.currentUserID(w, r)
+	if !ok {
+		return
+	}
+
+	var req confirmTOTPRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Password == "" || req.Code == "" {
+		http.Error(w, "Password and code are required", http.StatusBadRequest)
+		return
+	}
+
+	user, err := mc.db.GetByID(r.Context(), userID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if !mc.reauthenticate(w, r, user, req.Password) {
+		return
+	}
+
+	enrollment, err := mc.mfa.GetTOTP(r.Context(), userID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if enrollment.Enabled() {
+		writeError(w, db.ErrTOTPAlreadyEnabled)
+		return
+	}
+
+	step, ok := auth.ValidateTOTP(enrollment.Secret, req.Code, time.Now())
+	if !ok {
+		http.Error(w, "Invalid code", http.StatusBadRequest)
+		return
+	}
+
+	//Issues a fresh set of recovery codes, replacing any from an earlier enrollment
+	//both are written together, so an authenticator is never enabled without the codes that were shown
+	codes, hashes, err := auth.NewRecoveryCodes()
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	err = mc.store.WithTx(r.Context(), func(tx *db.Store) error {
+		if err := tx.MFA.ConfirmTOTP(r.Context(), userID, step); err != nil {
+			return err
+		}
+		return tx.MFA.ReplaceRecoveryCodes(r.Context(), userID, hashes)
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"message":        "Two-factor authentication enabled",
+		"recovery_codes": codes,
+	})
+}
+
+// LoginMFA completes a login that Login answered with a challenge
+// it exchanges the challenge token and an authenticator or recovery code for a session
+// wrong codes count as failed logins, so they lead to the same lockout as wrong passwords
+func (mc *Controller) LoginMFA(w http.ResponseWriter, r *http.Request) {
+	//Ensures that the request method is POST
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req loginMFARequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if req.MFAToken == "" || (req.Code == "") == (req.RecoveryCode == "") {
+		http.Error(w, "MFA token and either a code or a recovery code are required", http.StatusBadRequest)
+		return
+	}
+
+	//The challenge token proves the password was checked
+	subject, err := mc.auth.ValidateMFAChallenge(req.MFAToken)
+	if err != nil {
+		http.Error(w, "Invalid or expired MFA token", http.StatusUnauthorized)
+		return
+	}
+	userID, err := uuid.Parse(subject)
+	if err != nil {
+		http.Error(w, "Invalid or expired MFA token", http.StatusUnauthorized)
+		return
+	}
+	user, err := mc.db.GetByID(r.Context(), userID)
+	if errors.Is(err, db.ErrNotFound) {
+		http.Error(w, "Invalid or expired MFA token", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	//Refuses to check the code while the account is locked
+	if user.IsLocked(time.Now()) {
+		writeLocked(w, user)
+		return
+	}
+
+	valid, err := mc.checkSecondFactor(r.Context(), user.ID, req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if !valid {
+		//Records the failure, which locks the account once the threshold is reached
+		updated, err := mc.db.RecordFailedLogin(r.Context(), user.ID, mc.config.MaxFailedAttempts, mc.config.LockoutDuration)
+		if err != nil {
+			log.Printf("Failed to record failed login for user %s: %v", user.ID, err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if updated.IsLocked(time.Now()) {
+			writeLocked(w, updated)
+			return
+		}
+		http.Error(w, "Invalid code", http.StatusUnauthorized)
+		return
+	}
+
+	mc.completeLogin(w, r, user)
+}
+
+// reauthenticate checks the current password of a signed in user before their second factor is changed
+// it responds and returns false when the password is wrong, which counts as a failed login like it would at Login
+func (mc *Controller) reauthenticate(w http.ResponseWriter, r *http.Request, user models.User, password string) bool {
+	if user.IsLocked(time.Now()) {
+		writeLocked(w, user)
+		return false
+	}
+	if mc.hasher.Compare(user.HashedPassword, password) {
+		return true
+	}
+
+	updated, err := mc.db.RecordFailedLogin(r.Context(), user.ID, mc.config.MaxFailedAttempts, mc.config.LockoutDuration)
+	if err != nil {
+		log.Printf("Failed to record failed login for user %s: %v", user.ID, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return false
+	}
+	if updated.IsLocked(time.Now()) {
+		writeLocked(w, updated)
+		return false
+	}
+	http.Error(w, "Invalid password", http.StatusUnauthorized)
+	return false
+}
+
+// checkSecondFactor reports whether the code or recovery code of the request is valid for the user
+// either one is used up when it is accepted, so it cannot be presented again
+func (mc *Controller) checkSecondFactor(ctx context.Context, userID uuid.UUID, req loginMFARequest) (bool, error) {
+	if req.RecoveryCode != "" {
+		err := mc.mfa.UseRecoveryCode(ctx, userID, auth.HashRecoveryCode(req.RecoveryCode))
+		if errors.Is(err, db.ErrRecoveryCodeInvalid) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+
+	enrollment, err := mc.mfa.GetTOTP(ctx, userID)
+	if errors.Is(err, db.ErrTOTPNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !enrollment.Enabled() {
+		return false, nil
+	}
+
+	step, ok := auth.ValidateTOTP(enrollment.Secret, req.Code, time.Now())
+	if !ok {
+		return false, nil
+	}
+	err = mc.mfa.UseTOTPStep(ctx, userID, step)
+	if errors.Is(err, db.ErrTOTPStepUsed) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// writeMFAChallenge responds to a correct password on an account with two-factor authentication
+func (mc *Controller) writeMFAChallenge(w http.ResponseWriter, user models.User) {
+	token, expiresAt, err := mc.auth.IssueMFAChallenge(user.ID.String())
+	if err != nil {
+		log.Printf("Failed to issue mfa challenge for user %s: %v", user.ID, err)
+		http.Error(w, "Authentication failed", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, mfaChallengeResponse{
+		Message:   "mfa_required",
+		MFAToken:  token,
+		ExpiresAt: expiresAt,
+	})
+}
@@ -0,0 +1,234 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"time"
+
+	"joshuamURD/go-auth-api/pkgs/auth"
+	"joshuamURD/go-auth-api/pkgs/db"
+	"joshuamURD/go-auth-api/pkgs/mail"
+	"joshuamURD/go-auth-api/pkgs/models"
+)
+
+// errPasswordRejected rolls back a password reset whose new password breaks the policy
+var errPasswordRejected = errors.New("password rejected by policy")
+
+// forgotPasswordRequest is a representation of a valid request to the forgot password route
+type forgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+// resetPasswordRequest is a representation of a valid request to the reset password route
+type resetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// ForgotPassword handles a request for a password reset email
+// it always responds with 200 so that it cannot be used to find out which emails are registered
+func (pc *Controller) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	//Ensures that the request method is POST
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req forgotPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Email == "" {
+		http.Error(w, "Email is required", http.StatusBadRequest)
+		return
+	}
+
+	//Only sends an email when the user exists, without telling the caller either way
+	//the token and the email are made after responding, so a registered address is not answered any slower
+	user, err := pc.userByEmail(r.Context(), req.Email)
+	if errors.Is(err, models.ErrInvalidEmail) {
+		http.Error(w, "Invalid email address", http.StatusBadRequest)
+		return
+	}
+	if err == nil {
+		pc.sendInBackground(r.Context(), func(ctx context.Context) {
+			if err := pc.sendPasswordResetEmail(ctx, user); err != nil {
+				log.Printf("Failed to send password reset email to user %s: %v", user.ID, err)
+			}
+		})
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{
+		"message": "If the email is registered, a password reset link has been sent",
+	})
+}
+
+// resetPasswordForm is the page the emailed link opens when no PasswordResetURL is configured
+// it posts the token and the new password back to the same route as a form
+var resetPasswordForm = template.Must(template.New("reset").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Reset your password</title></head>
+<body>
+<form method="post">
+<input type="hidden" name="token" value="{{.}}">
+<label>New password <input type="password" name="password" autocomplete="new-password" required></label>
+<button type="submit">Reset password</button>
+</form>
+</body>
+</html>
+`))
+
+// ResetPassword handles setting a new password with a token from the reset email
+// GET serves a form for the emailed link, POST takes the token and password as JSON or as that form
+// it consumes the token, stores the new hash, ends every existing session of the user and lifts a lockout
+func (pc *Controller) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	//Checks if the request method is GET
+	if r.Method == http.MethodGet {
+		//The token is in the URL, so the page is not cached and the URL is not sent on as a referrer
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Referrer-Policy", "no-referrer")
+		w.Header().Set("Content-Security-Policy", "default-src 'none'; form-action 'self'")
+		resetPasswordForm.Execute(w, r.URL.Query().Get("token"))
+		return
+	} else if r.Method != http.MethodPost {
+		//Checks if the request method is POST
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req resetPasswordRequest
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "application/x-www-form-urlencoded" {
+		req.Token = r.PostFormValue("token")
+		req.Password = r.PostFormValue("password")
+	} else if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Token == "" || req.Password == "" {
+		http.Error(w, "Token and password are required", http.StatusBadRequest)
+		return
+	}
+
+	//Checks the policy before the token is used up, so a weak password can be retried with the same link
+	//the email is not known until the token is consumed, so that rule is checked again afterwards
+	if !pc.checkPassword(w, req.Password, "") {
+		return
+	}
+
+	//Hashes the new password before the transaction so that the store is not held while it runs
+	hashedPassword, err := pc.hasher.Hash(req.Password)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	//Consumes the token, stores the new hash and ends every session in one transaction
+	//a failure part way leaves the token usable and the old password and sessions in place
+	var user models.User
+	err = pc.store.WithTx(r.Context(), func(tx *db.Store) error {
+		stored, err := tx.OneTimeTokens.Consume(r.Context(), auth.HashOneTimeToken(req.Token), models.PurposePasswordReset)
+		if err != nil {
+			return err
+		}
+
+		user, err = tx.Users.GetByID(r.Context(), stored.UserID)
+		if err != nil {
+			return err
+		}
+		if violations, err := pc.config.PasswordPolicy.Check(req.Password, user.Email); err != nil || len(violations) > 0 {
+			return errPasswordRejected
+		}
+
+		//Only the hash is written, so a lock or role change made since the user was read is kept
+		if err := tx.Users.UpdatePasswordHash(r.Context(), user.ID, user.HashedPassword, hashedPassword); err != nil {
+			return err
+		}
+
+		//The new password ends a lockout caused by failed logins, but not a lock set by an admin
+		if err := tx.Users.ClearLockout(r.Context(), user.ID); err != nil {
+			return err
+		}
+
+		//Any other reset links and every existing session are no longer valid
+		if err := tx.OneTimeTokens.InvalidateForUser(r.Context(), user.ID, models.PurposePasswordReset); err != nil {
+			return err
+		}
+		return tx.RefreshTokens.RevokeAllForUser(r.Context(), user.ID)
+	})
+	if errors.Is(err, errPasswordRejected) {
+		//The rollback keeps the token, so the same link can be retried with another password
+		pc.checkPassword(w, req.Password, user.Email)
+		return
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{
+		"message": "Password reset successfully",
+	})
+}
+
+// sendPasswordResetEmail issues a reset token for the user and mails it to them
+// previously issued reset tokens are invalidated so only the latest email works
+func (pc *Controller) sendPasswordResetEmail(ctx context.Context, user models.User) error {
+	token, hash, err := auth.NewOneTimeToken()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	err = pc.store.WithTx(ctx, func(tx *db.Store) error {
+		if err := tx.OneTimeTokens.InvalidateForUser(ctx, user.ID, models.PurposePasswordReset); err != nil {
+			return err
+		}
+		return tx.OneTimeTokens.Create(ctx, models.OneTimeToken{
+			Hash:      hash,
+			UserID:    user.ID,
+			Purpose:   models.PurposePasswordReset,
+			ExpiresAt: now.Add(pc.config.PasswordResetTokenTTL),
+			CreatedAt: now,
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	link, err := pc.passwordResetLink(token)
+	if err != nil {
+		return err
+	}
+	return pc.mailer.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body:    fmt.Sprintf("A password reset was requested for your account. Use the link below to choose a new password.\n\n%s\n\nThe link expires in %s. If you did not request this, you can ignore this email.", link, pc.config.PasswordResetTokenTTL),
+	})
+}
+
+// passwordResetLink returns the link to PasswordResetURL, or to the form of ResetPassword, carrying token
+func (pc *Controller) passwordResetLink(token string) (string, error) {
+	page := pc.config.PasswordResetURL
+	if page == "" {
+		page = pc.config.BaseURL + "/password/reset"
+	}
+	link, err := url.Parse(page)
+	if err != nil {
+		return "", fmt.Errorf("invalid password reset url: %w", err)
+	}
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+	return link.String(), nil
+}
@@ -2,9 +2,13 @@ package controllers
 
 import (
 	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
 	"time"
 
+	"joshuamURD/go-auth-api/pkgs/auth"
+	"joshuamURD/go-auth-api/pkgs/db"
 	"joshuamURD/go-auth-api/pkgs/models"
 
 	"github.com/google/uuid"
@@ -25,8 +29,7 @@ func (rc *Controller) Register(w http.ResponseWriter, r *http.Request) {
 	}
 
 	//Check if already logged in
-	_, err := r.Cookie("refresh_token")
-	if err == nil {
+	if rc.hasSession(r) {
 		http.Error(w, "Already logged in", http.StatusUnauthorized)
 		return
 	}
@@ -44,6 +47,27 @@ func (rc *Controller) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	//Canonicalizes the email so the same address cannot register twice in different forms
+	email, err := rc.config.EmailPolicy.Normalize(req.Email)
+	if err != nil {
+		http.Error(w, "Invalid email address", http.StatusBadRequest)
+		return
+	}
+
+	//Refuses an address an account was registered with before the email policy changed, e.g. with its plus tag
+	if _, err := rc.userByEmail(r.Context(), req.Email); !errors.Is(err, db.ErrNotFound) {
+		if err == nil {
+			err = db.ErrEmailTaken
+		}
+		writeError(w, err)
+		return
+	}
+
+	//Refuses weak passwords before spending time hashing them
+	if !rc.checkPassword(w, req.Password, email) {
+		return
+	}
+
 	//Hashes the password
 	hashedPassword, err := rc.hasher.Hash(req.Password)
 	if err != nil {
@@ -54,23 +78,37 @@ func (rc *Controller) Register(w http.ResponseWriter, r *http.Request) {
 	//Creates a new user with the email and hashed password
 	user := models.User{
 		ID:             uuid.New(),
-		Email:          req.Email,
+		Email:          email,
 		HashedPassword: hashedPassword,
 		CreatedAt:      time.Now(),
 		UpdatedAt:      time.Now(),
 		Verified:       false,
 		FailedAttempts: 0,
 		Locked:         false,
+		Role:           models.RoleUser,
 	}
 
-	//Creates the user in the database
-	if _, err := (*rc.db).Create(user); err != nil {
-		http.Error(w, "Failed to create user", http.StatusInternalServerError)
+	//Creates the user together with their verification token, so neither is stored without the other
+	var verificationToken string
+	err = rc.store.WithTx(r.Context(), func(tx *db.Store) error {
+		if _, err := tx.Users.Create(r.Context(), user); err != nil {
+			return err
+		}
+		verificationToken, err = rc.issueVerificationToken(r.Context(), tx.OneTimeTokens, user.ID)
+		return err
+	})
+	if err != nil {
+		writeError(w, err)
 		return
 	}
 
+	//Sends the verification email once the token is stored, a failure here should not fail the registration
+	if err := rc.sendVerificationEmail(r.Context(), user, verificationToken); err != nil {
+		log.Printf("Failed to send verification email to user %s: %v", user.ID, err)
+	}
+
 	// Get auth response with access token
-	authResp, err := rc.auth.Authenticate(r.Context(), user.ID.String(), w)
+	authResp, err := rc.auth.Authenticate(r.Context(), auth.AuthClaims{UserID: user.ID.String(), Role: user.Role}, w)
 	if err != nil {
 		http.Error(w, "Authentication failed", http.StatusInternalServerError)
 		return
@@ -0,0 +1,200 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"joshuamURD/go-auth-api/pkgs/auth"
+	"joshuamURD/go-auth-api/pkgs/models"
+
+	"github.com/google/uuid"
+)
+
+// createTodoRequest is a representation of a valid request to create a todo
+type createTodoRequest struct {
+	Title       string            `json:"title"`
+	Description string            `json:"description"`
+	Status      models.TodoStatus `json:"status"`
+	DueDate     *time.Time        `json:"due_date"`
+}
+
+// updateTodoRequest is a representation of a valid request to update a todo
+// fields that are omitted from the request are left unchanged
+// the due date is kept raw so that an explicit null, which clears it, can be told apart from an omitted field
+type updateTodoRequest struct {
+	Title       *string            `json:"title"`
+	Description *string            `json:"description"`
+	Status      *models.TodoStatus `json:"status"`
+	DueDate     json.RawMessage    `json:"due_date"`
+}
+
+// Todos handles the /todos collection
+// GET lists the todos of the caller and POST creates a new one
+func (tc *Controller) Todos(w http.ResponseWriter, r *http.Request) {
+	ownerID, ok := tc.currentUserID(w, r)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		todos, err := tc.todos.GetByOwner(r.Context(), ownerID)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, todos)
+
+	case http.MethodPost:
+		//Decodes the request body into a createTodoRequest
+		var req createTodoRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		if strings.TrimSpace(req.Title) == "" {
+			http.Error(w, "Title is required", http.StatusBadRequest)
+			return
+		}
+
+		//Defaults the status to pending
+		if req.Status == "" {
+			req.Status = models.TodoPending
+		}
+		if !req.Status.Valid() {
+			http.Error(w, "Invalid status", http.StatusBadRequest)
+			return
+		}
+
+		now := time.Now()
+		todo := models.Todo{
+			ID:          uuid.New(),
+			OwnerID:     ownerID,
+			Title:       req.Title,
+			Description: req.Description,
+			Status:      req.Status,
+			DueDate:     req.DueDate,
+			CreatedAt:   now,
+			UpdatedAt:   now,
+		}
+
+		if err := tc.todos.Create(r.Context(), todo); err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, todo)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// Todo handles a single todo at /todos/{id}
+// GET returns it, PATCH updates it and DELETE removes it
+func (tc *Controller) Todo(w http.ResponseWriter, r *http.Request) {
+	ownerID, ok := tc.currentUserID(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Todo not found", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		todo, err := tc.todos.GetByID(r.Context(), id, ownerID)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, todo)
+
+	case http.MethodPatch:
+		var req updateTodoRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		todo, err := tc.todos.GetByID(r.Context(), id, ownerID)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		//Applies only the fields that were provided
+		if req.Title != nil {
+			if strings.TrimSpace(*req.Title) == "" {
+				http.Error(w, "Title is required", http.StatusBadRequest)
+				return
+			}
+			todo.Title = *req.Title
+		}
+		if req.Description != nil {
+			todo.Description = *req.Description
+		}
+		if req.Status != nil {
+			if !req.Status.Valid() {
+				http.Error(w, "Invalid status", http.StatusBadRequest)
+				return
+			}
+			todo.Status = *req.Status
+		}
+		if req.DueDate != nil {
+			var dueDate *time.Time
+			if err := json.Unmarshal(req.DueDate, &dueDate); err != nil {
+				http.Error(w, "Invalid due date", http.StatusBadRequest)
+				return
+			}
+			todo.DueDate = dueDate
+		}
+		todo.UpdatedAt = time.Now()
+
+		if err := tc.todos.Update(r.Context(), todo); err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, todo)
+
+	case http.MethodDelete:
+		if err := tc.todos.Delete(r.Context(), id, ownerID); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// currentUserID returns the ID of the user making the request
+// it relies on auth.RequireAuth having stored the claims in the request context
+// and writes a 401 if they are missing
+func (tc *Controller) currentUserID(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+	claims, ok := auth.ClaimsFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return uuid.Nil, false
+	}
+
+	userID, err := uuid.Parse(claims.UserID)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return uuid.Nil, false
+	}
+	return userID, true
+}
+
+// writeJSON writes v as a JSON response with the given status code
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
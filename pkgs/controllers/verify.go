@@ -0,0 +1,81 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"joshuamURD/go-auth-api/pkgs/auth"
+	"joshuamURD/go-auth-api/pkgs/db"
+	"joshuamURD/go-auth-api/pkgs/mail"
+	"joshuamURD/go-auth-api/pkgs/models"
+
+	"github.com/google/uuid"
+)
+
+// Verify handles the link sent in the verification email
+// it consumes the token from the query string and marks the user as verified
+func (vc *Controller) Verify(w http.ResponseWriter, r *http.Request) {
+	//Ensures that the request method is GET
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		http.Error(w, "Token is required", http.StatusBadRequest)
+		return
+	}
+
+	//Consumes the token and marks the user as verified in one transaction, so a failure leaves the link usable
+	err := vc.store.WithTx(r.Context(), func(tx *db.Store) error {
+		stored, err := tx.OneTimeTokens.Consume(r.Context(), auth.HashOneTimeToken(token), models.PurposeEmailVerification)
+		if err != nil {
+			return err
+		}
+
+		return tx.Users.MarkVerified(r.Context(), stored.UserID)
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{
+		"message": "Email verified successfully",
+	})
+}
+
+// issueVerificationToken stores a verification token for the user and returns the token to send them
+// it takes the repository to use so that it can join the transaction that creates the user
+func (vc *Controller) issueVerificationToken(ctx context.Context, tokens db.OneTimeTokenRepository, userID uuid.UUID) (string, error) {
+	token, hash, err := auth.NewOneTimeToken()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	if err := tokens.Create(ctx, models.OneTimeToken{
+		Hash:      hash,
+		UserID:    userID,
+		Purpose:   models.PurposeEmailVerification,
+		ExpiresAt: now.Add(vc.config.VerificationTokenTTL),
+		CreatedAt: now,
+	}); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// sendVerificationEmail mails the user the link that verifies their address with token
+func (vc *Controller) sendVerificationEmail(ctx context.Context, user models.User, token string) error {
+	link := vc.config.BaseURL + "/verify?token=" + url.QueryEscape(token)
+	return vc.mailer.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body:    fmt.Sprintf("Confirm your email address by opening the link below.\n\n%s\n\nThe link expires in %s.", link, vc.config.VerificationTokenTTL),
+	})
+}
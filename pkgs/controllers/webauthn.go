@@ -0,0 +1,255 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"joshuamURD/go-auth-api/pkgs/db"
+	"joshuamURD/go-auth-api/pkgs/models"
+	"joshuamURD/go-auth-api/pkgs/webauthn"
+
+	"github.com/google/uuid"
+)
+
+// beginPasskeyLoginRequest is a representation of a valid request to the passkey login begin route
+// the email is optional, without it any passkey the browser holds for the site can answer
+type beginPasskeyLoginRequest struct {
+	Email string `json:"email"`
+}
+
+// BeginPasskeyRegistration starts registering a passkey for the current user
+// it responds with the options to pass to navigator.credentials.create
+func (wc *Controller) BeginPasskeyRegistration(w http.ResponseWriter, r *http.Request) {
+	//Ensures that the request method is POST
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := wc.currentUserID(w, r)
+	if !ok {
+		return
+	}
+	user, err := wc.db.GetByID(r.Context(), userID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	//Excludes the passkeys the user already has so an authenticator is not registered twice
+	existing, err := wc.passkeys.GetCredentialsByUser(r.Context(), user.ID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	options, session, err := wc.relyingParty.BeginRegistration(user, existing)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if err := wc.passkeys.CreateSession(r.Context(), session); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{"publicKey": options})
+}
+
+// FinishPasskeyRegistration stores the passkey created with the options of BeginPasskeyRegistration
+func (wc *Controller) FinishPasskeyRegistration(w http.ResponseWriter, r *http.Request) {
+	//Ensures that the request method is POST
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := wc.currentUserID(w, r)
+	if !ok {
+		return
+	}
+
+	var req webauthn.RegistrationResponse
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	//Consumes the challenge so that the response cannot be replayed
+	challengeHash, err := req.ChallengeHash()
+	if err != nil {
+		http.Error(w, "Invalid passkey response", http.StatusBadRequest)
+		return
+	}
+	session, err := wc.passkeys.ConsumeSession(r.Context(), challengeHash, models.CeremonyRegistration)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if session.UserID == nil || *session.UserID != userID {
+		writeError(w, db.ErrWebAuthnSessionInvalid)
+		return
+	}
+
+	credential, err := wc.relyingParty.FinishRegistration(session, req)
+	if err != nil {
+		if errors.Is(err, webauthn.ErrInvalidResponse) {
+			http.Error(w, "Invalid passkey response", http.StatusBadRequest)
+			return
+		}
+		log.Printf("Passkey registration error for user %s: %v", userID, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if err := wc.passkeys.CreateCredential(r.Context(), credential); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, map[string]any{
+		"message":       "Passkey registered",
+		"credential_id": webauthn.URLEncoded(credential.ID),
+	})
+}
+
+// BeginPasskeyLogin starts a login with a passkey
+// it responds with the options to pass to navigator.credentials.get
+// an unknown email gets the same options as no email, so the response does not say whether it is registered
+func (wc *Controller) BeginPasskeyLogin(w http.ResponseWriter, r *http.Request) {
+	//Ensures that the request method is POST
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	//An empty body is a login with a discoverable passkey
+	var req beginPasskeyLoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	//Limits the login to the passkeys of the user when they have any
+	var userID *uuid.UUID
+	var credentials []models.WebAuthnCredential
+	if req.Email != "" {
+		user, err := wc.userByEmail(r.Context(), req.Email)
+		if err != nil && !errors.Is(err, db.ErrNotFound) && !errors.Is(err, models.ErrInvalidEmail) {
+			writeError(w, err)
+			return
+		}
+		if err == nil {
+			if credentials, err = wc.passkeys.GetCredentialsByUser(r.Context(), user.ID); err != nil {
+				writeError(w, err)
+				return
+			}
+			if len(credentials) > 0 {
+				userID = &user.ID
+			}
+		}
+	}
+
+	options, session, err := wc.relyingParty.BeginLogin(userID, credentials)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if err := wc.passkeys.CreateSession(r.Context(), session); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{"publicKey": options})
+}
+
+// FinishPasskeyLogin checks the assertion made with the options of BeginPasskeyLogin
+// a valid assertion starts a session exactly like a correct password would
+func (wc *Controller) FinishPasskeyLogin(w http.ResponseWriter, r *http.Request) {
+	//Ensures that the request method is POST
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req webauthn.AssertionResponse
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	if wc.hasSession(r) {
+		http.Error(w, "Already logged in", http.StatusBadRequest)
+		return
+	}
+
+	//Consumes the challenge so that the assertion cannot be replayed
+	challengeHash, err := req.ChallengeHash()
+	if err != nil {
+		http.Error(w, "Invalid passkey", http.StatusUnauthorized)
+		return
+	}
+	session, err := wc.passkeys.ConsumeSession(r.Context(), challengeHash, models.CeremonyLogin)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	//An unknown passkey gets the same response as an invalid signature
+	credential, err := wc.passkeys.GetCredential(r.Context(), req.RawID)
+	if errors.Is(err, db.ErrWebAuthnCredentialNotFound) {
+		http.Error(w, "Invalid passkey", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	user, err := wc.db.GetByID(r.Context(), credential.UserID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	//A bad assertion is not recorded as a failed login, since a passkey cannot be guessed
+	signCount, err := wc.relyingParty.FinishLogin(session, credential, req)
+	if errors.Is(err, webauthn.ErrSignCountRegressed) {
+		log.Printf("Passkey of user %s reported a signature counter that did not increase, it may have been cloned", user.ID)
+		http.Error(w, "Invalid passkey", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		if !errors.Is(err, webauthn.ErrInvalidResponse) {
+			log.Printf("Passkey login error for user %s: %v", user.ID, err)
+		}
+		http.Error(w, "Invalid passkey", http.StatusUnauthorized)
+		return
+	}
+
+	//Stores the new counter, refusing the assertion if another one with the same counter got there first
+	if err := wc.passkeys.UpdateSignCount(r.Context(), credential.ID, credential.SignCount, signCount); err != nil {
+		if errors.Is(err, db.ErrWebAuthnSignCountChanged) {
+			http.Error(w, "Invalid passkey", http.StatusUnauthorized)
+			return
+		}
+		writeError(w, err)
+		return
+	}
+
+	//Refuses locked accounts only once the passkey is proven, so an assertion from anyone else cannot reveal the lock
+	if user.IsLocked(time.Now()) {
+		writeLocked(w, user)
+		return
+	}
+
+	//Refuses unverified accounts when verification is required
+	if wc.config.RequireVerifiedEmail && !user.Verified {
+		http.Error(w, "Email address not verified", http.StatusForbidden)
+		return
+	}
+
+	wc.completeLogin(w, r, user)
+}
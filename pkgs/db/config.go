@@ -1,41 +1,139 @@
 package db
 
 import (
-	"sync"
+	"context"
+	"errors"
+	"fmt"
 )
 
-var (
-	instance *SQLiteRepository
-	once     sync.Once
+// Supported database drivers
+const (
+	DriverSQLite   = "sqlite"
+	DriverPostgres = "postgres"
 )
 
 // Config holds database configuration
 type Config struct {
+	// Driver selects the backend, DriverSQLite when empty
+	Driver string
+	// Path is the SQLite database file
 	Path string
+	// DSN is the PostgreSQL connection string
+	DSN string
+	// Migrate applies pending schema migrations when the store is opened
+	Migrate bool
 }
 
-// Initialize sets up the database connection with the given configuration
-func Initialize(config Config) error {
-	var err error
-	once.Do(func() {
-		creator := SQLiteTableCreator{}
-		instance = NewSQLiteRepository(config.Path, creator)
-	})
-	return err
+// Store holds the repositories sharing one database connection
+type Store struct {
+	Users         Database
+	Todos         TodoRepository
+	RefreshTokens RefreshTokenRepository
+	OneTimeTokens OneTimeTokenRepository
+	MFA           MFARepository
+	WebAuthn      WebAuthnRepository
+	Migrator      *Migrator
+	// Begin runs fn with a copy of the store whose repositories all work in one transaction,
+	// see WithTx. It is set by the backend that built the store.
+	Begin func(ctx context.Context, fn func(tx *Store) error) error
+	close func() error
 }
 
-// GetInstance returns the database instance
-func GetInstance() Database {
-	if instance == nil {
-		panic("Database not initialized. Call Initialize first")
+// ErrNoTransactions is returned by WithTx on a store whose backend did not set Begin
+var ErrNoTransactions = errors.New("store does not support transactions")
+
+// WithTx runs fn as a single unit of work across every repository: the calls made through
+// the repositories of tx are committed together when fn returns nil and rolled back otherwise.
+// Calling WithTx on tx joins the transaction already in progress.
+func (s *Store) WithTx(ctx context.Context, fn func(tx *Store) error) error {
+	if s.Begin == nil {
+		return ErrNoTransactions
+	}
+	return s.Begin(ctx, fn)
+}
+
+// newSQLiteStore builds the repositories on top of a SQLite connection, or the transaction it is bound to
+func newSQLiteStore(repo *SQLiteRepository) *Store {
+	return &Store{
+		Users:         repo,
+		Todos:         NewSQLiteTodoRepository(repo),
+		RefreshTokens: NewSQLiteRefreshTokenRepository(repo),
+		OneTimeTokens: NewSQLiteOneTimeTokenRepository(repo),
+		MFA:           NewSQLiteMFARepository(repo),
+		WebAuthn:      NewSQLiteWebAuthnRepository(repo),
+		Begin: func(ctx context.Context, fn func(tx *Store) error) error {
+			return repo.WithTx(ctx, func(tx Database) error {
+				return fn(newSQLiteStore(tx.(*SQLiteRepository)))
+			})
+		},
+	}
+}
+
+// newPostgresStore builds the repositories on top of a PostgreSQL connection, or the transaction it is bound to
+func newPostgresStore(repo *PostgresRepository) *Store {
+	return &Store{
+		Users:         repo,
+		Todos:         NewPostgresTodoRepository(repo),
+		RefreshTokens: NewPostgresRefreshTokenRepository(repo),
+		OneTimeTokens: NewPostgresOneTimeTokenRepository(repo),
+		MFA:           NewPostgresMFARepository(repo),
+		WebAuthn:      NewPostgresWebAuthnRepository(repo),
+		Begin: func(ctx context.Context, fn func(tx *Store) error) error {
+			return repo.WithTx(ctx, func(tx Database) error {
+				return fn(newPostgresStore(tx.(*PostgresRepository)))
+			})
+		},
+	}
+}
+
+// Open connects to the database described by config and builds its repositories
+// every call returns an independent store, so several can be open in one process
+func Open(config Config) (*Store, error) {
+	var store *Store
+	switch config.Driver {
+	case "", DriverSQLite:
+		repo, err := NewSQLiteRepository(config.Path)
+		if err != nil {
+			return nil, err
+		}
+		migrator, err := NewMigrator(repo.db, DriverSQLite)
+		if err != nil {
+			repo.Close()
+			return nil, err
+		}
+		store = newSQLiteStore(repo)
+		store.Migrator = migrator
+		store.close = repo.Close
+	case DriverPostgres:
+		repo, err := NewPostgresRepository(config.DSN)
+		if err != nil {
+			return nil, err
+		}
+		migrator, err := NewMigrator(repo.db, DriverPostgres)
+		if err != nil {
+			repo.Close()
+			return nil, err
+		}
+		store = newPostgresStore(repo)
+		store.Migrator = migrator
+		store.close = repo.Close
+	default:
+		return nil, fmt.Errorf("unsupported database driver %q", config.Driver)
+	}
+
+	if config.Migrate {
+		if _, err := store.Migrator.Up(); err != nil {
+			store.Close()
+			return nil, fmt.Errorf("failed to migrate database: %w", err)
+		}
 	}
-	return instance
+	return store, nil
 }
 
-// Close closes the database connection
-func Close() error {
-	if instance != nil {
-		return instance.db.Close()
+// Close closes the database connection, if the store has one
+func (s *Store) Close() error {
+	if s.close == nil {
+		return nil
 	}
-	return nil
+	return s.close()
 }
@@ -1,58 +1,100 @@
 package db
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"joshuamURD/go-auth-api/pkgs/models"
-	"log"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
-	_ "modernc.org/sqlite"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
 )
 
+// ErrEmailTaken is returned when creating or updating a user would give two accounts the same email
+var ErrEmailTaken = newKindError(ErrConflict, "email already registered")
+
+// ErrLastAdmin is returned by SetRole when the change would leave no admin
+var ErrLastAdmin = newKindError(ErrConflict, "cannot demote the last admin")
+
+// ErrAdminExists is returned by PromoteFirstAdmin when there already is an admin
+var ErrAdminExists = newKindError(ErrConflict, "an admin already exists")
+
+// ErrPasswordChanged is returned by UpdatePasswordHash when the stored hash is no longer the one being replaced
+var ErrPasswordChanged = newKindError(ErrConflict, "password changed concurrently")
+
 // SQLiteRepository is a wrapper around the sql.DB type.
+// Inside WithTx it is bound to the transaction and every statement runs in it.
 type SQLiteRepository struct {
 	db *sql.DB
+	tx *sql.Tx
 }
 
 // Database is an interface that defines the methods for the SQLiteRepository.
 type Database interface {
-	GetAll() ([]models.User, error)
-	Create(models.User) (int, error)
-	GetByEmail(string) (models.User, error)
-	GetByID(uuid.UUID) (models.User, error)
-}
-
-// TableCreator defines the interface for table creation
-type TableCreator interface {
-	CreateTable(db *sql.DB) error
-}
-
-// SQLiteTableCreator implements TableCreator for SQLite
-type SQLiteTableCreator struct{}
-
-func (s SQLiteTableCreator) CreateTable(db *sql.DB) error {
-	query := `
-	CREATE TABLE IF NOT EXISTS users (
-		id TEXT PRIMARY KEY,
-		email TEXT NOT NULL,
-		verified BOOLEAN NOT NULL,
-		failed_attempts INTEGER NOT NULL,
-		locked BOOLEAN NOT NULL,
-		hashed_password TEXT NOT NULL,
-		created_at TEXT NOT NULL,
-		updated_at TEXT NOT NULL
-	);`
-	_, err := db.Exec(query)
-	return err
-}
-
-// NewSQLiteRepository creates a new SQLiteRepository.
-func NewSQLiteRepository(path string, creator TableCreator) *SQLiteRepository {
+	GetAll(ctx context.Context) ([]models.User, error)
+	Create(ctx context.Context, user models.User) (int, error)
+	GetByEmail(ctx context.Context, email string) (models.User, error)
+	GetByID(ctx context.Context, id uuid.UUID) (models.User, error)
+	Update(ctx context.Context, user models.User) error
+	// UpdatePasswordHash replaces the password hash of a user only while it is still oldHash,
+	// leaving every other column as stored. It returns ErrPasswordChanged if the hash changed in the meantime.
+	UpdatePasswordHash(ctx context.Context, id uuid.UUID, oldHash, newHash string) error
+	RecordFailedLogin(ctx context.Context, id uuid.UUID, maxAttempts int, lockFor time.Duration) (models.User, error)
+	ResetFailedLogins(ctx context.Context, id uuid.UUID) error
+	// Lock, MarkVerified, ClearFailedAttempts and ClearLockout each write only the columns they change,
+	// so they cannot undo a concurrent change to the rest of the row
+	Lock(ctx context.Context, id uuid.UUID) error
+	MarkVerified(ctx context.Context, id uuid.UUID) error
+	ClearFailedAttempts(ctx context.Context, id uuid.UUID) error
+	// ClearLockout clears the failed attempt counter and a lock that has an end, keeping a lock set by an admin
+	ClearLockout(ctx context.Context, id uuid.UUID) error
+	// SetRole writes the role of a user. It returns ErrLastAdmin rather than demote the only admin,
+	// and concurrent calls cannot both pass that check.
+	SetRole(ctx context.Context, id uuid.UUID, role models.Role) error
+	// PromoteFirstAdmin makes a user an admin only while there is no admin, returning ErrAdminExists otherwise.
+	// Concurrent calls cannot both succeed.
+	PromoteFirstAdmin(ctx context.Context, id uuid.UUID) error
+	List(ctx context.Context, filter UserFilter) ([]models.User, int, error)
+	Delete(ctx context.Context, id uuid.UUID) error
+	// WithTx runs fn as a single unit of work: every call made through tx is committed
+	// together when fn returns nil and rolled back otherwise. Calling WithTx on tx joins
+	// the transaction already in progress.
+	WithTx(ctx context.Context, fn func(tx Database) error) error
+}
+
+// UserFilter narrows down and pages the users returned by List.
+// Nil pointers and empty strings do not filter.
+type UserFilter struct {
+	Email    string // matches users whose email contains this string
+	Verified *bool
+	Locked   *bool
+	Limit    int
+	Offset   int
+}
+
+// GetByAnyEmail returns the user stored under the first of emails that matches one,
+// e.g. the forms models.EmailPolicy.Lookups returns for an address
+func GetByAnyEmail(ctx context.Context, users Database, emails []string) (models.User, error) {
+	for _, email := range emails {
+		user, err := users.GetByEmail(ctx, email)
+		if !errors.Is(err, ErrNotFound) {
+			return user, err
+		}
+	}
+	return models.User{}, ErrUserNotFound
+}
+
+// NewSQLiteRepository opens the SQLite database file at path, creating it if needed.
+// The path ":memory:" opens a private in-memory database.
+// The schema is managed separately by a Migrator.
+func NewSQLiteRepository(path string) (*SQLiteRepository, error) {
 	db, err := sql.Open("sqlite", path)
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
 
 	// Configure connection pool
@@ -60,23 +102,19 @@ func NewSQLiteRepository(path string, creator TableCreator) *SQLiteRepository {
 	db.SetMaxIdleConns(25)                 // Set max idle connections
 	db.SetConnMaxLifetime(5 * time.Minute) // Set max lifetime for connections
 
-	// Test the connection
-	if err := db.Ping(); err != nil {
-		log.Fatal("Failed to ping database:", err)
+	// Every connection to :memory: is a separate database, so the pool is kept to a single one
+	if path == ":memory:" {
+		db.SetMaxOpenConns(1)
+		db.SetConnMaxLifetime(0)
 	}
 
-	if err := creator.CreateTable(db); err != nil {
-		log.Fatal("Failed to create table:", err)
-	}
-
-	repo := &SQLiteRepository{db: db}
-
-	// Migrate timestamps to RFC3339 format
-	if err := repo.MigrateTimestamps(); err != nil {
-		log.Printf("Warning: Failed to migrate timestamps: %v", err)
+	// Test the connection
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 
-	return repo
+	return &SQLiteRepository{db: db}, nil
 }
 
 // Close closes the database connection
@@ -84,153 +122,392 @@ func (d *SQLiteRepository) Close() error {
 	return d.db.Close()
 }
 
-// getItems retrieves all items from the database.
-func (d *SQLiteRepository) GetAll() ([]models.User, error) {
-	rows, err := d.db.Query("SELECT id, email, verified, failed_attempts, locked, hashed_password, created_at, updated_at FROM users")
+// userColumns is the column list every user query selects, in the order scanUser reads them
+const userColumns = "id, email, verified, failed_attempts, locked, locked_until, hashed_password, role, created_at, updated_at"
+
+// legacyTimestampLayout is the format timestamps were stored in before they were migrated to RFC3339
+const legacyTimestampLayout = "2006-01-02 15:04:05.999999999 -0700 MST"
+
+// GetAll retrieves all users from the database.
+func (d *SQLiteRepository) GetAll(ctx context.Context) ([]models.User, error) {
+	rows, err := d.conn().QueryContext(ctx, "SELECT "+userColumns+" FROM users")
 	if err != nil {
-		return nil, fmt.Errorf("database error: %w", err)
+		return nil, sqliteError("database error", err)
 	}
 	defer rows.Close()
 
 	var users []models.User
 	for rows.Next() {
-		var user models.User
-		var createdAtStr, updatedAtStr string
-
-		if err := rows.Scan(
-			&user.ID,
-			&user.Email,
-			&user.Verified,
-			&user.FailedAttempts,
-			&user.Locked,
-			&user.HashedPassword,
-			&createdAtStr,
-			&updatedAtStr,
-		); err != nil {
-			return nil, fmt.Errorf("scan error: %w", err)
-		}
-
-		// Parse the time strings
-		createdAt, err := time.Parse(time.RFC3339, createdAtStr)
+		user, err := scanUser(rows)
 		if err != nil {
-			return nil, fmt.Errorf("error parsing created_at time: %w", err)
+			return nil, err
 		}
-		user.CreatedAt = createdAt
-
-		updatedAt, err := time.Parse(time.RFC3339, updatedAtStr)
-		if err != nil {
-			return nil, fmt.Errorf("error parsing updated_at time: %w", err)
-		}
-		user.UpdatedAt = updatedAt
-
 		users = append(users, user)
 	}
 
-	return users, nil
+	return users, rows.Err()
 }
 
-// addItem inserts a new item into the database.
-func (d *SQLiteRepository) Create(user models.User) (int, error) {
+// Create inserts a new user into the database.
+func (d *SQLiteRepository) Create(ctx context.Context, user models.User) (int, error) {
 	// Format the timestamps in RFC3339 format
 	createdAt := user.CreatedAt.Format(time.RFC3339)
 	updatedAt := user.UpdatedAt.Format(time.RFC3339)
 
-	result, err := d.db.Exec(
-		"INSERT INTO users (id, email, verified, failed_attempts, locked, hashed_password, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+	result, err := d.conn().ExecContext(
+		ctx,
+		"INSERT INTO users (id, email, email_key, verified, failed_attempts, locked, locked_until, hashed_password, role, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
 		user.ID,
 		user.Email,
+		models.EmailKey(user.Email),
 		user.Verified,
 		user.FailedAttempts,
 		user.Locked,
+		formatNullTime(utcTime(user.LockedUntil)),
 		user.HashedPassword,
+		roleOrDefault(user.Role),
 		createdAt,
 		updatedAt,
 	)
+	if isSQLiteUniqueViolation(err) {
+		return 0, ErrEmailTaken
+	}
 	if err != nil {
-		return 0, fmt.Errorf("error creating user: %w", err)
+		return 0, sqliteError("error creating user", err)
 	}
 	id, err := result.LastInsertId()
 	return int(id), err
 }
 
-func (d *SQLiteRepository) GetByEmail(email string) (models.User, error) {
-	var user models.User
-	var createdAtStr, updatedAtStr string
+// GetByEmail retrieves a user by their email address, ignoring case as models.EmailKey does.
+func (d *SQLiteRepository) GetByEmail(ctx context.Context, email string) (models.User, error) {
+	row := d.conn().QueryRowContext(ctx, "SELECT "+userColumns+" FROM users WHERE email_key = ?", models.EmailKey(email))
+	user, err := scanUser(row)
+	if err == sql.ErrNoRows {
+		return user, fmt.Errorf("%w with email: %s", ErrUserNotFound, email)
+	}
+	return user, err
+}
 
-	row := d.db.QueryRow("SELECT id, email, verified, failed_attempts, locked, hashed_password, created_at, updated_at FROM users WHERE email = ?", email)
-	err := row.Scan(
-		&user.ID,
-		&user.Email,
-		&user.Verified,
-		&user.FailedAttempts,
-		&user.Locked,
-		&user.HashedPassword,
-		&createdAtStr,
-		&updatedAtStr,
+// GetByID retrieves a user by their ID.
+func (d *SQLiteRepository) GetByID(ctx context.Context, id uuid.UUID) (models.User, error) {
+	row := d.conn().QueryRowContext(ctx, "SELECT "+userColumns+" FROM users WHERE id = ?", id)
+	user, err := scanUser(row)
+	if err == sql.ErrNoRows {
+		return user, fmt.Errorf("%w with id: %s", ErrUserNotFound, id)
+	}
+	return user, err
+}
+
+// Update overwrites the stored fields of an existing user.
+func (d *SQLiteRepository) Update(ctx context.Context, user models.User) error {
+	result, err := d.conn().ExecContext(
+		ctx,
+		"UPDATE users SET email = ?, email_key = ?, verified = ?, failed_attempts = ?, locked = ?, locked_until = ?, hashed_password = ?, role = ?, updated_at = ? WHERE id = ?",
+		user.Email,
+		models.EmailKey(user.Email),
+		user.Verified,
+		user.FailedAttempts,
+		user.Locked,
+		formatNullTime(utcTime(user.LockedUntil)),
+		user.HashedPassword,
+		roleOrDefault(user.Role),
+		user.UpdatedAt.Format(time.RFC3339),
+		user.ID,
 	)
+	if isSQLiteUniqueViolation(err) {
+		return ErrEmailTaken
+	}
+	if err != nil {
+		return sqliteError("error updating user", err)
+	}
+	return expectAffected(result, fmt.Errorf("%w with id: %s", ErrUserNotFound, user.ID))
+}
 
+// UpdatePasswordHash replaces the password hash of a user if it is still oldHash, see Database.
+func (d *SQLiteRepository) UpdatePasswordHash(ctx context.Context, id uuid.UUID, oldHash, newHash string) error {
+	result, err := d.conn().ExecContext(
+		ctx,
+		"UPDATE users SET hashed_password = ?, updated_at = ? WHERE id = ? AND hashed_password = ?",
+		newHash,
+		time.Now().UTC().Format(time.RFC3339),
+		id,
+		oldHash,
+	)
+	if err != nil {
+		return sqliteError("error updating password", err)
+	}
+	if err := expectAffected(result, ErrPasswordChanged); err != nil {
+		// A missing user is reported as such rather than as a changed password
+		if _, getErr := d.GetByID(ctx, id); getErr != nil {
+			return getErr
+		}
+		return err
+	}
+	return nil
+}
+
+// RecordFailedLogin atomically increments the failed attempt counter of a user
+// and locks the account for lockFor once maxAttempts is reached. A lock whose time
+// has passed is cleared first so the count starts again. A maxAttempts of zero never locks.
+// It returns the user as stored after the update.
+func (d *SQLiteRepository) RecordFailedLogin(ctx context.Context, id uuid.UUID, maxAttempts int, lockFor time.Duration) (models.User, error) {
+	now := time.Now().UTC()
+
+	// Clear an expired lock so that the attempt counts from zero again
+	if _, err := d.conn().ExecContext(
+		ctx,
+		"UPDATE users SET locked = 0, locked_until = NULL, failed_attempts = 0 WHERE id = ? AND locked AND locked_until IS NOT NULL AND locked_until <= ?",
+		id,
+		now.Format(time.RFC3339),
+	); err != nil {
+		return models.User{}, sqliteError("error clearing expired lock", err)
+	}
+
+	// The new values are computed from the current row in a single statement so concurrent
+	// failures cannot lose increments
+	row := d.conn().QueryRowContext(
+		ctx,
+		`UPDATE users SET
+			failed_attempts = failed_attempts + 1,
+			locked = CASE WHEN ?1 > 0 AND failed_attempts + 1 >= ?1 THEN 1 ELSE locked END,
+			locked_until = CASE WHEN ?1 > 0 AND failed_attempts + 1 >= ?1 AND NOT locked THEN ?2 ELSE locked_until END,
+			updated_at = ?3
+		WHERE id = ?4
+		RETURNING `+userColumns,
+		maxAttempts,
+		now.Add(lockFor).Format(time.RFC3339),
+		now.Format(time.RFC3339),
+		id,
+	)
+	user, err := scanUser(row)
 	if err == sql.ErrNoRows {
-		return user, fmt.Errorf("user not found with email: %s", email)
+		return user, fmt.Errorf("%w with id: %s", ErrUserNotFound, id)
 	}
+	return user, err
+}
+
+// ResetFailedLogins clears the failed attempt counter and any lock on a user.
+func (d *SQLiteRepository) ResetFailedLogins(ctx context.Context, id uuid.UUID) error {
+	_, err := d.conn().ExecContext(
+		ctx,
+		"UPDATE users SET failed_attempts = 0, locked = 0, locked_until = NULL, updated_at = ? WHERE id = ?",
+		time.Now().Format(time.RFC3339),
+		id,
+	)
 	if err != nil {
-		return user, fmt.Errorf("database error: %w", err)
+		return sqliteError("error resetting failed logins", err)
 	}
+	return nil
+}
+
+// Lock locks a user until an admin unlocks them, keeping their failed attempt counter.
+func (d *SQLiteRepository) Lock(ctx context.Context, id uuid.UUID) error {
+	return d.updateColumns(ctx, id, "locked = 1, locked_until = NULL", "error locking user")
+}
 
-	// Try parsing with RFC3339 first
-	createdAt, err := time.Parse(time.RFC3339, createdAtStr)
+// MarkVerified marks the email of a user as verified.
+func (d *SQLiteRepository) MarkVerified(ctx context.Context, id uuid.UUID) error {
+	return d.updateColumns(ctx, id, "verified = 1", "error verifying user")
+}
+
+// ClearFailedAttempts sets the failed attempt counter of a user to zero, keeping any lock.
+func (d *SQLiteRepository) ClearFailedAttempts(ctx context.Context, id uuid.UUID) error {
+	return d.updateColumns(ctx, id, "failed_attempts = 0", "error clearing failed attempts")
+}
+
+// ClearLockout clears the failed attempts and a lockout of a user, but not a lock set by an admin.
+func (d *SQLiteRepository) ClearLockout(ctx context.Context, id uuid.UUID) error {
+	// Every expression reads the row as it was before the update
+	return d.updateColumns(ctx, id, "failed_attempts = 0, locked = (locked AND locked_until IS NULL), locked_until = NULL", "error clearing lockout")
+}
+
+// SetRole writes the role of a user, refusing to demote the only admin, see Database.
+// The check and the update are one statement, which SQLite runs under its write lock.
+func (d *SQLiteRepository) SetRole(ctx context.Context, id uuid.UUID, role models.Role) error {
+	result, err := d.conn().ExecContext(
+		ctx,
+		`UPDATE users SET role = ?1, updated_at = ?2 WHERE id = ?3
+		AND (?1 = ?4 OR role != ?4 OR EXISTS (SELECT 1 FROM users WHERE role = ?4 AND id != ?3))`,
+		role,
+		time.Now().UTC().Format(time.RFC3339),
+		id,
+		models.RoleAdmin,
+	)
 	if err != nil {
-		// If that fails, try parsing the current format
-		createdAt, err = time.Parse("2006-01-02 15:04:05.999999999 -0700 MST", createdAtStr)
-		if err != nil {
-			return user, fmt.Errorf("error parsing created_at time: %w", err)
+		return sqliteError("error setting role", err)
+	}
+	if err := expectAffected(result, ErrLastAdmin); err != nil {
+		if _, getErr := d.GetByID(ctx, id); getErr != nil {
+			return getErr
 		}
+		return err
 	}
-	user.CreatedAt = createdAt
+	return nil
+}
 
-	updatedAt, err := time.Parse(time.RFC3339, updatedAtStr)
+// PromoteFirstAdmin makes a user an admin while there is none, see Database.
+func (d *SQLiteRepository) PromoteFirstAdmin(ctx context.Context, id uuid.UUID) error {
+	result, err := d.conn().ExecContext(
+		ctx,
+		"UPDATE users SET role = ?1, updated_at = ?2 WHERE id = ?3 AND NOT EXISTS (SELECT 1 FROM users WHERE role = ?1)",
+		models.RoleAdmin,
+		time.Now().UTC().Format(time.RFC3339),
+		id,
+	)
 	if err != nil {
-		// If that fails, try parsing the current format
-		updatedAt, err = time.Parse("2006-01-02 15:04:05.999999999 -0700 MST", updatedAtStr)
+		return sqliteError("error promoting admin", err)
+	}
+	if err := expectAffected(result, ErrAdminExists); err != nil {
+		if _, getErr := d.GetByID(ctx, id); getErr != nil {
+			return getErr
+		}
+		return err
+	}
+	return nil
+}
+
+// updateColumns applies the SET clause assignments to a user and bumps updated_at
+func (d *SQLiteRepository) updateColumns(ctx context.Context, id uuid.UUID, assignments string, msg string) error {
+	result, err := d.conn().ExecContext(ctx, "UPDATE users SET "+assignments+", updated_at = ? WHERE id = ?", time.Now().UTC().Format(time.RFC3339), id)
+	if err != nil {
+		return sqliteError(msg, err)
+	}
+	return expectAffected(result, fmt.Errorf("%w with id: %s", ErrUserNotFound, id))
+}
+
+// List retrieves a page of users matching the filter, ordered by creation time,
+// along with the total number of matching users.
+func (d *SQLiteRepository) List(ctx context.Context, filter UserFilter) ([]models.User, int, error) {
+	where := "WHERE 1 = 1"
+	var args []any
+	if filter.Email != "" {
+		where += " AND email_key LIKE ? ESCAPE '\\'"
+		args = append(args, "%"+escapeLike(models.EmailKey(filter.Email))+"%")
+	}
+	if filter.Verified != nil {
+		where += " AND verified = ?"
+		args = append(args, *filter.Verified)
+	}
+	if filter.Locked != nil {
+		where += " AND locked = ?"
+		args = append(args, *filter.Locked)
+	}
+
+	var total int
+	if err := d.conn().QueryRowContext(ctx, "SELECT COUNT(*) FROM users "+where, args...).Scan(&total); err != nil {
+		return nil, 0, sqliteError("database error", err)
+	}
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = -1 // SQLite treats a negative limit as no limit
+	}
+	rows, err := d.conn().QueryContext(
+		ctx,
+		"SELECT "+userColumns+" FROM users "+where+" ORDER BY created_at, id LIMIT ? OFFSET ?",
+		append(args, limit, filter.Offset)...,
+	)
+	if err != nil {
+		return nil, 0, sqliteError("database error", err)
+	}
+	defer rows.Close()
+
+	users := []models.User{}
+	for rows.Next() {
+		user, err := scanUser(rows)
 		if err != nil {
-			return user, fmt.Errorf("error parsing updated_at time: %w", err)
+			return nil, 0, err
 		}
+		users = append(users, user)
 	}
-	user.UpdatedAt = updatedAt
 
-	return user, nil
+	return users, total, rows.Err()
+}
+
+// Delete removes a user along with their todos, tokens, second factors and passkeys.
+func (d *SQLiteRepository) Delete(ctx context.Context, id uuid.UUID) error {
+	return runInTx(ctx, d.db, d.tx, func(tx *sql.Tx) error {
+		// Foreign keys are not enforced by SQLite by default, so dependent rows are removed explicitly
+		for _, table := range []string{"todos", "refresh_tokens", "one_time_tokens", "totp_enrollments", "recovery_codes", "webauthn_credentials", "webauthn_sessions"} {
+			column := "user_id"
+			if table == "todos" {
+				column = "owner_id"
+			}
+			if _, err := tx.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE %s = ?", table, column), id); err != nil {
+				return sqliteError(fmt.Sprintf("error deleting %s for user %s", table, id), err)
+			}
+		}
+
+		result, err := tx.ExecContext(ctx, "DELETE FROM users WHERE id = ?", id)
+		if err != nil {
+			return sqliteError("error deleting user", err)
+		}
+		return expectAffected(result, fmt.Errorf("%w with id: %s", ErrUserNotFound, id))
+	})
+}
+
+// WithTx runs fn in a transaction, see Database.
+func (d *SQLiteRepository) WithTx(ctx context.Context, fn func(tx Database) error) error {
+	return runInTx(ctx, d.db, d.tx, func(tx *sql.Tx) error {
+		return fn(&SQLiteRepository{db: d.db, tx: tx})
+	})
 }
 
-func (d *SQLiteRepository) GetByID(id uuid.UUID) (models.User, error) {
+// conn returns the transaction the repository is bound to, or the connection pool
+func (d *SQLiteRepository) conn() querier {
+	if d.tx != nil {
+		return d.tx
+	}
+	return d.db
+}
+
+// escapeLike escapes the LIKE wildcards in s so it is matched literally
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// scanUser reads a user from a row selected with userColumns
+// sql.ErrNoRows is returned unwrapped so callers can report the missing user
+func scanUser(row rowScanner) (models.User, error) {
 	var user models.User
+	var lockedUntilStr sql.NullString
 	var createdAtStr, updatedAtStr string
 
-	row := d.db.QueryRow("SELECT id, email, verified, failed_attempts, locked, hashed_password, created_at, updated_at FROM users WHERE id = ?", id)
 	err := row.Scan(
 		&user.ID,
 		&user.Email,
 		&user.Verified,
 		&user.FailedAttempts,
 		&user.Locked,
+		&lockedUntilStr,
 		&user.HashedPassword,
+		&user.Role,
 		&createdAtStr,
 		&updatedAtStr,
 	)
-
 	if err == sql.ErrNoRows {
-		return user, fmt.Errorf("user not found with id: %s", id)
+		return user, err
 	}
 	if err != nil {
-		return user, fmt.Errorf("database error: %w", err)
+		return user, sqliteError("database error", err)
+	}
+
+	if lockedUntilStr.Valid {
+		lockedUntil, err := parseTimestamp(lockedUntilStr.String)
+		if err != nil {
+			return user, fmt.Errorf("error parsing locked_until time: %w", err)
+		}
+		user.LockedUntil = &lockedUntil
 	}
 
-	// Parse the time strings
-	createdAt, err := time.Parse(time.RFC3339, createdAtStr)
+	createdAt, err := parseTimestamp(createdAtStr)
 	if err != nil {
 		return user, fmt.Errorf("error parsing created_at time: %w", err)
 	}
 	user.CreatedAt = createdAt
 
-	updatedAt, err := time.Parse(time.RFC3339, updatedAtStr)
+	updatedAt, err := parseTimestamp(updatedAtStr)
 	if err != nil {
 		return user, fmt.Errorf("error parsing updated_at time: %w", err)
 	}
@@ -239,44 +516,40 @@ func (d *SQLiteRepository) GetByID(id uuid.UUID) (models.User, error) {
 	return user, nil
 }
 
-// MigrateTimestamps updates all existing timestamps to RFC3339 format
-func (d *SQLiteRepository) MigrateTimestamps() error {
-	rows, err := d.db.Query("SELECT id, created_at, updated_at FROM users")
+// isSQLiteUniqueViolation reports whether err comes from a UNIQUE index, the only one on users being the email
+func isSQLiteUniqueViolation(err error) bool {
+	var sqliteErr *sqlite.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE
+}
+
+// parseTimestamp parses an RFC3339 timestamp, falling back to the legacy format
+func parseTimestamp(s string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, s)
 	if err != nil {
-		return fmt.Errorf("failed to query users: %w", err)
+		return time.Parse(legacyTimestampLayout, s)
 	}
-	defer rows.Close()
-
-	for rows.Next() {
-		var id string
-		var createdAtStr, updatedAtStr string
-		if err := rows.Scan(&id, &createdAtStr, &updatedAtStr); err != nil {
-			return fmt.Errorf("failed to scan row: %w", err)
-		}
-
-		// Parse and reformat created_at
-		createdAt, err := time.Parse("2006-01-02 15:04:05.999999999 -0700 MST", createdAtStr)
-		if err == nil {
-			createdAtStr = createdAt.Format(time.RFC3339)
-		}
+	return t, nil
+}
 
-		// Parse and reformat updated_at
-		updatedAt, err := time.Parse("2006-01-02 15:04:05.999999999 -0700 MST", updatedAtStr)
-		if err == nil {
-			updatedAtStr = updatedAt.Format(time.RFC3339)
-		}
+// roleOrDefault returns the role to store, treating an unset role as a regular user
+func roleOrDefault(role models.Role) models.Role {
+	if role == "" {
+		return models.RoleUser
+	}
+	return role
+}
 
-		// Update the record
-		_, err = d.db.Exec(
-			"UPDATE users SET created_at = ?, updated_at = ? WHERE id = ?",
-			createdAtStr,
-			updatedAtStr,
-			id,
-		)
-		if err != nil {
-			return fmt.Errorf("failed to update timestamps for user %s: %w", id, err)
-		}
+// utcTime converts an optional time to UTC so stored values compare correctly as text
+func utcTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
 	}
+	u := t.UTC()
+	return &u
+}
 
-	return nil
+// isSQLitePrimaryKeyViolation reports whether err comes from inserting a row whose primary key is taken
+func isSQLitePrimaryKeyViolation(err error) bool {
+	var sqliteErr *sqlite.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY
 }
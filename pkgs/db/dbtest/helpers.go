@@ -0,0 +1,47 @@
+package dbtest
+
+import (
+	"testing"
+	"time"
+
+	"joshuamURD/go-auth-api/pkgs/models"
+
+	"github.com/google/uuid"
+)
+
+// newUser returns a user with the given email that has not been stored yet
+// the SQL backends store times to the second, so times are truncated to keep comparisons exact
+func newUser(email string) models.User {
+	now := time.Now().UTC().Truncate(time.Second)
+	return models.User{
+		ID:             uuid.New(),
+		Email:          email,
+		HashedPassword: "hash",
+		Role:           models.RoleUser,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+}
+
+// sameTime reports whether two times are within a second of each other
+func sameTime(a, b time.Time) bool {
+	d := a.Sub(b)
+	return d < time.Second && d > -time.Second
+}
+
+// assertUser fails the test unless got holds the stored fields of want
+func assertUser(t *testing.T, got, want models.User) {
+	t.Helper()
+	if got.ID != want.ID || got.Email != want.Email || got.Verified != want.Verified ||
+		got.FailedAttempts != want.FailedAttempts || got.Locked != want.Locked ||
+		got.HashedPassword != want.HashedPassword || got.Role != want.Role {
+		t.Errorf("got user %+v, want %+v", got, want)
+	}
+	if !sameTime(got.CreatedAt, want.CreatedAt) {
+		t.Errorf("got created_at %v, want %v", got.CreatedAt, want.CreatedAt)
+	}
+	if (got.LockedUntil == nil) != (want.LockedUntil == nil) ||
+		got.LockedUntil != nil && !sameTime(*got.LockedUntil, *want.LockedUntil) {
+		t.Errorf("got locked_until %v, want %v", got.LockedUntil, want.LockedUntil)
+	}
+}
@@ -0,0 +1,132 @@
+package dbtest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"joshuamURD/go-auth-api/pkgs/db"
+	"joshuamURD/go-auth-api/pkgs/models"
+)
+
+func testMFA(t *testing.T, newStore func(t *testing.T) *db.Store) {
+	ctx := context.Background()
+
+	t.Run("EnrollAndConfirm", func(t *testing.T) {
+		store := newStore(t)
+		mfa := store.MFA
+		user := storeUser(t, store, "alice@example.com")
+
+		if _, err := mfa.GetTOTP(ctx, user); !errors.Is(err, db.ErrTOTPNotFound) || !errors.Is(err, db.ErrNotFound) {
+			t.Errorf("GetTOTP without an enrollment returned %v, want ErrTOTPNotFound", err)
+		}
+		if err := mfa.ConfirmTOTP(ctx, user, 1); !errors.Is(err, db.ErrTOTPNotFound) {
+			t.Errorf("ConfirmTOTP without an enrollment returned %v, want ErrTOTPNotFound", err)
+		}
+
+		now := time.Now().UTC().Truncate(time.Second)
+		for _, secret := range []string{"FIRST", "SECOND"} {
+			if err := mfa.SaveTOTP(ctx, models.TOTPEnrollment{UserID: user, Secret: secret, CreatedAt: now}); err != nil {
+				t.Fatalf("SaveTOTP: %v", err)
+			}
+		}
+		pending, err := mfa.GetTOTP(ctx, user)
+		if err != nil {
+			t.Fatalf("GetTOTP: %v", err)
+		}
+		if pending.Secret != "SECOND" || pending.Enabled() || !sameTime(pending.CreatedAt, now) {
+			t.Errorf("got pending enrollment %+v, want the second unconfirmed secret", pending)
+		}
+
+		if err := mfa.UseTOTPStep(ctx, user, 10); !errors.Is(err, db.ErrTOTPStepUsed) {
+			t.Errorf("UseTOTPStep before confirming returned %v, want ErrTOTPStepUsed", err)
+		}
+
+		if err := mfa.ConfirmTOTP(ctx, user, 10); err != nil {
+			t.Fatalf("ConfirmTOTP: %v", err)
+		}
+		enabled, err := mfa.GetTOTP(ctx, user)
+		if err != nil {
+			t.Fatalf("GetTOTP: %v", err)
+		}
+		if !enabled.Enabled() || enabled.LastUsedStep != 10 {
+			t.Errorf("got confirmed enrollment %+v, want it enabled at step 10", enabled)
+		}
+
+		if err := mfa.ConfirmTOTP(ctx, user, 11); !errors.Is(err, db.ErrTOTPNotFound) {
+			t.Errorf("a second ConfirmTOTP returned %v, want ErrTOTPNotFound", err)
+		}
+		if err := mfa.SaveTOTP(ctx, models.TOTPEnrollment{UserID: user, Secret: "THIRD", CreatedAt: now}); !errors.Is(err, db.ErrTOTPAlreadyEnabled) || !errors.Is(err, db.ErrConflict) {
+			t.Errorf("SaveTOTP over a confirmed enrollment returned %v, want ErrTOTPAlreadyEnabled", err)
+		}
+		kept, err := mfa.GetTOTP(ctx, user)
+		if err != nil {
+			t.Fatalf("GetTOTP: %v", err)
+		}
+		if kept.Secret != "SECOND" {
+			t.Errorf("SaveTOTP replaced a confirmed secret with %q", kept.Secret)
+		}
+	})
+
+	t.Run("StepsOnlyMoveForward", func(t *testing.T) {
+		store := newStore(t)
+		mfa := store.MFA
+		user := storeUser(t, store, "alice@example.com")
+		if err := mfa.SaveTOTP(ctx, models.TOTPEnrollment{UserID: user, Secret: "SECRET", CreatedAt: time.Now()}); err != nil {
+			t.Fatalf("SaveTOTP: %v", err)
+		}
+		if err := mfa.ConfirmTOTP(ctx, user, 10); err != nil {
+			t.Fatalf("ConfirmTOTP: %v", err)
+		}
+
+		for _, step := range []int64{9, 10} {
+			if err := mfa.UseTOTPStep(ctx, user, step); !errors.Is(err, db.ErrTOTPStepUsed) {
+				t.Errorf("UseTOTPStep(%d) after step 10 returned %v, want ErrTOTPStepUsed", step, err)
+			}
+		}
+		if err := mfa.UseTOTPStep(ctx, user, 11); err != nil {
+			t.Errorf("UseTOTPStep(11) after step 10 returned %v", err)
+		}
+		if err := mfa.UseTOTPStep(ctx, user, 11); !errors.Is(err, db.ErrTOTPStepUsed) {
+			t.Errorf("replaying step 11 returned %v, want ErrTOTPStepUsed", err)
+		}
+	})
+
+	t.Run("RecoveryCodes", func(t *testing.T) {
+		store := newStore(t)
+		mfa := store.MFA
+		alice := storeUser(t, store, "alice@example.com")
+		bob := storeUser(t, store, "bob@example.com")
+
+		if err := mfa.ReplaceRecoveryCodes(ctx, alice, []string{"a1", "a2"}); err != nil {
+			t.Fatalf("ReplaceRecoveryCodes: %v", err)
+		}
+		if err := mfa.ReplaceRecoveryCodes(ctx, bob, []string{"b1"}); err != nil {
+			t.Fatalf("ReplaceRecoveryCodes: %v", err)
+		}
+
+		if err := mfa.UseRecoveryCode(ctx, alice, "b1"); !errors.Is(err, db.ErrRecoveryCodeInvalid) {
+			t.Errorf("UseRecoveryCode with another user's code returned %v, want ErrRecoveryCodeInvalid", err)
+		}
+		if err := mfa.UseRecoveryCode(ctx, alice, "a1"); err != nil {
+			t.Fatalf("UseRecoveryCode: %v", err)
+		}
+		if err := mfa.UseRecoveryCode(ctx, alice, "a1"); !errors.Is(err, db.ErrRecoveryCodeInvalid) || !errors.Is(err, db.ErrNotFound) {
+			t.Errorf("reusing a recovery code returned %v, want ErrRecoveryCodeInvalid", err)
+		}
+
+		if err := mfa.ReplaceRecoveryCodes(ctx, alice, []string{"a3"}); err != nil {
+			t.Fatalf("ReplaceRecoveryCodes: %v", err)
+		}
+		if err := mfa.UseRecoveryCode(ctx, alice, "a2"); !errors.Is(err, db.ErrRecoveryCodeInvalid) {
+			t.Errorf("UseRecoveryCode with a replaced code returned %v, want ErrRecoveryCodeInvalid", err)
+		}
+		if err := mfa.UseRecoveryCode(ctx, alice, "a3"); err != nil {
+			t.Errorf("UseRecoveryCode with a new code returned %v", err)
+		}
+		if err := mfa.UseRecoveryCode(ctx, bob, "b1"); err != nil {
+			t.Errorf("replacing one user's codes invalidated another's: %v", err)
+		}
+	})
+}
@@ -0,0 +1,150 @@
+package dbtest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"joshuamURD/go-auth-api/pkgs/db"
+	"joshuamURD/go-auth-api/pkgs/models"
+
+	"github.com/google/uuid"
+)
+
+// newTodo returns a pending todo owned by ownerID that has not been stored yet
+func newTodo(ownerID uuid.UUID, title string) models.Todo {
+	now := time.Now().UTC().Truncate(time.Second)
+	return models.Todo{
+		ID:        uuid.New(),
+		OwnerID:   ownerID,
+		Title:     title,
+		Status:    models.TodoPending,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+}
+
+func testTodos(t *testing.T, newStore func(t *testing.T) *db.Store) {
+	ctx := context.Background()
+
+	// setup stores two users and returns the todo repository
+	setup := func(t *testing.T) (db.TodoRepository, uuid.UUID, uuid.UUID) {
+		store := newStore(t)
+		alice := newUser("alice@example.com")
+		bob := newUser("bob@example.com")
+		for _, user := range []models.User{alice, bob} {
+			if _, err := store.Users.Create(ctx, user); err != nil {
+				t.Fatalf("Create user: %v", err)
+			}
+		}
+		return store.Todos, alice.ID, bob.ID
+	}
+
+	t.Run("CreateAndGet", func(t *testing.T) {
+		todos, alice, bob := setup(t)
+
+		first := newTodo(alice, "first")
+		due := first.CreatedAt.Add(24 * time.Hour)
+		first.DueDate = &due
+		second := newTodo(alice, "second")
+		second.CreatedAt = first.CreatedAt.Add(time.Minute)
+		other := newTodo(bob, "other")
+		for _, todo := range []models.Todo{second, first, other} {
+			if err := todos.Create(ctx, todo); err != nil {
+				t.Fatalf("Create: %v", err)
+			}
+		}
+
+		got, err := todos.GetByID(ctx, first.ID, alice)
+		if err != nil {
+			t.Fatalf("GetByID: %v", err)
+		}
+		if got.Title != first.Title || got.Status != first.Status || got.DueDate == nil || !sameTime(*got.DueDate, due) {
+			t.Errorf("got todo %+v, want %+v", got, first)
+		}
+
+		owned, err := todos.GetByOwner(ctx, alice)
+		if err != nil {
+			t.Fatalf("GetByOwner: %v", err)
+		}
+		if len(owned) != 2 || owned[0].ID != first.ID || owned[1].ID != second.ID {
+			t.Errorf("GetByOwner returned %+v, want the two todos of the owner oldest first", owned)
+		}
+
+		none, err := todos.GetByOwner(ctx, uuid.New())
+		if err != nil {
+			t.Fatalf("GetByOwner: %v", err)
+		}
+		if none == nil || len(none) != 0 {
+			t.Errorf("GetByOwner of a user without todos returned %v, want an empty list", none)
+		}
+	})
+
+	t.Run("ScopedToOwner", func(t *testing.T) {
+		todos, alice, bob := setup(t)
+		todo := newTodo(alice, "private")
+		if err := todos.Create(ctx, todo); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+
+		if _, err := todos.GetByID(ctx, todo.ID, bob); !errors.Is(err, db.ErrTodoNotFound) || !errors.Is(err, db.ErrNotFound) {
+			t.Errorf("GetByID by another user returned %v, want ErrTodoNotFound", err)
+		}
+
+		stolen := todo
+		stolen.OwnerID = bob
+		stolen.Title = "stolen"
+		if err := todos.Update(ctx, stolen); !errors.Is(err, db.ErrTodoNotFound) {
+			t.Errorf("Update by another user returned %v, want ErrTodoNotFound", err)
+		}
+		if err := todos.Delete(ctx, todo.ID, bob); !errors.Is(err, db.ErrTodoNotFound) {
+			t.Errorf("Delete by another user returned %v, want ErrTodoNotFound", err)
+		}
+
+		got, err := todos.GetByID(ctx, todo.ID, alice)
+		if err != nil {
+			t.Fatalf("GetByID: %v", err)
+		}
+		if got.Title != todo.Title {
+			t.Errorf("another user changed the todo to %+v", got)
+		}
+	})
+
+	t.Run("UpdateAndDelete", func(t *testing.T) {
+		todos, alice, _ := setup(t)
+		todo := newTodo(alice, "todo")
+		if err := todos.Create(ctx, todo); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+
+		todo.Title = "renamed"
+		todo.Description = "details"
+		todo.Status = models.TodoDone
+		todo.UpdatedAt = todo.UpdatedAt.Add(time.Minute)
+		if err := todos.Update(ctx, todo); err != nil {
+			t.Fatalf("Update: %v", err)
+		}
+		got, err := todos.GetByID(ctx, todo.ID, alice)
+		if err != nil {
+			t.Fatalf("GetByID: %v", err)
+		}
+		if got.Title != todo.Title || got.Description != todo.Description || got.Status != todo.Status ||
+			got.DueDate != nil || !sameTime(got.UpdatedAt, todo.UpdatedAt) {
+			t.Errorf("got todo %+v, want %+v", got, todo)
+		}
+
+		if err := todos.Delete(ctx, todo.ID, alice); err != nil {
+			t.Fatalf("Delete: %v", err)
+		}
+		if _, err := todos.GetByID(ctx, todo.ID, alice); !errors.Is(err, db.ErrTodoNotFound) {
+			t.Errorf("GetByID of a deleted todo returned %v, want ErrTodoNotFound", err)
+		}
+		if err := todos.Delete(ctx, todo.ID, alice); !errors.Is(err, db.ErrTodoNotFound) {
+			t.Errorf("Delete of a deleted todo returned %v, want ErrTodoNotFound", err)
+		}
+		if err := todos.Update(ctx, todo); !errors.Is(err, db.ErrTodoNotFound) {
+			t.Errorf("Update of a deleted todo returned %v, want ErrTodoNotFound", err)
+		}
+	})
+}
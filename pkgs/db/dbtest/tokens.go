@@ -0,0 +1,202 @@
+package dbtest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"joshuamURD/go-auth-api/pkgs/db"
+	"joshuamURD/go-auth-api/pkgs/models"
+
+	"github.com/google/uuid"
+)
+
+// newRefreshToken returns an active refresh token of userID that has not been stored yet
+func newRefreshToken(userID uuid.UUID, familyID string) models.RefreshToken {
+	now := time.Now().UTC().Truncate(time.Second)
+	return models.RefreshToken{
+		ID:        uuid.NewString(),
+		FamilyID:  familyID,
+		UserID:    userID,
+		ExpiresAt: now.Add(time.Hour),
+		CreatedAt: now,
+	}
+}
+
+// newOneTimeToken returns an email verification token of userID that expires after ttl
+func newOneTimeToken(userID uuid.UUID, hash string, ttl time.Duration) models.OneTimeToken {
+	now := time.Now().UTC().Truncate(time.Second)
+	return models.OneTimeToken{
+		Hash:      hash,
+		UserID:    userID,
+		Purpose:   models.PurposeEmailVerification,
+		ExpiresAt: now.Add(ttl),
+		CreatedAt: now,
+	}
+}
+
+// storeUser stores a new user and returns its ID
+func storeUser(t *testing.T, store *db.Store, email string) uuid.UUID {
+	t.Helper()
+	user := newUser(email)
+	if _, err := store.Users.Create(context.Background(), user); err != nil {
+		t.Fatalf("Create user: %v", err)
+	}
+	return user.ID
+}
+
+func testRefreshTokens(t *testing.T, newStore func(t *testing.T) *db.Store) {
+	ctx := context.Background()
+
+	t.Run("CreateAndRevoke", func(t *testing.T) {
+		store := newStore(t)
+		tokens := store.RefreshTokens
+		token := newRefreshToken(storeUser(t, store, "alice@example.com"), "family")
+		if err := tokens.Create(ctx, token); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+
+		got, err := tokens.GetByID(ctx, token.ID)
+		if err != nil {
+			t.Fatalf("GetByID: %v", err)
+		}
+		if got.FamilyID != token.FamilyID || got.UserID != token.UserID || got.RevokedAt != nil ||
+			!sameTime(got.ExpiresAt, token.ExpiresAt) || !sameTime(got.CreatedAt, token.CreatedAt) {
+			t.Errorf("got token %+v, want %+v", got, token)
+		}
+
+		if err := tokens.Revoke(ctx, token.ID, "replacement"); err != nil {
+			t.Fatalf("Revoke: %v", err)
+		}
+		got, err = tokens.GetByID(ctx, token.ID)
+		if err != nil {
+			t.Fatalf("GetByID: %v", err)
+		}
+		if got.RevokedAt == nil || got.ReplacedBy != "replacement" {
+			t.Errorf("Revoke left %+v", got)
+		}
+
+		if err := tokens.Revoke(ctx, token.ID, "again"); !errors.Is(err, db.ErrRefreshTokenRevoked) || !errors.Is(err, db.ErrConflict) {
+			t.Errorf("a second Revoke returned %v, want ErrRefreshTokenRevoked", err)
+		}
+		if err := tokens.Revoke(ctx, "missing", ""); !errors.Is(err, db.ErrRefreshTokenRevoked) {
+			t.Errorf("Revoke of a missing token returned %v, want ErrRefreshTokenRevoked", err)
+		}
+		if _, err := tokens.GetByID(ctx, "missing"); !errors.Is(err, db.ErrRefreshTokenNotFound) || !errors.Is(err, db.ErrNotFound) {
+			t.Errorf("GetByID of a missing token returned %v, want ErrRefreshTokenNotFound", err)
+		}
+	})
+
+	t.Run("RevokeFamilyAndUser", func(t *testing.T) {
+		store := newStore(t)
+		tokens := store.RefreshTokens
+		alice := storeUser(t, store, "alice@example.com")
+		bob := storeUser(t, store, "bob@example.com")
+
+		first := newRefreshToken(alice, "first")
+		second := newRefreshToken(alice, "second")
+		other := newRefreshToken(bob, "other")
+		for _, token := range []models.RefreshToken{first, second, other} {
+			if err := tokens.Create(ctx, token); err != nil {
+				t.Fatalf("Create: %v", err)
+			}
+		}
+
+		revoked := func(id string) bool {
+			token, err := tokens.GetByID(ctx, id)
+			if err != nil {
+				t.Fatalf("GetByID: %v", err)
+			}
+			return token.RevokedAt != nil
+		}
+
+		if err := tokens.RevokeFamily(ctx, "first"); err != nil {
+			t.Fatalf("RevokeFamily: %v", err)
+		}
+		if !revoked(first.ID) || revoked(second.ID) || revoked(other.ID) {
+			t.Errorf("RevokeFamily revoked the wrong tokens")
+		}
+
+		if err := tokens.RevokeAllForUser(ctx, alice); err != nil {
+			t.Fatalf("RevokeAllForUser: %v", err)
+		}
+		if !revoked(second.ID) || revoked(other.ID) {
+			t.Errorf("RevokeAllForUser revoked the wrong tokens")
+		}
+	})
+}
+
+func testOneTimeTokens(t *testing.T, newStore func(t *testing.T) *db.Store) {
+	ctx := context.Background()
+
+	t.Run("ConsumeOnce", func(t *testing.T) {
+		store := newStore(t)
+		tokens := store.OneTimeTokens
+		token := newOneTimeToken(storeUser(t, store, "alice@example.com"), "hash", time.Hour)
+		if err := tokens.Create(ctx, token); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+
+		if _, err := tokens.Consume(ctx, token.Hash, models.PurposePasswordReset); !errors.Is(err, db.ErrOneTimeTokenInvalid) {
+			t.Errorf("Consume for another purpose returned %v, want ErrOneTimeTokenInvalid", err)
+		}
+
+		got, err := tokens.Consume(ctx, token.Hash, token.Purpose)
+		if err != nil {
+			t.Fatalf("Consume: %v", err)
+		}
+		if got.UserID != token.UserID || got.Purpose != token.Purpose || got.UsedAt == nil || !sameTime(got.ExpiresAt, token.ExpiresAt) {
+			t.Errorf("got token %+v, want %+v", got, token)
+		}
+
+		if _, err := tokens.Consume(ctx, token.Hash, token.Purpose); !errors.Is(err, db.ErrOneTimeTokenInvalid) || !errors.Is(err, db.ErrNotFound) {
+			t.Errorf("a second Consume returned %v, want ErrOneTimeTokenInvalid", err)
+		}
+		if _, err := tokens.Consume(ctx, "missing", token.Purpose); !errors.Is(err, db.ErrOneTimeTokenInvalid) {
+			t.Errorf("Consume of a missing token returned %v, want ErrOneTimeTokenInvalid", err)
+		}
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		store := newStore(t)
+		tokens := store.OneTimeTokens
+		token := newOneTimeToken(storeUser(t, store, "alice@example.com"), "hash", -time.Minute)
+		if err := tokens.Create(ctx, token); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		if _, err := tokens.Consume(ctx, token.Hash, token.Purpose); !errors.Is(err, db.ErrOneTimeTokenInvalid) {
+			t.Errorf("Consume of an expired token returned %v, want ErrOneTimeTokenInvalid", err)
+		}
+	})
+
+	t.Run("InvalidateForUser", func(t *testing.T) {
+		store := newStore(t)
+		tokens := store.OneTimeTokens
+		alice := storeUser(t, store, "alice@example.com")
+		bob := storeUser(t, store, "bob@example.com")
+
+		verify := newOneTimeToken(alice, "verify", time.Hour)
+		reset := newOneTimeToken(alice, "reset", time.Hour)
+		reset.Purpose = models.PurposePasswordReset
+		other := newOneTimeToken(bob, "other", time.Hour)
+		for _, token := range []models.OneTimeToken{verify, reset, other} {
+			if err := tokens.Create(ctx, token); err != nil {
+				t.Fatalf("Create: %v", err)
+			}
+		}
+
+		if err := tokens.InvalidateForUser(ctx, alice, models.PurposeEmailVerification); err != nil {
+			t.Fatalf("InvalidateForUser: %v", err)
+		}
+		if _, err := tokens.Consume(ctx, verify.Hash, verify.Purpose); !errors.Is(err, db.ErrOneTimeTokenInvalid) {
+			t.Errorf("Consume of an invalidated token returned %v, want ErrOneTimeTokenInvalid", err)
+		}
+		if _, err := tokens.Consume(ctx, reset.Hash, reset.Purpose); err != nil {
+			t.Errorf("InvalidateForUser invalidated a token with another purpose: %v", err)
+		}
+		if _, err := tokens.Consume(ctx, other.Hash, other.Purpose); err != nil {
+			t.Errorf("InvalidateForUser invalidated a token of another user: %v", err)
+		}
+	})
+}
@@ -0,0 +1,710 @@
+package dbtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"joshuamURD/go-auth-api/pkgs/db"
+	"joshuamURD/go-auth-api/pkgs/models"
+
+	"github.com/google/uuid"
+)
+
+func testUsers(t *testing.T, newStore func(t *testing.T) *db.Store) {
+	ctx := context.Background()
+
+	t.Run("CreateAndGet", func(t *testing.T) {
+		users := newStore(t).Users
+		user := newUser("alice@example.com")
+		if _, err := users.Create(ctx, user); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+
+		got, err := users.GetByID(ctx, user.ID)
+		if err != nil {
+			t.Fatalf("GetByID: %v", err)
+		}
+		assertUser(t, got, user)
+
+		got, err = users.GetByEmail(ctx, "ALICE@example.com")
+		if err != nil {
+			t.Fatalf("GetByEmail ignoring case: %v", err)
+		}
+		assertUser(t, got, user)
+
+		all, err := users.GetAll(ctx)
+		if err != nil {
+			t.Fatalf("GetAll: %v", err)
+		}
+		if len(all) != 1 {
+			t.Errorf("GetAll returned %d users, want 1", len(all))
+		}
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		users := newStore(t).Users
+		if _, err := users.GetByID(ctx, uuid.New()); !errors.Is(err, db.ErrUserNotFound) || !errors.Is(err, db.ErrNotFound) {
+			t.Errorf("GetByID of a missing user returned %v, want ErrUserNotFound", err)
+		}
+		if _, err := users.GetByEmail(ctx, "nobody@example.com"); !errors.Is(err, db.ErrUserNotFound) {
+			t.Errorf("GetByEmail of a missing user returned %v, want ErrUserNotFound", err)
+		}
+		if err := users.Update(ctx, newUser("nobody@example.com")); !errors.Is(err, db.ErrUserNotFound) {
+			t.Errorf("Update of a missing user returned %v, want ErrUserNotFound", err)
+		}
+		if err := users.Delete(ctx, uuid.New()); !errors.Is(err, db.ErrUserNotFound) {
+			t.Errorf("Delete of a missing user returned %v, want ErrUserNotFound", err)
+		}
+	})
+
+	t.Run("EmailIsUnique", func(t *testing.T) {
+		users := newStore(t).Users
+		alice := newUser("alice@example.com")
+		bob := newUser("bob@example.com")
+		if _, err := users.Create(ctx, alice); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		if _, err := users.Create(ctx, bob); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+
+		if _, err := users.Create(ctx, newUser("Alice@Example.com")); !errors.Is(err, db.ErrEmailTaken) || !errors.Is(err, db.ErrConflict) {
+			t.Errorf("Create with a taken email returned %v, want ErrEmailTaken", err)
+		}
+
+		bob.Email = "ALICE@example.com"
+		if err := users.Update(ctx, bob); !errors.Is(err, db.ErrEmailTaken) {
+			t.Errorf("Update to a taken email returned %v, want ErrEmailTaken", err)
+		}
+
+		alice.Email = "Alice@example.com"
+		if err := users.Update(ctx, alice); err != nil {
+			t.Errorf("Update of a user's own email returned %v", err)
+		}
+	})
+
+	t.Run("EmailFoldsUnicode", func(t *testing.T) {
+		users := newStore(t).Users
+		emile := newUser("Émile@example.com")
+		if _, err := users.Create(ctx, emile); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+
+		for _, email := range []string{"émile@example.com", "ÉMILE@EXAMPLE.COM"} {
+			got, err := users.GetByEmail(ctx, email)
+			if err != nil || got.ID != emile.ID {
+				t.Errorf("GetByEmail(%q) = %v, %v, want Émile", email, got.ID, err)
+			}
+		}
+		if _, err := users.Create(ctx, newUser("émile@example.com")); !errors.Is(err, db.ErrEmailTaken) {
+			t.Errorf("Create with the email in another case returned %v, want ErrEmailTaken", err)
+		}
+
+		// The Kelvin sign folds to k like strings.EqualFold does
+		if _, err := users.Create(ctx, newUser("Kate@example.com")); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		if _, err := users.GetByEmail(ctx, "kate@example.com"); err != nil {
+			t.Errorf("GetByEmail with a plain k returned %v", err)
+		}
+
+		list, total, err := users.List(ctx, db.UserFilter{Email: "ÉMILE"})
+		if err != nil || total != 1 || len(list) != 1 || list[0].ID != emile.ID {
+			t.Errorf("List filtered on the email in another case = %v, %d, %v, want Émile", list, total, err)
+		}
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		users := newStore(t).Users
+		user := newUser("alice@example.com")
+		if _, err := users.Create(ctx, user); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+
+		user.Verified = true
+		user.HashedPassword = "new hash"
+		user.Role = "admin"
+		user.UpdatedAt = user.UpdatedAt.Add(time.Minute)
+		if err := users.Update(ctx, user); err != nil {
+			t.Fatalf("Update: %v", err)
+		}
+
+		got, err := users.GetByID(ctx, user.ID)
+		if err != nil {
+			t.Fatalf("GetByID: %v", err)
+		}
+		assertUser(t, got, user)
+	})
+
+	t.Run("UpdatePasswordHash", func(t *testing.T) {
+		users := newStore(t).Users
+		user := newUser("alice@example.com")
+		if _, err := users.Create(ctx, user); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+
+		// A change made after the old hash was read is kept
+		if _, err := users.RecordFailedLogin(ctx, user.ID, 1, time.Hour); err != nil {
+			t.Fatalf("RecordFailedLogin: %v", err)
+		}
+		if err := users.UpdatePasswordHash(ctx, user.ID, user.HashedPassword, "new hash"); err != nil {
+			t.Fatalf("UpdatePasswordHash: %v", err)
+		}
+		got, err := users.GetByID(ctx, user.ID)
+		if err != nil {
+			t.Fatalf("GetByID: %v", err)
+		}
+		if got.HashedPassword != "new hash" || !got.Locked || got.FailedAttempts != 1 {
+			t.Errorf("UpdatePasswordHash left %+v, want the new hash and the lock kept", got)
+		}
+
+		// The hash is not replaced once it is no longer the one that was read
+		if err := users.UpdatePasswordHash(ctx, user.ID, user.HashedPassword, "stale hash"); !errors.Is(err, db.ErrPasswordChanged) || !errors.Is(err, db.ErrConflict) {
+			t.Errorf("UpdatePasswordHash of a changed hash returned %v, want ErrPasswordChanged", err)
+		}
+		if got, err := users.GetByID(ctx, user.ID); err != nil || got.HashedPassword != "new hash" {
+			t.Errorf("the hash was replaced although it had changed: %q, %v", got.HashedPassword, err)
+		}
+
+		if err := users.UpdatePasswordHash(ctx, uuid.New(), "hash", "new hash"); !errors.Is(err, db.ErrUserNotFound) {
+			t.Errorf("UpdatePasswordHash of a missing user returned %v, want ErrUserNotFound", err)
+		}
+	})
+
+	t.Run("ColumnUpdates", func(t *testing.T) {
+		users := newStore(t).Users
+		user := newUser("alice@example.com")
+		if _, err := users.Create(ctx, user); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		if _, err := users.RecordFailedLogin(ctx, user.ID, 0, time.Hour); err != nil {
+			t.Fatalf("RecordFailedLogin: %v", err)
+		}
+
+		if err := users.Lock(ctx, user.ID); err != nil {
+			t.Fatalf("Lock: %v", err)
+		}
+		if err := users.MarkVerified(ctx, user.ID); err != nil {
+			t.Fatalf("MarkVerified: %v", err)
+		}
+		got, err := users.GetByID(ctx, user.ID)
+		if err != nil {
+			t.Fatalf("GetByID: %v", err)
+		}
+		if !got.Locked || got.LockedUntil != nil || !got.Verified || got.FailedAttempts != 1 || got.HashedPassword != user.HashedPassword {
+			t.Errorf("Lock and MarkVerified left %+v, want an indefinite lock, verified and the rest kept", got)
+		}
+
+		if err := users.ClearFailedAttempts(ctx, user.ID); err != nil {
+			t.Fatalf("ClearFailedAttempts: %v", err)
+		}
+		got, err = users.GetByID(ctx, user.ID)
+		if err != nil {
+			t.Fatalf("GetByID: %v", err)
+		}
+		if got.FailedAttempts != 0 || !got.Locked {
+			t.Errorf("ClearFailedAttempts left %+v, want no attempts and the lock kept", got)
+		}
+
+		// An admin lock survives ClearLockout, a lockout with an end does not
+		if _, err := users.RecordFailedLogin(ctx, user.ID, 0, time.Hour); err != nil {
+			t.Fatalf("RecordFailedLogin: %v", err)
+		}
+		if err := users.ClearLockout(ctx, user.ID); err != nil {
+			t.Fatalf("ClearLockout: %v", err)
+		}
+		got, err = users.GetByID(ctx, user.ID)
+		if err != nil {
+			t.Fatalf("GetByID: %v", err)
+		}
+		if got.FailedAttempts != 0 || !got.Locked {
+			t.Errorf("ClearLockout left %+v, want no attempts and the admin lock kept", got)
+		}
+		if err := users.ResetFailedLogins(ctx, user.ID); err != nil {
+			t.Fatalf("ResetFailedLogins: %v", err)
+		}
+		if _, err := users.RecordFailedLogin(ctx, user.ID, 1, time.Hour); err != nil {
+			t.Fatalf("RecordFailedLogin: %v", err)
+		}
+		if err := users.ClearLockout(ctx, user.ID); err != nil {
+			t.Fatalf("ClearLockout: %v", err)
+		}
+		got, err = users.GetByID(ctx, user.ID)
+		if err != nil {
+			t.Fatalf("GetByID: %v", err)
+		}
+		if got.FailedAttempts != 0 || got.Locked || got.LockedUntil != nil {
+			t.Errorf("ClearLockout left %+v, want the lockout lifted", got)
+		}
+
+		missing := uuid.New()
+		for name, update := range map[string]func(context.Context, uuid.UUID) error{
+			"Lock":                users.Lock,
+			"MarkVerified":        users.MarkVerified,
+			"ClearFailedAttempts": users.ClearFailedAttempts,
+			"ClearLockout":        users.ClearLockout,
+		} {
+			if err := update(ctx, missing); !errors.Is(err, db.ErrUserNotFound) {
+				t.Errorf("%s of a missing user returned %v, want ErrUserNotFound", name, err)
+			}
+		}
+	})
+
+	t.Run("SetRole", func(t *testing.T) {
+		users := newStore(t).Users
+		alice := newUser("alice@example.com")
+		bob := newUser("bob@example.com")
+		for _, user := range []models.User{alice, bob} {
+			if _, err := users.Create(ctx, user); err != nil {
+				t.Fatalf("Create: %v", err)
+			}
+		}
+		if _, err := users.RecordFailedLogin(ctx, alice.ID, 0, time.Hour); err != nil {
+			t.Fatalf("RecordFailedLogin: %v", err)
+		}
+
+		for _, id := range []uuid.UUID{alice.ID, bob.ID} {
+			if err := users.SetRole(ctx, id, models.RoleAdmin); err != nil {
+				t.Fatalf("SetRole: %v", err)
+			}
+		}
+		got, err := users.GetByID(ctx, alice.ID)
+		if err != nil {
+			t.Fatalf("GetByID: %v", err)
+		}
+		if got.Role != models.RoleAdmin || got.FailedAttempts != 1 {
+			t.Errorf("SetRole left %+v, want the admin role and the rest kept", got)
+		}
+
+		if err := users.SetRole(ctx, alice.ID, models.RoleUser); err != nil {
+			t.Fatalf("SetRole demoting one of two admins: %v", err)
+		}
+		if err := users.SetRole(ctx, bob.ID, models.RoleUser); !errors.Is(err, db.ErrLastAdmin) || !errors.Is(err, db.ErrConflict) {
+			t.Errorf("SetRole demoting the last admin returned %v, want ErrLastAdmin", err)
+		}
+		if got, err := users.GetByID(ctx, bob.ID); err != nil || got.Role != models.RoleAdmin {
+			t.Errorf("the last admin was demoted: %q, %v", got.Role, err)
+		}
+		if err := users.SetRole(ctx, bob.ID, models.RoleAdmin); err != nil {
+			t.Errorf("SetRole keeping the last admin an admin: %v", err)
+		}
+
+		if err := users.SetRole(ctx, uuid.New(), models.RoleUser); !errors.Is(err, db.ErrUserNotFound) {
+			t.Errorf("SetRole of a missing user returned %v, want ErrUserNotFound", err)
+		}
+	})
+
+	t.Run("PromoteFirstAdmin", func(t *testing.T) {
+		users := newStore(t).Users
+		var candidates []uuid.UUID
+		for i := 0; i < 4; i++ {
+			user := newUser(fmt.Sprintf("user%d@example.com", i))
+			if _, err := users.Create(ctx, user); err != nil {
+				t.Fatalf("Create: %v", err)
+			}
+			candidates = append(candidates, user.ID)
+		}
+
+		// Concurrent promotions leave exactly one admin, whichever wins
+		var wg sync.WaitGroup
+		for _, id := range candidates {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				users.PromoteFirstAdmin(ctx, id)
+			}()
+		}
+		wg.Wait()
+
+		all, err := users.GetAll(ctx)
+		if err != nil {
+			t.Fatalf("GetAll: %v", err)
+		}
+		var admins []uuid.UUID
+		for _, user := range all {
+			if user.Role == models.RoleAdmin {
+				admins = append(admins, user.ID)
+			}
+		}
+		if len(admins) != 1 {
+			t.Fatalf("concurrent promotions left %d admins, want 1", len(admins))
+		}
+
+		for _, id := range candidates {
+			if err := users.PromoteFirstAdmin(ctx, id); !errors.Is(err, db.ErrAdminExists) || !errors.Is(err, db.ErrConflict) {
+				t.Errorf("PromoteFirstAdmin with an admin returned %v, want ErrAdminExists", err)
+			}
+		}
+		if err := users.PromoteFirstAdmin(ctx, uuid.New()); !errors.Is(err, db.ErrUserNotFound) {
+			t.Errorf("PromoteFirstAdmin of a missing user returned %v, want ErrUserNotFound", err)
+		}
+	})
+
+	t.Run("FailedLogins", func(t *testing.T) {
+		users := newStore(t).Users
+		user := newUser("alice@example.com")
+		if _, err := users.Create(ctx, user); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+
+		for attempt := 1; attempt <= 3; attempt++ {
+			got, err := users.RecordFailedLogin(ctx, user.ID, 3, time.Hour)
+			if err != nil {
+				t.Fatalf("RecordFailedLogin: %v", err)
+			}
+			if got.FailedAttempts != attempt {
+				t.Errorf("after attempt %d the counter is %d", attempt, got.FailedAttempts)
+			}
+			if got.Locked != (attempt == 3) {
+				t.Errorf("after attempt %d locked is %v", attempt, got.Locked)
+			}
+		}
+
+		locked, err := users.GetByID(ctx, user.ID)
+		if err != nil {
+			t.Fatalf("GetByID: %v", err)
+		}
+		if !locked.IsLocked(time.Now()) || locked.LockedUntil == nil || !sameTime(*locked.LockedUntil, time.Now().Add(time.Hour)) {
+			t.Errorf("user is not locked for an hour: %+v", locked)
+		}
+
+		// Further failures keep the original lock end
+		again, err := users.RecordFailedLogin(ctx, user.ID, 3, 2*time.Hour)
+		if err != nil {
+			t.Fatalf("RecordFailedLogin: %v", err)
+		}
+		if again.LockedUntil == nil || !sameTime(*again.LockedUntil, *locked.LockedUntil) {
+			t.Errorf("a failure while locked moved the lock end to %v", again.LockedUntil)
+		}
+
+		if err := users.ResetFailedLogins(ctx, user.ID); err != nil {
+			t.Fatalf("ResetFailedLogins: %v", err)
+		}
+		reset, err := users.GetByID(ctx, user.ID)
+		if err != nil {
+			t.Fatalf("GetByID: %v", err)
+		}
+		if reset.FailedAttempts != 0 || reset.Locked || reset.LockedUntil != nil {
+			t.Errorf("ResetFailedLogins left %+v", reset)
+		}
+
+		// An expired lock is cleared before the attempt is counted
+		for i := 0; i < 2; i++ {
+			if _, err := users.RecordFailedLogin(ctx, user.ID, 2, -time.Minute); err != nil {
+				t.Fatalf("RecordFailedLogin: %v", err)
+			}
+		}
+		expired, err := users.RecordFailedLogin(ctx, user.ID, 2, time.Hour)
+		if err != nil {
+			t.Fatalf("RecordFailedLogin: %v", err)
+		}
+		if expired.FailedAttempts != 1 || expired.Locked {
+			t.Errorf("an expired lock was not cleared: %+v", expired)
+		}
+
+		// A limit of zero never locks
+		if err := users.ResetFailedLogins(ctx, user.ID); err != nil {
+			t.Fatalf("ResetFailedLogins: %v", err)
+		}
+		for i := 0; i < 10; i++ {
+			got, err := users.RecordFailedLogin(ctx, user.ID, 0, time.Hour)
+			if err != nil {
+				t.Fatalf("RecordFailedLogin: %v", err)
+			}
+			if got.Locked {
+				t.Fatalf("a limit of zero locked the user")
+			}
+		}
+
+		if _, err := users.RecordFailedLogin(ctx, uuid.New(), 3, time.Hour); !errors.Is(err, db.ErrUserNotFound) {
+			t.Errorf("RecordFailedLogin of a missing user returned %v, want ErrUserNotFound", err)
+		}
+	})
+
+	t.Run("List", func(t *testing.T) {
+		users := newStore(t).Users
+		base := time.Now().UTC().Truncate(time.Second)
+		var created []uuid.UUID
+		for i := 0; i < 5; i++ {
+			user := newUser(fmt.Sprintf("user%d@example.com", i))
+			if i == 4 {
+				user.Email = "other_100%@example.org"
+			}
+			user.CreatedAt = base.Add(time.Duration(i) * time.Minute)
+			user.Verified = i%2 == 0
+			if _, err := users.Create(ctx, user); err != nil {
+				t.Fatalf("Create: %v", err)
+			}
+			created = append(created, user.ID)
+		}
+
+		page, total, err := users.List(ctx, db.UserFilter{Limit: 2, Offset: 1})
+		if err != nil {
+			t.Fatalf("List: %v", err)
+		}
+		if total != 5 || len(page) != 2 || page[0].ID != created[1] || page[1].ID != created[2] {
+			t.Errorf("List page returned %d of %d users, want users 1 and 2 of 5", len(page), total)
+		}
+
+		all, total, err := users.List(ctx, db.UserFilter{Offset: 3})
+		if err != nil {
+			t.Fatalf("List: %v", err)
+		}
+		if total != 5 || len(all) != 2 {
+			t.Errorf("List without a limit returned %d of %d users, want 2 of 5", len(all), total)
+		}
+
+		empty, total, err := users.List(ctx, db.UserFilter{Offset: 10})
+		if err != nil {
+			t.Fatalf("List: %v", err)
+		}
+		if total != 5 || empty == nil || len(empty) != 0 {
+			t.Errorf("List past the end returned %v of %d users, want an empty list", empty, total)
+		}
+
+		matched, total, err := users.List(ctx, db.UserFilter{Email: "USER"})
+		if err != nil {
+			t.Fatalf("List: %v", err)
+		}
+		if total != 4 || len(matched) != 4 {
+			t.Errorf("List by email returned %d of %d users, want 4", len(matched), total)
+		}
+
+		// Wildcards in the filter match literally
+		matched, total, err = users.List(ctx, db.UserFilter{Email: "_100%"})
+		if err != nil {
+			t.Fatalf("List: %v", err)
+		}
+		if total != 1 || len(matched) != 1 || matched[0].ID != created[4] {
+			t.Errorf("List by an email with wildcards returned %d of %d users, want 1", len(matched), total)
+		}
+
+		verified := true
+		matched, total, err = users.List(ctx, db.UserFilter{Verified: &verified})
+		if err != nil {
+			t.Fatalf("List: %v", err)
+		}
+		if total != 3 || len(matched) != 3 {
+			t.Errorf("List of verified users returned %d of %d users, want 3", len(matched), total)
+		}
+
+		locked := true
+		_, total, err = users.List(ctx, db.UserFilter{Locked: &locked})
+		if err != nil {
+			t.Fatalf("List: %v", err)
+		}
+		if total != 0 {
+			t.Errorf("List of locked users returned %d users, want 0", total)
+		}
+	})
+
+	t.Run("DeleteCascades", func(t *testing.T) {
+		store := newStore(t)
+		user := newUser("alice@example.com")
+		if _, err := store.Users.Create(ctx, user); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		todo := newTodo(user.ID, "todo")
+		if err := store.Todos.Create(ctx, todo); err != nil {
+			t.Fatalf("Create todo: %v", err)
+		}
+		token := newRefreshToken(user.ID, "family")
+		if err := store.RefreshTokens.Create(ctx, token); err != nil {
+			t.Fatalf("Create refresh token: %v", err)
+		}
+		oneTime := newOneTimeToken(user.ID, "hash", time.Hour)
+		if err := store.OneTimeTokens.Create(ctx, oneTime); err != nil {
+			t.Fatalf("Create one-time token: %v", err)
+		}
+
+		if err := store.MFA.SaveTOTP(ctx, models.TOTPEnrollment{UserID: user.ID, Secret: "SECRET", CreatedAt: time.Now()}); err != nil {
+			t.Fatalf("SaveTOTP: %v", err)
+		}
+		if err := store.MFA.ReplaceRecoveryCodes(ctx, user.ID, []string{"code"}); err != nil {
+			t.Fatalf("ReplaceRecoveryCodes: %v", err)
+		}
+		if err := store.WebAuthn.CreateCredential(ctx, newCredential(user.ID, "key", time.Now())); err != nil {
+			t.Fatalf("CreateCredential: %v", err)
+		}
+		if err := store.WebAuthn.CreateSession(ctx, newSession(&user.ID, "challenge", models.CeremonyRegistration, time.Hour)); err != nil {
+			t.Fatalf("CreateSession: %v", err)
+		}
+
+		if err := store.Users.Delete(ctx, user.ID); err != nil {
+			t.Fatalf("Delete: %v", err)
+		}
+
+		if _, err := store.Users.GetByID(ctx, user.ID); !errors.Is(err, db.ErrUserNotFound) {
+			t.Errorf("GetByID of a deleted user returned %v, want ErrUserNotFound", err)
+		}
+		if _, err := store.Todos.GetByID(ctx, todo.ID, user.ID); !errors.Is(err, db.ErrTodoNotFound) {
+			t.Errorf("GetByID of a deleted user's todo returned %v, want ErrTodoNotFound", err)
+		}
+		if _, err := store.RefreshTokens.GetByID(ctx, token.ID); !errors.Is(err, db.ErrRefreshTokenNotFound) {
+			t.Errorf("GetByID of a deleted user's refresh token returned %v, want ErrRefreshTokenNotFound", err)
+		}
+		if _, err := store.OneTimeTokens.Consume(ctx, oneTime.Hash, oneTime.Purpose); !errors.Is(err, db.ErrOneTimeTokenInvalid) {
+			t.Errorf("Consume of a deleted user's token returned %v, want ErrOneTimeTokenInvalid", err)
+		}
+		if _, err := store.MFA.GetTOTP(ctx, user.ID); !errors.Is(err, db.ErrTOTPNotFound) {
+			t.Errorf("GetTOTP of a deleted user returned %v, want ErrTOTPNotFound", err)
+		}
+		if err := store.MFA.UseRecoveryCode(ctx, user.ID, "code"); !errors.Is(err, db.ErrRecoveryCodeInvalid) {
+			t.Errorf("UseRecoveryCode of a deleted user returned %v, want ErrRecoveryCodeInvalid", err)
+		}
+		if _, err := store.WebAuthn.GetCredential(ctx, []byte("key")); !errors.Is(err, db.ErrWebAuthnCredentialNotFound) {
+			t.Errorf("GetCredential of a deleted user's passkey returned %v, want ErrWebAuthnCredentialNotFound", err)
+		}
+		if _, err := store.WebAuthn.ConsumeSession(ctx, "challenge", models.CeremonyRegistration); !errors.Is(err, db.ErrWebAuthnSessionInvalid) {
+			t.Errorf("ConsumeSession of a deleted user's challenge returned %v, want ErrWebAuthnSessionInvalid", err)
+		}
+	})
+
+	t.Run("CancelledContext", func(t *testing.T) {
+		users := newStore(t).Users
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+		if _, err := users.Create(cancelled, newUser("alice@example.com")); !errors.Is(err, context.Canceled) {
+			t.Errorf("Create with a cancelled context returned %v, want context.Canceled", err)
+		}
+	})
+}
+
+func testTransactions(t *testing.T, newStore func(t *testing.T) *db.Store) {
+	ctx := context.Background()
+
+	t.Run("Commit", func(t *testing.T) {
+		users := newStore(t).Users
+		alice := newUser("alice@example.com")
+		bob := newUser("bob@example.com")
+		err := users.WithTx(ctx, func(tx db.Database) error {
+			if _, err := tx.Create(ctx, alice); err != nil {
+				return err
+			}
+			// Nested calls join the outer transaction
+			return tx.WithTx(ctx, func(tx db.Database) error {
+				if _, err := tx.GetByID(ctx, alice.ID); err != nil {
+					return err
+				}
+				_, err := tx.Create(ctx, bob)
+				return err
+			})
+		})
+		if err != nil {
+			t.Fatalf("WithTx: %v", err)
+		}
+		for _, user := range []uuid.UUID{alice.ID, bob.ID} {
+			if _, err := users.GetByID(ctx, user); err != nil {
+				t.Errorf("GetByID after commit: %v", err)
+			}
+		}
+	})
+
+	t.Run("Rollback", func(t *testing.T) {
+		users := newStore(t).Users
+		existing := newUser("existing@example.com")
+		if _, err := users.Create(ctx, existing); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+
+		alice := newUser("alice@example.com")
+		failure := errors.New("failure")
+		err := users.WithTx(ctx, func(tx db.Database) error {
+			if _, err := tx.Create(ctx, alice); err != nil {
+				return err
+			}
+			existing.Verified = true
+			if err := tx.Update(ctx, existing); err != nil {
+				return err
+			}
+			return failure
+		})
+		if !errors.Is(err, failure) {
+			t.Fatalf("WithTx returned %v, want the error of fn", err)
+		}
+
+		if _, err := users.GetByID(ctx, alice.ID); !errors.Is(err, db.ErrUserNotFound) {
+			t.Errorf("GetByID after rollback returned %v, want ErrUserNotFound", err)
+		}
+		got, err := users.GetByID(ctx, existing.ID)
+		if err != nil {
+			t.Fatalf("GetByID: %v", err)
+		}
+		if got.Verified {
+			t.Errorf("an update was kept after rollback")
+		}
+	})
+}
+
+func testStoreTransactions(t *testing.T, newStore func(t *testing.T) *db.Store) {
+	ctx := context.Background()
+
+	t.Run("Commit", func(t *testing.T) {
+		store := newStore(t)
+		alice := newUser("alice@example.com")
+		token := newOneTimeToken(alice.ID, "hash", time.Hour)
+		err := store.WithTx(ctx, func(tx *db.Store) error {
+			if _, err := tx.Users.Create(ctx, alice); err != nil {
+				return err
+			}
+			// Nested calls join the outer transaction
+			return tx.WithTx(ctx, func(tx *db.Store) error {
+				return tx.OneTimeTokens.Create(ctx, token)
+			})
+		})
+		if err != nil {
+			t.Fatalf("WithTx: %v", err)
+		}
+		if _, err := store.Users.GetByID(ctx, alice.ID); err != nil {
+			t.Errorf("GetByID after commit: %v", err)
+		}
+		if _, err := store.OneTimeTokens.Consume(ctx, token.Hash, token.Purpose); err != nil {
+			t.Errorf("Consume after commit: %v", err)
+		}
+	})
+
+	t.Run("Rollback", func(t *testing.T) {
+		store := newStore(t)
+		alice := storeUser(t, store, "alice@example.com")
+		session := newRefreshToken(alice, uuid.NewString())
+		if err := store.RefreshTokens.Create(ctx, session); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		bob := newUser("bob@example.com")
+
+		failure := errors.New("failure")
+		err := store.WithTx(ctx, func(tx *db.Store) error {
+			if _, err := tx.Users.Create(ctx, bob); err != nil {
+				return err
+			}
+			if err := tx.OneTimeTokens.Create(ctx, newOneTimeToken(bob.ID, "hash", time.Hour)); err != nil {
+				return err
+			}
+			if err := tx.RefreshTokens.RevokeAllForUser(ctx, alice); err != nil {
+				return err
+			}
+			return failure
+		})
+		if !errors.Is(err, failure) {
+			t.Fatalf("WithTx returned %v, want the error of fn", err)
+		}
+
+		if _, err := store.Users.GetByID(ctx, bob.ID); !errors.Is(err, db.ErrUserNotFound) {
+			t.Errorf("GetByID after rollback returned %v, want ErrUserNotFound", err)
+		}
+		if _, err := store.OneTimeTokens.Consume(ctx, "hash", models.PurposeEmailVerification); !errors.Is(err, db.ErrNotFound) {
+			t.Errorf("Consume after rollback returned %v, want ErrNotFound", err)
+		}
+		got, err := store.RefreshTokens.GetByID(ctx, session.ID)
+		if err != nil {
+			t.Fatalf("GetByID: %v", err)
+		}
+		if got.RevokedAt != nil {
+			t.Errorf("a revocation was kept after rollback")
+		}
+	})
+}
@@ -0,0 +1,162 @@
+package dbtest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"joshuamURD/go-auth-api/pkgs/db"
+	"joshuamURD/go-auth-api/pkgs/models"
+
+	"github.com/google/uuid"
+)
+
+// newCredential returns a credential of the user that has not been stored yet
+func newCredential(userID uuid.UUID, id string, createdAt time.Time) models.WebAuthnCredential {
+	return models.WebAuthnCredential{
+		ID:        []byte(id),
+		UserID:    userID,
+		PublicKey: []byte("cose key of " + id),
+		CreatedAt: createdAt.UTC().Truncate(time.Second),
+	}
+}
+
+// newSession returns a challenge for the ceremony that has not been stored yet
+func newSession(userID *uuid.UUID, hash string, ceremony models.WebAuthnCeremony, ttl time.Duration) models.WebAuthnSession {
+	now := time.Now().UTC().Truncate(time.Second)
+	return models.WebAuthnSession{
+		ChallengeHash: hash,
+		UserID:        userID,
+		Ceremony:      ceremony,
+		ExpiresAt:     now.Add(ttl),
+		CreatedAt:     now,
+	}
+}
+
+func testWebAuthn(t *testing.T, newStore func(t *testing.T) *db.Store) {
+	ctx := context.Background()
+
+	t.Run("Credentials", func(t *testing.T) {
+		store := newStore(t)
+		webauthn := store.WebAuthn
+		alice := storeUser(t, store, "alice@example.com")
+		bob := storeUser(t, store, "bob@example.com")
+
+		if _, err := webauthn.GetCredential(ctx, []byte("missing")); !errors.Is(err, db.ErrWebAuthnCredentialNotFound) || !errors.Is(err, db.ErrNotFound) {
+			t.Errorf("GetCredential of a missing credential returned %v, want ErrWebAuthnCredentialNotFound", err)
+		}
+
+		now := time.Now()
+		first := newCredential(alice, "first", now.Add(-time.Hour))
+		second := newCredential(alice, "second", now)
+		for _, credential := range []models.WebAuthnCredential{second, first, newCredential(bob, "other", now)} {
+			if err := webauthn.CreateCredential(ctx, credential); err != nil {
+				t.Fatalf("CreateCredential: %v", err)
+			}
+		}
+		if err := webauthn.CreateCredential(ctx, newCredential(bob, "first", now)); !errors.Is(err, db.ErrWebAuthnCredentialExists) || !errors.Is(err, db.ErrConflict) {
+			t.Errorf("CreateCredential with a taken ID returned %v, want ErrWebAuthnCredentialExists", err)
+		}
+
+		got, err := webauthn.GetCredential(ctx, []byte("first"))
+		if err != nil {
+			t.Fatalf("GetCredential: %v", err)
+		}
+		if !bytes.Equal(got.ID, first.ID) || got.UserID != alice || !bytes.Equal(got.PublicKey, first.PublicKey) ||
+			got.SignCount != 0 || got.LastUsedAt != nil || !sameTime(got.CreatedAt, first.CreatedAt) {
+			t.Errorf("got credential %+v, want %+v", got, first)
+		}
+
+		credentials, err := webauthn.GetCredentialsByUser(ctx, alice)
+		if err != nil {
+			t.Fatalf("GetCredentialsByUser: %v", err)
+		}
+		if len(credentials) != 2 || string(credentials[0].ID) != "first" || string(credentials[1].ID) != "second" {
+			t.Errorf("GetCredentialsByUser returned %d credentials, want first and second in order", len(credentials))
+		}
+		credentials, err = webauthn.GetCredentialsByUser(ctx, uuid.New())
+		if err != nil || credentials == nil || len(credentials) != 0 {
+			t.Errorf("GetCredentialsByUser of a user without credentials returned %v, %v, want an empty list", credentials, err)
+		}
+	})
+
+	t.Run("SignCount", func(t *testing.T) {
+		store := newStore(t)
+		webauthn := store.WebAuthn
+		user := storeUser(t, store, "alice@example.com")
+		if err := webauthn.CreateCredential(ctx, newCredential(user, "key", time.Now())); err != nil {
+			t.Fatalf("CreateCredential: %v", err)
+		}
+
+		if err := webauthn.UpdateSignCount(ctx, []byte("key"), 0, 5); err != nil {
+			t.Fatalf("UpdateSignCount: %v", err)
+		}
+		//A second assertion checked against the old counter lost the race
+		if err := webauthn.UpdateSignCount(ctx, []byte("key"), 0, 6); !errors.Is(err, db.ErrWebAuthnSignCountChanged) {
+			t.Errorf("UpdateSignCount from a stale counter returned %v, want ErrWebAuthnSignCountChanged", err)
+		}
+		if err := webauthn.UpdateSignCount(ctx, []byte("missing"), 0, 1); !errors.Is(err, db.ErrWebAuthnSignCountChanged) {
+			t.Errorf("UpdateSignCount of a missing credential returned %v, want ErrWebAuthnSignCountChanged", err)
+		}
+
+		//Counters beyond the range of a signed 32-bit integer are kept intact
+		if err := webauthn.UpdateSignCount(ctx, []byte("key"), 5, 1<<32-1); err != nil {
+			t.Fatalf("UpdateSignCount: %v", err)
+		}
+		got, err := webauthn.GetCredential(ctx, []byte("key"))
+		if err != nil {
+			t.Fatalf("GetCredential: %v", err)
+		}
+		if got.SignCount != 1<<32-1 {
+			t.Errorf("got sign count %d, want %d", got.SignCount, uint32(1<<32-1))
+		}
+		if got.LastUsedAt == nil || !sameTime(*got.LastUsedAt, time.Now()) {
+			t.Errorf("got last_used_at %v, want now", got.LastUsedAt)
+		}
+	})
+
+	t.Run("Sessions", func(t *testing.T) {
+		store := newStore(t)
+		webauthn := store.WebAuthn
+		user := storeUser(t, store, "alice@example.com")
+
+		for _, session := range []models.WebAuthnSession{
+			newSession(&user, "register", models.CeremonyRegistration, time.Minute),
+			newSession(nil, "login", models.CeremonyLogin, time.Minute),
+			newSession(&user, "expired", models.CeremonyLogin, -time.Minute),
+		} {
+			if err := webauthn.CreateSession(ctx, session); err != nil {
+				t.Fatalf("CreateSession: %v", err)
+			}
+		}
+
+		//A challenge is only accepted for the ceremony it was issued for
+		if _, err := webauthn.ConsumeSession(ctx, "register", models.CeremonyLogin); !errors.Is(err, db.ErrWebAuthnSessionInvalid) {
+			t.Errorf("ConsumeSession for another ceremony returned %v, want ErrWebAuthnSessionInvalid", err)
+		}
+		got, err := webauthn.ConsumeSession(ctx, "register", models.CeremonyRegistration)
+		if err != nil {
+			t.Fatalf("ConsumeSession: %v", err)
+		}
+		if got.ChallengeHash != "register" || got.Ceremony != models.CeremonyRegistration || got.UserID == nil || *got.UserID != user {
+			t.Errorf("got session %+v, want the registration of %s", got, user)
+		}
+		if _, err := webauthn.ConsumeSession(ctx, "register", models.CeremonyRegistration); !errors.Is(err, db.ErrWebAuthnSessionInvalid) || !errors.Is(err, db.ErrNotFound) {
+			t.Errorf("second ConsumeSession returned %v, want ErrWebAuthnSessionInvalid", err)
+		}
+
+		got, err = webauthn.ConsumeSession(ctx, "login", models.CeremonyLogin)
+		if err != nil {
+			t.Fatalf("ConsumeSession: %v", err)
+		}
+		if got.UserID != nil {
+			t.Errorf("got user %v for a discoverable login, want none", got.UserID)
+		}
+
+		if _, err := webauthn.ConsumeSession(ctx, "expired", models.CeremonyLogin); !errors.Is(err, db.ErrWebAuthnSessionInvalid) {
+			t.Errorf("ConsumeSession of an expired challenge returned %v, want ErrWebAuthnSessionInvalid", err)
+		}
+	})
+}
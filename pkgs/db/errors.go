@@ -0,0 +1,66 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// Every error returned by the repositories matches at most one of these with errors.Is,
+// whatever the backend, so callers never need to inspect driver errors or messages
+var (
+	// ErrNotFound is returned when the requested record does not exist
+	ErrNotFound = errors.New("not found")
+	// ErrConflict is returned when a write clashes with existing data, such as a duplicate key
+	// or a record already in the requested state
+	ErrConflict = errors.New("conflict")
+	// ErrConstraint is returned when a write violates any other constraint, such as a foreign key
+	ErrConstraint = errors.New("constraint violation")
+)
+
+// kindError is a specific error that also matches one of the generic sentinels
+type kindError struct {
+	msg  string
+	kind error
+}
+
+func (e *kindError) Error() string { return e.msg }
+func (e *kindError) Unwrap() error { return e.kind }
+
+// newKindError creates a specific error matching kind with errors.Is
+func newKindError(kind error, msg string) error {
+	return &kindError{msg: msg, kind: kind}
+}
+
+// ErrUserNotFound is returned when no user matches the given email or ID
+var ErrUserNotFound = newKindError(ErrNotFound, "user not found")
+
+// sqliteError wraps an error returned by the SQLite driver with msg,
+// adding the sentinel matching its constraint violation if there is one
+func sqliteError(msg string, err error) error {
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.Code()&0xff == sqlite3.SQLITE_CONSTRAINT {
+		switch sqliteErr.Code() {
+		case sqlite3.SQLITE_CONSTRAINT_UNIQUE, sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY:
+			return fmt.Errorf("%s: %w: %w", msg, ErrConflict, err)
+		}
+		return fmt.Errorf("%s: %w: %w", msg, ErrConstraint, err)
+	}
+	return fmt.Errorf("%s: %w", msg, err)
+}
+
+// postgresError wraps an error returned by the Postgres driver with msg,
+// adding the sentinel matching its integrity constraint violation (SQLSTATE class 23) if there is one
+func postgresError(msg string, err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && len(pgErr.Code) == 5 && pgErr.Code[:2] == "23" {
+		if pgErr.Code == "23505" {
+			return fmt.Errorf("%s: %w: %w", msg, ErrConflict, err)
+		}
+		return fmt.Errorf("%s: %w: %w", msg, ErrConstraint, err)
+	}
+	return fmt.Errorf("%s: %w", msg, err)
+}
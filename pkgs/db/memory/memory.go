@@ -0,0 +1,134 @@
+// Package memory implements the db repositories in process memory for tests and demos.
+// Nothing is persisted and every Store starts empty.
+package memory
+
+import (
+	"context"
+	"maps"
+	"sync"
+	"time"
+
+	"joshuamURD/go-auth-api/pkgs/db"
+	"joshuamURD/go-auth-api/pkgs/models"
+
+	"github.com/google/uuid"
+)
+
+// Driver is the db.Config driver name that selects the in-memory store
+const Driver = "memory"
+
+// state is the data shared by the repositories of one store
+// a transaction works on a copy that replaces data when it commits
+type state struct {
+	mu   sync.Mutex
+	data *tables
+}
+
+// tables holds one map per table
+type tables struct {
+	users         map[uuid.UUID]models.User
+	todos         map[uuid.UUID]models.Todo
+	refreshTokens map[string]models.RefreshToken
+	oneTimeTokens map[string]models.OneTimeToken
+	totp          map[uuid.UUID]models.TOTPEnrollment
+	recoveryCodes map[string]recoveryCode
+	credentials   map[string]models.WebAuthnCredential
+	sessions      map[string]models.WebAuthnSession
+	rowID         int
+}
+
+func newTables() *tables {
+	return &tables{
+		users:         map[uuid.UUID]models.User{},
+		todos:         map[uuid.UUID]models.Todo{},
+		refreshTokens: map[string]models.RefreshToken{},
+		oneTimeTokens: map[string]models.OneTimeToken{},
+		totp:          map[uuid.UUID]models.TOTPEnrollment{},
+		recoveryCodes: map[string]recoveryCode{},
+		credentials:   map[string]models.WebAuthnCredential{},
+		sessions:      map[string]models.WebAuthnSession{},
+	}
+}
+
+// clone copies the tables, records are values so a shallow copy of each map is enough
+func (t *tables) clone() *tables {
+	return &tables{
+		users:         maps.Clone(t.users),
+		todos:         maps.Clone(t.todos),
+		refreshTokens: maps.Clone(t.refreshTokens),
+		oneTimeTokens: maps.Clone(t.oneTimeTokens),
+		totp:          maps.Clone(t.totp),
+		recoveryCodes: maps.Clone(t.recoveryCodes),
+		credentials:   maps.Clone(t.credentials),
+		sessions:      maps.Clone(t.sessions),
+		rowID:         t.rowID,
+	}
+}
+
+// NewStore creates an empty in-memory store
+// it has no migrator since there is no schema to manage
+func NewStore() *db.Store {
+	return newStore(&state{data: newTables()}, nil)
+}
+
+// newStore builds the repositories on top of the state, or the copy of its tables a transaction works on
+func newStore(s *state, tx *tables) *db.Store {
+	return &db.Store{
+		Users:         &UserRepository{state: s, tx: tx},
+		Todos:         &TodoRepository{state: s, tx: tx},
+		RefreshTokens: &RefreshTokenRepository{state: s, tx: tx},
+		OneTimeTokens: &OneTimeTokenRepository{state: s, tx: tx},
+		MFA:           &MFARepository{state: s, tx: tx},
+		WebAuthn:      &WebAuthnRepository{state: s, tx: tx},
+		Begin: func(ctx context.Context, fn func(tx *db.Store) error) error {
+			return s.withTx(ctx, tx, func(work *tables) error {
+				return fn(newStore(s, work))
+			})
+		},
+	}
+}
+
+// run calls fn with the tables under the store lock, or with the tables of the
+// transaction in progress, whose lock is already held
+func (s *state) run(ctx context.Context, tx *tables, fn func(t *tables) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if tx != nil {
+		return fn(tx)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return fn(s.data)
+}
+
+// withTx calls fn with a copy of the tables that replaces them only if fn returns nil, or with
+// the tables of the transaction in progress. The store stays locked until fn returns, so fn must
+// only use the repositories bound to the copy.
+func (s *state) withTx(ctx context.Context, tx *tables, fn func(work *tables) error) error {
+	if tx != nil {
+		return fn(tx)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	work := s.data.clone()
+	if err := fn(work); err != nil {
+		return err
+	}
+	s.data = work
+	return nil
+}
+
+// copyTime returns a copy of an optional time so stored records never share memory with callers
+func copyTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	c := *t
+	return &c
+}
@@ -0,0 +1,13 @@
+package memory_test
+
+import (
+	"testing"
+
+	"joshuamURD/go-auth-api/pkgs/db"
+	"joshuamURD/go-auth-api/pkgs/db/dbtest"
+	"joshuamURD/go-auth-api/pkgs/db/memory"
+)
+
+func TestConformance(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T) *db.Store { return memory.NewStore() })
+}
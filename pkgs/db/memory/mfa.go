@@ -0,0 +1,107 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"joshuamURD/go-auth-api/pkgs/db"
+	"joshuamURD/go-auth-api/pkgs/models"
+
+	"github.com/google/uuid"
+)
+
+// recoveryCode is a stored recovery code, which has no model of its own
+type recoveryCode struct {
+	userID uuid.UUID
+	used   bool
+}
+
+// MFARepository implements db.MFARepository in memory.
+type MFARepository struct {
+	state *state
+	tx    *tables
+}
+
+// SaveTOTP stores an unconfirmed enrollment, replacing an earlier unconfirmed one.
+func (r *MFARepository) SaveTOTP(ctx context.Context, enrollment models.TOTPEnrollment) error {
+	return r.state.run(ctx, r.tx, func(t *tables) error {
+		if stored, ok := t.totp[enrollment.UserID]; ok && stored.Enabled() {
+			return db.ErrTOTPAlreadyEnabled
+		}
+		enrollment.ConfirmedAt = nil
+		enrollment.LastUsedStep = 0
+		t.totp[enrollment.UserID] = enrollment
+		return nil
+	})
+}
+
+// GetTOTP retrieves the enrollment of a user.
+func (r *MFARepository) GetTOTP(ctx context.Context, userID uuid.UUID) (models.TOTPEnrollment, error) {
+	var found models.TOTPEnrollment
+	err := r.state.run(ctx, r.tx, func(t *tables) error {
+		enrollment, ok := t.totp[userID]
+		if !ok {
+			return db.ErrTOTPNotFound
+		}
+		found = enrollment
+		found.ConfirmedAt = copyTime(enrollment.ConfirmedAt)
+		return nil
+	})
+	return found, err
+}
+
+// ConfirmTOTP enables a pending enrollment.
+func (r *MFARepository) ConfirmTOTP(ctx context.Context, userID uuid.UUID, step int64) error {
+	return r.state.run(ctx, r.tx, func(t *tables) error {
+		enrollment, ok := t.totp[userID]
+		if !ok || enrollment.Enabled() {
+			return db.ErrTOTPNotFound
+		}
+		now := time.Now().UTC()
+		enrollment.ConfirmedAt = &now
+		enrollment.LastUsedStep = step
+		t.totp[userID] = enrollment
+		return nil
+	})
+}
+
+// UseTOTPStep records the step of a code used to log in.
+func (r *MFARepository) UseTOTPStep(ctx context.Context, userID uuid.UUID, step int64) error {
+	return r.state.run(ctx, r.tx, func(t *tables) error {
+		enrollment, ok := t.totp[userID]
+		if !ok || !enrollment.Enabled() || enrollment.LastUsedStep >= step {
+			return db.ErrTOTPStepUsed
+		}
+		enrollment.LastUsedStep = step
+		t.totp[userID] = enrollment
+		return nil
+	})
+}
+
+// ReplaceRecoveryCodes replaces every recovery code of a user.
+func (r *MFARepository) ReplaceRecoveryCodes(ctx context.Context, userID uuid.UUID, hashes []string) error {
+	return r.state.run(ctx, r.tx, func(t *tables) error {
+		for hash, code := range t.recoveryCodes {
+			if code.userID == userID {
+				delete(t.recoveryCodes, hash)
+			}
+		}
+		for _, hash := range hashes {
+			t.recoveryCodes[hash] = recoveryCode{userID: userID}
+		}
+		return nil
+	})
+}
+
+// UseRecoveryCode marks an unused recovery code of a user as used.
+func (r *MFARepository) UseRecoveryCode(ctx context.Context, userID uuid.UUID, hash string) error {
+	return r.state.run(ctx, r.tx, func(t *tables) error {
+		code, ok := t.recoveryCodes[hash]
+		if !ok || code.userID != userID || code.used {
+			return db.ErrRecoveryCodeInvalid
+		}
+		code.used = true
+		t.recoveryCodes[hash] = code
+		return nil
+	})
+}
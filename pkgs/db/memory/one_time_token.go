@@ -0,0 +1,68 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"joshuamURD/go-auth-api/pkgs/db"
+	"joshuamURD/go-auth-api/pkgs/models"
+
+	"github.com/google/uuid"
+)
+
+// OneTimeTokenRepository implements db.OneTimeTokenRepository in memory.
+type OneTimeTokenRepository struct {
+	state *state
+	tx    *tables
+}
+
+// Create stores a new one-time token.
+func (r *OneTimeTokenRepository) Create(ctx context.Context, token models.OneTimeToken) error {
+	return r.state.run(ctx, r.tx, func(t *tables) error {
+		if _, ok := t.oneTimeTokens[token.Hash]; ok {
+			return fmt.Errorf("error creating one-time token: %w", db.ErrConflict)
+		}
+		t.oneTimeTokens[token.Hash] = copyOneTimeToken(token)
+		return nil
+	})
+}
+
+// Consume marks a token as used and returns it. It fails with db.ErrOneTimeTokenInvalid
+// unless the token exists, has the given purpose, is unused and has not expired.
+func (r *OneTimeTokenRepository) Consume(ctx context.Context, hash string, purpose models.TokenPurpose) (models.OneTimeToken, error) {
+	var consumed models.OneTimeToken
+	err := r.state.run(ctx, r.tx, func(t *tables) error {
+		now := time.Now().UTC()
+		token, ok := t.oneTimeTokens[hash]
+		if !ok || token.Purpose != purpose || token.UsedAt != nil || !token.ExpiresAt.After(now) {
+			return db.ErrOneTimeTokenInvalid
+		}
+		token.UsedAt = &now
+		t.oneTimeTokens[hash] = token
+		consumed = copyOneTimeToken(token)
+		return nil
+	})
+	return consumed, err
+}
+
+// InvalidateForUser marks every unused token of a user with the given purpose as used.
+func (r *OneTimeTokenRepository) InvalidateForUser(ctx context.Context, userID uuid.UUID, purpose models.TokenPurpose) error {
+	return r.state.run(ctx, r.tx, func(t *tables) error {
+		now := time.Now().UTC()
+		for hash, token := range t.oneTimeTokens {
+			if token.UserID == userID && token.Purpose == purpose && token.UsedAt == nil {
+				usedAt := now
+				token.UsedAt = &usedAt
+				t.oneTimeTokens[hash] = token
+			}
+		}
+		return nil
+	})
+}
+
+// copyOneTimeToken returns a copy of token that shares no memory with it
+func copyOneTimeToken(token models.OneTimeToken) models.OneTimeToken {
+	token.UsedAt = copyTime(token.UsedAt)
+	return token
+}
@@ -0,0 +1,93 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"joshuamURD/go-auth-api/pkgs/db"
+	"joshuamURD/go-auth-api/pkgs/models"
+
+	"github.com/google/uuid"
+)
+
+// RefreshTokenRepository implements db.RefreshTokenRepository in memory.
+type RefreshTokenRepository struct {
+	state *state
+	tx    *tables
+}
+
+// Create stores a newly issued refresh token.
+func (r *RefreshTokenRepository) Create(ctx context.Context, token models.RefreshToken) error {
+	return r.state.run(ctx, r.tx, func(t *tables) error {
+		if _, ok := t.refreshTokens[token.ID]; ok {
+			return fmt.Errorf("error creating refresh token: %w", db.ErrConflict)
+		}
+		t.refreshTokens[token.ID] = copyRefreshToken(token)
+		return nil
+	})
+}
+
+// GetByID retrieves a refresh token by its jti.
+func (r *RefreshTokenRepository) GetByID(ctx context.Context, id string) (models.RefreshToken, error) {
+	var found models.RefreshToken
+	err := r.state.run(ctx, r.tx, func(t *tables) error {
+		token, ok := t.refreshTokens[id]
+		if !ok {
+			return db.ErrRefreshTokenNotFound
+		}
+		found = copyRefreshToken(token)
+		return nil
+	})
+	return found, err
+}
+
+// Revoke marks a token as used, failing with db.ErrRefreshTokenRevoked unless it is still active.
+func (r *RefreshTokenRepository) Revoke(ctx context.Context, id string, replacedBy string) error {
+	return r.state.run(ctx, r.tx, func(t *tables) error {
+		token, ok := t.refreshTokens[id]
+		if !ok || token.RevokedAt != nil {
+			return db.ErrRefreshTokenRevoked
+		}
+		now := time.Now().UTC()
+		token.RevokedAt = &now
+		token.ReplacedBy = replacedBy
+		t.refreshTokens[id] = token
+		return nil
+	})
+}
+
+// RevokeFamily revokes every active token in a family.
+func (r *RefreshTokenRepository) RevokeFamily(ctx context.Context, familyID string) error {
+	return r.revokeWhere(ctx, func(token models.RefreshToken) bool {
+		return token.FamilyID == familyID
+	})
+}
+
+// RevokeAllForUser revokes every active token belonging to a user.
+func (r *RefreshTokenRepository) RevokeAllForUser(ctx context.Context, userID uuid.UUID) error {
+	return r.revokeWhere(ctx, func(token models.RefreshToken) bool {
+		return token.UserID == userID
+	})
+}
+
+// revokeWhere revokes every active token selected by match
+func (r *RefreshTokenRepository) revokeWhere(ctx context.Context, match func(models.RefreshToken) bool) error {
+	return r.state.run(ctx, r.tx, func(t *tables) error {
+		now := time.Now().UTC()
+		for id, token := range t.refreshTokens {
+			if token.RevokedAt == nil && match(token) {
+				revokedAt := now
+				token.RevokedAt = &revokedAt
+				t.refreshTokens[id] = token
+			}
+		}
+		return nil
+	})
+}
+
+// copyRefreshToken returns a copy of token that shares no memory with it
+func copyRefreshToken(token models.RefreshToken) models.RefreshToken {
+	token.RevokedAt = copyTime(token.RevokedAt)
+	return token
+}
@@ -0,0 +1,95 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"joshuamURD/go-auth-api/pkgs/db"
+	"joshuamURD/go-auth-api/pkgs/models"
+
+	"github.com/google/uuid"
+)
+
+// TodoRepository implements db.TodoRepository in memory.
+type TodoRepository struct {
+	state *state
+	tx    *tables
+}
+
+// Create stores a new todo.
+func (r *TodoRepository) Create(ctx context.Context, todo models.Todo) error {
+	return r.state.run(ctx, r.tx, func(t *tables) error {
+		if _, ok := t.todos[todo.ID]; ok {
+			return fmt.Errorf("error creating todo: %w", db.ErrConflict)
+		}
+		t.todos[todo.ID] = copyTodo(todo)
+		return nil
+	})
+}
+
+// GetByOwner retrieves all todos belonging to a user, oldest first.
+func (r *TodoRepository) GetByOwner(ctx context.Context, ownerID uuid.UUID) ([]models.Todo, error) {
+	todos := []models.Todo{}
+	err := r.state.run(ctx, r.tx, func(t *tables) error {
+		for _, todo := range t.todos {
+			if todo.OwnerID == ownerID {
+				todos = append(todos, copyTodo(todo))
+			}
+		}
+		return nil
+	})
+	sort.SliceStable(todos, func(i, j int) bool {
+		return todos[i].CreatedAt.Before(todos[j].CreatedAt)
+	})
+	return todos, err
+}
+
+// GetByID retrieves a single todo owned by the given user.
+func (r *TodoRepository) GetByID(ctx context.Context, id uuid.UUID, ownerID uuid.UUID) (models.Todo, error) {
+	var found models.Todo
+	err := r.state.run(ctx, r.tx, func(t *tables) error {
+		todo, ok := t.todos[id]
+		if !ok || todo.OwnerID != ownerID {
+			return db.ErrTodoNotFound
+		}
+		found = copyTodo(todo)
+		return nil
+	})
+	return found, err
+}
+
+// Update overwrites the mutable fields of a todo owned by todo.OwnerID.
+func (r *TodoRepository) Update(ctx context.Context, todo models.Todo) error {
+	return r.state.run(ctx, r.tx, func(t *tables) error {
+		stored, ok := t.todos[todo.ID]
+		if !ok || stored.OwnerID != todo.OwnerID {
+			return db.ErrTodoNotFound
+		}
+		stored.Title = todo.Title
+		stored.Description = todo.Description
+		stored.Status = todo.Status
+		stored.DueDate = copyTime(todo.DueDate)
+		stored.UpdatedAt = todo.UpdatedAt
+		t.todos[todo.ID] = stored
+		return nil
+	})
+}
+
+// Delete removes a todo owned by the given user.
+func (r *TodoRepository) Delete(ctx context.Context, id uuid.UUID, ownerID uuid.UUID) error {
+	return r.state.run(ctx, r.tx, func(t *tables) error {
+		todo, ok := t.todos[id]
+		if !ok || todo.OwnerID != ownerID {
+			return db.ErrTodoNotFound
+		}
+		delete(t.todos, id)
+		return nil
+	})
+}
+
+// copyTodo returns a copy of todo that shares no memory with it
+func copyTodo(todo models.Todo) models.Todo {
+	todo.DueDate = copyTime(todo.DueDate)
+	return todo
+}
@@ -0,0 +1,372 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"joshuamURD/go-auth-api/pkgs/db"
+	"joshuamURD/go-auth-api/pkgs/models"
+
+	"github.com/google/uuid"
+)
+
+// UserRepository implements db.Database in memory.
+// Inside WithTx it is bound to the transaction's copy of the tables.
+type UserRepository struct {
+	state *state
+	tx    *tables
+}
+
+// GetAll retrieves all users.
+func (r *UserRepository) GetAll(ctx context.Context) ([]models.User, error) {
+	var users []models.User
+	err := r.state.run(ctx, r.tx, func(t *tables) error {
+		for _, user := range t.users {
+			users = append(users, copyUser(user))
+		}
+		return nil
+	})
+	return users, err
+}
+
+// Create stores a new user, failing with db.ErrEmailTaken if another user has the same models.EmailKey.
+// The returned number increases with every user created, like a SQLite rowid.
+func (r *UserRepository) Create(ctx context.Context, user models.User) (int, error) {
+	var id int
+	err := r.state.run(ctx, r.tx, func(t *tables) error {
+		if _, ok := t.users[user.ID]; ok {
+			return fmt.Errorf("error creating user: %w", db.ErrConflict)
+		}
+		if emailTaken(t, user) {
+			return db.ErrEmailTaken
+		}
+		if user.Role == "" {
+			user.Role = models.RoleUser
+		}
+		t.rowID++
+		id = t.rowID
+		t.users[user.ID] = copyUser(user)
+		return nil
+	})
+	return id, err
+}
+
+// GetByEmail retrieves a user by their email address, ignoring case as models.EmailKey does.
+func (r *UserRepository) GetByEmail(ctx context.Context, email string) (models.User, error) {
+	var found models.User
+	err := r.state.run(ctx, r.tx, func(t *tables) error {
+		key := models.EmailKey(email)
+		for _, user := range t.users {
+			if models.EmailKey(user.Email) == key {
+				found = copyUser(user)
+				return nil
+			}
+		}
+		return fmt.Errorf("%w with email: %s", db.ErrUserNotFound, email)
+	})
+	return found, err
+}
+
+// GetByID retrieves a user by their ID.
+func (r *UserRepository) GetByID(ctx context.Context, id uuid.UUID) (models.User, error) {
+	var found models.User
+	err := r.state.run(ctx, r.tx, func(t *tables) error {
+		user, ok := t.users[id]
+		if !ok {
+			return fmt.Errorf("%w with id: %s", db.ErrUserNotFound, id)
+		}
+		found = copyUser(user)
+		return nil
+	})
+	return found, err
+}
+
+// Update overwrites the stored fields of an existing user.
+// The creation time is kept, as the SQL backends never update it.
+func (r *UserRepository) Update(ctx context.Context, user models.User) error {
+	return r.state.run(ctx, r.tx, func(t *tables) error {
+		stored, ok := t.users[user.ID]
+		if !ok {
+			return fmt.Errorf("%w with id: %s", db.ErrUserNotFound, user.ID)
+		}
+		if emailTaken(t, user) {
+			return db.ErrEmailTaken
+		}
+		if user.Role == "" {
+			user.Role = models.RoleUser
+		}
+		user.CreatedAt = stored.CreatedAt
+		t.users[user.ID] = copyUser(user)
+		return nil
+	})
+}
+
+// UpdatePasswordHash replaces the password hash of a user if it is still oldHash, see db.Database.
+func (r *UserRepository) UpdatePasswordHash(ctx context.Context, id uuid.UUID, oldHash, newHash string) error {
+	return r.state.run(ctx, r.tx, func(t *tables) error {
+		user, ok := t.users[id]
+		if !ok {
+			return fmt.Errorf("%w with id: %s", db.ErrUserNotFound, id)
+		}
+		if user.HashedPassword != oldHash {
+			return db.ErrPasswordChanged
+		}
+		user.HashedPassword = newHash
+		user.UpdatedAt = time.Now().UTC()
+		t.users[id] = user
+		return nil
+	})
+}
+
+// RecordFailedLogin increments the failed attempt counter of a user and locks the account
+// for lockFor once maxAttempts is reached, clearing an expired lock first. A maxAttempts of zero never locks.
+func (r *UserRepository) RecordFailedLogin(ctx context.Context, id uuid.UUID, maxAttempts int, lockFor time.Duration) (models.User, error) {
+	var updated models.User
+	err := r.state.run(ctx, r.tx, func(t *tables) error {
+		user, ok := t.users[id]
+		if !ok {
+			return fmt.Errorf("%w with id: %s", db.ErrUserNotFound, id)
+		}
+		now := time.Now().UTC()
+
+		// Clear an expired lock so that the attempt counts from zero again
+		if user.Locked && user.LockedUntil != nil && !user.LockedUntil.After(now) {
+			user.Locked = false
+			user.LockedUntil = nil
+			user.FailedAttempts = 0
+		}
+
+		user.FailedAttempts++
+		if maxAttempts > 0 && user.FailedAttempts >= maxAttempts {
+			if !user.Locked {
+				until := now.Add(lockFor)
+				user.LockedUntil = &until
+			}
+			user.Locked = true
+		}
+		user.UpdatedAt = now
+
+		t.users[id] = user
+		updated = copyUser(user)
+		return nil
+	})
+	return updated, err
+}
+
+// ResetFailedLogins clears the failed attempt counter and any lock on a user.
+func (r *UserRepository) ResetFailedLogins(ctx context.Context, id uuid.UUID) error {
+	return r.state.run(ctx, r.tx, func(t *tables) error {
+		user, ok := t.users[id]
+		if !ok {
+			return nil
+		}
+		user.FailedAttempts = 0
+		user.Locked = false
+		user.LockedUntil = nil
+		user.UpdatedAt = time.Now().UTC()
+		t.users[id] = user
+		return nil
+	})
+}
+
+// Lock locks a user until an admin unlocks them, keeping their failed attempt counter.
+func (r *UserRepository) Lock(ctx context.Context, id uuid.UUID) error {
+	return r.updateUser(ctx, id, func(user *models.User) {
+		user.Locked = true
+		user.LockedUntil = nil
+	})
+}
+
+// MarkVerified marks the email of a user as verified.
+func (r *UserRepository) MarkVerified(ctx context.Context, id uuid.UUID) error {
+	return r.updateUser(ctx, id, func(user *models.User) {
+		user.Verified = true
+	})
+}
+
+// ClearFailedAttempts sets the failed attempt counter of a user to zero, keeping any lock.
+func (r *UserRepository) ClearFailedAttempts(ctx context.Context, id uuid.UUID) error {
+	return r.updateUser(ctx, id, func(user *models.User) {
+		user.FailedAttempts = 0
+	})
+}
+
+// ClearLockout clears the failed attempts and a lockout of a user, but not a lock set by an admin.
+func (r *UserRepository) ClearLockout(ctx context.Context, id uuid.UUID) error {
+	return r.updateUser(ctx, id, func(user *models.User) {
+		user.FailedAttempts = 0
+		user.Locked = user.Locked && user.LockedUntil == nil
+		user.LockedUntil = nil
+	})
+}
+
+// SetRole writes the role of a user, refusing to demote the only admin, see db.Database.
+func (r *UserRepository) SetRole(ctx context.Context, id uuid.UUID, role models.Role) error {
+	return r.state.run(ctx, r.tx, func(t *tables) error {
+		user, ok := t.users[id]
+		if !ok {
+			return fmt.Errorf("%w with id: %s", db.ErrUserNotFound, id)
+		}
+		if user.Role == models.RoleAdmin && role != models.RoleAdmin && !otherAdmin(t, id) {
+			return db.ErrLastAdmin
+		}
+		user.Role = role
+		user.UpdatedAt = time.Now().UTC()
+		t.users[id] = user
+		return nil
+	})
+}
+
+// PromoteFirstAdmin makes a user an admin while there is none, see db.Database.
+func (r *UserRepository) PromoteFirstAdmin(ctx context.Context, id uuid.UUID) error {
+	return r.state.run(ctx, r.tx, func(t *tables) error {
+		user, ok := t.users[id]
+		if !ok {
+			return fmt.Errorf("%w with id: %s", db.ErrUserNotFound, id)
+		}
+		if user.Role == models.RoleAdmin || otherAdmin(t, id) {
+			return db.ErrAdminExists
+		}
+		user.Role = models.RoleAdmin
+		user.UpdatedAt = time.Now().UTC()
+		t.users[id] = user
+		return nil
+	})
+}
+
+// updateUser applies change to the stored user and bumps UpdatedAt
+func (r *UserRepository) updateUser(ctx context.Context, id uuid.UUID, change func(user *models.User)) error {
+	return r.state.run(ctx, r.tx, func(t *tables) error {
+		user, ok := t.users[id]
+		if !ok {
+			return fmt.Errorf("%w with id: %s", db.ErrUserNotFound, id)
+		}
+		change(&user)
+		user.UpdatedAt = time.Now().UTC()
+		t.users[id] = user
+		return nil
+	})
+}
+
+// List retrieves a page of users matching the filter, ordered by creation time,
+// along with the total number of matching users.
+func (r *UserRepository) List(ctx context.Context, filter db.UserFilter) ([]models.User, int, error) {
+	users := []models.User{}
+	var total int
+	err := r.state.run(ctx, r.tx, func(t *tables) error {
+		var matched []models.User
+		for _, user := range t.users {
+			if filter.Email != "" && !strings.Contains(models.EmailKey(user.Email), models.EmailKey(filter.Email)) {
+				continue
+			}
+			if filter.Verified != nil && user.Verified != *filter.Verified {
+				continue
+			}
+			if filter.Locked != nil && user.Locked != *filter.Locked {
+				continue
+			}
+			matched = append(matched, user)
+		}
+		sort.Slice(matched, func(i, j int) bool {
+			if !matched[i].CreatedAt.Equal(matched[j].CreatedAt) {
+				return matched[i].CreatedAt.Before(matched[j].CreatedAt)
+			}
+			return matched[i].ID.String() < matched[j].ID.String()
+		})
+
+		total = len(matched)
+		start := min(filter.Offset, total)
+		end := total
+		if filter.Limit > 0 {
+			end = min(start+filter.Limit, total)
+		}
+		for _, user := range matched[start:end] {
+			users = append(users, copyUser(user))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return users, total, nil
+}
+
+// Delete removes a user along with their todos, tokens, second factors and passkeys.
+func (r *UserRepository) Delete(ctx context.Context, id uuid.UUID) error {
+	return r.state.run(ctx, r.tx, func(t *tables) error {
+		if _, ok := t.users[id]; !ok {
+			return fmt.Errorf("%w with id: %s", db.ErrUserNotFound, id)
+		}
+		delete(t.users, id)
+		for todoID, todo := range t.todos {
+			if todo.OwnerID == id {
+				delete(t.todos, todoID)
+			}
+		}
+		for tokenID, token := range t.refreshTokens {
+			if token.UserID == id {
+				delete(t.refreshTokens, tokenID)
+			}
+		}
+		for hash, token := range t.oneTimeTokens {
+			if token.UserID == id {
+				delete(t.oneTimeTokens, hash)
+			}
+		}
+		delete(t.totp, id)
+		for hash, code := range t.recoveryCodes {
+			if code.userID == id {
+				delete(t.recoveryCodes, hash)
+			}
+		}
+		for credentialID, credential := range t.credentials {
+			if credential.UserID == id {
+				delete(t.credentials, credentialID)
+			}
+		}
+		for hash, session := range t.sessions {
+			if session.UserID != nil && *session.UserID == id {
+				delete(t.sessions, hash)
+			}
+		}
+		return nil
+	})
+}
+
+// WithTx runs fn against a copy of the tables that replaces them only if fn returns nil.
+// The store stays locked until fn returns, so fn must only use tx and not the other
+// repositories of the store.
+func (r *UserRepository) WithTx(ctx context.Context, fn func(tx db.Database) error) error {
+	return r.state.withTx(ctx, r.tx, func(work *tables) error {
+		return fn(&UserRepository{state: r.state, tx: work})
+	})
+}
+
+// emailTaken reports whether a different user already has the email of user, ignoring case
+func emailTaken(t *tables, user models.User) bool {
+	for _, other := range t.users {
+		if other.ID != user.ID && models.EmailKey(other.Email) == models.EmailKey(user.Email) {
+			return true
+		}
+	}
+	return false
+}
+
+// otherAdmin reports whether a user other than id is an admin
+func otherAdmin(t *tables, id uuid.UUID) bool {
+	for _, user := range t.users {
+		if user.ID != id && user.Role == models.RoleAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// copyUser returns a copy of user that shares no memory with it
+func copyUser(user models.User) models.User {
+	user.LockedUntil = copyTime(user.LockedUntil)
+	return user
+}
@@ -0,0 +1,119 @@
+package memory
+
+import (
+	"bytes"
+	"context"
+	"slices"
+	"time"
+
+	"joshuamURD/go-auth-api/pkgs/db"
+	"joshuamURD/go-auth-api/pkgs/models"
+
+	"github.com/google/uuid"
+)
+
+// WebAuthnRepository implements db.WebAuthnRepository in memory.
+type WebAuthnRepository struct {
+	state *state
+	tx    *tables
+}
+
+// CreateCredential stores a newly registered credential.
+func (r *WebAuthnRepository) CreateCredential(ctx context.Context, credential models.WebAuthnCredential) error {
+	return r.state.run(ctx, r.tx, func(t *tables) error {
+		if _, ok := t.credentials[string(credential.ID)]; ok {
+			return db.ErrWebAuthnCredentialExists
+		}
+		credential.LastUsedAt = nil
+		t.credentials[string(credential.ID)] = copyCredential(credential)
+		return nil
+	})
+}
+
+// GetCredential retrieves a credential by its ID.
+func (r *WebAuthnRepository) GetCredential(ctx context.Context, id []byte) (models.WebAuthnCredential, error) {
+	var found models.WebAuthnCredential
+	err := r.state.run(ctx, r.tx, func(t *tables) error {
+		credential, ok := t.credentials[string(id)]
+		if !ok {
+			return db.ErrWebAuthnCredentialNotFound
+		}
+		found = copyCredential(credential)
+		return nil
+	})
+	return found, err
+}
+
+// GetCredentialsByUser retrieves every credential of a user, oldest first.
+func (r *WebAuthnRepository) GetCredentialsByUser(ctx context.Context, userID uuid.UUID) ([]models.WebAuthnCredential, error) {
+	credentials := []models.WebAuthnCredential{}
+	err := r.state.run(ctx, r.tx, func(t *tables) error {
+		for _, credential := range t.credentials {
+			if credential.UserID == userID {
+				credentials = append(credentials, copyCredential(credential))
+			}
+		}
+		return nil
+	})
+	slices.SortFunc(credentials, func(a, b models.WebAuthnCredential) int {
+		if c := a.CreatedAt.Compare(b.CreatedAt); c != 0 {
+			return c
+		}
+		return bytes.Compare(a.ID, b.ID)
+	})
+	return credentials, err
+}
+
+// UpdateSignCount moves the counter of a credential only if nobody else moved it first.
+func (r *WebAuthnRepository) UpdateSignCount(ctx context.Context, id []byte, previous uint32, next uint32) error {
+	return r.state.run(ctx, r.tx, func(t *tables) error {
+		credential, ok := t.credentials[string(id)]
+		if !ok || credential.SignCount != previous {
+			return db.ErrWebAuthnSignCountChanged
+		}
+		now := time.Now().UTC()
+		credential.SignCount = next
+		credential.LastUsedAt = &now
+		t.credentials[string(id)] = credential
+		return nil
+	})
+}
+
+// CreateSession stores a challenge that has been sent to a browser.
+func (r *WebAuthnRepository) CreateSession(ctx context.Context, session models.WebAuthnSession) error {
+	return r.state.run(ctx, r.tx, func(t *tables) error {
+		if session.UserID != nil {
+			userID := *session.UserID
+			session.UserID = &userID
+		}
+		t.sessions[session.ChallengeHash] = session
+		return nil
+	})
+}
+
+// ConsumeSession removes a challenge and returns it.
+// Expired challenges are removed too when they are presented, but still rejected.
+func (r *WebAuthnRepository) ConsumeSession(ctx context.Context, challengeHash string, ceremony models.WebAuthnCeremony) (models.WebAuthnSession, error) {
+	var found models.WebAuthnSession
+	err := r.state.run(ctx, r.tx, func(t *tables) error {
+		session, ok := t.sessions[challengeHash]
+		if !ok || session.Ceremony != ceremony {
+			return db.ErrWebAuthnSessionInvalid
+		}
+		delete(t.sessions, challengeHash)
+		if !session.ExpiresAt.After(time.Now()) {
+			return db.ErrWebAuthnSessionInvalid
+		}
+		found = session
+		return nil
+	})
+	return found, err
+}
+
+// copyCredential returns a copy of a credential that shares no memory with the original
+func copyCredential(credential models.WebAuthnCredential) models.WebAuthnCredential {
+	credential.ID = bytes.Clone(credential.ID)
+	credential.PublicKey = bytes.Clone(credential.PublicKey)
+	credential.LastUsedAt = copyTime(credential.LastUsedAt)
+	return credential
+}
@@ -0,0 +1,175 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"joshuamURD/go-auth-api/pkgs/models"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrTOTPNotFound is returned when a user has no authenticator enrollment, or no pending one to confirm
+	ErrTOTPNotFound = newKindError(ErrNotFound, "authenticator not enrolled")
+	// ErrTOTPAlreadyEnabled is returned when enrolling a user whose authenticator is already confirmed
+	ErrTOTPAlreadyEnabled = newKindError(ErrConflict, "authenticator already enabled")
+	// ErrTOTPStepUsed is returned when a code is not newer than the last code accepted
+	ErrTOTPStepUsed = newKindError(ErrConflict, "authenticator code already used")
+	// ErrRecoveryCodeInvalid is returned when a recovery code is unknown, already used or belongs to another user
+	ErrRecoveryCodeInvalid = newKindError(ErrNotFound, "invalid recovery code")
+)
+
+// MFARepository is an interface that defines the methods for storing second factors.
+type MFARepository interface {
+	// SaveTOTP stores an unconfirmed enrollment, replacing an earlier unconfirmed one.
+	// It returns ErrTOTPAlreadyEnabled if the user's enrollment is already confirmed.
+	SaveTOTP(ctx context.Context, enrollment models.TOTPEnrollment) error
+	GetTOTP(ctx context.Context, userID uuid.UUID) (models.TOTPEnrollment, error)
+	// ConfirmTOTP enables a pending enrollment, recording the step of the code that confirmed it.
+	// It returns ErrTOTPNotFound unless the user has a pending enrollment.
+	ConfirmTOTP(ctx context.Context, userID uuid.UUID, step int64) error
+	// UseTOTPStep records the step of a code used to log in. It returns ErrTOTPStepUsed unless the step
+	// is later than every step accepted before, so that a code cannot be replayed.
+	UseTOTPStep(ctx context.Context, userID uuid.UUID, step int64) error
+	// ReplaceRecoveryCodes replaces every recovery code of a user with the given hashes.
+	ReplaceRecoveryCodes(ctx context.Context, userID uuid.UUID, hashes []string) error
+	// UseRecoveryCode marks an unused recovery code of a user as used, or returns ErrRecoveryCodeInvalid.
+	UseRecoveryCode(ctx context.Context, userID uuid.UUID, hash string) error
+}
+
+// SQLiteMFARepository implements MFARepository on top of a SQLite connection.
+type SQLiteMFARepository struct {
+	db *sql.DB
+	tx *sql.Tx
+}
+
+// NewSQLiteMFARepository creates a new SQLiteMFARepository sharing the connection
+// of an existing SQLiteRepository.
+func NewSQLiteMFARepository(repo *SQLiteRepository) *SQLiteMFARepository {
+	return &SQLiteMFARepository{db: repo.db, tx: repo.tx}
+}
+
+// SaveTOTP stores an unconfirmed enrollment, the upsert leaves a confirmed one untouched.
+func (d *SQLiteMFARepository) SaveTOTP(ctx context.Context, enrollment models.TOTPEnrollment) error {
+	result, err := d.conn().ExecContext(
+		ctx,
+		`INSERT INTO totp_enrollments (user_id, secret, last_used_step, created_at) VALUES (?, ?, 0, ?)
+		ON CONFLICT (user_id) DO UPDATE SET secret = excluded.secret, last_used_step = 0, created_at = excluded.created_at
+		WHERE totp_enrollments.confirmed_at IS NULL`,
+		enrollment.UserID,
+		enrollment.Secret,
+		enrollment.CreatedAt.UTC().Format(time.RFC3339),
+	)
+	if err != nil {
+		return sqliteError("error saving authenticator", err)
+	}
+	return expectAffected(result, ErrTOTPAlreadyEnabled)
+}
+
+// GetTOTP retrieves the enrollment of a user.
+func (d *SQLiteMFARepository) GetTOTP(ctx context.Context, userID uuid.UUID) (models.TOTPEnrollment, error) {
+	var enrollment models.TOTPEnrollment
+	var createdAtStr string
+	var confirmedAtStr sql.NullString
+
+	err := d.conn().QueryRowContext(
+		ctx,
+		"SELECT user_id, secret, confirmed_at, last_used_step, created_at FROM totp_enrollments WHERE user_id = ?",
+		userID,
+	).Scan(
+		&enrollment.UserID,
+		&enrollment.Secret,
+		&confirmedAtStr,
+		&enrollment.LastUsedStep,
+		&createdAtStr,
+	)
+	if err == sql.ErrNoRows {
+		return enrollment, ErrTOTPNotFound
+	}
+	if err != nil {
+		return enrollment, sqliteError("database error", err)
+	}
+
+	if enrollment.CreatedAt, err = time.Parse(time.RFC3339, createdAtStr); err != nil {
+		return enrollment, fmt.Errorf("error parsing created_at time: %w", err)
+	}
+	if confirmedAtStr.Valid {
+		confirmedAt, err := time.Parse(time.RFC3339, confirmedAtStr.String)
+		if err != nil {
+			return enrollment, fmt.Errorf("error parsing confirmed_at time: %w", err)
+		}
+		enrollment.ConfirmedAt = &confirmedAt
+	}
+
+	return enrollment, nil
+}
+
+// ConfirmTOTP enables a pending enrollment.
+func (d *SQLiteMFARepository) ConfirmTOTP(ctx context.Context, userID uuid.UUID, step int64) error {
+	result, err := d.conn().ExecContext(
+		ctx,
+		"UPDATE totp_enrollments SET confirmed_at = ?, last_used_step = ? WHERE user_id = ? AND confirmed_at IS NULL",
+		time.Now().UTC().Format(time.RFC3339),
+		step,
+		userID,
+	)
+	if err != nil {
+		return sqliteError("error confirming authenticator", err)
+	}
+	return expectAffected(result, ErrTOTPNotFound)
+}
+
+// UseTOTPStep records the step of a code in a single statement so concurrent logins cannot both use it.
+func (d *SQLiteMFARepository) UseTOTPStep(ctx context.Context, userID uuid.UUID, step int64) error {
+	result, err := d.conn().ExecContext(
+		ctx,
+		"UPDATE totp_enrollments SET last_used_step = ?1 WHERE user_id = ?2 AND confirmed_at IS NOT NULL AND last_used_step < ?1",
+		step,
+		userID,
+	)
+	if err != nil {
+		return sqliteError("error recording authenticator code", err)
+	}
+	return expectAffected(result, ErrTOTPStepUsed)
+}
+
+// ReplaceRecoveryCodes replaces every recovery code of a user in one transaction.
+func (d *SQLiteMFARepository) ReplaceRecoveryCodes(ctx context.Context, userID uuid.UUID, hashes []string) error {
+	return runInTx(ctx, d.db, d.tx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id = ?", userID); err != nil {
+			return sqliteError("error deleting recovery codes", err)
+		}
+		now := time.Now().UTC().Format(time.RFC3339)
+		for _, hash := range hashes {
+			if _, err := tx.ExecContext(ctx, "INSERT INTO recovery_codes (code_hash, user_id, created_at) VALUES (?, ?, ?)", hash, userID, now); err != nil {
+				return sqliteError("error creating recovery code", err)
+			}
+		}
+		return nil
+	})
+}
+
+// UseRecoveryCode marks a recovery code as used in a single statement so it can only ever be used once.
+func (d *SQLiteMFARepository) UseRecoveryCode(ctx context.Context, userID uuid.UUID, hash string) error {
+	result, err := d.conn().ExecContext(
+		ctx,
+		"UPDATE recovery_codes SET used_at = ? WHERE code_hash = ? AND user_id = ? AND used_at IS NULL",
+		time.Now().UTC().Format(time.RFC3339),
+		hash,
+		userID,
+	)
+	if err != nil {
+		return sqliteError("error using recovery code", err)
+	}
+	return expectAffected(result, ErrRecoveryCodeInvalid)
+}
+
+// conn returns the transaction the repository is bound to, or the connection pool
+func (d *SQLiteMFARepository) conn() querier {
+	if d.tx != nil {
+		return d.tx
+	}
+	return d.db
+}
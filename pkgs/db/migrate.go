@@ -0,0 +1,510 @@
+package db
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"joshuamURD/go-auth-api/pkgs/models"
+)
+
+// migrationFiles holds the schema migrations of every dialect as
+// migrations/<dialect>/<version>_<name>.up.sql with an optional matching .down.sql
+//
+//go:embed migrations
+var migrationFiles embed.FS
+
+var (
+	// ErrMigrationChecksum is returned when a migration was edited after it was applied
+	ErrMigrationChecksum = errors.New("migration checksum mismatch")
+	// ErrMigrationIrreversible is returned when rolling back a migration without a down script
+	ErrMigrationIrreversible = errors.New("migration has no down script")
+	// ErrDuplicateEmails is returned when the unique email index cannot be created because
+	// several accounts share an address
+	ErrDuplicateEmails = errors.New("several accounts share an email address")
+)
+
+// migrationLockKey is the Postgres advisory lock every migrator takes before looking at schema_migrations
+const migrationLockKey int64 = 0x6d6967726174696f // "migratio"
+
+// migrationLockWait is how long a SQLite migrator waits for the write lock held by another one
+const migrationLockWait = time.Minute
+
+// migrationHooks run in the transaction of the migration with the same name, before its up script,
+// so the data a migration cannot fix by itself is reported instead of failing on an opaque constraint error
+// and the values only Go can compute are filled in
+var migrationHooks = map[string]func(m *Migrator, ctx context.Context, tx querier) error{
+	"unique_email":     (*Migrator).checkDuplicateEmails,
+	"unique_email_key": (*Migrator).backfillEmailKeys,
+}
+
+// Migration is a single versioned schema change.
+type Migration struct {
+	Version  int
+	Name     string
+	Up       string
+	Down     string
+	Checksum string // hex SHA-256 of the up script
+}
+
+// MigrationStatus describes a known or applied migration.
+// Migrations applied to the database that are no longer shipped have an empty Up script.
+type MigrationStatus struct {
+	Migration
+	AppliedAt *time.Time
+	Modified  bool // the applied checksum differs from the shipped script
+}
+
+// Migrator applies and rolls back the migrations of one dialect, recording them in schema_migrations.
+type Migrator struct {
+	db         *sql.DB
+	dialect    string
+	migrations []Migration
+}
+
+// NewMigrator loads the migrations shipped for the dialect, DriverSQLite or DriverPostgres.
+func NewMigrator(db *sql.DB, dialect string) (*Migrator, error) {
+	migrations, err := loadMigrations(dialect)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, dialect: dialect, migrations: migrations}, nil
+}
+
+// Up applies every pending migration in order, each in its own transaction, and
+// returns the migrations applied. It refuses to run if an applied migration was edited.
+func (m *Migrator) Up() ([]Migration, error) {
+	var done []Migration
+	err := m.locked(func(ctx context.Context, conn *sql.Conn) error {
+		applied, err := m.prepare(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if err := m.verify(applied); err != nil {
+			return err
+		}
+
+		for _, migration := range m.migrations {
+			if _, ok := applied[migration.Version]; ok {
+				continue
+			}
+			err := m.inTx(ctx, conn, func(tx querier) error {
+				if hook, ok := migrationHooks[migration.Name]; ok {
+					if err := hook(m, ctx, tx); err != nil {
+						return err
+					}
+				}
+				if _, err := tx.ExecContext(ctx, migration.Up); err != nil {
+					return err
+				}
+				_, err := tx.ExecContext(ctx,
+					m.rebind("INSERT INTO schema_migrations (version, name, checksum, applied_at) VALUES (?, ?, ?, ?)"),
+					migration.Version,
+					migration.Name,
+					migration.Checksum,
+					time.Now().UTC().Format(time.RFC3339),
+				)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("error applying migration %04d_%s: %w", migration.Version, migration.Name, err)
+			}
+			done = append(done, migration)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Down rolls back the latest steps applied migrations, newest first, and returns them.
+func (m *Migrator) Down(steps int) ([]Migration, error) {
+	var done []Migration
+	err := m.locked(func(ctx context.Context, conn *sql.Conn) error {
+		applied, err := m.prepare(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if err := m.verify(applied); err != nil {
+			return err
+		}
+
+		for i := len(m.migrations) - 1; i >= 0 && len(done) < steps; i-- {
+			migration := m.migrations[i]
+			if _, ok := applied[migration.Version]; !ok {
+				continue
+			}
+			if migration.Down == "" {
+				return fmt.Errorf("%w: %04d_%s", ErrMigrationIrreversible, migration.Version, migration.Name)
+			}
+			err := m.inTx(ctx, conn, func(tx querier) error {
+				if _, err := tx.ExecContext(ctx, migration.Down); err != nil {
+					return err
+				}
+				_, err := tx.ExecContext(ctx, m.rebind("DELETE FROM schema_migrations WHERE version = ?"), migration.Version)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("error rolling back migration %04d_%s: %w", migration.Version, migration.Name, err)
+			}
+			done = append(done, migration)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Status lists every shipped migration along with any applied migration that is no longer shipped,
+// ordered by version.
+func (m *Migrator) Status() ([]MigrationStatus, error) {
+	var applied map[int]appliedMigration
+	err := m.locked(func(ctx context.Context, conn *sql.Conn) error {
+		var err error
+		applied, err = m.prepare(ctx, conn)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []MigrationStatus
+	for _, migration := range m.migrations {
+		status := MigrationStatus{Migration: migration}
+		if record, ok := applied[migration.Version]; ok {
+			status.AppliedAt = &record.appliedAt
+			status.Modified = record.checksum != migration.Checksum
+			delete(applied, migration.Version)
+		}
+		statuses = append(statuses, status)
+	}
+	for version, record := range applied {
+		appliedAt := record.appliedAt
+		statuses = append(statuses, MigrationStatus{
+			Migration: Migration{Version: version, Name: record.name, Checksum: record.checksum},
+			AppliedAt: &appliedAt,
+		})
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+	return statuses, nil
+}
+
+// appliedMigration is a row of schema_migrations
+type appliedMigration struct {
+	name      string
+	checksum  string
+	appliedAt time.Time
+}
+
+// locked runs fn on a single connection while holding the migration lock, so instances started
+// at the same time apply each migration once instead of racing on schema_migrations.
+// Postgres takes a session advisory lock. SQLite holds the database write lock in a
+// BEGIN IMMEDIATE transaction, committed once fn returns, which keeps the migrations fn applied
+// even when a later one fails
+func (m *Migrator) locked(fn func(ctx context.Context, conn *sql.Conn) error) error {
+	ctx := context.Background()
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	defer conn.Close()
+
+	if m.dialect == DriverPostgres {
+		if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLockKey); err != nil {
+			return fmt.Errorf("error taking migration lock: %w", err)
+		}
+		defer conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", migrationLockKey)
+		return fn(ctx, conn)
+	}
+
+	if _, err := conn.ExecContext(ctx, fmt.Sprintf("PRAGMA busy_timeout = %d", migrationLockWait.Milliseconds())); err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	if _, err := conn.ExecContext(ctx, "BEGIN IMMEDIATE"); err != nil {
+		return fmt.Errorf("error taking migration lock: %w", err)
+	}
+	err = fn(ctx, conn)
+	if _, commitErr := conn.ExecContext(ctx, "COMMIT"); commitErr != nil {
+		conn.ExecContext(ctx, "ROLLBACK")
+		return errors.Join(err, fmt.Errorf("error committing migrations: %w", commitErr))
+	}
+	return err
+}
+
+// prepare creates schema_migrations, bringing databases created before versioned migrations
+// up to the baseline schema first, and returns the recorded migrations
+func (m *Migrator) prepare(ctx context.Context, conn *sql.Conn) (map[int]appliedMigration, error) {
+	if m.dialect == DriverSQLite {
+		if err := adoptLegacySQLite(ctx, conn); err != nil {
+			return nil, fmt.Errorf("error adopting existing schema: %w", err)
+		}
+	}
+	_, err := conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		checksum TEXT NOT NULL,
+		applied_at TEXT NOT NULL
+	)`)
+	if err != nil {
+		return nil, fmt.Errorf("error creating schema_migrations: %w", err)
+	}
+	return m.applied(ctx, conn)
+}
+
+// applied returns the recorded migrations keyed by version
+func (m *Migrator) applied(ctx context.Context, conn *sql.Conn) (map[int]appliedMigration, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT version, name, checksum, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	defer rows.Close()
+
+	applied := map[int]appliedMigration{}
+	for rows.Next() {
+		var version int
+		var record appliedMigration
+		var appliedAtStr string
+		if err := rows.Scan(&version, &record.name, &record.checksum, &appliedAtStr); err != nil {
+			return nil, fmt.Errorf("scan error: %w", err)
+		}
+		if record.appliedAt, err = time.Parse(time.RFC3339, appliedAtStr); err != nil {
+			return nil, fmt.Errorf("error parsing applied_at time: %w", err)
+		}
+		applied[version] = record
+	}
+	return applied, rows.Err()
+}
+
+// verify fails if a shipped migration was changed after it was applied
+func (m *Migrator) verify(applied map[int]appliedMigration) error {
+	for _, migration := range m.migrations {
+		if record, ok := applied[migration.Version]; ok && record.checksum != migration.Checksum {
+			return fmt.Errorf("%w: %04d_%s", ErrMigrationChecksum, migration.Version, migration.Name)
+		}
+	}
+	return nil
+}
+
+// inTx runs fn in a transaction on conn, committing only if it succeeds
+// on SQLite conn is already in the transaction taken by locked, so fn runs in a savepoint instead
+func (m *Migrator) inTx(ctx context.Context, conn *sql.Conn, fn func(tx querier) error) error {
+	if m.dialect == DriverSQLite {
+		if _, err := conn.ExecContext(ctx, "SAVEPOINT migration"); err != nil {
+			return err
+		}
+		if err := fn(conn); err != nil {
+			conn.ExecContext(ctx, "ROLLBACK TO migration")
+			conn.ExecContext(ctx, "RELEASE migration")
+			return err
+		}
+		_, err := conn.ExecContext(ctx, "RELEASE migration")
+		return err
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// rebind rewrites ? placeholders into the numbered form Postgres expects
+func (m *Migrator) rebind(query string) string {
+	if m.dialect != DriverPostgres {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// loadMigrations reads and orders the embedded migrations of a dialect
+func loadMigrations(dialect string) ([]Migration, error) {
+	dir := path.Join("migrations", dialect)
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, fmt.Errorf("no migrations for dialect %q: %w", dialect, err)
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		name, direction, ok := strings.Cut(strings.TrimSuffix(entry.Name(), ".sql"), ".")
+		if !ok || entry.IsDir() || !strings.HasSuffix(entry.Name(), ".sql") {
+			return nil, fmt.Errorf("unexpected migration file %s", entry.Name())
+		}
+		versionStr, label, _ := strings.Cut(name, "_")
+		version, err := strconv.Atoi(versionStr)
+		if err != nil {
+			return nil, fmt.Errorf("migration file %s does not start with a version: %w", entry.Name(), err)
+		}
+
+		content, err := fs.ReadFile(migrationFiles, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: label}
+			byVersion[version] = migration
+		} else if migration.Name != label {
+			return nil, fmt.Errorf("migration version %d is used by both %s and %s", version, migration.Name, label)
+		}
+		switch direction {
+		case "up":
+			sum := sha256.Sum256(content)
+			migration.Up = string(content)
+			migration.Checksum = hex.EncodeToString(sum[:])
+		case "down":
+			migration.Down = string(content)
+		default:
+			return nil, fmt.Errorf("migration file %s is neither up nor down", entry.Name())
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" {
+			return nil, fmt.Errorf("migration %04d_%s has no up script", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// checkDuplicateEmails lists the addresses used by more than one account once canonicalized
+// they have to be merged or removed by hand, the migration cannot tell which account to keep
+func (m *Migrator) checkDuplicateEmails(ctx context.Context, tx querier) error {
+	rows, err := tx.QueryContext(ctx, "SELECT lower(trim(email)), COUNT(*) FROM users GROUP BY lower(trim(email)) HAVING COUNT(*) > 1 ORDER BY 1")
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	defer rows.Close()
+
+	var duplicates []string
+	for rows.Next() {
+		var email string
+		var count int
+		if err := rows.Scan(&email, &count); err != nil {
+			return fmt.Errorf("scan error: %w", err)
+		}
+		duplicates = append(duplicates, fmt.Sprintf("%s (%d accounts)", email, count))
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(duplicates) > 0 {
+		return fmt.Errorf("%w, merge or delete them before migrating: %s", ErrDuplicateEmails, strings.Join(duplicates, ", "))
+	}
+	return nil
+}
+
+// backfillEmailKeys sets the email_key of every account to models.EmailKey of its address
+// it fails with ErrDuplicateEmails when addresses the databases kept apart fold to the same key,
+// such as ones differing only in the case of a non-ASCII letter on SQLite
+func (m *Migrator) backfillEmailKeys(ctx context.Context, tx querier) error {
+	rows, err := tx.QueryContext(ctx, "SELECT id, email FROM users ORDER BY email")
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	defer rows.Close()
+
+	keys := map[string]string{}
+	accounts := map[string][]string{}
+	for rows.Next() {
+		var id, email string
+		if err := rows.Scan(&id, &email); err != nil {
+			return fmt.Errorf("scan error: %w", err)
+		}
+		key := models.EmailKey(email)
+		keys[id] = key
+		accounts[key] = append(accounts[key], email)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	var duplicates []string
+	for _, emails := range accounts {
+		if len(emails) > 1 {
+			duplicates = append(duplicates, strings.Join(emails, " and "))
+		}
+	}
+	if len(duplicates) > 0 {
+		sort.Strings(duplicates)
+		return fmt.Errorf("%w, merge or delete them before migrating: %s", ErrDuplicateEmails, strings.Join(duplicates, ", "))
+	}
+
+	for id, key := range keys {
+		if _, err := tx.ExecContext(ctx, m.rebind("UPDATE users SET email_key = ? WHERE id = ?"), key, id); err != nil {
+			return fmt.Errorf("database error: %w", err)
+		}
+	}
+	return nil
+}
+
+// adoptLegacySQLite adds the columns a users table created by earlier releases may lack,
+// so the baseline migration describes it. Databases already under version control are left alone.
+func adoptLegacySQLite(ctx context.Context, db querier) error {
+	var legacy bool
+	err := db.QueryRowContext(ctx, `SELECT
+		EXISTS (SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'users')
+		AND NOT EXISTS (SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'schema_migrations')`).Scan(&legacy)
+	if err != nil || !legacy {
+		return err
+	}
+
+	if err := addColumnIfMissing(ctx, db, "users", "locked_until", "TEXT"); err != nil {
+		return err
+	}
+	return addColumnIfMissing(ctx, db, "users", "role", "TEXT NOT NULL DEFAULT 'user'")
+}
+
+// addColumnIfMissing adds a column to an existing SQLite table that was created before the column existed
+func addColumnIfMissing(ctx context.Context, db querier, table, column, definition string) error {
+	rows, err := db.QueryContext(ctx, fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	_, err = db.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
@@ -0,0 +1,212 @@
+package db_test
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"joshuamURD/go-auth-api/pkgs/db"
+	"joshuamURD/go-auth-api/pkgs/db/dbtest"
+	"joshuamURD/go-auth-api/pkgs/models"
+)
+
+func TestSQLiteMigrations(t *testing.T) {
+	conn, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to open sqlite: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	testMigrations(t, conn, db.DriverSQLite)
+}
+
+func TestPostgresMigrations(t *testing.T) {
+	conn, err := sql.Open("pgx", dbtest.PostgresDSN(t))
+	if err != nil {
+		t.Fatalf("failed to open postgres: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	testMigrations(t, conn, db.DriverPostgres)
+}
+
+func TestSQLiteConcurrentMigrations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	testConcurrentMigrations(t, db.DriverSQLite, func() (*sql.DB, error) { return sql.Open("sqlite", path) })
+}
+
+func TestPostgresConcurrentMigrations(t *testing.T) {
+	dsn := dbtest.PostgresDSN(t)
+	testConcurrentMigrations(t, db.DriverPostgres, func() (*sql.DB, error) { return sql.Open("pgx", dsn) })
+}
+
+// testConcurrentMigrations runs Up from several instances of the server at once against an empty database
+// exactly one of them applies each migration, the others wait for it and find nothing left to do
+func testConcurrentMigrations(t *testing.T, dialect string, open func() (*sql.DB, error)) {
+	const instances = 4
+	migrators := make([]*db.Migrator, instances)
+	for i := range migrators {
+		conn, err := open()
+		if err != nil {
+			t.Fatalf("failed to open database: %v", err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		if migrators[i], err = db.NewMigrator(conn, dialect); err != nil {
+			t.Fatalf("NewMigrator: %v", err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	applied := make([]int, instances)
+	errs := make([]error, instances)
+	for i, migrator := range migrators {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			done, err := migrator.Up()
+			applied[i], errs[i] = len(done), err
+		}()
+	}
+	wg.Wait()
+
+	statuses, err := migrators[0].Status()
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	total := 0
+	for i := range migrators {
+		if errs[i] != nil {
+			t.Errorf("Up of instance %d: %v", i, errs[i])
+		}
+		total += applied[i]
+	}
+	if total != len(statuses) {
+		t.Errorf("the instances applied %d migrations in total, want %d", total, len(statuses))
+	}
+	assertAllApplied(t, migrators[0])
+}
+
+// testMigrations applies every migration of the dialect to an empty database, rolls them all back
+// and applies them again, which fails if a down script leaves anything behind that its up script creates
+func testMigrations(t *testing.T, conn *sql.DB, dialect string) {
+	migrator, err := db.NewMigrator(conn, dialect)
+	if err != nil {
+		t.Fatalf("NewMigrator: %v", err)
+	}
+	statuses, err := migrator.Status()
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	if len(statuses) == 0 {
+		t.Fatal("no migrations shipped")
+	}
+	for _, status := range statuses {
+		if status.AppliedAt != nil {
+			t.Errorf("migration %04d_%s is applied to an empty database", status.Version, status.Name)
+		}
+	}
+
+	applied, err := migrator.Up()
+	if err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+	if len(applied) != len(statuses) {
+		t.Errorf("Up applied %d migrations, want %d", len(applied), len(statuses))
+	}
+	assertAllApplied(t, migrator)
+
+	if again, err := migrator.Up(); err != nil || len(again) != 0 {
+		t.Errorf("second Up applied %d migrations with error %v, want none", len(again), err)
+	}
+
+	rolledBack, err := migrator.Down(len(statuses))
+	if err != nil {
+		t.Fatalf("Down: %v", err)
+	}
+	if len(rolledBack) != len(statuses) {
+		t.Errorf("Down rolled back %d migrations, want %d", len(rolledBack), len(statuses))
+	}
+	statuses, err = migrator.Status()
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	for _, status := range statuses {
+		if status.AppliedAt != nil {
+			t.Errorf("migration %04d_%s is still applied after rolling back", status.Version, status.Name)
+		}
+	}
+
+	if _, err := migrator.Up(); err != nil {
+		t.Fatalf("Up after Down: %v", err)
+	}
+	assertAllApplied(t, migrator)
+}
+
+func assertAllApplied(t *testing.T, migrator *db.Migrator) {
+	t.Helper()
+	statuses, err := migrator.Status()
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	for _, status := range statuses {
+		if status.AppliedAt == nil || status.Modified {
+			t.Errorf("migration %04d_%s: applied %v, modified %v, want applied and unmodified", status.Version, status.Name, status.AppliedAt, status.Modified)
+		}
+	}
+}
+
+// TestSQLiteEmailKeyBackfill migrates accounts created under the COLLATE NOCASE index, which let two
+// addresses differing only in the case of a non-ASCII letter in, and refuses to index them until one goes
+func TestSQLiteEmailKeyBackfill(t *testing.T) {
+	conn, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to open sqlite: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	migrator, err := db.NewMigrator(conn, db.DriverSQLite)
+	if err != nil {
+		t.Fatalf("NewMigrator: %v", err)
+	}
+	if _, err := migrator.Up(); err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+	if _, err := migrator.Down(2); err != nil {
+		t.Fatalf("Down: %v", err)
+	}
+
+	for id, email := range map[string]string{"1": "Émile@example.com", "2": "émile@example.com", "3": "bob@example.com"} {
+		_, err := conn.Exec("INSERT INTO users (id, email, verified, failed_attempts, locked, hashed_password, created_at, updated_at) VALUES (?, ?, 0, 0, 0, '', '', '')", id, email)
+		if err != nil {
+			t.Fatalf("failed to insert %s: %v", email, err)
+		}
+	}
+	if _, err := migrator.Up(); !errors.Is(err, db.ErrDuplicateEmails) {
+		t.Fatalf("Up with accounts sharing an email key returned %v, want ErrDuplicateEmails", err)
+	}
+
+	if _, err := conn.Exec("DELETE FROM users WHERE id = '2'"); err != nil {
+		t.Fatalf("failed to delete the duplicate: %v", err)
+	}
+	if _, err := migrator.Up(); err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+	assertAllApplied(t, migrator)
+
+	rows, err := conn.Query("SELECT email, email_key FROM users")
+	if err != nil {
+		t.Fatalf("failed to read the email keys: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var email, key string
+		if err := rows.Scan(&email, &key); err != nil {
+			t.Fatalf("scan error: %v", err)
+		}
+		if key != models.EmailKey(email) {
+			t.Errorf("email_key of %s = %q, want %q", email, key, models.EmailKey(email))
+		}
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,5 @@
+-- Stored addresses are canonicalized the way Register now does it: trimmed, with a lowercase domain.
+UPDATE users SET email = trim(email) WHERE email <> trim(email);
+UPDATE users SET email = substring(email FROM 1 FOR position('@' IN email)) || lower(substring(email FROM position('@' IN email) + 1))
+WHERE position('@' IN email) > 0;
+CREATE UNIQUE INDEX idx_users_email_lower ON users (lower(email));
@@ -0,0 +1,14 @@
+CREATE TABLE totp_enrollments (
+	user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+	secret TEXT NOT NULL,
+	confirmed_at TIMESTAMP,
+	last_used_step BIGINT NOT NULL DEFAULT 0,
+	created_at TIMESTAMP NOT NULL
+);
+CREATE TABLE recovery_codes (
+	code_hash TEXT PRIMARY KEY,
+	user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	created_at TIMESTAMP NOT NULL,
+	used_at TIMESTAMP
+);
+CREATE INDEX idx_recovery_codes_user_id ON recovery_codes(user_id);
@@ -0,0 +1,16 @@
+CREATE TABLE webauthn_credentials (
+	id BYTEA PRIMARY KEY,
+	user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	public_key BYTEA NOT NULL,
+	sign_count BIGINT NOT NULL DEFAULT 0,
+	created_at TIMESTAMP NOT NULL,
+	last_used_at TIMESTAMP
+);
+CREATE INDEX idx_webauthn_credentials_user_id ON webauthn_credentials(user_id);
+CREATE TABLE webauthn_sessions (
+	challenge_hash TEXT PRIMARY KEY,
+	user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+	ceremony TEXT NOT NULL,
+	expires_at TIMESTAMP NOT NULL,
+	created_at TIMESTAMP NOT NULL
+);
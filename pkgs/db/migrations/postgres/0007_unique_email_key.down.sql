@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_users_email_key;
+ALTER TABLE users ALTER COLUMN email_key DROP NOT NULL;
+CREATE UNIQUE INDEX idx_users_email_lower ON users (lower(email));
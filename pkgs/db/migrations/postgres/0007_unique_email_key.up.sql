@@ -0,0 +1,5 @@
+-- email_key is filled in by the migrator with models.EmailKey, which folds case with the Unicode tables
+-- of Go rather than those of the database, so both databases match addresses the same way.
+DROP INDEX IF EXISTS idx_users_email_lower;
+ALTER TABLE users ALTER COLUMN email_key SET NOT NULL;
+CREATE UNIQUE INDEX idx_users_email_key ON users(email_key);
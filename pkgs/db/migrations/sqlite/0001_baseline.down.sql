@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS one_time_tokens;
+DROP TABLE IF EXISTS refresh_tokens;
+DROP TABLE IF EXISTS todos;
+DROP TABLE IF EXISTS users;
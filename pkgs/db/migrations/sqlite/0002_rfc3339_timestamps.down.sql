@@ -0,0 +1,2 @@
+-- The legacy format is still readable, so there is nothing to convert back.
+SELECT 1;
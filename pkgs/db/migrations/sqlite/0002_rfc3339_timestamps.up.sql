@@ -0,0 +1,13 @@
+-- Users created by the first releases stored timestamps in Go's default time format,
+-- e.g. "2025-01-23 10:11:12.123456 +0100 CET". They are rewritten as RFC3339, dropping
+-- the fractional seconds, so they sort and compare like every other timestamp.
+UPDATE users SET created_at =
+	substr(created_at, 1, 10) || 'T' || substr(created_at, 12, 8)
+	|| substr(substr(created_at, 20), instr(substr(created_at, 20), ' ') + 1, 3) || ':'
+	|| substr(substr(created_at, 20), instr(substr(created_at, 20), ' ') + 4, 2)
+WHERE created_at GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9] [0-9][0-9]:[0-9][0-9]:[0-9][0-9]*';
+UPDATE users SET updated_at =
+	substr(updated_at, 1, 10) || 'T' || substr(updated_at, 12, 8)
+	|| substr(substr(updated_at, 20), instr(substr(updated_at, 20), ' ') + 1, 3) || ':'
+	|| substr(substr(updated_at, 20), instr(substr(updated_at, 20), ' ') + 4, 2)
+WHERE updated_at GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9] [0-9][0-9]:[0-9][0-9]:[0-9][0-9]*';
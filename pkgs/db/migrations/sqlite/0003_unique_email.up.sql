@@ -0,0 +1,5 @@
+-- Stored addresses are canonicalized the way Register now does it: trimmed, with a lowercase domain.
+UPDATE users SET email = trim(email) WHERE email != trim(email);
+UPDATE users SET email = substr(email, 1, instr(email, '@')) || lower(substr(email, instr(email, '@') + 1))
+WHERE instr(email, '@') > 0;
+CREATE UNIQUE INDEX idx_users_email ON users(email COLLATE NOCASE);
@@ -0,0 +1,14 @@
+CREATE TABLE totp_enrollments (
+	user_id TEXT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+	secret TEXT NOT NULL,
+	confirmed_at TEXT,
+	last_used_step BIGINT NOT NULL DEFAULT 0,
+	created_at TEXT NOT NULL
+);
+CREATE TABLE recovery_codes (
+	code_hash TEXT PRIMARY KEY,
+	user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	created_at TEXT NOT NULL,
+	used_at TEXT
+);
+CREATE INDEX idx_recovery_codes_user_id ON recovery_codes(user_id);
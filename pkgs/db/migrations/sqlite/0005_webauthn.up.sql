@@ -0,0 +1,16 @@
+CREATE TABLE webauthn_credentials (
+	id BLOB PRIMARY KEY,
+	user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	public_key BLOB NOT NULL,
+	sign_count BIGINT NOT NULL DEFAULT 0,
+	created_at TEXT NOT NULL,
+	last_used_at TEXT
+);
+CREATE INDEX idx_webauthn_credentials_user_id ON webauthn_credentials(user_id);
+CREATE TABLE webauthn_sessions (
+	challenge_hash TEXT PRIMARY KEY,
+	user_id TEXT REFERENCES users(id) ON DELETE CASCADE,
+	ceremony TEXT NOT NULL,
+	expires_at TEXT NOT NULL,
+	created_at TEXT NOT NULL
+);
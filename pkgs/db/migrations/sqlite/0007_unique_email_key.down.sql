@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_users_email_key;
+CREATE UNIQUE INDEX idx_users_email ON users(email COLLATE NOCASE);
@@ -0,0 +1,4 @@
+-- email_key is filled in by the migrator with models.EmailKey, which folds case beyond ASCII
+-- unlike COLLATE NOCASE, so both databases match addresses the same way.
+DROP INDEX IF EXISTS idx_users_email;
+CREATE UNIQUE INDEX idx_users_email_key ON users(email_key);
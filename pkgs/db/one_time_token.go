@@ -0,0 +1,121 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"joshuamURD/go-auth-api/pkgs/models"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrOneTimeTokenInvalid is returned when a one-time token is unknown, expired, already used
+// or issued for a different purpose
+var ErrOneTimeTokenInvalid = newKindError(ErrNotFound, "invalid or expired token")
+
+// OneTimeTokenRepository is an interface that defines the methods for storing one-time tokens.
+type OneTimeTokenRepository interface {
+	Create(ctx context.Context, token models.OneTimeToken) error
+	// Consume marks a token as used and returns it. It fails with ErrOneTimeTokenInvalid
+	// unless the token exists, has the given purpose, is unused and has not expired.
+	Consume(ctx context.Context, hash string, purpose models.TokenPurpose) (models.OneTimeToken, error)
+	// InvalidateForUser marks every unused token of a user with the given purpose as used.
+	InvalidateForUser(ctx context.Context, userID uuid.UUID, purpose models.TokenPurpose) error
+}
+
+// SQLiteOneTimeTokenRepository implements OneTimeTokenRepository on top of a SQLite connection.
+type SQLiteOneTimeTokenRepository struct {
+	db *sql.DB
+	tx *sql.Tx
+}
+
+// NewSQLiteOneTimeTokenRepository creates a new SQLiteOneTimeTokenRepository sharing the connection
+// of an existing SQLiteRepository.
+func NewSQLiteOneTimeTokenRepository(repo *SQLiteRepository) *SQLiteOneTimeTokenRepository {
+	return &SQLiteOneTimeTokenRepository{db: repo.db, tx: repo.tx}
+}
+
+// Create inserts a new one-time token.
+func (d *SQLiteOneTimeTokenRepository) Create(ctx context.Context, token models.OneTimeToken) error {
+	_, err := d.conn().ExecContext(
+		ctx,
+		"INSERT INTO one_time_tokens (token_hash, user_id, purpose, expires_at, created_at) VALUES (?, ?, ?, ?, ?)",
+		token.Hash,
+		token.UserID,
+		token.Purpose,
+		token.ExpiresAt.UTC().Format(time.RFC3339),
+		token.CreatedAt.UTC().Format(time.RFC3339),
+	)
+	if err != nil {
+		return sqliteError("error creating one-time token", err)
+	}
+	return nil
+}
+
+// Consume marks a token as used in a single statement so it can only ever be redeemed once.
+func (d *SQLiteOneTimeTokenRepository) Consume(ctx context.Context, hash string, purpose models.TokenPurpose) (models.OneTimeToken, error) {
+	now := time.Now().UTC().Format(time.RFC3339)
+
+	var token models.OneTimeToken
+	var expiresAtStr, createdAtStr, usedAtStr string
+	err := d.conn().QueryRowContext(
+		ctx,
+		`UPDATE one_time_tokens SET used_at = ?1
+		WHERE token_hash = ?2 AND purpose = ?3 AND used_at IS NULL AND expires_at > ?1
+		RETURNING token_hash, user_id, purpose, expires_at, created_at, used_at`,
+		now,
+		hash,
+		purpose,
+	).Scan(
+		&token.Hash,
+		&token.UserID,
+		&token.Purpose,
+		&expiresAtStr,
+		&createdAtStr,
+		&usedAtStr,
+	)
+	if err == sql.ErrNoRows {
+		return token, ErrOneTimeTokenInvalid
+	}
+	if err != nil {
+		return token, sqliteError("database error", err)
+	}
+
+	if token.ExpiresAt, err = time.Parse(time.RFC3339, expiresAtStr); err != nil {
+		return token, fmt.Errorf("error parsing expires_at time: %w", err)
+	}
+	if token.CreatedAt, err = time.Parse(time.RFC3339, createdAtStr); err != nil {
+		return token, fmt.Errorf("error parsing created_at time: %w", err)
+	}
+	usedAt, err := time.Parse(time.RFC3339, usedAtStr)
+	if err != nil {
+		return token, fmt.Errorf("error parsing used_at time: %w", err)
+	}
+	token.UsedAt = &usedAt
+
+	return token, nil
+}
+
+// InvalidateForUser marks every unused token of a user with the given purpose as used.
+func (d *SQLiteOneTimeTokenRepository) InvalidateForUser(ctx context.Context, userID uuid.UUID, purpose models.TokenPurpose) error {
+	_, err := d.conn().ExecContext(
+		ctx,
+		"UPDATE one_time_tokens SET used_at = ? WHERE user_id = ? AND purpose = ? AND used_at IS NULL",
+		time.Now().UTC().Format(time.RFC3339),
+		userID,
+		purpose,
+	)
+	if err != nil {
+		return sqliteError("error invalidating one-time tokens", err)
+	}
+	return nil
+}
+
+// conn returns the transaction the repository is bound to, or the connection pool
+func (d *SQLiteOneTimeTokenRepository) conn() querier {
+	if d.tx != nil {
+		return d.tx
+	}
+	return d.db
+}
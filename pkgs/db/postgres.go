@@ -1,22 +1,430 @@
 package db
 
-import "database/sql"
-
-// PostgresTableCreator implements TableCreator for PostgreSQL
-type PostgresTableCreator struct{}
-
-func (p PostgresTableCreator) CreateTable(db *sql.DB) error {
-	query := `
-    CREATE TABLE IF NOT EXISTS users (
-        id UUID PRIMARY KEY,
-        email TEXT NOT NULL UNIQUE,
-        verified BOOLEAN NOT NULL,
-        failed_attempts INTEGER NOT NULL,
-        locked BOOLEAN NOT NULL,
-        hashed_password TEXT NOT NULL,
-        created_at TIMESTAMP NOT NULL,
-        updated_at TIMESTAMP NOT NULL
-    );`
-	_, err := db.Exec(query)
-	return err
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"joshuamURD/go-auth-api/pkgs/models"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
+	_ "github.com/jackc/pgx/v5/stdlib" // Registers the "pgx" database/sql driver
+)
+
+// PostgresRepository implements Database on top of a PostgreSQL connection.
+// IDs are stored as native UUIDs and timestamps as TIMESTAMP values in UTC.
+// Inside WithTx it is bound to the transaction and every statement runs in it.
+type PostgresRepository struct {
+	db *sql.DB
+	tx *sql.Tx
+}
+
+// NewPostgresRepository connects to the PostgreSQL database described by dsn.
+// The schema is managed separately by a Migrator.
+func NewPostgresRepository(dsn string) (*PostgresRepository, error) {
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	// Configure connection pool
+	db.SetMaxOpenConns(25)                 // Limit max open connections
+	db.SetMaxIdleConns(25)                 // Set max idle connections
+	db.SetConnMaxLifetime(5 * time.Minute) // Set max lifetime for connections
+
+	// Test the connection
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to ping database: %w", err)
+	}
+
+	return &PostgresRepository{db: db}, nil
+}
+
+// Close closes the database connection
+func (d *PostgresRepository) Close() error {
+	return d.db.Close()
+}
+
+// GetAll retrieves all users from the database.
+func (d *PostgresRepository) GetAll(ctx context.Context) ([]models.User, error) {
+	rows, err := d.conn().QueryContext(ctx, "SELECT "+userColumns+" FROM users")
+	if err != nil {
+		return nil, postgresError("database error", err)
+	}
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		user, err := scanPostgresUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+
+	return users, rows.Err()
+}
+
+// Create inserts a new user into the database.
+// PostgreSQL has no rowid, so the returned number is always zero.
+func (d *PostgresRepository) Create(ctx context.Context, user models.User) (int, error) {
+	_, err := d.conn().ExecContext(
+		ctx,
+		"INSERT INTO users (id, email, email_key, verified, failed_attempts, locked, locked_until, hashed_password, role, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)",
+		user.ID,
+		user.Email,
+		models.EmailKey(user.Email),
+		user.Verified,
+		user.FailedAttempts,
+		user.Locked,
+		utcTime(user.LockedUntil),
+		user.HashedPassword,
+		roleOrDefault(user.Role),
+		user.CreatedAt.UTC(),
+		user.UpdatedAt.UTC(),
+	)
+	if isPostgresEmailViolation(err) {
+		return 0, ErrEmailTaken
+	}
+	if err != nil {
+		return 0, postgresError("error creating user", err)
+	}
+	return 0, nil
+}
+
+// GetByEmail retrieves a user by their email address, ignoring case as models.EmailKey does.
+func (d *PostgresRepository) GetByEmail(ctx context.Context, email string) (models.User, error) {
+	row := d.conn().QueryRowContext(ctx, "SELECT "+userColumns+" FROM users WHERE email_key = $1", models.EmailKey(email))
+	user, err := scanPostgresUser(row)
+	if err == sql.ErrNoRows {
+		return user, fmt.Errorf("%w with email: %s", ErrUserNotFound, email)
+	}
+	return user, err
+}
+
+// GetByID retrieves a user by their ID.
+func (d *PostgresRepository) GetByID(ctx context.Context, id uuid.UUID) (models.User, error) {
+	row := d.conn().QueryRowContext(ctx, "SELECT "+userColumns+" FROM users WHERE id = $1", id)
+	user, err := scanPostgresUser(row)
+	if err == sql.ErrNoRows {
+		return user, fmt.Errorf("%w with id: %s", ErrUserNotFound, id)
+	}
+	return user, err
+}
+
+// Update overwrites the stored fields of an existing user.
+func (d *PostgresRepository) Update(ctx context.Context, user models.User) error {
+	result, err := d.conn().ExecContext(
+		ctx,
+		"UPDATE users SET email = $1, email_key = $2, verified = $3, failed_attempts = $4, locked = $5, locked_until = $6, hashed_password = $7, role = $8, updated_at = $9 WHERE id = $10",
+		user.Email,
+		models.EmailKey(user.Email),
+		user.Verified,
+		user.FailedAttempts,
+		user.Locked,
+		utcTime(user.LockedUntil),
+		user.HashedPassword,
+		roleOrDefault(user.Role),
+		user.UpdatedAt.UTC(),
+		user.ID,
+	)
+	if isPostgresEmailViolation(err) {
+		return ErrEmailTaken
+	}
+	if err != nil {
+		return postgresError("error updating user", err)
+	}
+	return expectAffected(result, fmt.Errorf("%w with id: %s", ErrUserNotFound, user.ID))
+}
+
+// UpdatePasswordHash replaces the password hash of a user if it is still oldHash, see Database.
+func (d *PostgresRepository) UpdatePasswordHash(ctx context.Context, id uuid.UUID, oldHash, newHash string) error {
+	result, err := d.conn().ExecContext(
+		ctx,
+		"UPDATE users SET hashed_password = $1, updated_at = $2 WHERE id = $3 AND hashed_password = $4",
+		newHash,
+		time.Now().UTC(),
+		id,
+		oldHash,
+	)
+	if err != nil {
+		return postgresError("error updating password", err)
+	}
+	if err := expectAffected(result, ErrPasswordChanged); err != nil {
+		// A missing user is reported as such rather than as a changed password
+		if _, getErr := d.GetByID(ctx, id); getErr != nil {
+			return getErr
+		}
+		return err
+	}
+	return nil
+}
+
+// RecordFailedLogin atomically increments the failed attempt counter of a user
+// and locks the account for lockFor once maxAttempts is reached. A lock whose time
+// has passed is cleared first so the count starts again. A maxAttempts of zero never locks.
+// It returns the user as stored after the update.
+func (d *PostgresRepository) RecordFailedLogin(ctx context.Context, id uuid.UUID, maxAttempts int, lockFor time.Duration) (models.User, error) {
+	now := time.Now().UTC()
+
+	// Clear an expired lock so that the attempt counts from zero again
+	if _, err := d.conn().ExecContext(
+		ctx,
+		"UPDATE users SET locked = FALSE, locked_until = NULL, failed_attempts = 0 WHERE id = $1 AND locked AND locked_until IS NOT NULL AND locked_until <= $2",
+		id,
+		now,
+	); err != nil {
+		return models.User{}, postgresError("error clearing expired lock", err)
+	}
+
+	// The new values are computed from the current row in a single statement so concurrent
+	// failures cannot lose increments
+	row := d.conn().QueryRowContext(
+		ctx,
+		`UPDATE users SET
+			failed_attempts = failed_attempts + 1,
+			locked = CASE WHEN $1 > 0 AND failed_attempts + 1 >= $1 THEN TRUE ELSE locked END,
+			locked_until = CASE WHEN $1 > 0 AND failed_attempts + 1 >= $1 AND NOT locked THEN $2 ELSE locked_until END,
+			updated_at = $3
+		WHERE id = $4
+		RETURNING `+userColumns,
+		maxAttempts,
+		now.Add(lockFor),
+		now,
+		id,
+	)
+	user, err := scanPostgresUser(row)
+	if err == sql.ErrNoRows {
+		return user, fmt.Errorf("%w with id: %s", ErrUserNotFound, id)
+	}
+	return user, err
+}
+
+// ResetFailedLogins clears the failed attempt counter and any lock on a user.
+func (d *PostgresRepository) ResetFailedLogins(ctx context.Context, id uuid.UUID) error {
+	_, err := d.conn().ExecContext(
+		ctx,
+		"UPDATE users SET failed_attempts = 0, locked = FALSE, locked_until = NULL, updated_at = $1 WHERE id = $2",
+		time.Now().UTC(),
+		id,
+	)
+	if err != nil {
+		return postgresError("error resetting failed logins", err)
+	}
+	return nil
+}
+
+// Lock locks a user until an admin unlocks them, keeping their failed attempt counter.
+func (d *PostgresRepository) Lock(ctx context.Context, id uuid.UUID) error {
+	return d.updateColumns(ctx, id, "locked = TRUE, locked_until = NULL", "error locking user")
+}
+
+// MarkVerified marks the email of a user as verified.
+func (d *PostgresRepository) MarkVerified(ctx context.Context, id uuid.UUID) error {
+	return d.updateColumns(ctx, id, "verified = TRUE", "error verifying user")
+}
+
+// ClearFailedAttempts sets the failed attempt counter of a user to zero, keeping any lock.
+func (d *PostgresRepository) ClearFailedAttempts(ctx context.Context, id uuid.UUID) error {
+	return d.updateColumns(ctx, id, "failed_attempts = 0", "error clearing failed attempts")
+}
+
+// ClearLockout clears the failed attempts and a lockout of a user, but not a lock set by an admin.
+func (d *PostgresRepository) ClearLockout(ctx context.Context, id uuid.UUID) error {
+	// Every expression reads the row as it was before the update
+	return d.updateColumns(ctx, id, "failed_attempts = 0, locked = (locked AND locked_until IS NULL), locked_until = NULL", "error clearing lockout")
+}
+
+// SetRole writes the role of a user, refusing to demote the only admin, see Database.
+func (d *PostgresRepository) SetRole(ctx context.Context, id uuid.UUID, role models.Role) error {
+	return runInTx(ctx, d.db, d.tx, func(tx *sql.Tx) error {
+		if err := lockRoles(ctx, tx); err != nil {
+			return err
+		}
+		result, err := tx.ExecContext(
+			ctx,
+			`UPDATE users SET role = $1, updated_at = $2 WHERE id = $3
+			AND ($1 = $4 OR role != $4 OR EXISTS (SELECT 1 FROM users WHERE role = $4 AND id != $3))`,
+			role,
+			time.Now().UTC(),
+			id,
+			models.RoleAdmin,
+		)
+		if err != nil {
+			return postgresError("error setting role", err)
+		}
+		if err := expectAffected(result, ErrLastAdmin); err != nil {
+			if _, getErr := (&PostgresRepository{db: d.db, tx: tx}).GetByID(ctx, id); getErr != nil {
+				return getErr
+			}
+			return err
+		}
+		return nil
+	})
+}
+
+// PromoteFirstAdmin makes a user an admin while there is none, see Database.
+func (d *PostgresRepository) PromoteFirstAdmin(ctx context.Context, id uuid.UUID) error {
+	return runInTx(ctx, d.db, d.tx, func(tx *sql.Tx) error {
+		if err := lockRoles(ctx, tx); err != nil {
+			return err
+		}
+		result, err := tx.ExecContext(
+			ctx,
+			"UPDATE users SET role = $1, updated_at = $2 WHERE id = $3 AND NOT EXISTS (SELECT 1 FROM users WHERE role = $1)",
+			models.RoleAdmin,
+			time.Now().UTC(),
+			id,
+		)
+		if err != nil {
+			return postgresError("error promoting admin", err)
+		}
+		if err := expectAffected(result, ErrAdminExists); err != nil {
+			if _, getErr := (&PostgresRepository{db: d.db, tx: tx}).GetByID(ctx, id); getErr != nil {
+				return getErr
+			}
+			return err
+		}
+		return nil
+	})
+}
+
+// roleLockKey identifies the transaction advisory lock every role change takes
+const roleLockKey int64 = 0x726f6c6573 // "roles"
+
+// lockRoles serializes the role changes of concurrent transactions until tx ends
+// under READ COMMITTED each statement sees the rows committed before it started, so without the lock two
+// transactions could both find another admin and each demote one of the last two
+func lockRoles(ctx context.Context, tx *sql.Tx) error {
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", roleLockKey); err != nil {
+		return postgresError("error locking roles", err)
+	}
+	return nil
+}
+
+// updateColumns applies the SET clause assignments to a user and bumps updated_at
+func (d *PostgresRepository) updateColumns(ctx context.Context, id uuid.UUID, assignments string, msg string) error {
+	result, err := d.conn().ExecContext(ctx, "UPDATE users SET "+assignments+", updated_at = $1 WHERE id = $2", time.Now().UTC(), id)
+	if err != nil {
+		return postgresError(msg, err)
+	}
+	return expectAffected(result, fmt.Errorf("%w with id: %s", ErrUserNotFound, id))
+}
+
+// List retrieves a page of users matching the filter, ordered by creation time,
+// along with the total number of matching users.
+func (d *PostgresRepository) List(ctx context.Context, filter UserFilter) ([]models.User, int, error) {
+	where := "WHERE TRUE"
+	var args []any
+	if filter.Email != "" {
+		args = append(args, "%"+escapeLike(models.EmailKey(filter.Email))+"%")
+		where += fmt.Sprintf(" AND email_key LIKE $%d", len(args))
+	}
+	if filter.Verified != nil {
+		args = append(args, *filter.Verified)
+		where += fmt.Sprintf(" AND verified = $%d", len(args))
+	}
+	if filter.Locked != nil {
+		args = append(args, *filter.Locked)
+		where += fmt.Sprintf(" AND locked = $%d", len(args))
+	}
+
+	var total int
+	if err := d.conn().QueryRowContext(ctx, "SELECT COUNT(*) FROM users "+where, args...).Scan(&total); err != nil {
+		return nil, 0, postgresError("database error", err)
+	}
+
+	// A NULL limit means no limit
+	var limit any
+	if filter.Limit > 0 {
+		limit = filter.Limit
+	}
+	query := fmt.Sprintf("SELECT %s FROM users %s ORDER BY created_at, id LIMIT $%d OFFSET $%d", userColumns, where, len(args)+1, len(args)+2)
+	rows, err := d.conn().QueryContext(ctx, query, append(args, limit, filter.Offset)...)
+	if err != nil {
+		return nil, 0, postgresError("database error", err)
+	}
+	defer rows.Close()
+
+	users := []models.User{}
+	for rows.Next() {
+		user, err := scanPostgresUser(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		users = append(users, user)
+	}
+
+	return users, total, rows.Err()
+}
+
+// Delete removes a user, their todos and tokens are removed by the ON DELETE CASCADE constraints.
+func (d *PostgresRepository) Delete(ctx context.Context, id uuid.UUID) error {
+	result, err := d.conn().ExecContext(ctx, "DELETE FROM users WHERE id = $1", id)
+	if err != nil {
+		return postgresError("error deleting user", err)
+	}
+	return expectAffected(result, fmt.Errorf("%w with id: %s", ErrUserNotFound, id))
+}
+
+// WithTx runs fn in a transaction, see Database.
+func (d *PostgresRepository) WithTx(ctx context.Context, fn func(tx Database) error) error {
+	return runInTx(ctx, d.db, d.tx, func(tx *sql.Tx) error {
+		return fn(&PostgresRepository{db: d.db, tx: tx})
+	})
+}
+
+// conn returns the transaction the repository is bound to, or the connection pool
+func (d *PostgresRepository) conn() querier {
+	if d.tx != nil {
+		return d.tx
+	}
+	return d.db
+}
+
+// scanPostgresUser reads a user from a row selected with userColumns
+// sql.ErrNoRows is returned unwrapped so callers can report the missing user
+func scanPostgresUser(row rowScanner) (models.User, error) {
+	var user models.User
+	var lockedUntil sql.NullTime
+
+	err := row.Scan(
+		&user.ID,
+		&user.Email,
+		&user.Verified,
+		&user.FailedAttempts,
+		&user.Locked,
+		&lockedUntil,
+		&user.HashedPassword,
+		&user.Role,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return user, err
+	}
+	if err != nil {
+		return user, postgresError("database error", err)
+	}
+
+	user.LockedUntil = nullTimePtr(lockedUntil)
+	return user, nil
+}
+
+// isPostgresEmailViolation reports whether err comes from one of the unique constraints on users.email
+func isPostgresEmailViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505" && strings.Contains(pgErr.ConstraintName, "email")
+}
+
+// nullTimePtr converts a nullable timestamp to an optional time
+func nullTimePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
 }
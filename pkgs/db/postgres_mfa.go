@@ -0,0 +1,134 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"joshuamURD/go-auth-api/pkgs/models"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// PostgresMFARepository implements MFARepository on top of a PostgreSQL connection.
+type PostgresMFARepository struct {
+	db *sql.DB
+	tx *sql.Tx
+}
+
+// NewPostgresMFARepository creates a new PostgresMFARepository sharing the connection
+// of an existing PostgresRepository.
+func NewPostgresMFARepository(repo *PostgresRepository) *PostgresMFARepository {
+	return &PostgresMFARepository{db: repo.db, tx: repo.tx}
+}
+
+// SaveTOTP stores an unconfirmed enrollment, the upsert leaves a confirmed one untouched.
+func (d *PostgresMFARepository) SaveTOTP(ctx context.Context, enrollment models.TOTPEnrollment) error {
+	result, err := d.conn().ExecContext(
+		ctx,
+		`INSERT INTO totp_enrollments (user_id, secret, last_used_step, created_at) VALUES ($1, $2, 0, $3)
+		ON CONFLICT (user_id) DO UPDATE SET secret = excluded.secret, last_used_step = 0, created_at = excluded.created_at
+		WHERE totp_enrollments.confirmed_at IS NULL`,
+		enrollment.UserID,
+		enrollment.Secret,
+		enrollment.CreatedAt.UTC(),
+	)
+	if err != nil {
+		return postgresError("error saving authenticator", err)
+	}
+	return expectAffected(result, ErrTOTPAlreadyEnabled)
+}
+
+// GetTOTP retrieves the enrollment of a user.
+func (d *PostgresMFARepository) GetTOTP(ctx context.Context, userID uuid.UUID) (models.TOTPEnrollment, error) {
+	var enrollment models.TOTPEnrollment
+	var confirmedAt sql.NullTime
+
+	err := d.conn().QueryRowContext(
+		ctx,
+		"SELECT user_id, secret, confirmed_at, last_used_step, created_at FROM totp_enrollments WHERE user_id = $1",
+		userID,
+	).Scan(
+		&enrollment.UserID,
+		&enrollment.Secret,
+		&confirmedAt,
+		&enrollment.LastUsedStep,
+		&enrollment.CreatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return enrollment, ErrTOTPNotFound
+	}
+	if err != nil {
+		return enrollment, postgresError("database error", err)
+	}
+	enrollment.ConfirmedAt = nullTimePtr(confirmedAt)
+
+	return enrollment, nil
+}
+
+// ConfirmTOTP enables a pending enrollment.
+func (d *PostgresMFARepository) ConfirmTOTP(ctx context.Context, userID uuid.UUID, step int64) error {
+	result, err := d.conn().ExecContext(
+		ctx,
+		"UPDATE totp_enrollments SET confirmed_at = $1, last_used_step = $2 WHERE user_id = $3 AND confirmed_at IS NULL",
+		time.Now().UTC(),
+		step,
+		userID,
+	)
+	if err != nil {
+		return postgresError("error confirming authenticator", err)
+	}
+	return expectAffected(result, ErrTOTPNotFound)
+}
+
+// UseTOTPStep records the step of a code in a single statement so concurrent logins cannot both use it.
+func (d *PostgresMFARepository) UseTOTPStep(ctx context.Context, userID uuid.UUID, step int64) error {
+	result, err := d.conn().ExecContext(
+		ctx,
+		"UPDATE totp_enrollments SET last_used_step = $1 WHERE user_id = $2 AND confirmed_at IS NOT NULL AND last_used_step < $1",
+		step,
+		userID,
+	)
+	if err != nil {
+		return postgresError("error recording authenticator code", err)
+	}
+	return expectAffected(result, ErrTOTPStepUsed)
+}
+
+// ReplaceRecoveryCodes replaces every recovery code of a user in one transaction.
+func (d *PostgresMFARepository) ReplaceRecoveryCodes(ctx context.Context, userID uuid.UUID, hashes []string) error {
+	return runInTx(ctx, d.db, d.tx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id = $1", userID); err != nil {
+			return postgresError("error deleting recovery codes", err)
+		}
+		now := time.Now().UTC()
+		for _, hash := range hashes {
+			if _, err := tx.ExecContext(ctx, "INSERT INTO recovery_codes (code_hash, user_id, created_at) VALUES ($1, $2, $3)", hash, userID, now); err != nil {
+				return postgresError("error creating recovery code", err)
+			}
+		}
+		return nil
+	})
+}
+
+// UseRecoveryCode marks a recovery code as used in a single statement so it can only ever be used once.
+func (d *PostgresMFARepository) UseRecoveryCode(ctx context.Context, userID uuid.UUID, hash string) error {
+	result, err := d.conn().ExecContext(
+		ctx,
+		"UPDATE recovery_codes SET used_at = $1 WHERE code_hash = $2 AND user_id = $3 AND used_at IS NULL",
+		time.Now().UTC(),
+		hash,
+		userID,
+	)
+	if err != nil {
+		return postgresError("error using recovery code", err)
+	}
+	return expectAffected(result, ErrRecoveryCodeInvalid)
+}
+
+// conn returns the transaction the repository is bound to, or the connection pool
+func (d *PostgresMFARepository) conn() querier {
+	if d.tx != nil {
+		return d.tx
+	}
+	return d.db
+}
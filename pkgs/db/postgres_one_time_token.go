@@ -0,0 +1,93 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"joshuamURD/go-auth-api/pkgs/models"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// PostgresOneTimeTokenRepository implements OneTimeTokenRepository on top of a PostgreSQL connection.
+type PostgresOneTimeTokenRepository struct {
+	db *sql.DB
+	tx *sql.Tx
+}
+
+// NewPostgresOneTimeTokenRepository creates a new PostgresOneTimeTokenRepository sharing the connection
+// of an existing PostgresRepository.
+func NewPostgresOneTimeTokenRepository(repo *PostgresRepository) *PostgresOneTimeTokenRepository {
+	return &PostgresOneTimeTokenRepository{db: repo.db, tx: repo.tx}
+}
+
+// Create inserts a new one-time token.
+func (d *PostgresOneTimeTokenRepository) Create(ctx context.Context, token models.OneTimeToken) error {
+	_, err := d.conn().ExecContext(
+		ctx,
+		"INSERT INTO one_time_tokens (token_hash, user_id, purpose, expires_at, created_at) VALUES ($1, $2, $3, $4, $5)",
+		token.Hash,
+		token.UserID,
+		token.Purpose,
+		token.ExpiresAt.UTC(),
+		token.CreatedAt.UTC(),
+	)
+	if err != nil {
+		return postgresError("error creating one-time token", err)
+	}
+	return nil
+}
+
+// Consume marks a token as used in a single statement so it can only ever be redeemed once.
+func (d *PostgresOneTimeTokenRepository) Consume(ctx context.Context, hash string, purpose models.TokenPurpose) (models.OneTimeToken, error) {
+	var token models.OneTimeToken
+	var usedAt time.Time
+	err := d.conn().QueryRowContext(
+		ctx,
+		`UPDATE one_time_tokens SET used_at = $1
+		WHERE token_hash = $2 AND purpose = $3 AND used_at IS NULL AND expires_at > $1
+		RETURNING token_hash, user_id, purpose, expires_at, created_at, used_at`,
+		time.Now().UTC(),
+		hash,
+		purpose,
+	).Scan(
+		&token.Hash,
+		&token.UserID,
+		&token.Purpose,
+		&token.ExpiresAt,
+		&token.CreatedAt,
+		&usedAt,
+	)
+	if err == sql.ErrNoRows {
+		return token, ErrOneTimeTokenInvalid
+	}
+	if err != nil {
+		return token, postgresError("database error", err)
+	}
+	token.UsedAt = &usedAt
+
+	return token, nil
+}
+
+// InvalidateForUser marks every unused token of a user with the given purpose as used.
+func (d *PostgresOneTimeTokenRepository) InvalidateForUser(ctx context.Context, userID uuid.UUID, purpose models.TokenPurpose) error {
+	_, err := d.conn().ExecContext(
+		ctx,
+		"UPDATE one_time_tokens SET used_at = $1 WHERE user_id = $2 AND purpose = $3 AND used_at IS NULL",
+		time.Now().UTC(),
+		userID,
+		purpose,
+	)
+	if err != nil {
+		return postgresError("error invalidating one-time tokens", err)
+	}
+	return nil
+}
+
+// conn returns the transaction the repository is bound to, or the connection pool
+func (d *PostgresOneTimeTokenRepository) conn() querier {
+	if d.tx != nil {
+		return d.tx
+	}
+	return d.db
+}
@@ -0,0 +1,121 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"joshuamURD/go-auth-api/pkgs/models"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// PostgresRefreshTokenRepository implements RefreshTokenRepository on top of a PostgreSQL connection.
+type PostgresRefreshTokenRepository struct {
+	db *sql.DB
+	tx *sql.Tx
+}
+
+// NewPostgresRefreshTokenRepository creates a new PostgresRefreshTokenRepository sharing the connection
+// of an existing PostgresRepository.
+func NewPostgresRefreshTokenRepository(repo *PostgresRepository) *PostgresRefreshTokenRepository {
+	return &PostgresRefreshTokenRepository{db: repo.db, tx: repo.tx}
+}
+
+// Create inserts a newly issued refresh token.
+func (d *PostgresRefreshTokenRepository) Create(ctx context.Context, token models.RefreshToken) error {
+	_, err := d.conn().ExecContext(
+		ctx,
+		"INSERT INTO refresh_tokens (id, family_id, user_id, expires_at, created_at) VALUES ($1, $2, $3, $4, $5)",
+		token.ID,
+		token.FamilyID,
+		token.UserID,
+		token.ExpiresAt.UTC(),
+		token.CreatedAt.UTC(),
+	)
+	if err != nil {
+		return postgresError("error creating refresh token", err)
+	}
+	return nil
+}
+
+// GetByID retrieves a refresh token by its jti.
+func (d *PostgresRefreshTokenRepository) GetByID(ctx context.Context, id string) (models.RefreshToken, error) {
+	var token models.RefreshToken
+	var revokedAt sql.NullTime
+	var replacedBy sql.NullString
+
+	row := d.conn().QueryRowContext(ctx, "SELECT id, family_id, user_id, expires_at, created_at, revoked_at, replaced_by FROM refresh_tokens WHERE id = $1", id)
+	err := row.Scan(
+		&token.ID,
+		&token.FamilyID,
+		&token.UserID,
+		&token.ExpiresAt,
+		&token.CreatedAt,
+		&revokedAt,
+		&replacedBy,
+	)
+	if err == sql.ErrNoRows {
+		return token, ErrRefreshTokenNotFound
+	}
+	if err != nil {
+		return token, postgresError("database error", err)
+	}
+
+	token.RevokedAt = nullTimePtr(revokedAt)
+	token.ReplacedBy = replacedBy.String
+
+	return token, nil
+}
+
+// Revoke marks a token as used. The update only matches tokens that are still active,
+// so two concurrent refreshes with the same token cannot both succeed.
+func (d *PostgresRefreshTokenRepository) Revoke(ctx context.Context, id string, replacedBy string) error {
+	result, err := d.conn().ExecContext(
+		ctx,
+		"UPDATE refresh_tokens SET revoked_at = $1, replaced_by = $2 WHERE id = $3 AND revoked_at IS NULL",
+		time.Now().UTC(),
+		sql.NullString{String: replacedBy, Valid: replacedBy != ""},
+		id,
+	)
+	if err != nil {
+		return postgresError("error revoking refresh token", err)
+	}
+	return expectAffected(result, ErrRefreshTokenRevoked)
+}
+
+// RevokeFamily revokes every active token in a family.
+func (d *PostgresRefreshTokenRepository) RevokeFamily(ctx context.Context, familyID string) error {
+	_, err := d.conn().ExecContext(
+		ctx,
+		"UPDATE refresh_tokens SET revoked_at = $1 WHERE family_id = $2 AND revoked_at IS NULL",
+		time.Now().UTC(),
+		familyID,
+	)
+	if err != nil {
+		return postgresError("error revoking refresh token family", err)
+	}
+	return nil
+}
+
+// RevokeAllForUser revokes every active token belonging to a user.
+func (d *PostgresRefreshTokenRepository) RevokeAllForUser(ctx context.Context, userID uuid.UUID) error {
+	_, err := d.conn().ExecContext(
+		ctx,
+		"UPDATE refresh_tokens SET revoked_at = $1 WHERE user_id = $2 AND revoked_at IS NULL",
+		time.Now().UTC(),
+		userID,
+	)
+	if err != nil {
+		return postgresError(fmt.Sprintf("error revoking refresh tokens for user %s", userID), err)
+	}
+	return nil
+}
+
+// conn returns the transaction the repository is bound to, or the connection pool
+func (d *PostgresRefreshTokenRepository) conn() querier {
+	if d.tx != nil {
+		return d.tx
+	}
+	return d.db
+}
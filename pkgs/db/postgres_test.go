@@ -0,0 +1,12 @@
+package db_test
+
+import (
+	"testing"
+
+	"joshuamURD/go-auth-api/pkgs/db/dbtest"
+)
+
+// TestPostgresConformance runs against the server in TEST_DATABASE_URL and is skipped without it
+func TestPostgresConformance(t *testing.T) {
+	dbtest.Run(t, dbtest.PostgresStore)
+}
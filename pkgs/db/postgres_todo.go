@@ -0,0 +1,132 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"joshuamURD/go-auth-api/pkgs/models"
+
+	"github.com/google/uuid"
+)
+
+// PostgresTodoRepository implements TodoRepository on top of a PostgreSQL connection.
+type PostgresTodoRepository struct {
+	db *sql.DB
+	tx *sql.Tx
+}
+
+// NewPostgresTodoRepository creates a new PostgresTodoRepository sharing the connection
+// of an existing PostgresRepository.
+func NewPostgresTodoRepository(repo *PostgresRepository) *PostgresTodoRepository {
+	return &PostgresTodoRepository{db: repo.db, tx: repo.tx}
+}
+
+// Create inserts a new todo into the database.
+func (d *PostgresTodoRepository) Create(ctx context.Context, todo models.Todo) error {
+	_, err := d.conn().ExecContext(
+		ctx,
+		"INSERT INTO todos (id, owner_id, title, description, status, due_date, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)",
+		todo.ID,
+		todo.OwnerID,
+		todo.Title,
+		todo.Description,
+		todo.Status,
+		utcTime(todo.DueDate),
+		todo.CreatedAt.UTC(),
+		todo.UpdatedAt.UTC(),
+	)
+	if err != nil {
+		return postgresError("error creating todo", err)
+	}
+	return nil
+}
+
+// GetByOwner retrieves all todos belonging to a user, oldest first.
+func (d *PostgresTodoRepository) GetByOwner(ctx context.Context, ownerID uuid.UUID) ([]models.Todo, error) {
+	rows, err := d.conn().QueryContext(ctx, "SELECT id, owner_id, title, description, status, due_date, created_at, updated_at FROM todos WHERE owner_id = $1 ORDER BY created_at", ownerID)
+	if err != nil {
+		return nil, postgresError("database error", err)
+	}
+	defer rows.Close()
+
+	todos := []models.Todo{}
+	for rows.Next() {
+		todo, err := scanPostgresTodo(rows)
+		if err != nil {
+			return nil, err
+		}
+		todos = append(todos, todo)
+	}
+
+	return todos, rows.Err()
+}
+
+// GetByID retrieves a single todo owned by the given user.
+func (d *PostgresTodoRepository) GetByID(ctx context.Context, id uuid.UUID, ownerID uuid.UUID) (models.Todo, error) {
+	row := d.conn().QueryRowContext(ctx, "SELECT id, owner_id, title, description, status, due_date, created_at, updated_at FROM todos WHERE id = $1 AND owner_id = $2", id, ownerID)
+	todo, err := scanPostgresTodo(row)
+	if err == sql.ErrNoRows {
+		return todo, ErrTodoNotFound
+	}
+	return todo, err
+}
+
+// Update overwrites the mutable fields of a todo owned by todo.OwnerID.
+func (d *PostgresTodoRepository) Update(ctx context.Context, todo models.Todo) error {
+	result, err := d.conn().ExecContext(
+		ctx,
+		"UPDATE todos SET title = $1, description = $2, status = $3, due_date = $4, updated_at = $5 WHERE id = $6 AND owner_id = $7",
+		todo.Title,
+		todo.Description,
+		todo.Status,
+		utcTime(todo.DueDate),
+		todo.UpdatedAt.UTC(),
+		todo.ID,
+		todo.OwnerID,
+	)
+	if err != nil {
+		return postgresError("error updating todo", err)
+	}
+	return expectAffected(result, ErrTodoNotFound)
+}
+
+// Delete removes a todo owned by the given user.
+func (d *PostgresTodoRepository) Delete(ctx context.Context, id uuid.UUID, ownerID uuid.UUID) error {
+	result, err := d.conn().ExecContext(ctx, "DELETE FROM todos WHERE id = $1 AND owner_id = $2", id, ownerID)
+	if err != nil {
+		return postgresError("error deleting todo", err)
+	}
+	return expectAffected(result, ErrTodoNotFound)
+}
+
+// scanPostgresTodo reads a todo from a row selected with the standard todo column list
+func scanPostgresTodo(row rowScanner) (models.Todo, error) {
+	var todo models.Todo
+	var dueDate sql.NullTime
+
+	if err := row.Scan(
+		&todo.ID,
+		&todo.OwnerID,
+		&todo.Title,
+		&todo.Description,
+		&todo.Status,
+		&dueDate,
+		&todo.CreatedAt,
+		&todo.UpdatedAt,
+	); err != nil {
+		if err == sql.ErrNoRows {
+			return todo, err
+		}
+		return todo, postgresError("scan error", err)
+	}
+
+	todo.DueDate = nullTimePtr(dueDate)
+	return todo, nil
+}
+
+// conn returns the transaction the repository is bound to, or the connection pool
+func (d *PostgresTodoRepository) conn() querier {
+	if d.tx != nil {
+		return d.tx
+	}
+	return d.db
+}
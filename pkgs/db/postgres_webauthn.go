@@ -0,0 +1,173 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"joshuamURD/go-auth-api/pkgs/models"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// PostgresWebAuthnRepository implements WebAuthnRepository on top of a PostgreSQL connection.
+type PostgresWebAuthnRepository struct {
+	db *sql.DB
+	tx *sql.Tx
+}
+
+// NewPostgresWebAuthnRepository creates a new PostgresWebAuthnRepository sharing the connection
+// of an existing PostgresRepository.
+func NewPostgresWebAuthnRepository(repo *PostgresRepository) *PostgresWebAuthnRepository {
+	return &PostgresWebAuthnRepository{db: repo.db, tx: repo.tx}
+}
+
+// CreateCredential inserts a newly registered credential.
+func (d *PostgresWebAuthnRepository) CreateCredential(ctx context.Context, credential models.WebAuthnCredential) error {
+	_, err := d.conn().ExecContext(
+		ctx,
+		"INSERT INTO webauthn_credentials (id, user_id, public_key, sign_count, created_at) VALUES ($1, $2, $3, $4, $5)",
+		credential.ID,
+		credential.UserID,
+		credential.PublicKey,
+		int64(credential.SignCount),
+		credential.CreatedAt.UTC(),
+	)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == "webauthn_credentials_pkey" {
+		return ErrWebAuthnCredentialExists
+	}
+	if err != nil {
+		return postgresError("error creating webauthn credential", err)
+	}
+	return nil
+}
+
+// GetCredential retrieves a credential by its ID.
+func (d *PostgresWebAuthnRepository) GetCredential(ctx context.Context, id []byte) (models.WebAuthnCredential, error) {
+	row := d.conn().QueryRowContext(ctx, "SELECT id, user_id, public_key, sign_count, created_at, last_used_at FROM webauthn_credentials WHERE id = $1", id)
+	credential, err := scanPostgresCredential(row)
+	if err == sql.ErrNoRows {
+		return credential, ErrWebAuthnCredentialNotFound
+	}
+	return credential, err
+}
+
+// GetCredentialsByUser retrieves every credential of a user, oldest first.
+func (d *PostgresWebAuthnRepository) GetCredentialsByUser(ctx context.Context, userID uuid.UUID) ([]models.WebAuthnCredential, error) {
+	rows, err := d.conn().QueryContext(ctx, "SELECT id, user_id, public_key, sign_count, created_at, last_used_at FROM webauthn_credentials WHERE user_id = $1 ORDER BY created_at", userID)
+	if err != nil {
+		return nil, postgresError("database error", err)
+	}
+	defer rows.Close()
+
+	credentials := []models.WebAuthnCredential{}
+	for rows.Next() {
+		credential, err := scanPostgresCredential(rows)
+		if err != nil {
+			return nil, err
+		}
+		credentials = append(credentials, credential)
+	}
+	return credentials, rows.Err()
+}
+
+// UpdateSignCount moves the counter of a credential only if nobody else moved it first.
+func (d *PostgresWebAuthnRepository) UpdateSignCount(ctx context.Context, id []byte, previous uint32, next uint32) error {
+	result, err := d.conn().ExecContext(
+		ctx,
+		"UPDATE webauthn_credentials SET sign_count = $1, last_used_at = $2 WHERE id = $3 AND sign_count = $4",
+		int64(next),
+		time.Now().UTC(),
+		id,
+		int64(previous),
+	)
+	if err != nil {
+		return postgresError("error updating webauthn credential", err)
+	}
+	return expectAffected(result, ErrWebAuthnSignCountChanged)
+}
+
+// CreateSession stores a challenge that has been sent to a browser.
+func (d *PostgresWebAuthnRepository) CreateSession(ctx context.Context, session models.WebAuthnSession) error {
+	_, err := d.conn().ExecContext(
+		ctx,
+		"INSERT INTO webauthn_sessions (challenge_hash, user_id, ceremony, expires_at, created_at) VALUES ($1, $2, $3, $4, $5)",
+		session.ChallengeHash,
+		nullUUID(session.UserID),
+		session.Ceremony,
+		session.ExpiresAt.UTC(),
+		session.CreatedAt.UTC(),
+	)
+	if err != nil {
+		return postgresError("error creating webauthn session", err)
+	}
+	return nil
+}
+
+// ConsumeSession deletes a challenge in a single statement so it can only ever be answered once.
+// Expired challenges are deleted too when they are presented, but still rejected.
+func (d *PostgresWebAuthnRepository) ConsumeSession(ctx context.Context, challengeHash string, ceremony models.WebAuthnCeremony) (models.WebAuthnSession, error) {
+	var session models.WebAuthnSession
+	var userID uuid.NullUUID
+	err := d.conn().QueryRowContext(
+		ctx,
+		"DELETE FROM webauthn_sessions WHERE challenge_hash = $1 AND ceremony = $2 RETURNING challenge_hash, user_id, ceremony, expires_at, created_at",
+		challengeHash,
+		ceremony,
+	).Scan(
+		&session.ChallengeHash,
+		&userID,
+		&session.Ceremony,
+		&session.ExpiresAt,
+		&session.CreatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return session, ErrWebAuthnSessionInvalid
+	}
+	if err != nil {
+		return session, postgresError("database error", err)
+	}
+	if userID.Valid {
+		session.UserID = &userID.UUID
+	}
+
+	if !session.ExpiresAt.After(time.Now()) {
+		return session, ErrWebAuthnSessionInvalid
+	}
+	return session, nil
+}
+
+// scanPostgresCredential reads a credential from a row selected with the standard credential column list
+func scanPostgresCredential(row rowScanner) (models.WebAuthnCredential, error) {
+	var credential models.WebAuthnCredential
+	var signCount int64
+	var lastUsedAt sql.NullTime
+
+	if err := row.Scan(
+		&credential.ID,
+		&credential.UserID,
+		&credential.PublicKey,
+		&signCount,
+		&credential.CreatedAt,
+		&lastUsedAt,
+	); err != nil {
+		if err == sql.ErrNoRows {
+			return credential, err
+		}
+		return credential, postgresError("scan error", err)
+	}
+	credential.SignCount = uint32(signCount)
+	credential.LastUsedAt = nullTimePtr(lastUsedAt)
+
+	return credential, nil
+}
+
+// conn returns the transaction the repository is bound to, or the connection pool
+func (d *PostgresWebAuthnRepository) conn() querier {
+	if d.tx != nil {
+		return d.tx
+	}
+	return d.db
+}
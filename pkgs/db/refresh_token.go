@@ -0,0 +1,159 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"joshuamURD/go-auth-api/pkgs/models"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrRefreshTokenNotFound is returned when no refresh token exists with the given ID
+	ErrRefreshTokenNotFound = newKindError(ErrNotFound, "refresh token not found")
+	// ErrRefreshTokenRevoked is returned when revoking a token that has already been revoked
+	ErrRefreshTokenRevoked = newKindError(ErrConflict, "refresh token already revoked")
+)
+
+// RefreshTokenRepository is an interface that defines the methods for persisting refresh tokens.
+type RefreshTokenRepository interface {
+	Create(ctx context.Context, token models.RefreshToken) error
+	GetByID(ctx context.Context, id string) (models.RefreshToken, error)
+	// Revoke marks a single token as used, recording the token that replaced it.
+	// It returns ErrRefreshTokenRevoked if the token was already revoked.
+	Revoke(ctx context.Context, id string, replacedBy string) error
+	// RevokeFamily revokes every token rotated from the same login.
+	RevokeFamily(ctx context.Context, familyID string) error
+	// RevokeAllForUser revokes every active token belonging to a user.
+	RevokeAllForUser(ctx context.Context, userID uuid.UUID) error
+}
+
+// SQLiteRefreshTokenRepository implements RefreshTokenRepository on top of a SQLite connection.
+type SQLiteRefreshTokenRepository struct {
+	db *sql.DB
+	tx *sql.Tx
+}
+
+// NewSQLiteRefreshTokenRepository creates a new SQLiteRefreshTokenRepository sharing the connection
+// of an existing SQLiteRepository.
+func NewSQLiteRefreshTokenRepository(repo *SQLiteRepository) *SQLiteRefreshTokenRepository {
+	return &SQLiteRefreshTokenRepository{db: repo.db, tx: repo.tx}
+}
+
+// Create inserts a newly issued refresh token.
+func (d *SQLiteRefreshTokenRepository) Create(ctx context.Context, token models.RefreshToken) error {
+	_, err := d.conn().ExecContext(
+		ctx,
+		"INSERT INTO refresh_tokens (id, family_id, user_id, expires_at, created_at) VALUES (?, ?, ?, ?, ?)",
+		token.ID,
+		token.FamilyID,
+		token.UserID,
+		token.ExpiresAt.UTC().Format(time.RFC3339),
+		token.CreatedAt.UTC().Format(time.RFC3339),
+	)
+	if err != nil {
+		return sqliteError("error creating refresh token", err)
+	}
+	return nil
+}
+
+// GetByID retrieves a refresh token by its jti.
+func (d *SQLiteRefreshTokenRepository) GetByID(ctx context.Context, id string) (models.RefreshToken, error) {
+	var token models.RefreshToken
+	var expiresAtStr, createdAtStr string
+	var revokedAtStr, replacedBy sql.NullString
+
+	row := d.conn().QueryRowContext(ctx, "SELECT id, family_id, user_id, expires_at, created_at, revoked_at, replaced_by FROM refresh_tokens WHERE id = ?", id)
+	err := row.Scan(
+		&token.ID,
+		&token.FamilyID,
+		&token.UserID,
+		&expiresAtStr,
+		&createdAtStr,
+		&revokedAtStr,
+		&replacedBy,
+	)
+	if err == sql.ErrNoRows {
+		return token, ErrRefreshTokenNotFound
+	}
+	if err != nil {
+		return token, sqliteError("database error", err)
+	}
+
+	expiresAt, err := time.Parse(time.RFC3339, expiresAtStr)
+	if err != nil {
+		return token, fmt.Errorf("error parsing expires_at time: %w", err)
+	}
+	token.ExpiresAt = expiresAt
+
+	createdAt, err := time.Parse(time.RFC3339, createdAtStr)
+	if err != nil {
+		return token, fmt.Errorf("error parsing created_at time: %w", err)
+	}
+	token.CreatedAt = createdAt
+
+	if revokedAtStr.Valid {
+		revokedAt, err := time.Parse(time.RFC3339, revokedAtStr.String)
+		if err != nil {
+			return token, fmt.Errorf("error parsing revoked_at time: %w", err)
+		}
+		token.RevokedAt = &revokedAt
+	}
+	token.ReplacedBy = replacedBy.String
+
+	return token, nil
+}
+
+// Revoke marks a token as used. The update only matches tokens that are still active,
+// so two concurrent refreshes with the same token cannot both succeed.
+func (d *SQLiteRefreshTokenRepository) Revoke(ctx context.Context, id string, replacedBy string) error {
+	result, err := d.conn().ExecContext(
+		ctx,
+		"UPDATE refresh_tokens SET revoked_at = ?, replaced_by = ? WHERE id = ? AND revoked_at IS NULL",
+		time.Now().UTC().Format(time.RFC3339),
+		sql.NullString{String: replacedBy, Valid: replacedBy != ""},
+		id,
+	)
+	if err != nil {
+		return sqliteError("error revoking refresh token", err)
+	}
+	return expectAffected(result, ErrRefreshTokenRevoked)
+}
+
+// RevokeFamily revokes every active token in a family.
+func (d *SQLiteRefreshTokenRepository) RevokeFamily(ctx context.Context, familyID string) error {
+	_, err := d.conn().ExecContext(
+		ctx,
+		"UPDATE refresh_tokens SET revoked_at = ? WHERE family_id = ? AND revoked_at IS NULL",
+		time.Now().UTC().Format(time.RFC3339),
+		familyID,
+	)
+	if err != nil {
+		return sqliteError("error revoking refresh token family", err)
+	}
+	return nil
+}
+
+// RevokeAllForUser revokes every active token belonging to a user.
+func (d *SQLiteRefreshTokenRepository) RevokeAllForUser(ctx context.Context, userID uuid.UUID) error {
+	_, err := d.conn().ExecContext(
+		ctx,
+		"UPDATE refresh_tokens SET revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL",
+		time.Now().UTC().Format(time.RFC3339),
+		userID,
+	)
+	if err != nil {
+		return sqliteError(fmt.Sprintf("error revoking refresh tokens for user %s", userID), err)
+	}
+	return nil
+}
+
+// conn returns the transaction the repository is bound to, or the connection pool
+func (d *SQLiteRefreshTokenRepository) conn() querier {
+	if d.tx != nil {
+		return d.tx
+	}
+	return d.db
+}
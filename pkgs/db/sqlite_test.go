@@ -0,0 +1,11 @@
+package db_test
+
+import (
+	"testing"
+
+	"joshuamURD/go-auth-api/pkgs/db/dbtest"
+)
+
+func TestSQLiteConformance(t *testing.T) {
+	dbtest.Run(t, dbtest.SQLiteStore)
+}
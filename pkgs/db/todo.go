@@ -0,0 +1,192 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"joshuamURD/go-auth-api/pkgs/models"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrTodoNotFound is returned when a todo does not exist or is not owned by the caller
+var ErrTodoNotFound = newKindError(ErrNotFound, "todo not found")
+
+// TodoRepository is an interface that defines the methods for storing todo items.
+// Every lookup is scoped to the owner so that users can only see their own todos.
+type TodoRepository interface {
+	Create(ctx context.Context, todo models.Todo) error
+	GetByOwner(ctx context.Context, ownerID uuid.UUID) ([]models.Todo, error)
+	GetByID(ctx context.Context, id uuid.UUID, ownerID uuid.UUID) (models.Todo, error)
+	Update(ctx context.Context, todo models.Todo) error
+	Delete(ctx context.Context, id uuid.UUID, ownerID uuid.UUID) error
+}
+
+// SQLiteTodoRepository implements TodoRepository on top of a SQLite connection.
+type SQLiteTodoRepository struct {
+	db *sql.DB
+	tx *sql.Tx
+}
+
+// NewSQLiteTodoRepository creates a new SQLiteTodoRepository sharing the connection
+// of an existing SQLiteRepository.
+func NewSQLiteTodoRepository(repo *SQLiteRepository) *SQLiteTodoRepository {
+	return &SQLiteTodoRepository{db: repo.db, tx: repo.tx}
+}
+
+// Create inserts a new todo into the database.
+func (d *SQLiteTodoRepository) Create(ctx context.Context, todo models.Todo) error {
+	_, err := d.conn().ExecContext(
+		ctx,
+		"INSERT INTO todos (id, owner_id, title, description, status, due_date, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		todo.ID,
+		todo.OwnerID,
+		todo.Title,
+		todo.Description,
+		todo.Status,
+		formatNullTime(todo.DueDate),
+		todo.CreatedAt.Format(time.RFC3339),
+		todo.UpdatedAt.Format(time.RFC3339),
+	)
+	if err != nil {
+		return sqliteError("error creating todo", err)
+	}
+	return nil
+}
+
+// GetByOwner retrieves all todos belonging to a user, oldest first.
+func (d *SQLiteTodoRepository) GetByOwner(ctx context.Context, ownerID uuid.UUID) ([]models.Todo, error) {
+	rows, err := d.conn().QueryContext(ctx, "SELECT id, owner_id, title, description, status, due_date, created_at, updated_at FROM todos WHERE owner_id = ? ORDER BY created_at", ownerID)
+	if err != nil {
+		return nil, sqliteError("database error", err)
+	}
+	defer rows.Close()
+
+	todos := []models.Todo{}
+	for rows.Next() {
+		todo, err := scanTodo(rows)
+		if err != nil {
+			return nil, err
+		}
+		todos = append(todos, todo)
+	}
+
+	return todos, rows.Err()
+}
+
+// GetByID retrieves a single todo owned by the given user.
+func (d *SQLiteTodoRepository) GetByID(ctx context.Context, id uuid.UUID, ownerID uuid.UUID) (models.Todo, error) {
+	row := d.conn().QueryRowContext(ctx, "SELECT id, owner_id, title, description, status, due_date, created_at, updated_at FROM todos WHERE id = ? AND owner_id = ?", id, ownerID)
+	todo, err := scanTodo(row)
+	if err == sql.ErrNoRows {
+		return todo, ErrTodoNotFound
+	}
+	return todo, err
+}
+
+// Update overwrites the mutable fields of a todo owned by todo.OwnerID.
+func (d *SQLiteTodoRepository) Update(ctx context.Context, todo models.Todo) error {
+	result, err := d.conn().ExecContext(
+		ctx,
+		"UPDATE todos SET title = ?, description = ?, status = ?, due_date = ?, updated_at = ? WHERE id = ? AND owner_id = ?",
+		todo.Title,
+		todo.Description,
+		todo.Status,
+		formatNullTime(todo.DueDate),
+		todo.UpdatedAt.Format(time.RFC3339),
+		todo.ID,
+		todo.OwnerID,
+	)
+	if err != nil {
+		return sqliteError("error updating todo", err)
+	}
+	return expectAffected(result, ErrTodoNotFound)
+}
+
+// Delete removes a todo owned by the given user.
+func (d *SQLiteTodoRepository) Delete(ctx context.Context, id uuid.UUID, ownerID uuid.UUID) error {
+	result, err := d.conn().ExecContext(ctx, "DELETE FROM todos WHERE id = ? AND owner_id = ?", id, ownerID)
+	if err != nil {
+		return sqliteError("error deleting todo", err)
+	}
+	return expectAffected(result, ErrTodoNotFound)
+}
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanTodo reads a todo from a row selected with the standard todo column list
+func scanTodo(row rowScanner) (models.Todo, error) {
+	var todo models.Todo
+	var dueDateStr sql.NullString
+	var createdAtStr, updatedAtStr string
+
+	if err := row.Scan(
+		&todo.ID,
+		&todo.OwnerID,
+		&todo.Title,
+		&todo.Description,
+		&todo.Status,
+		&dueDateStr,
+		&createdAtStr,
+		&updatedAtStr,
+	); err != nil {
+		if err == sql.ErrNoRows {
+			return todo, err
+		}
+		return todo, sqliteError("scan error", err)
+	}
+
+	if dueDateStr.Valid {
+		dueDate, err := time.Parse(time.RFC3339, dueDateStr.String)
+		if err != nil {
+			return todo, fmt.Errorf("error parsing due_date time: %w", err)
+		}
+		todo.DueDate = &dueDate
+	}
+
+	createdAt, err := time.Parse(time.RFC3339, createdAtStr)
+	if err != nil {
+		return todo, fmt.Errorf("error parsing created_at time: %w", err)
+	}
+	todo.CreatedAt = createdAt
+
+	updatedAt, err := time.Parse(time.RFC3339, updatedAtStr)
+	if err != nil {
+		return todo, fmt.Errorf("error parsing updated_at time: %w", err)
+	}
+	todo.UpdatedAt = updatedAt
+
+	return todo, nil
+}
+
+// formatNullTime formats an optional time as RFC3339, storing NULL when unset
+func formatNullTime(t *time.Time) sql.NullString {
+	if t == nil {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: t.Format(time.RFC3339), Valid: true}
+}
+
+// expectAffected returns notFound when an UPDATE or DELETE matched no rows
+func expectAffected(result sql.Result, notFound error) error {
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	if n == 0 {
+		return notFound
+	}
+	return nil
+}
+
+// conn returns the transaction the repository is bound to, or the connection pool
+func (d *SQLiteTodoRepository) conn() querier {
+	if d.tx != nil {
+		return d.tx
+	}
+	return d.db
+}
@@ -0,0 +1,228 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"joshuamURD/go-auth-api/pkgs/models"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrWebAuthnCredentialNotFound is returned when no credential exists with the given ID
+	ErrWebAuthnCredentialNotFound = newKindError(ErrNotFound, "webauthn credential not found")
+	// ErrWebAuthnCredentialExists is returned when registering a credential ID that is already registered
+	ErrWebAuthnCredentialExists = newKindError(ErrConflict, "webauthn credential already registered")
+	// ErrWebAuthnSignCountChanged is returned when the signature counter of a credential moved
+	// while an assertion made with it was being checked
+	ErrWebAuthnSignCountChanged = newKindError(ErrConflict, "webauthn signature counter changed")
+	// ErrWebAuthnSessionInvalid is returned when a challenge is unknown, expired, already answered
+	// or was issued for another ceremony
+	ErrWebAuthnSessionInvalid = newKindError(ErrNotFound, "invalid or expired webauthn challenge")
+)
+
+// WebAuthnRepository is an interface that defines the methods for storing passkeys and their challenges.
+type WebAuthnRepository interface {
+	// CreateCredential stores a newly registered credential.
+	// It returns ErrWebAuthnCredentialExists if the credential ID is taken.
+	CreateCredential(ctx context.Context, credential models.WebAuthnCredential) error
+	GetCredential(ctx context.Context, id []byte) (models.WebAuthnCredential, error)
+	GetCredentialsByUser(ctx context.Context, userID uuid.UUID) ([]models.WebAuthnCredential, error)
+	// UpdateSignCount records a successful assertion, moving the counter from previous to next.
+	// It returns ErrWebAuthnSignCountChanged if the stored counter is no longer previous.
+	UpdateSignCount(ctx context.Context, id []byte, previous uint32, next uint32) error
+	CreateSession(ctx context.Context, session models.WebAuthnSession) error
+	// ConsumeSession removes a challenge and returns it. It fails with ErrWebAuthnSessionInvalid
+	// unless the challenge exists, was issued for the given ceremony and has not expired.
+	ConsumeSession(ctx context.Context, challengeHash string, ceremony models.WebAuthnCeremony) (models.WebAuthnSession, error)
+}
+
+// SQLiteWebAuthnRepository implements WebAuthnRepository on top of a SQLite connection.
+type SQLiteWebAuthnRepository struct {
+	db *sql.DB
+	tx *sql.Tx
+}
+
+// NewSQLiteWebAuthnRepository creates a new SQLiteWebAuthnRepository sharing the connection
+// of an existing SQLiteRepository.
+func NewSQLiteWebAuthnRepository(repo *SQLiteRepository) *SQLiteWebAuthnRepository {
+	return &SQLiteWebAuthnRepository{db: repo.db, tx: repo.tx}
+}
+
+// CreateCredential inserts a newly registered credential.
+func (d *SQLiteWebAuthnRepository) CreateCredential(ctx context.Context, credential models.WebAuthnCredential) error {
+	_, err := d.conn().ExecContext(
+		ctx,
+		"INSERT INTO webauthn_credentials (id, user_id, public_key, sign_count, created_at) VALUES (?, ?, ?, ?, ?)",
+		credential.ID,
+		credential.UserID,
+		credential.PublicKey,
+		credential.SignCount,
+		credential.CreatedAt.UTC().Format(time.RFC3339),
+	)
+	if isSQLitePrimaryKeyViolation(err) {
+		return ErrWebAuthnCredentialExists
+	}
+	if err != nil {
+		return sqliteError("error creating webauthn credential", err)
+	}
+	return nil
+}
+
+// GetCredential retrieves a credential by its ID.
+func (d *SQLiteWebAuthnRepository) GetCredential(ctx context.Context, id []byte) (models.WebAuthnCredential, error) {
+	row := d.conn().QueryRowContext(ctx, "SELECT id, user_id, public_key, sign_count, created_at, last_used_at FROM webauthn_credentials WHERE id = ?", id)
+	credential, err := scanSQLiteCredential(row)
+	if err == sql.ErrNoRows {
+		return credential, ErrWebAuthnCredentialNotFound
+	}
+	return credential, err
+}
+
+// GetCredentialsByUser retrieves every credential of a user, oldest first.
+func (d *SQLiteWebAuthnRepository) GetCredentialsByUser(ctx context.Context, userID uuid.UUID) ([]models.WebAuthnCredential, error) {
+	rows, err := d.conn().QueryContext(ctx, "SELECT id, user_id, public_key, sign_count, created_at, last_used_at FROM webauthn_credentials WHERE user_id = ? ORDER BY created_at", userID)
+	if err != nil {
+		return nil, sqliteError("database error", err)
+	}
+	defer rows.Close()
+
+	credentials := []models.WebAuthnCredential{}
+	for rows.Next() {
+		credential, err := scanSQLiteCredential(rows)
+		if err != nil {
+			return nil, err
+		}
+		credentials = append(credentials, credential)
+	}
+	return credentials, rows.Err()
+}
+
+// UpdateSignCount moves the counter of a credential only if nobody else moved it first.
+func (d *SQLiteWebAuthnRepository) UpdateSignCount(ctx context.Context, id []byte, previous uint32, next uint32) error {
+	result, err := d.conn().ExecContext(
+		ctx,
+		"UPDATE webauthn_credentials SET sign_count = ?, last_used_at = ? WHERE id = ? AND sign_count = ?",
+		next,
+		time.Now().UTC().Format(time.RFC3339),
+		id,
+		previous,
+	)
+	if err != nil {
+		return sqliteError("error updating webauthn credential", err)
+	}
+	return expectAffected(result, ErrWebAuthnSignCountChanged)
+}
+
+// CreateSession stores a challenge that has been sent to a browser.
+func (d *SQLiteWebAuthnRepository) CreateSession(ctx context.Context, session models.WebAuthnSession) error {
+	_, err := d.conn().ExecContext(
+		ctx,
+		"INSERT INTO webauthn_sessions (challenge_hash, user_id, ceremony, expires_at, created_at) VALUES (?, ?, ?, ?, ?)",
+		session.ChallengeHash,
+		nullUUID(session.UserID),
+		session.Ceremony,
+		session.ExpiresAt.UTC().Format(time.RFC3339),
+		session.CreatedAt.UTC().Format(time.RFC3339),
+	)
+	if err != nil {
+		return sqliteError("error creating webauthn session", err)
+	}
+	return nil
+}
+
+// ConsumeSession deletes a challenge in a single statement so it can only ever be answered once.
+// Expired challenges are deleted too when they are presented, but still rejected.
+func (d *SQLiteWebAuthnRepository) ConsumeSession(ctx context.Context, challengeHash string, ceremony models.WebAuthnCeremony) (models.WebAuthnSession, error) {
+	var session models.WebAuthnSession
+	var userID uuid.NullUUID
+	var expiresAtStr, createdAtStr string
+	err := d.conn().QueryRowContext(
+		ctx,
+		"DELETE FROM webauthn_sessions WHERE challenge_hash = ? AND ceremony = ? RETURNING challenge_hash, user_id, ceremony, expires_at, created_at",
+		challengeHash,
+		ceremony,
+	).Scan(
+		&session.ChallengeHash,
+		&userID,
+		&session.Ceremony,
+		&expiresAtStr,
+		&createdAtStr,
+	)
+	if err == sql.ErrNoRows {
+		return session, ErrWebAuthnSessionInvalid
+	}
+	if err != nil {
+		return session, sqliteError("database error", err)
+	}
+
+	if session.ExpiresAt, err = time.Parse(time.RFC3339, expiresAtStr); err != nil {
+		return session, fmt.Errorf("error parsing expires_at time: %w", err)
+	}
+	if session.CreatedAt, err = time.Parse(time.RFC3339, createdAtStr); err != nil {
+		return session, fmt.Errorf("error parsing created_at time: %w", err)
+	}
+	if userID.Valid {
+		session.UserID = &userID.UUID
+	}
+
+	if !session.ExpiresAt.After(time.Now()) {
+		return session, ErrWebAuthnSessionInvalid
+	}
+	return session, nil
+}
+
+// scanSQLiteCredential reads a credential from a row selected with the standard credential column list
+func scanSQLiteCredential(row rowScanner) (models.WebAuthnCredential, error) {
+	var credential models.WebAuthnCredential
+	var createdAtStr string
+	var lastUsedAtStr sql.NullString
+
+	if err := row.Scan(
+		&credential.ID,
+		&credential.UserID,
+		&credential.PublicKey,
+		&credential.SignCount,
+		&createdAtStr,
+		&lastUsedAtStr,
+	); err != nil {
+		if err == sql.ErrNoRows {
+			return credential, err
+		}
+		return credential, sqliteError("scan error", err)
+	}
+
+	createdAt, err := time.Parse(time.RFC3339, createdAtStr)
+	if err != nil {
+		return credential, fmt.Errorf("error parsing created_at time: %w", err)
+	}
+	credential.CreatedAt = createdAt
+
+	if lastUsedAtStr.Valid {
+		lastUsedAt, err := time.Parse(time.RFC3339, lastUsedAtStr.String)
+		if err != nil {
+			return credential, fmt.Errorf("error parsing last_used_at time: %w", err)
+		}
+		credential.LastUsedAt = &lastUsedAt
+	}
+
+	return credential, nil
+}
+
+// nullUUID stores an optional user ID as NULL when unset
+func nullUUID(id *uuid.UUID) uuid.NullUUID {
+	if id == nil {
+		return uuid.NullUUID{}
+	}
+	return uuid.NullUUID{UUID: *id, Valid: true}
+}
+
+// conn returns the transaction the repository is bound to, or the connection pool
+func (d *SQLiteWebAuthnRepository) conn() querier {
+	if d.tx != nil {
+		return d.tx
+	}
+	return d.db
+}
@@ -0,0 +1,132 @@
+package hash
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// errInvalidArgon2idHash is returned when a stored hash is not in the encoding Hash produces
+var errInvalidArgon2idHash = errors.New("invalid argon2id hash")
+
+// Argon2idParams are the tunable costs of an Argon2id hash
+type Argon2idParams struct {
+	// Memory is the amount of memory used in KiB
+	Memory uint32
+	// Time is the number of passes over the memory
+	Time uint32
+	// Parallelism is the number of threads used
+	Parallelism uint8
+	// SaltLength is the length of the random salt in bytes
+	SaltLength uint32
+	// KeyLength is the length of the derived key in bytes
+	KeyLength uint32
+}
+
+// DefaultArgon2idParams returns 64 MiB of memory, three passes and two threads
+func DefaultArgon2idParams() Argon2idParams {
+	return Argon2idParams{
+		Memory:      64 * 1024,
+		Time:        3,
+		Parallelism: 2,
+		SaltLength:  16,
+		KeyLength:   32,
+	}
+}
+
+// argon2idHasher implements Hasher interface
+type argon2idHasher struct {
+	params Argon2idParams
+}
+
+// NewArgon2idHasher creates a new instance of argon2idHasher
+// hashes are stored in the PHC string format, $argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>
+func NewArgon2idHasher(params Argon2idParams) Hasher {
+	return &argon2idHasher{
+		params: params,
+	}
+}
+
+// Hash implements Hasher.Hash
+func (a *argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, a.params.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("failed to hash password: %w", err)
+	}
+	key := argon2.IDKey([]byte(password), salt, a.params.Time, a.params.Memory, a.params.Parallelism, a.params.KeyLength)
+	return encodeArgon2id(a.params, salt, key), nil
+}
+
+// Compare implements Hasher.Compare
+func (a *argon2idHasher) Compare(hashedPassword, plainPassword string) bool {
+	return compareArgon2id(hashedPassword, plainPassword)
+}
+
+// NeedsRehash implements Hasher.NeedsRehash, an Argon2id hash with other parameters needs rehashing
+func (a *argon2idHasher) NeedsRehash(hashedPassword string) bool {
+	params, _, _, err := decodeArgon2id(hashedPassword)
+	return err != nil || params != a.params
+}
+
+// compareArgon2id checks a password against an Argon2id hash using the parameters stored in the hash
+func compareArgon2id(hashedPassword, plainPassword string) bool {
+	params, salt, key, err := decodeArgon2id(hashedPassword)
+	if err != nil {
+		return false
+	}
+	candidate := argon2.IDKey([]byte(plainPassword), salt, params.Time, params.Memory, params.Parallelism, params.KeyLength)
+	return subtle.ConstantTimeCompare(key, candidate) == 1
+}
+
+// encodeArgon2id formats a derived key with its salt and parameters
+func encodeArgon2id(params Argon2idParams, salt, key []byte) string {
+	return fmt.Sprintf(
+		"$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version,
+		params.Memory,
+		params.Time,
+		params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	)
+}
+
+// decodeArgon2id parses a hash made by encodeArgon2id
+func decodeArgon2id(hashedPassword string) (Argon2idParams, []byte, []byte, error) {
+	var params Argon2idParams
+
+	parts := strings.Split(hashedPassword, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != string(Argon2id) {
+		return params, nil, nil, errInvalidArgon2idHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, errInvalidArgon2idHash
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Time, &params.Parallelism); err != nil {
+		return params, nil, nil, errInvalidArgon2idHash
+	}
+	//argon2.IDKey panics without a pass or a thread, and trailing text after the parameters is not a hash Hash made
+	if params.Time == 0 || params.Parallelism == 0 || parts[3] != fmt.Sprintf("m=%d,t=%d,p=%d", params.Memory, params.Time, params.Parallelism) {
+		return params, nil, nil, errInvalidArgon2idHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, errInvalidArgon2idHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, errInvalidArgon2idHash
+	}
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+
+	return params, salt, key, nil
+}
@@ -0,0 +1,113 @@
+package hash
+
+import (
+	"strings"
+	"testing"
+)
+
+// testArgon2idParams are cheap parameters so the tests do not spend 64 MiB per hash
+var testArgon2idParams = Argon2idParams{Memory: 64, Time: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+func TestArgon2idRoundTrip(t *testing.T) {
+	hasher := NewArgon2idHasher(testArgon2idParams)
+	hashed, err := hasher.Hash("correct horse")
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+	if !strings.HasPrefix(hashed, "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Errorf("Hash = %q, want the PHC format with the parameters", hashed)
+	}
+
+	params, salt, key, err := decodeArgon2id(hashed)
+	if err != nil {
+		t.Fatalf("decodeArgon2id: %v", err)
+	}
+	if params != testArgon2idParams {
+		t.Errorf("decoded parameters %+v, want %+v", params, testArgon2idParams)
+	}
+	if encodeArgon2id(params, salt, key) != hashed {
+		t.Errorf("encoding the decoded hash does not give it back")
+	}
+
+	if !hasher.Compare(hashed, "correct horse") {
+		t.Errorf("Compare refused the password")
+	}
+	if hasher.Compare(hashed, "battery staple") {
+		t.Errorf("Compare accepted another password")
+	}
+	if other, _ := hasher.Hash("correct horse"); other == hashed {
+		t.Errorf("two hashes of the same password share a salt")
+	}
+}
+
+func TestArgon2idRejectsMalformedHashes(t *testing.T) {
+	const salt, key = "c29tZXNhbHRzb21lc2FsdA", "a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2U"
+	tests := []struct {
+		name   string
+		hashed string
+	}{
+		{"empty", ""},
+		{"bcrypt", "$2a$10$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy"},
+		{"missing key", "$argon2id$v=19$m=64,t=1,p=1$" + salt},
+		{"extra field", "$argon2id$v=19$m=64,t=1,p=1$" + salt + "$" + key + "$"},
+		{"argon2i", "$argon2i$v=19$m=64,t=1,p=1$" + salt + "$" + key},
+		{"other version", "$argon2id$v=16$m=64,t=1,p=1$" + salt + "$" + key},
+		{"no parallelism", "$argon2id$v=19$m=64,t=1,p=0$" + salt + "$" + key},
+		{"no passes", "$argon2id$v=19$m=64,t=0,p=1$" + salt + "$" + key},
+		{"parallelism overflow", "$argon2id$v=19$m=64,t=1,p=256$" + salt + "$" + key},
+		{"trailing parameters", "$argon2id$v=19$m=64,t=1,p=1,k=2$" + salt + "$" + key},
+		{"reordered parameters", "$argon2id$v=19$t=1,m=64,p=1$" + salt + "$" + key},
+		{"bad salt", "$argon2id$v=19$m=64,t=1,p=1$not*base64$" + key},
+		{"padded key", "$argon2id$v=19$m=64,t=1,p=1$" + salt + "$" + key + "="},
+		{"empty key", "$argon2id$v=19$m=64,t=1,p=1$" + salt + "$"},
+	}
+
+	hasher := NewArgon2idHasher(testArgon2idParams)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, _, err := decodeArgon2id(tt.hashed); err == nil {
+				t.Errorf("decodeArgon2id accepted %q", tt.hashed)
+			}
+			if hasher.Compare(tt.hashed, "correct horse") {
+				t.Errorf("Compare accepted %q", tt.hashed)
+			}
+			if !hasher.NeedsRehash(tt.hashed) {
+				t.Errorf("NeedsRehash is false for %q", tt.hashed)
+			}
+		})
+	}
+}
+
+func TestArgon2idNeedsRehash(t *testing.T) {
+	hashed, err := NewArgon2idHasher(testArgon2idParams).Hash("correct horse")
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		change func(p *Argon2idParams)
+		want   bool
+	}{
+		{"same parameters", func(p *Argon2idParams) {}, false},
+		{"more memory", func(p *Argon2idParams) { p.Memory *= 2 }, true},
+		{"more passes", func(p *Argon2idParams) { p.Time++ }, true},
+		{"more threads", func(p *Argon2idParams) { p.Parallelism++ }, true},
+		{"longer salt", func(p *Argon2idParams) { p.SaltLength = 32 }, true},
+		{"longer key", func(p *Argon2idParams) { p.KeyLength = 64 }, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := testArgon2idParams
+			tt.change(&params)
+			hasher := NewArgon2idHasher(params)
+			if got := hasher.NeedsRehash(hashed); got != tt.want {
+				t.Errorf("NeedsRehash = %v, want %v", got, tt.want)
+			}
+			//The stored parameters are used to compare, whatever the current ones are
+			if !hasher.Compare(hashed, "correct horse") {
+				t.Errorf("Compare refused the password")
+			}
+		})
+	}
+}
@@ -2,6 +2,7 @@ package hash
 
 import (
 	"fmt"
+	"strings"
 
 	"golang.org/x/crypto/bcrypt"
 )
@@ -11,6 +12,98 @@ import (
 type Hasher interface {
 	Hash(password string) (string, error)
 	Compare(hashedPassword, plainPassword string) bool
+	// NeedsRehash reports whether a stored hash was made with another algorithm or other
+	// parameters than Hash uses now, so it should be replaced the next time the password is known
+	NeedsRehash(hashedPassword string) bool
+}
+
+// Algorithm names a password hashing algorithm
+type Algorithm string
+
+const (
+	// Bcrypt is the algorithm every hash was made with before Argon2id was supported
+	Bcrypt Algorithm = "bcrypt"
+	// Argon2id is the memory-hard algorithm recommended by RFC 9106
+	Argon2id Algorithm = "argon2id"
+)
+
+// ParseAlgorithm returns the algorithm with the given name, an empty name selects Argon2id
+func ParseAlgorithm(name string) (Algorithm, error) {
+	switch alg := Algorithm(name); alg {
+	case "":
+		return Argon2id, nil
+	case Bcrypt, Argon2id:
+		return alg, nil
+	default:
+		return "", fmt.Errorf("unsupported password hashing algorithm %q", name)
+	}
+}
+
+// Identify returns the algorithm a stored hash was made with, judged by its prefix,
+// or an empty algorithm if the format is unknown
+func Identify(hashedPassword string) Algorithm {
+	switch {
+	case strings.HasPrefix(hashedPassword, "$2a$"), strings.HasPrefix(hashedPassword, "$2b$"), strings.HasPrefix(hashedPassword, "$2y$"):
+		return Bcrypt
+	case strings.HasPrefix(hashedPassword, "$argon2id$"):
+		return Argon2id
+	default:
+		return ""
+	}
+}
+
+// Config selects the algorithm new passwords are hashed with and its parameters
+type Config struct {
+	// Algorithm is used for new hashes, stored hashes of the other algorithms are still accepted
+	Algorithm Algorithm
+	// BcryptCost is the cost of new bcrypt hashes
+	BcryptCost int
+	// Argon2id holds the parameters of new Argon2id hashes
+	Argon2id Argon2idParams
+	// Peppers are mixed into passwords before hashing when set, the first one into new hashes
+	Peppers []Pepper
+}
+
+// DefaultConfig returns Argon2id with the default parameters
+func DefaultConfig() Config {
+	return Config{
+		Algorithm:  Argon2id,
+		BcryptCost: bcrypt.DefaultCost,
+		Argon2id:   DefaultArgon2idParams(),
+	}
+}
+
+// New creates a hasher that hashes with the configured algorithm and verifies hashes of every supported algorithm
+// with peppers configured, hashes without the current pepper are accepted and upgraded through NeedsRehash
+func New(config Config) (Hasher, error) {
+	hasher, err := newAlgorithmHasher(config)
+	if err != nil {
+		return nil, err
+	}
+	if len(config.Peppers) == 0 {
+		return hasher, nil
+	}
+	return NewPepperedHasher(hasher, config.Peppers...)
+}
+
+// newAlgorithmHasher creates the multi-algorithm hasher of the configured algorithm
+func newAlgorithmHasher(config Config) (Hasher, error) {
+	switch config.Algorithm {
+	case Bcrypt:
+		//bcrypt silently falls back to its default cost outside this range, so every hash would need rehashing
+		if config.BcryptCost < bcrypt.MinCost || config.BcryptCost > bcrypt.MaxCost {
+			return nil, fmt.Errorf("bcrypt cost %d is outside %d-%d", config.BcryptCost, bcrypt.MinCost, bcrypt.MaxCost)
+		}
+		return NewMultiHasher(NewBcryptHasher(config.BcryptCost)), nil
+	case Argon2id:
+		p := config.Argon2id
+		if p.Memory < 8*uint32(p.Parallelism) || p.Time == 0 || p.Parallelism == 0 || p.SaltLength < 8 || p.KeyLength < 16 {
+			return nil, fmt.Errorf("invalid argon2id parameters %+v", p)
+		}
+		return NewMultiHasher(NewArgon2idHasher(p)), nil
+	default:
+		return nil, fmt.Errorf("unsupported password hashing algorithm %q", config.Algorithm)
+	}
 }
 
 // bcryptHasher implements Hasher interface
@@ -39,3 +132,9 @@ func (b *bcryptHasher) Compare(hashedPassword, plainPassword string) bool {
 	err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(plainPassword))
 	return err == nil
 }
+
+// NeedsRehash implements Hasher.NeedsRehash, a bcrypt hash of another cost needs rehashing
+func (b *bcryptHasher) NeedsRehash(hashedPassword string) bool {
+	cost, err := bcrypt.Cost([]byte(hashedPassword))
+	return err != nil || cost != b.cost
+}
@@ -0,0 +1,40 @@
+package hash
+
+import "golang.org/x/crypto/bcrypt"
+
+// multiHasher implements Hasher interface
+// it hashes with one algorithm but verifies hashes of every supported algorithm,
+// so the algorithm can change without invalidating stored passwords
+type multiHasher struct {
+	current Hasher
+}
+
+// NewMultiHasher creates a hasher that hashes with current and verifies any bcrypt or Argon2id hash
+// NeedsRehash reports every hash that current would not have produced
+func NewMultiHasher(current Hasher) Hasher {
+	return &multiHasher{
+		current: current,
+	}
+}
+
+// Hash implements Hasher.Hash
+func (m *multiHasher) Hash(password string) (string, error) {
+	return m.current.Hash(password)
+}
+
+// Compare implements Hasher.Compare, picking the algorithm from the format of the stored hash
+func (m *multiHasher) Compare(hashedPassword, plainPassword string) bool {
+	switch Identify(hashedPassword) {
+	case Bcrypt:
+		return bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(plainPassword)) == nil
+	case Argon2id:
+		return compareArgon2id(hashedPassword, plainPassword)
+	default:
+		return false
+	}
+}
+
+// NeedsRehash implements Hasher.NeedsRehash
+func (m *multiHasher) NeedsRehash(hashedPassword string) bool {
+	return m.current.NeedsRehash(hashedPassword)
+}
@@ -0,0 +1,78 @@
+package hash
+
+import (
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestMultiHasherAcceptsLegacyBcrypt(t *testing.T) {
+	legacy, err := NewBcryptHasher(bcrypt.MinCost).Hash("correct horse")
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+	hasher, err := New(Config{Algorithm: Argon2id, Argon2id: testArgon2idParams})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	if !hasher.Compare(legacy, "correct horse") {
+		t.Errorf("Compare refused the bcrypt hash")
+	}
+	if hasher.Compare(legacy, "battery staple") {
+		t.Errorf("Compare accepted another password for the bcrypt hash")
+	}
+	if !hasher.NeedsRehash(legacy) {
+		t.Errorf("NeedsRehash is false for a bcrypt hash when Argon2id is current")
+	}
+
+	upgraded, err := hasher.Hash("correct horse")
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+	if Identify(upgraded) != Argon2id {
+		t.Errorf("the rehashed password is not Argon2id: %q", upgraded)
+	}
+	if hasher.NeedsRehash(upgraded) {
+		t.Errorf("NeedsRehash is true for a hash just made")
+	}
+}
+
+func TestMultiHasherNeedsRehash(t *testing.T) {
+	argon2id, err := NewArgon2idHasher(testArgon2idParams).Hash("correct horse")
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+	bcryptHash, err := NewBcryptHasher(bcrypt.MinCost).Hash("correct horse")
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		config Config
+		hashed string
+		want   bool
+	}{
+		{"argon2id current", Config{Algorithm: Argon2id, Argon2id: testArgon2idParams}, argon2id, false},
+		{"argon2id with other parameters", Config{Algorithm: Argon2id, Argon2id: Argon2idParams{Memory: 128, Time: 2, Parallelism: 1, SaltLength: 16, KeyLength: 32}}, argon2id, true},
+		{"argon2id when bcrypt is current", Config{Algorithm: Bcrypt, BcryptCost: bcrypt.MinCost}, argon2id, true},
+		{"bcrypt current", Config{Algorithm: Bcrypt, BcryptCost: bcrypt.MinCost}, bcryptHash, false},
+		{"bcrypt of a lower cost", Config{Algorithm: Bcrypt, BcryptCost: bcrypt.MinCost + 1}, bcryptHash, true},
+		{"unknown format", Config{Algorithm: Argon2id, Argon2id: testArgon2idParams}, "plaintext", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hasher, err := New(tt.config)
+			if err != nil {
+				t.Fatalf("New: %v", err)
+			}
+			if got := hasher.NeedsRehash(tt.hashed); got != tt.want {
+				t.Errorf("NeedsRehash = %v, want %v", got, tt.want)
+			}
+			if tt.hashed != "plaintext" && !hasher.Compare(tt.hashed, "correct horse") {
+				t.Errorf("Compare refused the password")
+			}
+		})
+	}
+}
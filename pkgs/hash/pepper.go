@@ -0,0 +1,145 @@
+package hash
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// pepperPrefix starts every hash made with a pepper, followed by the pepper version and the inner hash
+// e.g. $pepper$v=2$argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>
+const pepperPrefix = "$pepper$v="
+
+// Pepper is a server-side secret mixed into every password before it is hashed
+// it is kept out of the database, so a leaked users table alone cannot be cracked
+type Pepper struct {
+	// Version is stored with each hash so that the pepper can be rotated
+	Version int
+	// Secret is the HMAC-SHA256 key, at least 32 bytes
+	Secret []byte
+}
+
+// pepperedHasher implements Hasher interface
+type pepperedHasher struct {
+	inner   Hasher
+	current Pepper
+	peppers map[int][]byte
+}
+
+// NewPepperedHasher creates a hasher that hashes HMAC-SHA256(pepper, password) with inner instead of the password
+// the first pepper is used for new hashes, the others only verify hashes made before a rotation
+// hashes made without a pepper are still accepted and reported by NeedsRehash
+func NewPepperedHasher(inner Hasher, peppers ...Pepper) (Hasher, error) {
+	if len(peppers) == 0 {
+		return nil, fmt.Errorf("at least one pepper is required")
+	}
+	byVersion := make(map[int][]byte, len(peppers))
+	for _, pepper := range peppers {
+		if pepper.Version <= 0 {
+			return nil, fmt.Errorf("pepper version %d must be positive", pepper.Version)
+		}
+		if len(pepper.Secret) < 32 {
+			return nil, fmt.Errorf("pepper version %d must be at least 32 bytes, got %d", pepper.Version, len(pepper.Secret))
+		}
+		if _, ok := byVersion[pepper.Version]; ok {
+			return nil, fmt.Errorf("duplicate pepper version %d", pepper.Version)
+		}
+		byVersion[pepper.Version] = pepper.Secret
+	}
+	return &pepperedHasher{
+		inner:   inner,
+		current: peppers[0],
+		peppers: byVersion,
+	}, nil
+}
+
+// Hash implements Hasher.Hash
+func (p *pepperedHasher) Hash(password string) (string, error) {
+	hashed, err := p.inner.Hash(applyPepper(p.current.Secret, password))
+	if err != nil {
+		return "", err
+	}
+	return pepperPrefix + strconv.Itoa(p.current.Version) + hashed, nil
+}
+
+// Compare implements Hasher.Compare
+func (p *pepperedHasher) Compare(hashedPassword, plainPassword string) bool {
+	version, inner, ok := splitPepper(hashedPassword)
+	if !ok {
+		return p.inner.Compare(hashedPassword, plainPassword)
+	}
+	secret, ok := p.peppers[version]
+	if !ok {
+		return false
+	}
+	return p.inner.Compare(inner, applyPepper(secret, plainPassword))
+}
+
+// NeedsRehash implements Hasher.NeedsRehash, a hash without the current pepper needs rehashing
+func (p *pepperedHasher) NeedsRehash(hashedPassword string) bool {
+	version, inner, ok := splitPepper(hashedPassword)
+	return !ok || version != p.current.Version || p.inner.NeedsRehash(inner)
+}
+
+// applyPepper returns the base64 encoded HMAC of the password
+// the encoding keeps it at 44 bytes, well under the 72 bytes bcrypt reads
+func applyPepper(secret []byte, password string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(password))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// splitPepper returns the pepper version and the inner hash of a peppered hash
+func splitPepper(hashedPassword string) (int, string, bool) {
+	rest, ok := strings.CutPrefix(hashedPassword, pepperPrefix)
+	if !ok {
+		return 0, "", false
+	}
+	end := strings.Index(rest, "$")
+	if end <= 0 {
+		return 0, "", false
+	}
+	version, err := strconv.Atoi(rest[:end])
+	if err != nil {
+		return 0, "", false
+	}
+	return version, rest[end:], true
+}
+
+// LoadPeppers parses a comma separated list of version:base64-secret pairs from an environment variable,
+// or from a file when the variable is empty, the current pepper coming first. It returns nil when neither is set.
+func LoadPeppers(envValue, path string) ([]Pepper, error) {
+	encoded := envValue
+	if encoded == "" && path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read pepper file: %w", err)
+		}
+		encoded = strings.TrimSpace(string(data))
+	}
+	if encoded == "" {
+		return nil, nil
+	}
+
+	var peppers []Pepper
+	for _, entry := range strings.Split(encoded, ",") {
+		versionStr, secretStr, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if !ok {
+			return nil, fmt.Errorf("pepper %q is not in the form version:secret", entry)
+		}
+		version, err := strconv.Atoi(versionStr)
+		if err != nil {
+			return nil, fmt.Errorf("pepper version %q is not a number", versionStr)
+		}
+		secret, err := base64.StdEncoding.DecodeString(secretStr)
+		if err != nil {
+			return nil, fmt.Errorf("pepper version %d is not valid base64: %w", version, err)
+		}
+		peppers = append(peppers, Pepper{Version: version, Secret: secret})
+	}
+	return peppers, nil
+}
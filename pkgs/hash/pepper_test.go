@@ -0,0 +1,133 @@
+package hash
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testPepper returns a pepper whose secret is 32 copies of b
+func testPepper(version int, b byte) Pepper {
+	return Pepper{Version: version, Secret: bytes.Repeat([]byte{b}, 32)}
+}
+
+// newTestPepperedHasher returns an Argon2id hasher with the given peppers, the first one current
+func newTestPepperedHasher(t *testing.T, peppers ...Pepper) Hasher {
+	t.Helper()
+	hasher, err := NewPepperedHasher(NewMultiHasher(NewArgon2idHasher(testArgon2idParams)), peppers...)
+	if err != nil {
+		t.Fatalf("NewPepperedHasher: %v", err)
+	}
+	return hasher
+}
+
+func TestPepperRotation(t *testing.T) {
+	v1 := newTestPepperedHasher(t, testPepper(1, 'a'))
+	old, err := v1.Hash("correct horse")
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+	if !strings.HasPrefix(old, "$pepper$v=1$argon2id$") {
+		t.Fatalf("Hash = %q, want the pepper version before the inner hash", old)
+	}
+
+	//v2 is current, v1 is only kept to verify the hashes made before the rotation
+	v2 := newTestPepperedHasher(t, testPepper(2, 'b'), testPepper(1, 'a'))
+	if !v2.Compare(old, "correct horse") {
+		t.Errorf("Compare refused a v1 hash after the rotation")
+	}
+	if v2.Compare(old, "battery staple") {
+		t.Errorf("Compare accepted another password for a v1 hash")
+	}
+	if !v2.NeedsRehash(old) {
+		t.Errorf("NeedsRehash is false for a v1 hash when v2 is current")
+	}
+
+	upgraded, err := v2.Hash("correct horse")
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+	if !strings.HasPrefix(upgraded, "$pepper$v=2$") || v2.NeedsRehash(upgraded) {
+		t.Errorf("the rehashed password %q is not a current v2 hash", upgraded)
+	}
+	if v1.Compare(upgraded, "correct horse") {
+		t.Errorf("a hasher without v2 accepted a v2 hash")
+	}
+}
+
+func TestPepperFailsClosed(t *testing.T) {
+	hasher := newTestPepperedHasher(t, testPepper(2, 'b'), testPepper(1, 'a'))
+	retired, err := newTestPepperedHasher(t, testPepper(3, 'c')).Hash("correct horse")
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+	inner, err := NewArgon2idHasher(testArgon2idParams).Hash("correct horse")
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		hashed string
+	}{
+		{"unknown version", retired},
+		{"version of another pepper", strings.Replace(retired, "v=3", "v=1", 1)},
+		{"missing version", "$pepper$v=" + inner},
+		{"version not a number", "$pepper$v=x" + inner},
+		{"no inner hash", "$pepper$v=1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if hasher.Compare(tt.hashed, "correct horse") {
+				t.Errorf("Compare accepted %q", tt.hashed)
+			}
+			if !hasher.NeedsRehash(tt.hashed) {
+				t.Errorf("NeedsRehash is false for %q", tt.hashed)
+			}
+		})
+	}
+}
+
+func TestPepperAcceptsUnpepperedHashes(t *testing.T) {
+	hasher := newTestPepperedHasher(t, testPepper(1, 'a'))
+	plain, err := NewArgon2idHasher(testArgon2idParams).Hash("correct horse")
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+	if !hasher.Compare(plain, "correct horse") {
+		t.Errorf("Compare refused a hash made before peppers were configured")
+	}
+	if !hasher.NeedsRehash(plain) {
+		t.Errorf("NeedsRehash is false for a hash without a pepper")
+	}
+}
+
+func TestPepperBcryptLength(t *testing.T) {
+	hasher, err := NewPepperedHasher(NewMultiHasher(NewBcryptHasher(bcrypt.MinCost)), testPepper(1, 'a'))
+	if err != nil {
+		t.Fatalf("NewPepperedHasher: %v", err)
+	}
+
+	//bcrypt alone refuses passwords over 72 bytes, the HMAC of any password is 44
+	long := strings.Repeat("x", 72)
+	if _, err := NewBcryptHasher(bcrypt.MinCost).Hash(long + "1"); err == nil {
+		t.Fatalf("bcrypt hashed a password over 72 bytes, the limit this test relies on is gone")
+	}
+	if got := len(applyPepper(testPepper(1, 'a').Secret, long+long)); got != 44 {
+		t.Errorf("the peppered password is %d bytes, want 44", got)
+	}
+
+	hashed, err := hasher.Hash(long + "1")
+	if err != nil {
+		t.Fatalf("Hash of a password over 72 bytes: %v", err)
+	}
+	if !hasher.Compare(hashed, long+"1") {
+		t.Errorf("Compare refused the long password")
+	}
+	//Every byte counts, not only the first 72 that bcrypt would read
+	if hasher.Compare(hashed, long+"2") {
+		t.Errorf("Compare accepted a password that only differs after 72 bytes")
+	}
+}
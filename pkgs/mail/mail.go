@@ -0,0 +1,85 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Message is an email to be delivered to a single recipient
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer defines the interface for delivering emails
+// It is used to send verification and other account emails
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// writerMailer implements Mailer by writing messages to an io.Writer
+// it is meant for local development and tests where no real delivery is wanted
+type writerMailer struct {
+	w  io.Writer
+	mu sync.Mutex
+}
+
+// NewWriterMailer creates a Mailer that writes every message to w, e.g. os.Stdout
+func NewWriterMailer(w io.Writer) Mailer {
+	return &writerMailer{w: w}
+}
+
+// Send implements Mailer.Send
+func (m *writerMailer) Send(ctx context.Context, msg Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return writeMessage(m.w, msg)
+}
+
+// fileMailer implements Mailer by appending messages to a file
+type fileMailer struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewFileMailer creates a Mailer that appends every message to the file at path
+func NewFileMailer(path string) Mailer {
+	return &fileMailer{path: path}
+}
+
+// Send implements Mailer.Send
+func (m *fileMailer) Send(ctx context.Context, msg Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, err := os.OpenFile(m.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open mail file: %w", err)
+	}
+	defer f.Close()
+
+	return writeMessage(f, msg)
+}
+
+// writeMessage writes a message in a simple RFC 5322 like layout
+func writeMessage(w io.Writer, msg Message) error {
+	_, err := fmt.Fprintf(w, "Date: %s\nTo: %s\nSubject: %s\n\n%s\n\n",
+		time.Now().Format(time.RFC1123Z), msg.To, msg.Subject, msg.Body)
+	if err != nil {
+		return fmt.Errorf("failed to write message: %w", err)
+	}
+	return nil
+}
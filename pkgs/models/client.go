@@ -6,14 +6,38 @@ import (
 	"github.com/google/uuid"
 )
 
+// Role is the set of permissions a user has
+type Role string
+
+const (
+	RoleUser  Role = "user"
+	RoleAdmin Role = "admin"
+)
+
+// Valid reports whether the role is one of the known roles
+func (r Role) Valid() bool {
+	switch r {
+	case RoleUser, RoleAdmin:
+		return true
+	}
+	return false
+}
+
 type User struct {
 	ID             uuid.UUID
 	Email          string
 	Verified       bool
 	FailedAttempts int
 	Locked         bool
+	LockedUntil    *time.Time
 	HashedPassword string
-	role           int
+	Role           Role
 	CreatedAt      time.Time
 	UpdatedAt      time.Time
 }
+
+// IsLocked reports whether the account is locked at the given time
+// a lock without an end time lasts until it is cleared
+func (u User) IsLocked(now time.Time) bool {
+	return u.Locked && (u.LockedUntil == nil || now.Before(*u.LockedUntil))
+}
@@ -0,0 +1,71 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+)
+
+// ErrInvalidEmail is returned when an address has no local part or domain
+var ErrInvalidEmail = errors.New("invalid email address")
+
+// EmailPolicy controls how email addresses are canonicalized before they are stored or looked up
+type EmailPolicy struct {
+	// StripPlusTag removes a "+tag" suffix from the local part, so user+news@example.com
+	// and user@example.com are the same account
+	StripPlusTag bool
+}
+
+// Normalize returns the canonical form of an email address
+// surrounding whitespace is trimmed and the domain is lowercased, the local part keeps its case
+// because only the receiving server may interpret it
+func (p EmailPolicy) Normalize(email string) (string, error) {
+	email = strings.TrimSpace(email)
+
+	at := strings.LastIndex(email, "@")
+	if at <= 0 || at == len(email)-1 || strings.ContainsAny(email, " \t\r\n") {
+		return "", ErrInvalidEmail
+	}
+	local, domain := email[:at], strings.ToLower(email[at+1:])
+
+	if p.StripPlusTag {
+		if tag := strings.Index(local, "+"); tag > 0 {
+			local = local[:tag]
+		}
+	}
+
+	return local + "@" + domain, nil
+}
+
+// EmailKey returns the form addresses are compared in to tell whether they belong to the same account
+// every rune is case folded with the Unicode tables of Go, so all the stores agree on which addresses match
+// whatever the collation of their database, and two addresses that strings.EqualFold matches get the same key
+func EmailKey(email string) string {
+	return strings.Map(foldRune, email)
+}
+
+// foldRune returns the lowercase of the smallest rune that case folds to r, the same for every rune of its orbit
+func foldRune(r rune) rune {
+	smallest := r
+	for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+		smallest = min(smallest, f)
+	}
+	return unicode.ToLower(smallest)
+}
+
+// Lookups returns the forms an address may be stored under, the canonical form first
+// accounts registered before StripPlusTag was enabled keep their tag, so with it the address
+// as normalized without stripping follows when it differs
+func (p EmailPolicy) Lookups(email string) ([]string, error) {
+	canonical, err := p.Normalize(email)
+	if err != nil {
+		return nil, err
+	}
+	forms := []string{canonical}
+	if p.StripPlusTag {
+		if tagged, _ := (EmailPolicy{}).Normalize(email); tagged != canonical {
+			forms = append(forms, tagged)
+		}
+	}
+	return forms, nil
+}
@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// TOTPEnrollment is a user's authenticator app, registered with a shared secret
+// it only protects logins once a code has confirmed that the app was set up correctly
+type TOTPEnrollment struct {
+	UserID uuid.UUID
+	// Secret is the base32 encoded key shared with the authenticator app
+	Secret      string
+	ConfirmedAt *time.Time
+	// LastUsedStep is the time step of the last accepted code, so that no code is accepted twice
+	LastUsedStep int64
+	CreatedAt    time.Time
+}
+
+// Enabled reports whether the enrollment has been confirmed and is required at login
+func (e TOTPEnrollment) Enabled() bool {
+	return e.ConfirmedAt != nil
+}
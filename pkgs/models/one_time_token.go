@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// TokenPurpose is what a one-time token may be used for
+type TokenPurpose string
+
+const (
+	PurposeEmailVerification TokenPurpose = "email_verification"
+	PurposePasswordReset     TokenPurpose = "password_reset"
+)
+
+// OneTimeToken is a single-use, expiring token sent to a user out of band
+// only the hash of the token is stored
+type OneTimeToken struct {
+	Hash      string
+	UserID    uuid.UUID
+	Purpose   TokenPurpose
+	ExpiresAt time.Time
+	CreatedAt time.Time
+	UsedAt    *time.Time
+}
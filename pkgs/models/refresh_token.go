@@ -0,0 +1,25 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RefreshToken is the server-side record of an issued refresh token
+// every token rotated from the same login shares a FamilyID so that the whole
+// chain can be revoked when a used token is replayed
+type RefreshToken struct {
+	ID         string
+	FamilyID   string
+	UserID     uuid.UUID
+	ExpiresAt  time.Time
+	CreatedAt  time.Time
+	RevokedAt  *time.Time
+	ReplacedBy string
+}
+
+// Revoked reports whether the token has been used or explicitly revoked
+func (t RefreshToken) Revoked() bool {
+	return t.RevokedAt != nil
+}
@@ -0,0 +1,37 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// TodoStatus is the state a todo item is in
+type TodoStatus string
+
+const (
+	TodoPending    TodoStatus = "pending"
+	TodoInProgress TodoStatus = "in_progress"
+	TodoDone       TodoStatus = "done"
+)
+
+// Valid reports whether the status is one of the known todo statuses
+func (s TodoStatus) Valid() bool {
+	switch s {
+	case TodoPending, TodoInProgress, TodoDone:
+		return true
+	}
+	return false
+}
+
+// Todo is a single todo item owned by a user
+type Todo struct {
+	ID          uuid.UUID  `json:"id"`
+	OwnerID     uuid.UUID  `json:"owner_id"`
+	Title       string     `json:"title"`
+	Description string     `json:"description"`
+	Status      TodoStatus `json:"status"`
+	DueDate     *time.Time `json:"due_date,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
@@ -0,0 +1,40 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// WebAuthnCeremony is the WebAuthn operation a challenge was issued for
+type WebAuthnCeremony string
+
+const (
+	CeremonyRegistration WebAuthnCeremony = "registration"
+	CeremonyLogin        WebAuthnCeremony = "login"
+)
+
+// WebAuthnCredential is a passkey or security key registered by a user
+type WebAuthnCredential struct {
+	// ID is the credential ID chosen by the authenticator
+	ID     []byte
+	UserID uuid.UUID
+	// PublicKey is the COSE encoded key the authenticator signs with
+	PublicKey []byte
+	// SignCount is the last signature counter reported by the authenticator, zero if it keeps none
+	SignCount  uint32
+	CreatedAt  time.Time
+	LastUsedAt *time.Time
+}
+
+// WebAuthnSession is a challenge sent to a browser that has not been answered yet
+// only the hash of the challenge is stored
+type WebAuthnSession struct {
+	ChallengeHash string
+	// UserID is the user the ceremony is for, nil for a login with a discoverable credential
+	// where the user is only known from the credential used
+	UserID    *uuid.UUID
+	Ceremony  WebAuthnCeremony
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
@@ -0,0 +1,69 @@
+package password
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// BreachedList reports whether a password is known from a data breach
+type BreachedList interface {
+	Contains(password string) (bool, error)
+}
+
+// prefixDir implements BreachedList interface
+type prefixDir struct {
+	dir string
+}
+
+// NewPrefixDir creates a BreachedList backed by a directory of range files in the format of the
+// Have I Been Pwned range API. The SHA-1 of the password is split after five hex characters, the
+// file named after the prefix is read and the remaining suffix looked up in it, so the full hash
+// never has to be loaded or compared with the whole list. Each line of a file is SUFFIX:COUNT, e.g.
+//
+//	dir/5BAA6.txt
+//	1E4C9B93F3F0682250B6CF8331B7EE68FD8:9545824
+//
+// A missing range file means no breached password has that prefix, and entries with a count of zero are ignored.
+// A missing directory is an error rather than an empty list, so a wrong path does not let every password through.
+func NewPrefixDir(dir string) BreachedList {
+	return &prefixDir{dir: dir}
+}
+
+// Contains implements BreachedList.Contains
+func (p *prefixDir) Contains(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+
+	file, err := os.Open(filepath.Join(p.dir, prefix+".txt"))
+	if errors.Is(err, fs.ErrNotExist) {
+		if _, err := os.Stat(p.dir); err != nil {
+			return false, fmt.Errorf("breached password directory unavailable: %w", err)
+		}
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		//Padding entries added to hide the size of a range have a count of zero
+		line, count, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if strings.EqualFold(line, suffix) && count != "0" {
+			return true, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return false, fmt.Errorf("failed to read range file %s: %w", prefix, err)
+	}
+	return false, nil
+}
@@ -0,0 +1,67 @@
+package password
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeRange writes a range file to dir with the given lines
+func writeRange(t *testing.T, dir string, prefix string, lines string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, prefix+".txt"), []byte(lines), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+}
+
+func TestPrefixDirContains(t *testing.T) {
+	//SHA-1("password") is 5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8, looked up as 5BAA6 and the rest
+	//SHA-1("letmein") is B7A875FC1EA228B9061041B7CEC4BD3C52AB3CE3
+	//SHA-1("123456") is 7C4A8D09CA3762AF61E59520943DC26494F8941B
+	//SHA-1("Password") starts with 8BE3C, whose range does not hold the rest
+	dir := t.TempDir()
+	writeRange(t, dir, "5BAA6", "0018A45C4D1DEF81644B54AB7F969B88D65:1\r\n1e4c9b93f3f0682250b6cf8331b7ee68fd8:9545824\r\n")
+	writeRange(t, dir, "B7A87", "5FC1EA228B9061041B7CEC4BD3C52AB3CE3:0\n")
+	writeRange(t, dir, "7C4A8", "D09CA3762AF61E59520943DC26494F8941B\n")
+	writeRange(t, dir, "8BE3C", "0000000000000000000000000000000000:3\n")
+	list := NewPrefixDir(dir)
+
+	tests := []struct {
+		name     string
+		password string
+		want     bool
+	}{
+		{"suffix in the range, any case", "password", true},
+		{"padding entry with a count of zero", "letmein", false},
+		{"entry without a count", "123456", true},
+		{"no range file for the prefix", "correct horse battery staple", false},
+		{"prefix matches but not the suffix", "Password", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := list.Contains(tt.password)
+			if err != nil {
+				t.Fatalf("Contains: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Contains(%q) = %v, want %v", tt.password, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrefixDirUnavailable(t *testing.T) {
+	//A directory that does not exist is a misconfiguration, not a list without breached passwords
+	if _, err := NewPrefixDir(filepath.Join(t.TempDir(), "missing")).Contains("password"); err == nil {
+		t.Errorf("Contains succeeded without the directory")
+	}
+
+	//A range file that cannot be read is an error too
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "5BAA6.txt"), 0o700); err != nil {
+		t.Fatalf("Mkdir: %v", err)
+	}
+	if _, err := NewPrefixDir(dir).Contains("password"); err == nil {
+		t.Errorf("Contains succeeded with an unreadable range file")
+	}
+}
@@ -0,0 +1,134 @@
+// Package password checks new passwords against a configurable policy before they are hashed.
+package password
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Violation codes identify which rule a password broke
+const (
+	CodeTooShort         = "too_short"
+	CodeTooLong          = "too_long"
+	CodeMissingUppercase = "missing_uppercase"
+	CodeMissingLowercase = "missing_lowercase"
+	CodeMissingDigit     = "missing_digit"
+	CodeMissingSymbol    = "missing_symbol"
+	CodeContainsEmail    = "contains_email"
+	CodeBreached         = "breached"
+)
+
+// Violation is a single rule a password does not satisfy
+type Violation struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Policy holds the rules a new password must satisfy
+type Policy struct {
+	// MinLength is the minimum number of characters
+	MinLength int
+	// MaxLength is the maximum number of characters, zero allows any length
+	MaxLength int
+	// MaxBytes is the maximum length in bytes, zero allows any length
+	// bcrypt ignores everything past 72 bytes, so it should be 72 when hashing with bcrypt
+	MaxBytes int
+	// RequireUppercase, RequireLowercase, RequireDigit and RequireSymbol each require
+	// at least one character of that class
+	RequireUppercase bool
+	RequireLowercase bool
+	RequireDigit     bool
+	RequireSymbol    bool
+	// RejectEmail refuses passwords that contain the user's email address or its local part
+	RejectEmail bool
+	// Breached is checked for passwords known from data breaches, nil skips the check
+	Breached BreachedList
+}
+
+// DefaultPolicy returns the length limits recommended by NIST SP 800-63B,
+// with no character class rules and no breached password list
+func DefaultPolicy() Policy {
+	return Policy{
+		MinLength:   8,
+		MaxLength:   64,
+		RejectEmail: true,
+	}
+}
+
+// Check returns every rule password breaks for the user with the given email
+// an empty list means the password is accepted, the error is only set when the breached list cannot be read
+func (p Policy) Check(password string, email string) ([]Violation, error) {
+	violations := []Violation{}
+	add := func(code string, format string, args ...any) {
+		violations = append(violations, Violation{Code: code, Message: fmt.Sprintf(format, args...)})
+	}
+
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		add(CodeTooShort, "Password must be at least %d characters long", p.MinLength)
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		add(CodeTooLong, "Password must be at most %d characters long", p.MaxLength)
+	} else if p.MaxBytes > 0 && len(password) > p.MaxBytes {
+		add(CodeTooLong, "Password must be at most %d bytes long", p.MaxBytes)
+	}
+
+	var upper, lower, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			symbol = true
+		}
+	}
+	if p.RequireUppercase && !upper {
+		add(CodeMissingUppercase, "Password must contain an uppercase letter")
+	}
+	if p.RequireLowercase && !lower {
+		add(CodeMissingLowercase, "Password must contain a lowercase letter")
+	}
+	if p.RequireDigit && !digit {
+		add(CodeMissingDigit, "Password must contain a digit")
+	}
+	if p.RequireSymbol && !symbol {
+		add(CodeMissingSymbol, "Password must contain a symbol")
+	}
+
+	if p.RejectEmail && containsEmail(password, email) {
+		add(CodeContainsEmail, "Password must not contain your email address")
+	}
+
+	if p.Breached != nil {
+		breached, err := p.Breached.Contains(password)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check breached passwords: %w", err)
+		}
+		if breached {
+			add(CodeBreached, "Password has appeared in a data breach, choose another one")
+		}
+	}
+
+	return violations, nil
+}
+
+// containsEmail reports whether password contains the email or its local part, ignoring case
+// local parts shorter than three characters match too many passwords to be useful
+func containsEmail(password string, email string) bool {
+	if email == "" {
+		return false
+	}
+	password = strings.ToLower(password)
+	email = strings.ToLower(email)
+	if strings.Contains(password, email) {
+		return true
+	}
+	local, _, _ := strings.Cut(email, "@")
+	return utf8.RuneCountInString(local) >= 3 && strings.Contains(password, local)
+}
@@ -0,0 +1,98 @@
+package password
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// breachedStub is a BreachedList holding a fixed set of passwords, or failing with err
+type breachedStub struct {
+	passwords []string
+	err       error
+}
+
+func (b breachedStub) Contains(password string) (bool, error) {
+	return slices.Contains(b.passwords, password), b.err
+}
+
+// codes returns the codes of violations in order
+func codes(violations []Violation) []string {
+	var codes []string
+	for _, v := range violations {
+		codes = append(codes, v.Code)
+	}
+	return codes
+}
+
+func TestPolicyCheck(t *testing.T) {
+	strict := Policy{
+		MinLength:        8,
+		MaxLength:        16,
+		RequireUppercase: true,
+		RequireLowercase: true,
+		RequireDigit:     true,
+		RequireSymbol:    true,
+		RejectEmail:      true,
+		Breached:         breachedStub{passwords: []string{"Password1!"}},
+	}
+
+	tests := []struct {
+		name     string
+		policy   Policy
+		password string
+		email    string
+		want     []string
+	}{
+		{"accepted", strict, "Tr0ub4dor&3", "alice@example.com", nil},
+		{"too short", strict, "Ab1!", "alice@example.com", []string{CodeTooShort}},
+		{"too long", strict, "Tr0ub4dor&3-Tr0ub4dor&3", "alice@example.com", []string{CodeTooLong}},
+		{"length counts characters, not bytes", DefaultPolicy(), "ééééééé", "", []string{CodeTooShort}},
+		{"too many bytes", Policy{MaxBytes: 72}, strings.Repeat("é", 37), "", []string{CodeTooLong}},
+		{"character limit reported before bytes", Policy{MaxLength: 4, MaxBytes: 4}, "ééééé", "", []string{CodeTooLong}},
+		{"missing uppercase", strict, "tr0ub4dor&3", "alice@example.com", []string{CodeMissingUppercase}},
+		{"missing lowercase", strict, "TR0UB4DOR&3", "alice@example.com", []string{CodeMissingLowercase}},
+		{"missing digit", strict, "Troubador&three", "alice@example.com", []string{CodeMissingDigit}},
+		{"missing symbol", strict, "Tr0ub4dor3", "alice@example.com", []string{CodeMissingSymbol}},
+		{"space counts as a symbol", strict, "Tr0ub4dor 3", "alice@example.com", nil},
+		{"every class missing", strict, "        ", "", []string{CodeMissingUppercase, CodeMissingLowercase, CodeMissingDigit}},
+		{"contains the email", DefaultPolicy(), "xALICE@example.comx", "alice@example.com", []string{CodeContainsEmail}},
+		{"contains the local part", DefaultPolicy(), "i-am-Alice-99", "alice@example.com", []string{CodeContainsEmail}},
+		{"short local part is allowed", DefaultPolicy(), "bobsled racer", "bo@example.com", nil},
+		{"email rule disabled", Policy{MinLength: 8}, "alice is great", "alice@example.com", nil},
+		{"no email to compare with", DefaultPolicy(), "alice is great", "", nil},
+		{"breached", strict, "Password1!", "alice@example.com", []string{CodeBreached}},
+		{"several rules", strict, "alice", "alice@example.com", []string{CodeTooShort, CodeMissingUppercase, CodeMissingDigit, CodeMissingSymbol, CodeContainsEmail}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			violations, err := tt.policy.Check(tt.password, tt.email)
+			if err != nil {
+				t.Fatalf("Check: %v", err)
+			}
+			if got := codes(violations); !slices.Equal(got, tt.want) {
+				t.Errorf("Check(%q) = %v, want %v", tt.password, got, tt.want)
+			}
+			for _, v := range violations {
+				if v.Message == "" {
+					t.Errorf("violation %s has no message", v.Code)
+				}
+			}
+		})
+	}
+}
+
+func TestPolicyCheckBreachedUnavailable(t *testing.T) {
+	unavailable := errors.New("unavailable")
+	policy := DefaultPolicy()
+	policy.Breached = breachedStub{err: unavailable}
+
+	violations, err := policy.Check("correct horse", "alice@example.com")
+	if !errors.Is(err, unavailable) {
+		t.Errorf("Check returned %v, want the error of the breached list", err)
+	}
+	if violations != nil {
+		t.Errorf("Check returned violations %v along with the error", violations)
+	}
+}
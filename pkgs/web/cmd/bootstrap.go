@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"joshuamURD/go-auth-api/pkgs/db"
+	"joshuamURD/go-auth-api/pkgs/models"
+)
+
+// bootstrapAdmin promotes an existing user to admin
+// it only works while there is no admin yet, after that roles are managed through the admin API
+// the user is looked up under every form the policy may have stored the email in, like Login does
+// the check and the promotion are atomic, so concurrent runs cannot create two admins
+func bootstrapAdmin(ctx context.Context, database db.Database, policy models.EmailPolicy, email string) error {
+	forms, err := policy.Lookups(email)
+	if err != nil {
+		return err
+	}
+	user, err := db.GetByAnyEmail(ctx, database, forms)
+	if err != nil {
+		return err
+	}
+
+	if err := database.PromoteFirstAdmin(ctx, user.ID); errors.Is(err, db.ErrAdminExists) {
+		return errors.New("an admin already exists, use the admin API to assign roles")
+	} else if err != nil {
+		return err
+	}
+
+	fmt.Printf("User %s (%s) is now an admin\n", user.Email, user.ID)
+	return nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"joshuamURD/go-auth-api/pkgs/db/memory"
+	"joshuamURD/go-auth-api/pkgs/models"
+
+	"github.com/google/uuid"
+)
+
+func TestBootstrapAdminNormalizesEmail(t *testing.T) {
+	ctx := context.Background()
+	users := memory.NewStore().Users
+	policy := models.EmailPolicy{StripPlusTag: true}
+
+	user := models.User{ID: uuid.New(), Email: "alice@example.com", Role: models.RoleUser, CreatedAt: time.Now(), UpdatedAt: time.Now()}
+	if _, err := users.Create(ctx, user); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	if err := bootstrapAdmin(ctx, users, policy, " Alice+admin@Example.COM "); err != nil {
+		t.Fatalf("bootstrapAdmin: %v", err)
+	}
+	got, err := users.GetByID(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("GetByID: %v", err)
+	}
+	if got.Role != models.RoleAdmin {
+		t.Errorf("got role %q, want admin", got.Role)
+	}
+
+	if err := bootstrapAdmin(ctx, users, policy, "alice@example.com"); err == nil {
+		t.Errorf("bootstrapAdmin succeeded while an admin exists")
+	}
+}
+
+func TestBootstrapAdminFindsTaggedAddress(t *testing.T) {
+	ctx := context.Background()
+	users := memory.NewStore().Users
+
+	//The account was registered before stripping was enabled, so it is stored with its tag
+	user := models.User{ID: uuid.New(), Email: "alice+admin@example.com", Role: models.RoleUser, CreatedAt: time.Now(), UpdatedAt: time.Now()}
+	if _, err := users.Create(ctx, user); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	if err := bootstrapAdmin(ctx, users, models.EmailPolicy{StripPlusTag: true}, "alice+admin@example.com"); err != nil {
+		t.Fatalf("bootstrapAdmin: %v", err)
+	}
+	all, err := users.GetAll(ctx)
+	if err != nil {
+		t.Fatalf("GetAll: %v", err)
+	}
+	if len(all) != 1 || all[0].Role != models.RoleAdmin {
+		t.Errorf("got users %+v, want the tagged account promoted", all)
+	}
+}
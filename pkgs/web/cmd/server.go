@@ -1,63 +1,65 @@
 package main
 
 import (
+	"context"
 	"fmt"
-	"joshuamURD/go-auth-api/pkgs/auth"
-	"joshuamURD/go-auth-api/pkgs/controllers"
+	"joshuamURD/go-auth-api/pkgs/app"
 	"joshuamURD/go-auth-api/pkgs/db"
-	"joshuamURD/go-auth-api/pkgs/hash"
 	"log"
-	"net/http"
 	"os"
-
-	"golang.org/x/crypto/bcrypt"
-	_ "modernc.org/sqlite" // Import with blank identifier to register the driver
 )
 
 func main() {
-	// The database is initialised with the path to the database file
-	db.Initialize(db.Config{Path: "test.db"})
-	database := db.GetInstance()
-
-	//Initialises a hasher with the default cost of bcrypt
-	hasher := hash.NewBcryptHasher(bcrypt.DefaultCost)
-
-	//Initialises the key manager with the private key path
-	keyManager := auth.NewKeyManager("private.pem", "public.pem")
+	//Loads the settings, the defaults can be adjusted through the environment
+	config, err := app.ConfigFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
 
-	//Ensures that the keys exist
-	if err := keyManager.EnsureKeys(); err != nil {
-		log.Fatalf("Failed to ensure keys: %v", err)
+	//Runs a one-off command instead of the server when one is given
+	//bootstrap-admin <email> promotes the first admin
+	//migrate up|down [steps]|status manages the schema
+	if len(os.Args) > 1 {
+		if err := runCommand(config, os.Args[1:]); err != nil {
+			log.Fatalf("%s failed: %v", os.Args[1], err)
+		}
+		return
 	}
-	fmt.Println("Keys ensured")
 
-	//Loads the private key
-	privateKey, err := keyManager.LoadPrivateKey()
+	//Opens the database, applying pending migrations, loads the keys and wires up the services
+	application, err := app.New(config)
 	if err != nil {
-		log.Fatalf("Failed to load private key: %v", err)
+		log.Fatalf("Failed to start: %v", err)
 	}
+	defer application.Close()
 
-	//Initialises the auth service with the private key
-	authService := auth.NewJWTAuthService(privateKey)
-
-	//Intialise the controllers with the hasher and the database
-	//The controller is used to handle the requests and responses
-	registerController := controllers.NewController(hasher, &database, authService)
-
-	//Initialises the mux and add the routes to it
-	mux := http.NewServeMux()
-	mux.HandleFunc("/register", registerController.Register)
-	mux.HandleFunc("/login", registerController.Login)
+	log.Printf("Server is running on %s", config.Addr)
 
-	//Initialises the server with the mux and the port and the error log
-	server := http.Server{
-		Addr:     "127.0.0.1:8080",
-		Handler:  mux,
-		ErrorLog: log.New(os.Stderr, "ErrorLog: ", log.Lshortfile),
+	//Starts the server
+	if err := application.Run(context.Background()); err != nil {
+		log.Printf("Server stopped: %v", err)
 	}
+}
 
-	log.Printf("Server is running on %s", server.Addr)
+// runCommand runs a subcommand against the database only
+// pending migrations are applied first, except by the migrate command itself
+func runCommand(config app.Config, args []string) error {
+	config.DB.Migrate = args[0] != "migrate"
+	store, err := db.Open(config.DB)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
 
-	//Starts the server
-	server.ListenAndServe()
+	switch args[0] {
+	case "bootstrap-admin":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: %s bootstrap-admin <email>", os.Args[0])
+		}
+		return bootstrapAdmin(context.Background(), store.Users, config.Controller.EmailPolicy, args[1])
+	case "migrate":
+		return runMigrate(store.Migrator, args[1:], os.Stdout)
+	default:
+		return fmt.Errorf("unknown command %q", args[0])
+	}
 }
@@ -0,0 +1,13 @@
+package webauthn
+
+// DecodeCBOR exposes decodeCBOR to the fuzz tests, which live in webauthn_test to seed from webauthntest
+var DecodeCBOR = decodeCBOR
+
+// VerifyWithCOSEKey parses a COSE_Key and checks a signature over message with it
+func VerifyWithCOSEKey(cose, message, signature []byte) (bool, error) {
+	key, err := parsePublicKey(cose)
+	if err != nil {
+		return false, err
+	}
+	return key.verify(message, signature), nil
+}
@@ -0,0 +1,230 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// URLEncoded is binary data sent to and from the browser as unpadded base64url,
+// the encoding of the WebAuthn JSON serialization
+type URLEncoded []byte
+
+// MarshalJSON encodes the data as an unpadded base64url string
+func (u URLEncoded) MarshalJSON() ([]byte, error) {
+	return json.Marshal(base64.RawURLEncoding.EncodeToString(u))
+}
+
+// UnmarshalJSON decodes a base64url string, with or without padding
+func (u *URLEncoded) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return fmt.Errorf("invalid base64url: %w", err)
+	}
+	*u = decoded
+	return nil
+}
+
+// CredentialDescriptor identifies a credential in allowCredentials and excludeCredentials
+type CredentialDescriptor struct {
+	Type string     `json:"type"`
+	ID   URLEncoded `json:"id"`
+}
+
+// RelyingPartyEntity names the relying party to the authenticator
+type RelyingPartyEntity struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// UserEntity describes the account a credential is created for
+// ID is the user handle the authenticator returns when the credential is used
+type UserEntity struct {
+	ID          URLEncoded `json:"id"`
+	Name        string     `json:"name"`
+	DisplayName string     `json:"displayName"`
+}
+
+// CredentialParameter is a credential type and signature algorithm the relying party accepts
+type CredentialParameter struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+// AuthenticatorSelection states the requirements on the authenticator that creates a credential
+type AuthenticatorSelection struct {
+	ResidentKey      string `json:"residentKey"`
+	UserVerification string `json:"userVerification"`
+}
+
+// CreationOptions are the options of navigator.credentials.create, in the form accepted by
+// PublicKeyCredential.parseCreationOptionsFromJSON
+type CreationOptions struct {
+	Challenge              URLEncoded             `json:"challenge"`
+	RP                     RelyingPartyEntity     `json:"rp"`
+	User                   UserEntity             `json:"user"`
+	PubKeyCredParams       []CredentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection AuthenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                 `json:"attestation"`
+}
+
+// RequestOptions are the options of navigator.credentials.get, in the form accepted by
+// PublicKeyCredential.parseRequestOptionsFromJSON
+type RequestOptions struct {
+	Challenge        URLEncoded             `json:"challenge"`
+	Timeout          int64                  `json:"timeout"`
+	RPID             string                 `json:"rpId"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+// RegistrationResponse is the credential returned by navigator.credentials.create, as serialized by toJSON
+type RegistrationResponse struct {
+	ID       string     `json:"id"`
+	RawID    URLEncoded `json:"rawId"`
+	Type     string     `json:"type"`
+	Response struct {
+		ClientDataJSON    URLEncoded `json:"clientDataJSON"`
+		AttestationObject URLEncoded `json:"attestationObject"`
+		Transports        []string   `json:"transports,omitempty"`
+	} `json:"response"`
+}
+
+// AssertionResponse is the credential returned by navigator.credentials.get, as serialized by toJSON
+type AssertionResponse struct {
+	ID       string     `json:"id"`
+	RawID    URLEncoded `json:"rawId"`
+	Type     string     `json:"type"`
+	Response struct {
+		ClientDataJSON    URLEncoded `json:"clientDataJSON"`
+		AuthenticatorData URLEncoded `json:"authenticatorData"`
+		Signature         URLEncoded `json:"signature"`
+		UserHandle        URLEncoded `json:"userHandle,omitempty"`
+	} `json:"response"`
+}
+
+// ChallengeHash returns the hash of the challenge the response answers, under which its session is stored
+func (r RegistrationResponse) ChallengeHash() (string, error) {
+	return challengeHash(r.Response.ClientDataJSON)
+}
+
+// ChallengeHash returns the hash of the challenge the response answers, under which its session is stored
+func (r AssertionResponse) ChallengeHash() (string, error) {
+	return challengeHash(r.Response.ClientDataJSON)
+}
+
+// clientData holds the fields of the client data JSON that are checked
+type clientData struct {
+	Type        string     `json:"type"`
+	Challenge   URLEncoded `json:"challenge"`
+	Origin      string     `json:"origin"`
+	CrossOrigin bool       `json:"crossOrigin"`
+}
+
+func parseClientData(data []byte) (clientData, error) {
+	var cd clientData
+	if err := json.Unmarshal(data, &cd); err != nil {
+		return cd, fmt.Errorf("%w: malformed client data: %v", ErrInvalidResponse, err)
+	}
+	if len(cd.Challenge) == 0 {
+		return cd, fmt.Errorf("%w: client data has no challenge", ErrInvalidResponse)
+	}
+	return cd, nil
+}
+
+func challengeHash(clientDataJSON []byte) (string, error) {
+	cd, err := parseClientData(clientDataJSON)
+	if err != nil {
+		return "", err
+	}
+	return HashChallenge(cd.Challenge), nil
+}
+
+// Authenticator data flags
+const (
+	flagUserPresent       = 0x01
+	flagUserVerified      = 0x04
+	flagBackupEligible    = 0x08
+	flagBackupState       = 0x10
+	flagAttestedData      = 0x40
+	flagExtensionData     = 0x80
+	authDataMinLength     = 37
+	maxCredentialIDLength = 1023
+)
+
+// authenticatorData is the parsed authenticator data of a registration or assertion
+type authenticatorData struct {
+	rpIDHash     []byte
+	flags        byte
+	signCount    uint32
+	credentialID []byte
+	publicKey    []byte
+}
+
+// parseAuthenticatorData splits authenticator data into its fields
+// see https://www.w3.org/TR/webauthn-3/#sctn-authenticator-data
+func parseAuthenticatorData(data []byte) (authenticatorData, error) {
+	var ad authenticatorData
+	if len(data) < authDataMinLength {
+		return ad, fmt.Errorf("%w: authenticator data is too short", ErrInvalidResponse)
+	}
+	ad.rpIDHash = data[:32]
+	ad.flags = data[32]
+	ad.signCount = binary.BigEndian.Uint32(data[33:37])
+	rest := data[authDataMinLength:]
+
+	if ad.flags&flagBackupState != 0 && ad.flags&flagBackupEligible == 0 {
+		return ad, fmt.Errorf("%w: backed up credential is not backup eligible", ErrInvalidResponse)
+	}
+
+	if ad.flags&flagAttestedData != 0 {
+		//The AAGUID identifies the authenticator model, which is not used
+		if len(rest) < 18 {
+			return ad, fmt.Errorf("%w: attested credential data is too short", ErrInvalidResponse)
+		}
+		idLength := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if idLength == 0 || idLength > maxCredentialIDLength || idLength > len(rest) {
+			return ad, fmt.Errorf("%w: invalid credential ID length", ErrInvalidResponse)
+		}
+		ad.credentialID = rest[:idLength]
+		rest = rest[idLength:]
+
+		_, after, err := decodeCBOR(rest)
+		if err != nil {
+			return ad, fmt.Errorf("%w: malformed credential public key: %v", ErrInvalidResponse, err)
+		}
+		ad.publicKey = rest[:len(rest)-len(after)]
+		rest = after
+	}
+
+	if ad.flags&flagExtensionData != 0 {
+		//Extension outputs are not used, but must be well formed
+		_, after, err := decodeCBOR(rest)
+		if err != nil {
+			return ad, fmt.Errorf("%w: malformed extension data: %v", ErrInvalidResponse, err)
+		}
+		rest = after
+	}
+
+	if len(rest) != 0 {
+		return ad, fmt.Errorf("%w: trailing authenticator data", ErrInvalidResponse)
+	}
+	return ad, nil
+}
+
+// hasRPID reports whether the authenticator data was made for the relying party ID
+func (ad authenticatorData) hasRPID(rpID string) bool {
+	sum := sha256.Sum256([]byte(rpID))
+	return bytes.Equal(ad.rpIDHash, sum[:])
+}
@@ -0,0 +1,320 @@
+// Package webauthn implements the relying party side of WebAuthn registration and assertion
+// ceremonies for passkeys and security keys. Attestation is not requested, so credentials are
+// trusted on first use like a password would be, and ES256, EdDSA and RS256 keys are supported.
+//
+// The package checks responses but keeps no state: the caller stores the hash of each challenge
+// until it is answered, and the credentials that are registered.
+package webauthn
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"joshuamURD/go-auth-api/pkgs/models"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrInvalidResponse is returned for a response that does not answer the ceremony it claims to
+	ErrInvalidResponse = errors.New("invalid webauthn response")
+	// ErrSignCountRegressed is returned when an authenticator reports a signature counter that did not
+	// increase, which suggests the credential has been cloned
+	ErrSignCountRegressed = errors.New("webauthn signature counter did not increase")
+)
+
+// Config describes the relying party
+type Config struct {
+	// RPID is the domain credentials are scoped to, the host of the origins or a parent of it
+	RPID string
+	// RPName is the name authenticators show for the relying party
+	RPName string
+	// Origins are the origins of the pages allowed to run the ceremonies, e.g. https://example.com
+	Origins []string
+	// RequireUserVerification refuses authenticators that did not check a PIN or biometric
+	RequireUserVerification bool
+	// Timeout is how long a challenge stays valid
+	Timeout time.Duration
+}
+
+// DefaultConfig returns a config for a relying party served from localhost
+func DefaultConfig() Config {
+	return Config{
+		RPID:    "localhost",
+		RPName:  "go-auth-api",
+		Origins: []string{"http://localhost:8080"},
+		Timeout: 5 * time.Minute,
+	}
+}
+
+// RelyingParty builds ceremony options and checks the responses to them
+type RelyingParty struct {
+	config Config
+}
+
+// NewRelyingParty creates a new RelyingParty
+// It returns an error if the config has no RP ID, origins or timeout
+func NewRelyingParty(config Config) (*RelyingParty, error) {
+	if config.RPID == "" {
+		return nil, errors.New("webauthn: RP ID is required")
+	}
+	if len(config.Origins) == 0 {
+		return nil, errors.New("webauthn: at least one origin is required")
+	}
+	if config.Timeout <= 0 {
+		return nil, errors.New("webauthn: timeout must be positive")
+	}
+	if config.RPName == "" {
+		config.RPName = config.RPID
+	}
+	config.Origins = slices.Clone(config.Origins)
+	return &RelyingParty{config: config}, nil
+}
+
+// HashChallenge returns the hash under which a challenge is stored
+// challenges are random so a fast hash is sufficient
+func HashChallenge(challenge []byte) string {
+	sum := sha256.Sum256(challenge)
+	return hex.EncodeToString(sum[:])
+}
+
+// UserHandle returns the user handle of a user, the bytes of their ID
+func UserHandle(userID uuid.UUID) []byte {
+	return userID[:]
+}
+
+// BeginRegistration returns the options for creating a credential for a user along with the
+// session to store until the response arrives. Existing credentials are excluded so the same
+// authenticator is not registered twice.
+func (rp *RelyingParty) BeginRegistration(user models.User, existing []models.WebAuthnCredential) (CreationOptions, models.WebAuthnSession, error) {
+	challenge, session, err := rp.newSession(&user.ID, models.CeremonyRegistration)
+	if err != nil {
+		return CreationOptions{}, session, err
+	}
+
+	params := make([]CredentialParameter, 0, len(supportedAlgorithms))
+	for _, alg := range supportedAlgorithms {
+		params = append(params, CredentialParameter{Type: "public-key", Alg: alg})
+	}
+
+	return CreationOptions{
+		Challenge: challenge,
+		RP:        RelyingPartyEntity{ID: rp.config.RPID, Name: rp.config.RPName},
+		User: UserEntity{
+			ID:          UserHandle(user.ID),
+			Name:        user.Email,
+			DisplayName: user.Email,
+		},
+		PubKeyCredParams:   params,
+		Timeout:            rp.config.Timeout.Milliseconds(),
+		ExcludeCredentials: descriptors(existing),
+		AuthenticatorSelection: AuthenticatorSelection{
+			ResidentKey:      "preferred",
+			UserVerification: rp.userVerification(),
+		},
+		Attestation: "none",
+	}, session, nil
+}
+
+// FinishRegistration checks a response to the options of BeginRegistration and returns the new credential
+// the session must be the one stored under the response's ChallengeHash, already consumed by the caller.
+// Attestation statements are not verified, since none is requested and no decision depends on the
+// authenticator model.
+func (rp *RelyingParty) FinishRegistration(session models.WebAuthnSession, response RegistrationResponse) (models.WebAuthnCredential, error) {
+	if session.Ceremony != models.CeremonyRegistration || session.UserID == nil {
+		return models.WebAuthnCredential{}, fmt.Errorf("%w: not a registration challenge", ErrInvalidResponse)
+	}
+	if response.Type != "public-key" {
+		return models.WebAuthnCredential{}, fmt.Errorf("%w: credential type %q", ErrInvalidResponse, response.Type)
+	}
+	if err := rp.checkClientData(session, response.Response.ClientDataJSON, "webauthn.create"); err != nil {
+		return models.WebAuthnCredential{}, err
+	}
+
+	value, rest, err := decodeCBOR(response.Response.AttestationObject)
+	if err != nil || len(rest) != 0 {
+		return models.WebAuthnCredential{}, fmt.Errorf("%w: malformed attestation object", ErrInvalidResponse)
+	}
+	attestation, _ := value.(map[any]any)
+	if _, ok := attestation["fmt"].(string); !ok {
+		return models.WebAuthnCredential{}, fmt.Errorf("%w: attestation object has no format", ErrInvalidResponse)
+	}
+	rawAuthData, ok := attestation["authData"].([]byte)
+	if !ok {
+		return models.WebAuthnCredential{}, fmt.Errorf("%w: attestation object has no authenticator data", ErrInvalidResponse)
+	}
+
+	authData, err := parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return models.WebAuthnCredential{}, err
+	}
+	if err := rp.checkAuthenticatorData(authData); err != nil {
+		return models.WebAuthnCredential{}, err
+	}
+	if authData.flags&flagAttestedData == 0 {
+		return models.WebAuthnCredential{}, fmt.Errorf("%w: no attested credential data", ErrInvalidResponse)
+	}
+	if subtle.ConstantTimeCompare(authData.credentialID, response.RawID) != 1 {
+		return models.WebAuthnCredential{}, fmt.Errorf("%w: credential ID does not match the raw ID", ErrInvalidResponse)
+	}
+	if _, err := parsePublicKey(authData.publicKey); err != nil {
+		return models.WebAuthnCredential{}, fmt.Errorf("%w: %v", ErrInvalidResponse, err)
+	}
+
+	return models.WebAuthnCredential{
+		ID:        append([]byte(nil), authData.credentialID...),
+		UserID:    *session.UserID,
+		PublicKey: append([]byte(nil), authData.publicKey...),
+		SignCount: authData.signCount,
+		CreatedAt: time.Now(),
+	}, nil
+}
+
+// BeginLogin returns the options for an assertion along with the session to store until the response
+// arrives. With a user the assertion is limited to their credentials, without one any discoverable
+// credential for the relying party can answer it.
+func (rp *RelyingParty) BeginLogin(userID *uuid.UUID, credentials []models.WebAuthnCredential) (RequestOptions, models.WebAuthnSession, error) {
+	challenge, session, err := rp.newSession(userID, models.CeremonyLogin)
+	if err != nil {
+		return RequestOptions{}, session, err
+	}
+
+	return RequestOptions{
+		Challenge:        challenge,
+		Timeout:          rp.config.Timeout.Milliseconds(),
+		RPID:             rp.config.RPID,
+		AllowCredentials: descriptors(credentials),
+		UserVerification: rp.userVerification(),
+	}, session, nil
+}
+
+// FinishLogin checks a response to the options of BeginLogin made with the stored credential it names
+// and returns the signature counter to store. The session must be the one stored under the response's
+// ChallengeHash, already consumed by the caller.
+func (rp *RelyingParty) FinishLogin(session models.WebAuthnSession, credential models.WebAuthnCredential, response AssertionResponse) (uint32, error) {
+	if session.Ceremony != models.CeremonyLogin {
+		return 0, fmt.Errorf("%w: not a login challenge", ErrInvalidResponse)
+	}
+	if response.Type != "public-key" {
+		return 0, fmt.Errorf("%w: credential type %q", ErrInvalidResponse, response.Type)
+	}
+	if subtle.ConstantTimeCompare(credential.ID, response.RawID) != 1 {
+		return 0, fmt.Errorf("%w: response is not from the credential", ErrInvalidResponse)
+	}
+
+	//A challenge issued for a user can only be answered with their credentials, and a discoverable
+	//credential must say which user it belongs to
+	if session.UserID != nil && *session.UserID != credential.UserID {
+		return 0, fmt.Errorf("%w: credential belongs to another user", ErrInvalidResponse)
+	}
+	userHandle := response.Response.UserHandle
+	if session.UserID == nil && len(userHandle) == 0 {
+		return 0, fmt.Errorf("%w: discoverable credential returned no user handle", ErrInvalidResponse)
+	}
+	if len(userHandle) != 0 && subtle.ConstantTimeCompare(userHandle, UserHandle(credential.UserID)) != 1 {
+		return 0, fmt.Errorf("%w: user handle does not match the credential", ErrInvalidResponse)
+	}
+
+	clientDataJSON := response.Response.ClientDataJSON
+	if err := rp.checkClientData(session, clientDataJSON, "webauthn.get"); err != nil {
+		return 0, err
+	}
+	authData, err := parseAuthenticatorData(response.Response.AuthenticatorData)
+	if err != nil {
+		return 0, err
+	}
+	if err := rp.checkAuthenticatorData(authData); err != nil {
+		return 0, err
+	}
+
+	key, err := parsePublicKey(credential.PublicKey)
+	if err != nil {
+		return 0, fmt.Errorf("stored credential public key: %w", err)
+	}
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := append(append([]byte(nil), response.Response.AuthenticatorData...), clientDataHash[:]...)
+	if !key.verify(signed, response.Response.Signature) {
+		return 0, fmt.Errorf("%w: invalid signature", ErrInvalidResponse)
+	}
+
+	//Authenticators without a counter always report zero, any other must count up
+	if (authData.signCount != 0 || credential.SignCount != 0) && authData.signCount <= credential.SignCount {
+		return 0, ErrSignCountRegressed
+	}
+	return authData.signCount, nil
+}
+
+// newSession generates a challenge and the session it is stored as
+func (rp *RelyingParty) newSession(userID *uuid.UUID, ceremony models.WebAuthnCeremony) (URLEncoded, models.WebAuthnSession, error) {
+	challenge := make([]byte, 32)
+	if _, err := rand.Read(challenge); err != nil {
+		return nil, models.WebAuthnSession{}, fmt.Errorf("failed to generate challenge: %w", err)
+	}
+
+	now := time.Now()
+	return challenge, models.WebAuthnSession{
+		ChallengeHash: HashChallenge(challenge),
+		UserID:        userID,
+		Ceremony:      ceremony,
+		ExpiresAt:     now.Add(rp.config.Timeout),
+		CreatedAt:     now,
+	}, nil
+}
+
+// checkClientData checks that the client data answers the session's challenge from an allowed origin
+func (rp *RelyingParty) checkClientData(session models.WebAuthnSession, clientDataJSON []byte, ceremonyType string) error {
+	cd, err := parseClientData(clientDataJSON)
+	if err != nil {
+		return err
+	}
+	if cd.Type != ceremonyType {
+		return fmt.Errorf("%w: client data type %q", ErrInvalidResponse, cd.Type)
+	}
+	if subtle.ConstantTimeCompare([]byte(HashChallenge(cd.Challenge)), []byte(session.ChallengeHash)) != 1 {
+		return fmt.Errorf("%w: challenge does not match", ErrInvalidResponse)
+	}
+	if !slices.Contains(rp.config.Origins, cd.Origin) {
+		return fmt.Errorf("%w: origin %q is not allowed", ErrInvalidResponse, cd.Origin)
+	}
+	if cd.CrossOrigin {
+		return fmt.Errorf("%w: cross-origin ceremonies are not allowed", ErrInvalidResponse)
+	}
+	return nil
+}
+
+// checkAuthenticatorData checks that the authenticator data is for this relying party and that
+// the user was present, and verified if that is required
+func (rp *RelyingParty) checkAuthenticatorData(authData authenticatorData) error {
+	if !authData.hasRPID(rp.config.RPID) {
+		return fmt.Errorf("%w: RP ID hash does not match", ErrInvalidResponse)
+	}
+	if authData.flags&flagUserPresent == 0 {
+		return fmt.Errorf("%w: user was not present", ErrInvalidResponse)
+	}
+	if rp.config.RequireUserVerification && authData.flags&flagUserVerified == 0 {
+		return fmt.Errorf("%w: user was not verified", ErrInvalidResponse)
+	}
+	return nil
+}
+
+func (rp *RelyingParty) userVerification() string {
+	if rp.config.RequireUserVerification {
+		return "required"
+	}
+	return "preferred"
+}
+
+// descriptors lists credentials for allowCredentials and excludeCredentials
+func descriptors(credentials []models.WebAuthnCredential) []CredentialDescriptor {
+	list := make([]CredentialDescriptor, 0, len(credentials))
+	for _, credential := range credentials {
+		list = append(list, CredentialDescriptor{Type: "public-key", ID: credential.ID})
+	}
+	return list
+}
@@ -0,0 +1,524 @@
+package webauthn_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"joshuamURD/go-auth-api/pkgs/models"
+	"joshuamURD/go-auth-api/pkgs/webauthn"
+	"joshuamURD/go-auth-api/pkgs/webauthn/webauthntest"
+
+	"github.com/google/uuid"
+)
+
+const (
+	rpID   = "auth.example.com"
+	origin = "https://auth.example.com"
+)
+
+func newRelyingParty(t testing.TB, requireUserVerification bool) *webauthn.RelyingParty {
+	t.Helper()
+	rp, err := webauthn.NewRelyingParty(webauthn.Config{
+		RPID:                    rpID,
+		Origins:                 []string{origin},
+		RequireUserVerification: requireUserVerification,
+		Timeout:                 time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("NewRelyingParty: %v", err)
+	}
+	return rp
+}
+
+func newUser() models.User {
+	return models.User{ID: uuid.New(), Email: "user@example.com"}
+}
+
+// register runs a registration ceremony with the authenticator and returns the credential to store
+func register(t testing.TB, rp *webauthn.RelyingParty, authenticator *webauthntest.Authenticator, user models.User) models.WebAuthnCredential {
+	t.Helper()
+	options, session, err := rp.BeginRegistration(user, nil)
+	if err != nil {
+		t.Fatalf("BeginRegistration: %v", err)
+	}
+	response, err := authenticator.Register(options)
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	credential, err := rp.FinishRegistration(session, response)
+	if err != nil {
+		t.Fatalf("FinishRegistration: %v", err)
+	}
+	return credential
+}
+
+// editClientData returns the client data JSON with one field replaced
+func editClientData(t *testing.T, clientDataJSON []byte, field string, value any) []byte {
+	t.Helper()
+	var fields map[string]any
+	if err := json.Unmarshal(clientDataJSON, &fields); err != nil {
+		t.Fatalf("client data: %v", err)
+	}
+	fields[field] = value
+	data, err := json.Marshal(fields)
+	if err != nil {
+		t.Fatalf("client data: %v", err)
+	}
+	return data
+}
+
+// otherChallenge is a well formed challenge that no session was issued for
+var otherChallenge = base64.RawURLEncoding.EncodeToString(bytes.Repeat([]byte{0x42}, 32))
+
+// checkError fails unless err wraps want and mentions message, or is nil when want is
+func checkError(t *testing.T, err error, want error, message string) {
+	t.Helper()
+	if want == nil {
+		if err != nil {
+			t.Fatalf("the response was refused: %v", err)
+		}
+		return
+	}
+	if !errors.Is(err, want) {
+		t.Fatalf("got error %v, want %v", err, want)
+	}
+	if !strings.Contains(err.Error(), message) {
+		t.Fatalf("got error %q, want it to mention %q", err, message)
+	}
+}
+
+// registrationCeremony is a registration that a test case alters before or after the authenticator answers it
+type registrationCeremony struct {
+	rp            *webauthn.RelyingParty
+	authenticator *webauthntest.Authenticator
+	user          models.User
+	options       webauthn.CreationOptions
+	session       models.WebAuthnSession
+	response      webauthn.RegistrationResponse
+}
+
+// clearFlag clears a flag of the authenticator data inside the attestation object, found by its RP ID hash
+func (c *registrationCeremony) clearFlag(t *testing.T, flag byte) {
+	t.Helper()
+	hash := sha256.Sum256([]byte(rpID))
+	at := bytes.Index(c.response.Response.AttestationObject, hash[:])
+	if at < 0 {
+		t.Fatal("no authenticator data in the attestation object")
+	}
+	c.response.Response.AttestationObject[at+32] &^= flag
+}
+
+func TestFinishRegistration(t *testing.T) {
+	tests := []struct {
+		name                    string
+		requireUserVerification bool
+		before                  func(c *registrationCeremony)
+		after                   func(t *testing.T, c *registrationCeremony)
+		wantErr                 error
+		wantMessage             string
+	}{
+		{name: "valid"},
+		{
+			name:        "rp ID hash mismatch",
+			before:      func(c *registrationCeremony) { c.options.RP.ID = "example.com" },
+			wantErr:     webauthn.ErrInvalidResponse,
+			wantMessage: "RP ID hash does not match",
+		},
+		{
+			name:        "origin mismatch",
+			before:      func(c *registrationCeremony) { c.authenticator.Origin = "https://evil.example.com" },
+			wantErr:     webauthn.ErrInvalidResponse,
+			wantMessage: `origin "https://evil.example.com" is not allowed`,
+		},
+		{
+			name: "cross-origin",
+			after: func(t *testing.T, c *registrationCeremony) {
+				c.response.Response.ClientDataJSON = editClientData(t, c.response.Response.ClientDataJSON, "crossOrigin", true)
+			},
+			wantErr:     webauthn.ErrInvalidResponse,
+			wantMessage: "cross-origin",
+		},
+		{
+			name: "challenge mismatch",
+			after: func(t *testing.T, c *registrationCeremony) {
+				c.response.Response.ClientDataJSON = editClientData(t, c.response.Response.ClientDataJSON, "challenge", otherChallenge)
+			},
+			wantErr:     webauthn.ErrInvalidResponse,
+			wantMessage: "challenge does not match",
+		},
+		{
+			name: "client data type mismatch",
+			after: func(t *testing.T, c *registrationCeremony) {
+				c.response.Response.ClientDataJSON = editClientData(t, c.response.Response.ClientDataJSON, "type", "webauthn.get")
+			},
+			wantErr:     webauthn.ErrInvalidResponse,
+			wantMessage: `client data type "webauthn.get"`,
+		},
+		{
+			name:        "credential type mismatch",
+			after:       func(t *testing.T, c *registrationCeremony) { c.response.Type = "password" },
+			wantErr:     webauthn.ErrInvalidResponse,
+			wantMessage: `credential type "password"`,
+		},
+		{
+			name:        "login challenge",
+			after:       func(t *testing.T, c *registrationCeremony) { c.session.Ceremony = models.CeremonyLogin },
+			wantErr:     webauthn.ErrInvalidResponse,
+			wantMessage: "not a registration challenge",
+		},
+		{
+			name:        "user not present",
+			after:       func(t *testing.T, c *registrationCeremony) { c.clearFlag(t, 0x01) },
+			wantErr:     webauthn.ErrInvalidResponse,
+			wantMessage: "user was not present",
+		},
+		{
+			name:                    "user not verified",
+			requireUserVerification: true,
+			before:                  func(c *registrationCeremony) { c.authenticator.UserVerified = false },
+			wantErr:                 webauthn.ErrInvalidResponse,
+			wantMessage:             "user was not verified",
+		},
+		{
+			name:   "user not verified when it is not required",
+			before: func(c *registrationCeremony) { c.authenticator.UserVerified = false },
+		},
+		{
+			name:        "raw ID mismatch",
+			after:       func(t *testing.T, c *registrationCeremony) { c.response.RawID = []byte("another credential") },
+			wantErr:     webauthn.ErrInvalidResponse,
+			wantMessage: "credential ID does not match the raw ID",
+		},
+		{
+			name:        "malformed attestation object",
+			after:       func(t *testing.T, c *registrationCeremony) { c.response.Response.AttestationObject = []byte{0xa1} },
+			wantErr:     webauthn.ErrInvalidResponse,
+			wantMessage: "malformed attestation object",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &registrationCeremony{
+				rp:            newRelyingParty(t, tt.requireUserVerification),
+				authenticator: webauthntest.New(origin),
+				user:          newUser(),
+			}
+			var err error
+			if c.options, c.session, err = c.rp.BeginRegistration(c.user, nil); err != nil {
+				t.Fatalf("BeginRegistration: %v", err)
+			}
+			if tt.before != nil {
+				tt.before(c)
+			}
+			if c.response, err = c.authenticator.Register(c.options); err != nil {
+				t.Fatalf("Register: %v", err)
+			}
+			if tt.after != nil {
+				tt.after(t, c)
+			}
+
+			credential, err := c.rp.FinishRegistration(c.session, c.response)
+			checkError(t, err, tt.wantErr, tt.wantMessage)
+			if err != nil {
+				return
+			}
+			if !bytes.Equal(credential.ID, c.response.RawID) || credential.UserID != c.user.ID || credential.SignCount != 0 {
+				t.Errorf("got credential %x of user %s with counter %d, want %x of %s with 0",
+					credential.ID, credential.UserID, credential.SignCount, []byte(c.response.RawID), c.user.ID)
+			}
+		})
+	}
+}
+
+// loginCeremony is an assertion that a test case alters before or after the authenticator answers it
+type loginCeremony struct {
+	rp            *webauthn.RelyingParty
+	authenticator *webauthntest.Authenticator
+	user          models.User
+	credential    models.WebAuthnCredential
+	// userID is the user the login is started for, nil for a discoverable credential
+	userID   *uuid.UUID
+	options  webauthn.RequestOptions
+	session  models.WebAuthnSession
+	response webauthn.AssertionResponse
+}
+
+func TestFinishLogin(t *testing.T) {
+	tests := []struct {
+		name                    string
+		requireUserVerification bool
+		before                  func(t *testing.T, c *loginCeremony)
+		after                   func(t *testing.T, c *loginCeremony)
+		wantErr                 error
+		wantMessage             string
+	}{
+		{name: "valid"},
+		{
+			name:   "discoverable credential",
+			before: func(t *testing.T, c *loginCeremony) { c.userID = nil },
+		},
+		{
+			name:        "rp ID hash mismatch",
+			after:       func(t *testing.T, c *loginCeremony) { c.response.Response.AuthenticatorData[0] ^= 0xff },
+			wantErr:     webauthn.ErrInvalidResponse,
+			wantMessage: "RP ID hash does not match",
+		},
+		{
+			name:        "origin mismatch",
+			before:      func(t *testing.T, c *loginCeremony) { c.authenticator.Origin = "https://evil.example.com" },
+			wantErr:     webauthn.ErrInvalidResponse,
+			wantMessage: `origin "https://evil.example.com" is not allowed`,
+		},
+		{
+			name: "challenge mismatch",
+			after: func(t *testing.T, c *loginCeremony) {
+				c.response.Response.ClientDataJSON = editClientData(t, c.response.Response.ClientDataJSON, "challenge", otherChallenge)
+			},
+			wantErr:     webauthn.ErrInvalidResponse,
+			wantMessage: "challenge does not match",
+		},
+		{
+			name: "client data type mismatch",
+			after: func(t *testing.T, c *loginCeremony) {
+				c.response.Response.ClientDataJSON = editClientData(t, c.response.Response.ClientDataJSON, "type", "webauthn.create")
+			},
+			wantErr:     webauthn.ErrInvalidResponse,
+			wantMessage: `client data type "webauthn.create"`,
+		},
+		{
+			name:        "credential type mismatch",
+			after:       func(t *testing.T, c *loginCeremony) { c.response.Type = "password" },
+			wantErr:     webauthn.ErrInvalidResponse,
+			wantMessage: `credential type "password"`,
+		},
+		{
+			name:        "registration challenge",
+			after:       func(t *testing.T, c *loginCeremony) { c.session.Ceremony = models.CeremonyRegistration },
+			wantErr:     webauthn.ErrInvalidResponse,
+			wantMessage: "not a login challenge",
+		},
+		{
+			name:        "user not present",
+			after:       func(t *testing.T, c *loginCeremony) { c.response.Response.AuthenticatorData[32] &^= 0x01 },
+			wantErr:     webauthn.ErrInvalidResponse,
+			wantMessage: "user was not present",
+		},
+		{
+			name:                    "user not verified",
+			requireUserVerification: true,
+			before:                  func(t *testing.T, c *loginCeremony) { c.authenticator.UserVerified = false },
+			wantErr:                 webauthn.ErrInvalidResponse,
+			wantMessage:             "user was not verified",
+		},
+		{
+			name:   "user not verified when it is not required",
+			before: func(t *testing.T, c *loginCeremony) { c.authenticator.UserVerified = false },
+		},
+		{
+			name: "invalid signature",
+			after: func(t *testing.T, c *loginCeremony) {
+				signature := c.response.Response.Signature
+				signature[len(signature)-1] ^= 0xff
+			},
+			wantErr:     webauthn.ErrInvalidResponse,
+			wantMessage: "invalid signature",
+		},
+		{
+			name: "sign count regressed",
+			before: func(t *testing.T, c *loginCeremony) {
+				c.credential.SignCount = 10
+				c.authenticator.SetSignCount(c.credential.ID, 3)
+			},
+			wantErr: webauthn.ErrSignCountRegressed,
+		},
+		{
+			name:    "sign count not increased",
+			before:  func(t *testing.T, c *loginCeremony) { c.credential.SignCount = 1 },
+			wantErr: webauthn.ErrSignCountRegressed,
+		},
+		{
+			name:    "counter dropped to zero",
+			before:  func(t *testing.T, c *loginCeremony) { c.credential.SignCount = 5; c.authenticator.NoCounter = true },
+			wantErr: webauthn.ErrSignCountRegressed,
+		},
+		{
+			name:   "authenticator without a counter",
+			before: func(t *testing.T, c *loginCeremony) { c.authenticator.NoCounter = true },
+		},
+		{
+			name: "unknown credential",
+			after: func(t *testing.T, c *loginCeremony) {
+				c.credential = register(t, c.rp, webauthntest.New(origin), c.user)
+			},
+			wantErr:     webauthn.ErrInvalidResponse,
+			wantMessage: "response is not from the credential",
+		},
+		{
+			name: "credential of another user",
+			before: func(t *testing.T, c *loginCeremony) {
+				c.credential = register(t, c.rp, c.authenticator, newUser())
+			},
+			wantErr:     webauthn.ErrInvalidResponse,
+			wantMessage: "credential belongs to another user",
+		},
+		{
+			name:        "discoverable credential without a user handle",
+			before:      func(t *testing.T, c *loginCeremony) { c.userID = nil },
+			after:       func(t *testing.T, c *loginCeremony) { c.response.Response.UserHandle = nil },
+			wantErr:     webauthn.ErrInvalidResponse,
+			wantMessage: "no user handle",
+		},
+		{
+			name: "user handle mismatch",
+			after: func(t *testing.T, c *loginCeremony) {
+				c.response.Response.UserHandle = webauthn.UserHandle(uuid.New())
+			},
+			wantErr:     webauthn.ErrInvalidResponse,
+			wantMessage: "user handle does not match the credential",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &loginCeremony{
+				rp:            newRelyingParty(t, tt.requireUserVerification),
+				authenticator: webauthntest.New(origin),
+				user:          newUser(),
+			}
+			c.userID = &c.user.ID
+			c.credential = register(t, c.rp, c.authenticator, c.user)
+			if tt.before != nil {
+				tt.before(t, c)
+			}
+
+			var err error
+			if c.options, c.session, err = c.rp.BeginLogin(c.userID, []models.WebAuthnCredential{c.credential}); err != nil {
+				t.Fatalf("BeginLogin: %v", err)
+			}
+			if c.response, err = c.authenticator.Login(c.options); err != nil {
+				t.Fatalf("Login: %v", err)
+			}
+			if tt.after != nil {
+				tt.after(t, c)
+			}
+
+			count, err := c.rp.FinishLogin(c.session, c.credential, c.response)
+			checkError(t, err, tt.wantErr, tt.wantMessage)
+			if want := binary.BigEndian.Uint32(c.response.Response.AuthenticatorData[33:37]); err == nil && count != want {
+				t.Errorf("got counter %d, want the %d the authenticator reported", count, want)
+			}
+		})
+	}
+}
+
+func TestFinishLoginReplay(t *testing.T) {
+	rp := newRelyingParty(t, false)
+	authenticator := webauthntest.New(origin)
+	user := newUser()
+	credential := register(t, rp, authenticator, user)
+	credentials := []models.WebAuthnCredential{credential}
+
+	options, session, err := rp.BeginLogin(&user.ID, credentials)
+	if err != nil {
+		t.Fatalf("BeginLogin: %v", err)
+	}
+	response, err := authenticator.Login(options)
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	if credential.SignCount, err = rp.FinishLogin(session, credential, response); err != nil {
+		t.Fatalf("FinishLogin: %v", err)
+	}
+
+	//A captured response does not answer the next challenge
+	_, next, err := rp.BeginLogin(&user.ID, credentials)
+	if err != nil {
+		t.Fatalf("BeginLogin: %v", err)
+	}
+	_, err = rp.FinishLogin(next, credential, response)
+	checkError(t, err, webauthn.ErrInvalidResponse, "challenge does not match")
+
+	//Answering the same challenge again is caught by the stored counter, should the session not have been consumed
+	_, err = rp.FinishLogin(session, credential, response)
+	checkError(t, err, webauthn.ErrSignCountRegressed, "")
+}
+
+// registrationSeeds returns a real attestation object and the COSE key in it, with an assertion signed by the key
+func registrationSeeds(f *testing.F) (attestation, cose, signed, signature []byte) {
+	f.Helper()
+	rp := newRelyingParty(f, false)
+	authenticator := webauthntest.New(origin)
+	user := newUser()
+
+	options, session, err := rp.BeginRegistration(user, nil)
+	if err != nil {
+		f.Fatalf("BeginRegistration: %v", err)
+	}
+	registration, err := authenticator.Register(options)
+	if err != nil {
+		f.Fatalf("Register: %v", err)
+	}
+	credential, err := rp.FinishRegistration(session, registration)
+	if err != nil {
+		f.Fatalf("FinishRegistration: %v", err)
+	}
+
+	loginOptions, _, err := rp.BeginLogin(&user.ID, []models.WebAuthnCredential{credential})
+	if err != nil {
+		f.Fatalf("BeginLogin: %v", err)
+	}
+	assertion, err := authenticator.Login(loginOptions)
+	if err != nil {
+		f.Fatalf("Login: %v", err)
+	}
+	clientDataHash := sha256.Sum256(assertion.Response.ClientDataJSON)
+	signed = append(append([]byte(nil), assertion.Response.AuthenticatorData...), clientDataHash[:]...)
+	return registration.Response.AttestationObject, credential.PublicKey, signed, assertion.Response.Signature
+}
+
+func FuzzDecodeCBOR(f *testing.F) {
+	attestation, cose, _, _ := registrationSeeds(f)
+	f.Add(attestation)
+	f.Add(cose)
+	f.Add([]byte{0x9f, 0x01, 0xff})                                     // indefinite length array
+	f.Add([]byte{0xbb, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}) // map claiming 2^64-1 pairs
+	f.Add([]byte{0x1b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}) // integer overflowing int64
+	f.Add(bytes.Repeat([]byte{0x81}, 64))                               // deeply nested arrays
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		_, rest, err := webauthn.DecodeCBOR(data)
+		if err != nil {
+			return
+		}
+		if len(rest) >= len(data) || !bytes.HasSuffix(data, rest) {
+			t.Fatalf("decoding %x left %x, which is not a shorter suffix of the input", data, rest)
+		}
+	})
+}
+
+func FuzzParseCOSEKey(f *testing.F) {
+	_, cose, signed, signature := registrationSeeds(f)
+	f.Add(cose, signed, signature)
+
+	//EdDSA and RS256 keys, which webauthntest does not create
+	eddsa := append([]byte{0xa4, 0x01, 0x01, 0x03, 0x27, 0x20, 0x06, 0x21, 0x58, 0x20}, make([]byte, 32)...)
+	f.Add(eddsa, signed, make([]byte, 64))
+	rsa := append([]byte{0xa4, 0x01, 0x03, 0x03, 0x39, 0x01, 0x00, 0x20, 0x59, 0x01, 0x00}, bytes.Repeat([]byte{0xff}, 256)...)
+	rsa = append(rsa, 0x21, 0x43, 0x01, 0x00, 0x01)
+	f.Add(rsa, signed, make([]byte, 256))
+
+	f.Fuzz(func(t *testing.T, cose, message, signature []byte) {
+		ok, err := webauthn.VerifyWithCOSEKey(cose, message, signature)
+		if err != nil && ok {
+			t.Fatalf("a key that failed to parse verified a signature")
+		}
+	})
+}
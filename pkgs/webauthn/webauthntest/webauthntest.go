@@ -0,0 +1,234 @@
+// Package webauthntest provides a software authenticator that answers the options of a
+// webauthn.RelyingParty the way a browser and a passkey would, for exercising the ceremonies
+// without a browser, e.g.
+//
+//	authenticator := webauthntest.New("http://localhost:8080")
+//	response, err := authenticator.Register(options)
+package webauthntest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"slices"
+
+	"joshuamURD/go-auth-api/pkgs/webauthn"
+)
+
+// ErrNoCredential is returned by Login when the authenticator holds no credential the options allow
+var ErrNoCredential = errors.New("webauthntest: no matching credential")
+
+// Authenticator is a software authenticator holding discoverable ES256 credentials in memory
+// it is not safe for concurrent use
+type Authenticator struct {
+	// Origin is the origin reported in the client data
+	Origin string
+	// UserVerified sets the user verified flag, as if a PIN or biometric had been checked
+	UserVerified bool
+	// NoCounter makes the authenticator report a signature counter of zero, like many passkey providers
+	NoCounter bool
+
+	credentials []*credential
+}
+
+// credential is a key pair created by the authenticator
+type credential struct {
+	id         []byte
+	rpID       string
+	userHandle []byte
+	key        *ecdsa.PrivateKey
+	signCount  uint32
+}
+
+// New creates an authenticator that runs ceremonies from origin
+func New(origin string) *Authenticator {
+	return &Authenticator{Origin: origin, UserVerified: true}
+}
+
+// Register creates a credential for the options returned by RelyingParty.BeginRegistration
+// excluded credentials are not checked, so the same user can register several times
+func (a *Authenticator) Register(options webauthn.CreationOptions) (webauthn.RegistrationResponse, error) {
+	var response webauthn.RegistrationResponse
+	if !slices.ContainsFunc(options.PubKeyCredParams, func(p webauthn.CredentialParameter) bool { return p.Alg == webauthn.AlgES256 }) {
+		return response, errors.New("webauthntest: ES256 is not offered")
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return response, err
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return response, err
+	}
+	cred := &credential{
+		id:         id,
+		rpID:       options.RP.ID,
+		userHandle: append([]byte(nil), options.User.ID...),
+		key:        key,
+	}
+	a.credentials = append(a.credentials, cred)
+
+	//Attested credential data: an all zero AAGUID, the credential ID and its COSE key
+	attested := make([]byte, 16, 16+2+len(id))
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(id)))
+	attested = append(attested, id...)
+	attested = append(attested, coseKey(&key.PublicKey)...)
+	authData := a.authenticatorData(cred, 0x40, attested)
+
+	attestation := cborMap(3)
+	attestation = append(attestation, cborText("fmt")...)
+	attestation = append(attestation, cborText("none")...)
+	attestation = append(attestation, cborText("attStmt")...)
+	attestation = append(attestation, cborMap(0)...)
+	attestation = append(attestation, cborText("authData")...)
+	attestation = append(attestation, cborBytes(authData)...)
+
+	response.ID = base64.RawURLEncoding.EncodeToString(id)
+	response.RawID = id
+	response.Type = "public-key"
+	response.Response.ClientDataJSON = a.clientData("webauthn.create", options.Challenge)
+	response.Response.AttestationObject = attestation
+	response.Response.Transports = []string{"internal"}
+	return response, nil
+}
+
+// Login signs the challenge of the options returned by RelyingParty.BeginLogin with the first
+// credential for the relying party that the options allow, any of them if none are listed
+func (a *Authenticator) Login(options webauthn.RequestOptions) (webauthn.AssertionResponse, error) {
+	var response webauthn.AssertionResponse
+	cred := a.find(options)
+	if cred == nil {
+		return response, ErrNoCredential
+	}
+
+	if !a.NoCounter {
+		cred.signCount++
+	}
+	authData := a.authenticatorData(cred, 0, nil)
+	clientDataJSON := a.clientData("webauthn.get", options.Challenge)
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	digest := sha256.Sum256(append(append([]byte(nil), authData...), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, cred.key, digest[:])
+	if err != nil {
+		return response, err
+	}
+
+	response.ID = base64.RawURLEncoding.EncodeToString(cred.id)
+	response.RawID = cred.id
+	response.Type = "public-key"
+	response.Response.ClientDataJSON = clientDataJSON
+	response.Response.AuthenticatorData = authData
+	response.Response.Signature = signature
+	response.Response.UserHandle = cred.userHandle
+	return response, nil
+}
+
+// SetSignCount sets the counter of a credential, e.g. to act like a cloned authenticator
+func (a *Authenticator) SetSignCount(id []byte, count uint32) {
+	for _, cred := range a.credentials {
+		if slices.Equal(cred.id, id) {
+			cred.signCount = count
+		}
+	}
+}
+
+func (a *Authenticator) find(options webauthn.RequestOptions) *credential {
+	for _, cred := range a.credentials {
+		if cred.rpID != options.RPID {
+			continue
+		}
+		if len(options.AllowCredentials) == 0 {
+			return cred
+		}
+		for _, allowed := range options.AllowCredentials {
+			if slices.Equal(allowed.ID, cred.id) {
+				return cred
+			}
+		}
+	}
+	return nil
+}
+
+// authenticatorData builds the authenticator data of a ceremony with the user present flag set
+func (a *Authenticator) authenticatorData(cred *credential, flags byte, attested []byte) []byte {
+	flags |= 0x01
+	if a.UserVerified {
+		flags |= 0x04
+	}
+	rpIDHash := sha256.Sum256([]byte(cred.rpID))
+	data := append(rpIDHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, cred.signCount)
+	return append(data, attested...)
+}
+
+// clientData builds the client data JSON a browser would send
+func (a *Authenticator) clientData(ceremonyType string, challenge []byte) []byte {
+	data, _ := json.Marshal(map[string]any{
+		"type":        ceremonyType,
+		"challenge":   base64.RawURLEncoding.EncodeToString(challenge),
+		"origin":      a.Origin,
+		"crossOrigin": false,
+	})
+	return data
+}
+
+// coseKey encodes a P-256 public key as an ES256 COSE_Key
+func coseKey(key *ecdsa.PublicKey) []byte {
+	x := make([]byte, 32)
+	y := make([]byte, 32)
+	key.X.FillBytes(x)
+	key.Y.FillBytes(y)
+
+	out := cborMap(5)
+	out = append(out, cborInt(1)...)
+	out = append(out, cborInt(2)...)
+	out = append(out, cborInt(3)...)
+	out = append(out, cborInt(webauthn.AlgES256)...)
+	out = append(out, cborInt(-1)...)
+	out = append(out, cborInt(1)...)
+	out = append(out, cborInt(-2)...)
+	out = append(out, cborBytes(x)...)
+	out = append(out, cborInt(-3)...)
+	out = append(out, cborBytes(y)...)
+	return out
+}
+
+// cborHead encodes the initial bytes of a CBOR item, its major type and argument
+func cborHead(major byte, n uint64) []byte {
+	switch {
+	case n < 24:
+		return []byte{major<<5 | byte(n)}
+	case n <= 0xff:
+		return []byte{major<<5 | 24, byte(n)}
+	case n <= 0xffff:
+		return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(n))
+	default:
+		return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(n))
+	}
+}
+
+func cborInt(n int) []byte {
+	if n < 0 {
+		return cborHead(1, uint64(-1-n))
+	}
+	return cborHead(0, uint64(n))
+}
+
+func cborBytes(b []byte) []byte {
+	return append(cborHead(2, uint64(len(b))), b...)
+}
+
+func cborText(s string) []byte {
+	return append(cborHead(3, uint64(len(s))), s...)
+}
+
+// cborMap encodes the head of a map of n pairs, which the caller appends
+func cborMap(n int) []byte {
+	return cborHead(5, uint64(n))
+}